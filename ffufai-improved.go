@@ -1,30 +1,266 @@
 package main
 
 import (
+        "bufio"
         "bytes"
+        "compress/gzip"
         "context"
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha256"
+        "crypto/tls"
+        "crypto/x509"
+        "embed"
+        "encoding/base64"
+        "encoding/csv"
+        "encoding/hex"
         "encoding/json"
+        "encoding/pem"
+        "encoding/xml"
+        "errors"
         "flag"
         "fmt"
+        "html"
+        "io"
+        "math"
+        "math/bits"
+        mathrand "math/rand"
+        "net"
         "net/http"
         "net/url"
         "os"
         "os/exec"
         "os/signal"
+        "path"
+        "path/filepath"
+        "reflect"
         "regexp"
+        "runtime"
+        "sort"
+        "strconv"
         "strings"
+        "sync"
         "syscall"
+        "text/tabwriter"
+        "text/template"
         "time"
+        "unicode"
+        "unicode/utf8"
 )
 
 const (
-        Version        = "1.0.0"
-        PerplexityURL  = "https://api.perplexity.ai/chat/completions"
-        DefaultModel   = "sonar-pro"
+        Version                = "1.0.0"
+        PerplexityURL          = "https://api.perplexity.ai/chat/completions"
+        AnthropicURL           = "https://api.anthropic.com/v1/messages"
+        AnthropicVersion       = "2023-06-01"
+        OllamaDefaultHost      = "http://localhost:11434"
+        GeminiBaseURL          = "https://generativelanguage.googleapis.com/v1beta/models/"
+        OpenAIURL              = "https://api.openai.com/v1/chat/completions"
+        OpenRouterURL          = "https://openrouter.ai/api/v1/chat/completions"
+        GroqURL                = "https://api.groq.com/openai/v1/chat/completions"
+        MistralURL             = "https://api.mistral.ai/v1/chat/completions"
+        ProjectURL             = "https://github.com/youseefhamdi/ffufai"
+        DefaultModel           = "sonar-pro"
+        DefaultAnthropicModel  = "claude-3-5-haiku-latest"
+        DefaultOllamaModel     = "llama3.1"
+        DefaultGeminiModel     = "gemini-1.5-flash"
+        DefaultAzureVersion    = "2024-06-01"
+        DefaultOpenAIModel     = "gpt-4o-mini"
+        DefaultOpenRouterModel = "openai/gpt-4o-mini"
+        DefaultGroqModel       = "llama-3.1-8b-instant"
+        DefaultMistralModel    = "mistral-small-latest"
+        DefaultBedrockModel    = "anthropic.claude-3-5-haiku-20241022-v1:0"
+        BedrockAnthropicVer    = "bedrock-2023-05-31"
+        // Defaults of --ai-timeout and --probe-timeout
         RequestTimeout = 30 * time.Second
         HeaderTimeout  = 10 * time.Second
 )
 
+// Subcommands; all but chat replace the fuzzing run
+const (
+        // List the models a provider offers
+        CommandModels = "models"
+        // Rank recursion candidates from an existing ffuf JSON file
+        CommandRecurse = "recurse"
+        // Refine the suggestions in a REPL before running ffuf
+        CommandChat = "chat"
+        // Compare models on the extension prompt without running ffuf
+        CommandBench = "bench"
+        // Check ffuf, the provider credentials and the target's reachability
+        CommandDoctor = "doctor"
+)
+
+// Exit codes of ffufai's own failures, from the sysexits.h range so they
+// never clash with ffuf's 1 or a Go panic's 2. When ffuf itself fails,
+// ffufai exits with ffuf's code.
+const (
+        // Invalid flags or arguments, a missing API key or a missing ffuf
+        ExitUsage = 64
+        // The target was unreachable in the pre-flight check
+        ExitProbeFailure = 68
+        // The AI provider failed or suggested nothing usable
+        ExitAIFailure = 69
+        // Any other ffufai failure
+        ExitFailure = 70
+        // Stopped by Ctrl+C or SIGTERM, as shells report SIGINT
+        ExitInterrupted = 130
+)
+
+// How --ensemble combines the providers' suggestions
+const (
+        EnsembleUnion     = "union"
+        EnsembleIntersect = "intersect"
+)
+
+// How --system-prompt combines with the built-in system message
+const (
+        SystemPromptReplace = "replace"
+        SystemPromptAppend  = "append"
+)
+
+// Supported AI providers
+const (
+        ProviderPerplexity = "perplexity"
+        ProviderAnthropic  = "anthropic"
+        ProviderOllama     = "ollama"
+        ProviderGemini     = "gemini"
+        ProviderAzure      = "azure"
+        ProviderOpenRouter = "openrouter"
+        ProviderGroq       = "groq"
+        ProviderOpenAI     = "openai"
+        ProviderMistral    = "mistral"
+        ProviderBedrock    = "bedrock"
+)
+
+var supportedProviders = []string{ProviderPerplexity, ProviderOpenAI, ProviderAnthropic, ProviderOllama, ProviderGemini, ProviderAzure, ProviderOpenRouter, ProviderGroq, ProviderMistral, ProviderBedrock}
+
+// System message shared by all providers
+const systemPrompt = "You are a cybersecurity expert that suggests file extensions for web application fuzzing. You respond only with valid JSON containing an extensions array."
+
+// System message for the extension prompts: the built-in one, replaced by or
+// followed by the --system-prompt text. An empty override keeps the default.
+func resolveSystemPrompt(text, file, mode string) (string, error) {
+        if file != "" {
+                if text != "" {
+                        return "", fmt.Errorf("--system-prompt and --system-prompt-file cannot be combined")
+                }
+                data, err := os.ReadFile(file)
+                if err != nil {
+                        return "", fmt.Errorf("reading system prompt file: %w", err)
+                }
+                text = string(data)
+        }
+        if mode != SystemPromptReplace && mode != SystemPromptAppend {
+                return "", fmt.Errorf("--system-prompt-mode must be %s or %s", SystemPromptReplace, SystemPromptAppend)
+        }
+
+        text = strings.TrimSpace(text)
+        switch {
+        case text == "":
+                return systemPrompt, nil
+        case mode == SystemPromptAppend:
+                return systemPrompt + "\n\n" + text, nil
+        default:
+                return text, nil
+        }
+}
+
+// Longest --ai-context hint sent to the model, in bytes
+const maxAIContext = 1024
+
+// Read the --ai-context hint, from a file when it starts with @. Control
+// characters other than newlines and tabs are dropped and long hints are
+// cut to maxAIContext.
+func loadAIContext(value string) (string, error) {
+        if strings.HasPrefix(value, "@") {
+                data, err := os.ReadFile(value[1:])
+                if err != nil {
+                        return "", fmt.Errorf("reading AI context: %w", err)
+                }
+                value = string(data)
+        }
+        value = strings.TrimSpace(strings.Map(func(r rune) rune {
+                if unicode.IsControl(r) && r != '\n' && r != '\t' {
+                        return -1
+                }
+                return r
+        }, strings.ToValidUTF8(value, "")))
+
+        if len(value) > maxAIContext {
+                fmt.Fprintf(os.Stderr, "%sWarning: --ai-context is longer than %d bytes, truncating%s\n", ColorYellow, maxAIContext, ColorReset)
+                value = truncateRunes(value, maxAIContext)
+        }
+        return value, nil
+}
+
+// Cut a string to at most max bytes without splitting a UTF-8 sequence
+func truncateRunes(value string, max int) string {
+        if len(value) <= max {
+                return value
+        }
+        cut := max
+        for cut > 0 && !utf8.RuneStart(value[cut]) {
+                cut--
+        }
+        return value[:cut]
+}
+
+// HTTP methods the AI may suggest for the fuzz run; DELETE is left out
+var allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "HEAD", "OPTIONS"}
+
+// Suggested methods that change server state, applied only when the OPTIONS
+// probe lists them
+var stateChangingMethods = []string{"PUT", "PATCH"}
+
+// JSON schema for ExtensionsResponse with every field a reply may hold
+var extensionsSchema = newExtensionsSchema(false, true, true)
+
+// JSON schema for ExtensionsResponse, sent to providers that support structured
+// output. explain adds a reason to every extension (--explain); method and
+// matchCodes ask for a method and match codes, which are left out when they
+// would not be applied.
+func newExtensionsSchema(explain, method, matchCodes bool) map[string]interface{} {
+        itemProperties := map[string]interface{}{
+                "ext":        map[string]interface{}{"type": "string"},
+                "confidence": map[string]interface{}{"type": "number"},
+        }
+        itemRequired := []string{"ext", "confidence"}
+        if explain {
+                itemProperties["reason"] = map[string]interface{}{"type": "string"}
+                itemRequired = append(itemRequired, "reason")
+        }
+
+        properties := map[string]interface{}{
+                "extensions": map[string]interface{}{
+                        "type": "array",
+                        "items": map[string]interface{}{
+                                "type":                 "object",
+                                "properties":           itemProperties,
+                                "required":             itemRequired,
+                                "additionalProperties": false,
+                        },
+                },
+        }
+        required := []string{"extensions"}
+        if method {
+                properties["method"] = map[string]interface{}{"type": "string", "enum": allowedMethods}
+                properties["method_reason"] = map[string]interface{}{"type": "string"}
+                required = append(required, "method", "method_reason")
+        }
+        if matchCodes {
+                properties["match_codes"] = map[string]interface{}{"type": "string"}
+                properties["match_reason"] = map[string]interface{}{"type": "string"}
+                required = append(required, "match_codes", "match_reason")
+        }
+
+        return map[string]interface{}{
+                "type":                 "object",
+                "properties":           properties,
+                "required":             required,
+                "additionalProperties": false,
+        }
+}
+
 // Color codes for terminal output
 const (
         ColorBlack  = "\033[30m"
@@ -63,10 +299,24 @@ const wolfBanner = ColorBlack + ColorBold + `
 
 // Perplexity API structures
 type PerplexityRequest struct {
-        Model       string    `json:"model"`
-        Messages    []Message `json:"messages"`
-        MaxTokens   int       `json:"max_tokens"`
-        Temperature float64   `json:"temperature"`
+        Model          string          `json:"model,omitempty"`
+        Messages       []Message       `json:"messages"`
+        MaxTokens      int             `json:"max_tokens"`
+        Temperature    float64         `json:"temperature"`
+        Stream         bool            `json:"stream,omitempty"`
+        ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// Structured output request shared by OpenAI and Perplexity
+type ResponseFormat struct {
+        Type       string            `json:"type"`
+        JSONSchema *JSONSchemaFormat `json:"json_schema,omitempty"`
+}
+
+type JSONSchemaFormat struct {
+        Name   string                 `json:"name"`
+        Strict bool                   `json:"strict"`
+        Schema map[string]interface{} `json:"schema"`
 }
 
 type Message struct {
@@ -95,494 +345,13513 @@ type Usage struct {
         TotalTokens      int `json:"total_tokens"`
 }
 
-type ExtensionsResponse struct {
-        Extensions []string `json:"extensions"`
+// One server-sent event from a streaming chat completion
+type StreamChunk struct {
+        Choices []StreamChoice `json:"choices"`
+        Usage   *Usage         `json:"usage"`
 }
 
-// Configuration
-type Config struct {
-        FfufPath      string
-        MaxExtensions int
-        URL           string
-        FfufArgs      []string
-        Model         string
-        Verbose       bool
-        DryRun        bool
+type StreamChoice struct {
+        Delta        Message `json:"delta"`
+        FinishReason string  `json:"finish_reason"`
 }
 
-// Display wolf banner with colors
-func displayBanner() {
-        fmt.Print(wolfBanner)
+// Error envelope used by OpenAI-compatible APIs; OpenRouter adds metadata and
+// some self-hosted servers (vLLM) report a top-level message instead
+type ChatErrorResponse struct {
+        Message string `json:"message"`
+        Error   struct {
+                Message  string          `json:"message"`
+                Type     string          `json:"type"`
+                Code     json.RawMessage `json:"code"`
+                Metadata json.RawMessage `json:"metadata"`
+        } `json:"error"`
 }
 
-// Get API key from environment
-func getAPIKey() (string, error) {
-        key := os.Getenv("PERPLEXITY_API_KEY")
-        if key == "" {
-                return "", fmt.Errorf("PERPLEXITY_API_KEY environment variable not set")
-        }
-        return key, nil
+// Mistral error body; message may be a string or an object
+type MistralErrorResponse struct {
+        Object  string          `json:"object"`
+        Message json.RawMessage `json:"message"`
+        Type    string          `json:"type"`
+        Detail  []struct {
+                Loc []interface{} `json:"loc"`
+                Msg string        `json:"msg"`
+        } `json:"detail"`
 }
 
-// Get HTTP headers for a URL with proper timeout and context
-func getHeaders(ctx context.Context, urlStr string) (map[string]string, error) {
-        client := &http.Client{
-                Timeout: HeaderTimeout,
-        }
-
-        req, err := http.NewRequestWithContext(ctx, "HEAD", urlStr, nil)
-        if err != nil {
-                return nil, fmt.Errorf("creating HEAD request: %w", err)
-        }
+// Anthropic API structures
+type AnthropicRequest struct {
+        Model       string    `json:"model"`
+        System      string    `json:"system,omitempty"`
+        Messages    []Message `json:"messages"`
+        MaxTokens   int       `json:"max_tokens"`
+        Temperature float64   `json:"temperature"`
+}
 
-        // Set a common User-Agent to avoid blocking
-        req.Header.Set("User-Agent", "ffufai/"+Version)
+type AnthropicResponse struct {
+        ID         string         `json:"id"`
+        Model      string         `json:"model"`
+        Content    []ContentBlock `json:"content"`
+        StopReason string         `json:"stop_reason"`
+        Usage      AnthropicUsage `json:"usage"`
+}
 
-        resp, err := client.Do(req)
-        if err != nil {
-                return nil, fmt.Errorf("executing HEAD request: %w", err)
-        }
-        defer resp.Body.Close()
+type ContentBlock struct {
+        Type string `json:"type"`
+        Text string `json:"text"`
+}
 
-        headers := make(map[string]string)
-        for key, values := range resp.Header {
-                if len(values) > 0 {
-                        headers[key] = values[0]
-                }
-        }
+type AnthropicUsage struct {
+        InputTokens  int `json:"input_tokens"`
+        OutputTokens int `json:"output_tokens"`
+}
 
-        // Add response status for context
-        headers["Status-Code"] = resp.Status
+// Ollama API structures
+type OllamaRequest struct {
+        Model    string        `json:"model"`
+        Messages []Message     `json:"messages"`
+        Stream   bool          `json:"stream"`
+        Options  OllamaOptions `json:"options"`
+        // Either "json" or a JSON schema the reply must follow
+        Format interface{} `json:"format,omitempty"`
+}
 
-        return headers, nil
+type OllamaOptions struct {
+        Temperature float64 `json:"temperature"`
+        NumPredict  int     `json:"num_predict"`
 }
 
-// Get AI-suggested extensions using Perplexity API
-func getAIExtensions(ctx context.Context, urlStr string, headers map[string]string, apiKey string, config *Config) (*ExtensionsResponse, error) {
-        // Convert headers to JSON string for the prompt
-        headersJSON, err := json.MarshalIndent(headers, "", "  ")
-        if err != nil {
-                return nil, fmt.Errorf("marshaling headers: %w", err)
-        }
+type OllamaResponse struct {
+        Model           string  `json:"model"`
+        Message         Message `json:"message"`
+        Done            bool    `json:"done"`
+        Error           string  `json:"error"`
+        PromptEvalCount int     `json:"prompt_eval_count"`
+        EvalCount       int     `json:"eval_count"`
+}
 
-        prompt := fmt.Sprintf(`Given the following URL and HTTP headers, suggest the most likely file extensions for fuzzing this endpoint.
-Respond with a JSON object containing a list of extensions. The response will be parsed with json.Unmarshal(),
-so it must be valid JSON. No preamble or explanation needed. Use the format: {"extensions": [".ext1", ".ext2", ...]}.
+// Gemini API structures
+type GeminiRequest struct {
+        SystemInstruction *GeminiContent         `json:"systemInstruction,omitempty"`
+        Contents          []GeminiContent        `json:"contents"`
+        GenerationConfig  GeminiGenerationConfig `json:"generationConfig"`
+}
 
-Guidelines:
-- Suggest up to %d extensions maximum
-- Only suggest extensions that make logical sense for this URL path and headers  
-- If the path contains specific technology indicators (like /js/, /css/, /api/, /admin/), prioritize related extensions
-- Consider the Server header and other technology indicators in headers
-- Prefer commonly exploited file types if the path suggests admin/config areas
-- For generic paths, suggest a mix of web technologies (.php, .html, .js, .css, .txt, .xml, .json)
+type GeminiContent struct {
+        Role  string       `json:"role,omitempty"`
+        Parts []GeminiPart `json:"parts"`
+}
 
-Examples:
-1. URL: https://example.com/presentations/FUZZ
-   Headers: {"Content-Type": "application/pdf", "Server": "Apache"}
-   Response: {"extensions": [".pdf", ".ppt", ".pptx", ".doc"]}
+type GeminiPart struct {
+        Text string `json:"text"`
+}
 
-2. URL: https://example.com/admin/FUZZ  
-   Headers: {"Server": "Microsoft-IIS/10.0", "X-Powered-By": "ASP.NET"}
-   Response: {"extensions": [".aspx", ".asp", ".config", ".xml"]}
+type GeminiGenerationConfig struct {
+        Temperature     float64 `json:"temperature"`
+        MaxOutputTokens int     `json:"maxOutputTokens"`
+}
 
-3. URL: https://example.com/api/FUZZ
-   Headers: {"Content-Type": "application/json", "Server": "nginx"}
-   Response: {"extensions": [".json", ".xml", ".php", ".py"]}
+type GeminiResponse struct {
+        Candidates    []GeminiCandidate `json:"candidates"`
+        UsageMetadata struct {
+                PromptTokenCount     int `json:"promptTokenCount"`
+                CandidatesTokenCount int `json:"candidatesTokenCount"`
+                TotalTokenCount      int `json:"totalTokenCount"`
+        } `json:"usageMetadata"`
+}
 
-URL: %s
-Headers: %s
+type GeminiCandidate struct {
+        Content      GeminiContent `json:"content"`
+        FinishReason string        `json:"finishReason"`
+}
 
-Response:`, config.MaxExtensions, urlStr, string(headersJSON))
+type GeminiErrorResponse struct {
+        Error struct {
+                Code    int    `json:"code"`
+                Message string `json:"message"`
+                Status  string `json:"status"`
+                Details []struct {
+                        Type       string `json:"@type"`
+                        RetryDelay string `json:"retryDelay"`
+                } `json:"details"`
+        } `json:"error"`
+}
 
-        // Prepare the Perplexity API request
-        reqBody := PerplexityRequest{
-                Model: config.Model,
-                Messages: []Message{
-                        {
-                                Role:    "system",
-                                Content: "You are a cybersecurity expert that suggests file extensions for web application fuzzing. You respond only with valid JSON containing an extensions array.",
-                        },
-                        {
-                                Role:    "user",
-                                Content: prompt,
-                        },
-                },
-                MaxTokens:   500,
-                Temperature: 0.1, // Low temperature for consistent results
-        }
+// AIProvider sends a prompt to one AI backend and returns its raw reply.
+// Prompt construction and extension parsing stay provider-agnostic.
+type AIProvider interface {
+        Name() string
+        Suggest(ctx context.Context, input PromptInput) (RawCompletion, error)
+}
 
-        // Marshal the request body
-        jsonData, err := json.Marshal(reqBody)
-        if err != nil {
-                return nil, fmt.Errorf("marshaling API request: %w", err)
-        }
+// Prompt sent to an AIProvider
+type PromptInput struct {
+        System      string
+        Prompt      string
+        MaxTokens   int
+        Temperature float64
+        // JSON schema the reply must follow on providers with structured output;
+        // nil for free-form prompts
+        Schema map[string]interface{}
+}
 
-        // Create HTTP request with context
-        req, err := http.NewRequestWithContext(ctx, "POST", PerplexityURL, bytes.NewBuffer(jsonData))
-        if err != nil {
-                return nil, fmt.Errorf("creating API request: %w", err)
-        }
+// Unparsed model reply with token usage when the provider reports it.
+// Structured is set when the provider was asked to follow the input's Schema;
+// Model is set when a model other than the configured one answered.
+type RawCompletion struct {
+        Content    string
+        Usage      Usage
+        Structured bool
+        Model      string
+}
 
-        // Set headers
-        req.Header.Set("Content-Type", "application/json")
-        req.Header.Set("Authorization", "Bearer "+apiKey)
-        req.Header.Set("User-Agent", "ffufai/"+Version)
+type ExtensionsResponse struct {
+        Extensions   []string           `json:"extensions"`
+        Confidence   map[string]float64 `json:"confidence,omitempty"`
+        Reasons      map[string]string  `json:"reasons,omitempty"`
+        Method       string             `json:"method,omitempty"`
+        MethodReason string             `json:"method_reason,omitempty"`
+        MatchCodes   string             `json:"match_codes,omitempty"`
+        MatchReason  string             `json:"match_reason,omitempty"`
+        Provider     string             `json:"provider,omitempty"`
+        Model        string             `json:"model,omitempty"`
+}
 
-        // Make the request with timeout
-        client := &http.Client{
-                Timeout: RequestTimeout,
+// Accept extensions as {"ext": ".aspx", "confidence": 0.9} objects or, as
+// older prompts produced, plain strings without a confidence
+func (r *ExtensionsResponse) UnmarshalJSON(data []byte) error {
+        type fields ExtensionsResponse
+        var raw struct {
+                fields
+                Extensions []json.RawMessage `json:"extensions"`
         }
-
-        if config.Verbose {
-                fmt.Printf("Making Perplexity API request...\n")
+        if err := json.Unmarshal(data, &raw); err != nil {
+                return err
         }
-
-        resp, err := client.Do(req)
-        if err != nil {
-                return nil, fmt.Errorf("executing API request: %w", err)
+        *r = ExtensionsResponse(raw.fields)
+        if raw.Extensions == nil {
+                return nil
         }
-        defer resp.Body.Close()
 
-        // Check response status
-        if resp.StatusCode != http.StatusOK {
-                return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, resp.Status)
-        }
+        r.Extensions = make([]string, 0, len(raw.Extensions))
+        for _, item := range raw.Extensions {
+                var ext string
+                if err := json.Unmarshal(item, &ext); err == nil {
+                        r.Extensions = append(r.Extensions, ext)
+                        continue
+                }
+                var scored struct {
+                        Ext        string   `json:"ext"`
+                        Confidence *float64 `json:"confidence"`
+                        Reason     string   `json:"reason"`
+                }
+                if err := json.Unmarshal(item, &scored); err != nil {
+                        return fmt.Errorf("extension entry %s is neither a string nor an object", item)
+                }
+                r.Extensions = append(r.Extensions, scored.Ext)
 
-        // Parse the response
-        var perplexityResp PerplexityResponse
-        if err := json.NewDecoder(resp.Body).Decode(&perplexityResp); err != nil {
-                return nil, fmt.Errorf("parsing API response: %w", err)
+                // Keyed the way cleanExtensions will spell the extension
+                key := scored.Ext
+                if !strings.HasPrefix(key, ".") {
+                        key = "." + key
+                }
+                if scored.Confidence != nil {
+                        if r.Confidence == nil {
+                                r.Confidence = make(map[string]float64)
+                        }
+                        r.Confidence[key] = *scored.Confidence
+                }
+                if scored.Reason != "" {
+                        if r.Reasons == nil {
+                                r.Reasons = make(map[string]string)
+                        }
+                        r.Reasons[key] = scored.Reason
+                }
         }
+        return nil
+}
 
-        if len(perplexityResp.Choices) == 0 {
-                return nil, fmt.Errorf("no choices in API response")
-        }
+// Error returned when an AI API answers with a non-200 status.
+// Body holds the raw response when the provider read it, and RetryAfter the
+// wait the response asked for before another attempt.
+type APIError struct {
+        StatusCode int
+        Message    string
+        Body       string
+        RetryAfter time.Duration
+}
 
-        content := perplexityResp.Choices[0].Message.Content
+func (e *APIError) Error() string {
+        return e.Message
+}
 
-        if config.Verbose {
-                fmt.Printf("AI Response: %s\n", content)
-        }
+// Create an APIError for a response status with a formatted message
+func apiErrorf(statusCode int, format string, args ...interface{}) *APIError {
+        return &APIError{StatusCode: statusCode, Message: fmt.Sprintf(format, args...)}
+}
 
-        // Extract JSON from the response using regex
-        jsonRegex := regexp.MustCompile(`\{[^{}]*"extensions"\s*:\s*\[[^\]]*\][^{}]*\}`)
-        matches := jsonRegex.FindAllString(content, -1)
+// Create an APIError for a non-200 response, keeping its Retry-After
+func responseError(resp *http.Response, format string, args ...interface{}) *APIError {
+        apiErr := apiErrorf(resp.StatusCode, format, args...)
+        apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+        return apiErr
+}
 
-        if len(matches) == 0 {
-                return nil, fmt.Errorf("no valid JSON found in AI response")
+// Wait asked for by a Retry-After header given in seconds or as an HTTP
+// date; zero when the header is absent, invalid or already past
+func parseRetryAfter(value string, now time.Time) time.Duration {
+        value = strings.TrimSpace(value)
+        if value == "" {
+                return 0
         }
-
-        // Try to parse the first match
-        var extensionsResp ExtensionsResponse
-        if err := json.Unmarshal([]byte(matches[0]), &extensionsResp); err != nil {
-                return nil, fmt.Errorf("parsing AI response JSON: %w", err)
+        if seconds, err := strconv.Atoi(value); err == nil {
+                return time.Duration(max(seconds, 0)) * time.Second
         }
-
-        // Validate and clean extensions
-        var validExtensions []string
-        for _, ext := range extensionsResp.Extensions {
-                // Ensure extension starts with dot
-                if !strings.HasPrefix(ext, ".") {
-                        ext = "." + ext
-                }
-                // Basic validation: only alphanumeric and common symbols
-                if matched, _ := regexp.MatchString(`^\.[a-zA-Z0-9]+$`, ext); matched {
-                        validExtensions = append(validExtensions, ext)
-                }
+        if date, err := http.ParseTime(value); err == nil && date.After(now) {
+                return date.Sub(now)
         }
-
-        extensionsResp.Extensions = validExtensions
-        return &extensionsResp, nil
+        return 0
 }
 
-// Parse command line arguments with better error handling
-// Parse command line arguments with better error handling
-func parseArgs() (*Config, error) {
-        config := &Config{
-                Model: DefaultModel,
-        }
+// Configuration
+type Config struct {
+        FfufPath      string
+        FfufVersion   string
+        MaxExtensions int
+        MinConfidence float64
+        Explain       bool
+        Rationale     []ExtensionRationale
+        URL           string
 
-        // Create a custom flag set that exits on help
-        fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+        // Edit the suggested extensions at a prompt before ffuf runs (--interactive)
+        Interactive bool
 
-        // Define flags including help flags
-        var urlFlag string
-        var showVersion bool
-        var showHelp bool
+        // Wordlist keyword marking the fuzzed position in the URL: --keyword,
+        // or the keyword of a -w file:KEYWORD found in the URL path, FUZZ
+        // by default
+        Keyword string
 
-        fs.StringVar(&config.FfufPath, "ffuf-path", "ffuf", "Path to ffuf executable")
-        fs.IntVar(&config.MaxExtensions, "max-extensions", 4, "Maximum number of extensions to suggest (1-10)")
-        fs.StringVar(&config.Model, "model", DefaultModel, "Perplexity model to use")
-        fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
-        fs.BoolVar(&config.DryRun, "dry-run", false, "Show what would be executed without running ffuf")
-        fs.StringVar(&urlFlag, "u", "", "Target URL with FUZZ keyword (required)")
-        fs.BoolVar(&showVersion, "version", false, "Show version information")
-        fs.BoolVar(&showHelp, "help", false, "Show usage information")
-        fs.BoolVar(&showHelp, "h", false, "Show usage information")
+        // Extensions from the user's own -e, or else the ffufrc's, taken
+        // out of FfufArgs and fuzzed ahead of the AI's in a single -e
+        UserExtensions []string
 
-        // Custom usage function with banner
-        fs.Usage = func() {
-                displayBanner()
-                fmt.Fprintf(os.Stderr, "Usage: %s [options] -u URL [ffuf options]\n\n", os.Args[0])
-                fmt.Fprintf(os.Stderr, "Options:\n")
-                fs.PrintDefaults()
-                fmt.Fprintf(os.Stderr, "\nExamples:\n")
-                fmt.Fprintf(os.Stderr, "  %s -u https://example.com/FUZZ -w /path/to/wordlist.txt\n", os.Args[0])
-                fmt.Fprintf(os.Stderr, "  %s --verbose --max-extensions 6 -u https://example.com/admin/FUZZ -w wordlist.txt -fc 404\n", os.Args[0])
-                fmt.Fprintf(os.Stderr, "  %s --dry-run -u https://example.com/api/FUZZ -w wordlist.txt -fc 301\n", os.Args[0])
-                fmt.Fprintf(os.Stderr, "\nCommon ffuf Options:\n")
-                fmt.Fprintf(os.Stderr, "  -w FILE         Wordlist file path\n")
-                fmt.Fprintf(os.Stderr, "  -fc CODE        Filter HTTP status codes (e.g., -fc 404,301)\n")
-                fmt.Fprintf(os.Stderr, "  -mc CODE        Match HTTP status codes only (e.g., -mc 200,403)\n")
-                fmt.Fprintf(os.Stderr, "  -fs SIZE        Filter response size (e.g., -fs 134)\n")
-                fmt.Fprintf(os.Stderr, "  -t NUM          Number of concurrent threads (default: 40)\n")
-                fmt.Fprintf(os.Stderr, "  -X METHOD       HTTP method (GET, POST, etc.)\n")
-                fmt.Fprintf(os.Stderr, "  -o FILE         Output file (json, csv, html)\n")
-                fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
-                fmt.Fprintf(os.Stderr, "  PERPLEXITY_API_KEY    Perplexity AI API key (required)\n")
-                fmt.Fprintf(os.Stderr, "                        Get yours at: https://www.perplexity.ai/settings/api\n\n")
-                fmt.Fprintf(os.Stderr, "Note: All ffuf options can be passed after the -u URL argument.\n")
-        }
+        // ffuf's default options from its ffufrc, or the file its -config
+        // names, as the flags they stand for; ffufai adds no flag of its own
+        // that these already set
+        Ffufrc     string
+        FfufrcArgs []string
 
-        // Parse only our known flags, ignore unknown ones for help/version
+        FfufArgs      []string
+        Provider      string
+        Providers     []string
+        Model         string
+        APIBase       string
+        APIKeyEnv     string
+        APIKeyFiles   []string
+        InsecureAPI   bool
+        Stream        bool
+        Command       string
+        JSONOutput    bool
+        Ensemble      bool
+        EnsembleMode  string
+        Hedge         bool
+        HedgeModel    string
+        WordlistDir   string
+        GenWordlist   int
+        GenValues     string
+        Backups       bool
+        Refine        int
+        BypassPass    bool
+        Mutate        bool
+        SuggestFilter bool
+        AutoCalibrate bool
+        SuggestBypass bool
+        SuggestMethod bool
+        OptionsProbe  bool
+        AutoMatchers  bool
+        // Whether the AI is asked for a method: only with --suggest-method
+        // and no -X of the user's
+        AskMethod bool
+        // Whether the AI is asked for match codes: only when they would be
+        // applied, with auto matchers on and no matcher or filter of the user's
+        AskMatchCodes bool
+        SuggestVhosts bool
+        VhostsOut     string
+        Triage        bool
+        TriageTop     int
+        TriageOut     string
+        LiveTriage    bool
+        RankRecursion bool
+        MaxRecursion  int
+        RecursionOut  string
+        ResultsFile   string
+        NextSteps     bool
+        NextStepsOut  string
+        Severity      bool
+        SeverityAI    bool
+        ConfigFile    string
+
+        // Levels of found directories --smart-recursion fuzzes with their own
+        // extensions, and the estimated requests all passes may take
+        // together (--max-total-requests); zero means no cap
+        SmartRecursion   int
+        MaxTotalRequests int
+        // Local severity rules and the severity of each result after the run
+        SeverityRules []SeverityRule
+        Severities    map[string]string
+        // Results of every ffuf pass of the run, which the analyses above
+        // read once after the last pass
+        PassResults *FfufOutput
+        // Headers of the base URL and its stack from stage one or --stack,
+        // kept for later prompts and the analysis after the run
+        TargetHeaders map[string]string
+        Stack         *StackDescriptor
+        RecordDir     string
+        ReplayFile    string
+        ReplayForce   bool
+        Replay        *Exchange
+        Verbose       bool
+        DryRun        bool
+        Teach         bool
+
+        // Print each ffuf command as a JSON array on stdout instead of
+        // running it; everything else goes to stderr
+        PrintArgv bool
+
+        // Keep the temporary ffuf results files instead of removing them
+        KeepArtifacts bool
+
+        // Extensions per ffuf run (--ext-batch), zero for a single run, and
+        // how many of those runs go at once (--ext-parallel)
+        ExtBatch    int
+        ExtParallel int
+
+        // Header filtering before the prompts; --full-headers sends them all
+        FullHeaders    bool
+        HeaderValueMax int
+        HeaderBlockMax int
+
+        // Hard caps on the AI spend of the run; zero means no cap
+        MaxTotalTokens int
+        MaxCost        float64
+        // JSON file of the run's statistics and token totals
+        StatsOut string
+
+        // Extension prompt template from --prompt-file; nil uses the built-in one
+        PromptFile     string
+        PromptTemplate *template.Template
+
+        // System message of the extension prompts after --system-prompt
+        SystemPrompt string
+
+        // Free-form target hint from --ai-context, cleaned and length-limited
+        AIContext string
+
+        // Results per --live-triage batch and the longest wait before a partial one
+        LiveTriageBatch    int
+        LiveTriageInterval time.Duration
+
+        // ffuf's output untouched (--raw-ffuf-output), and whether ffufai
+        // instead reads ffuf's -json result lines as they arrive and prints
+        // its own line for each
+        RawFfufOutput bool
+        StreamResults bool
+
+        // Method and body type of the fuzz requests from ffuf's -X, -d and -H,
+        // set only when they are not plain GET or HEAD requests
+        RequestMethod string
+        BodyType      string
+
+        // Description of the FUZZ wordlist added to the prompt (--wordlist-context)
+        WordlistContext bool
+        WordlistSample  string
+
+        // Line counts of the -w files, counted when the arguments are checked
+        WordlistLines map[string]int
+
+        // Hints from the base page added to the prompts (--body-hints)
+        BodyHints bool
+        PageHints string
+
+        // Favicon fingerprinting: extra hashes from the config file and the
+        // product the target's favicon matched (--favicon)
+        Favicon       bool
+        FaviconHashes map[int32]string
+        FaviconMatch  string
+
+        // Probe the target without verifying its certificate (--insecure), and
+        // the leaf certificate the probe saw
+        Insecure    bool
+        Certificate *CertInfo
+
+        // Local technology fingerprint (--fingerprint) and its rules
+        Fingerprint      bool
+        FingerprintRules []FingerprintRule
+        Technologies     *StackDescriptor
+
+        // Analyze the destination of a header probe redirect to another host
+        // instead of the redirect itself (--follow-host-redirects)
+        FollowHostRedirects bool
+
+        // Condensed robots.txt of the target added to the prompt (--robots)
+        FetchRobots bool
+        Robots      string
+
+        // Summary of the target's sitemaps added to the prompt (--sitemap) and
+        // the file their page paths are written to
+        FetchSitemap bool
+        Sitemap      string
+        SeedPathsOut string
+
+        // Well-known paths probed next to the FUZZ directory (--probe-paths)
+        // and the summary of their distinguishing headers
+        ProbePaths []string
+        PathProbes string
+
+        // Scripts read from the base page (--js-files) and what they name
+        ScriptFiles int
+        Scripts     string
+
+        // Hosts and paths from the target's CSP, and the targets file the
+        // related hosts are added to (--related-out)
+        CSP        string
+        RelatedOut string
+
+        // API, CMS and OAuth links from the target's Link headers
+        Links []HeaderLink
+
+        // Mount point of the HTML shell a single-page app serves for every
+        // path, empty when the calibration probes found none
+        SPAShell string
+
+        // Security header audit of the base response, unless --no-audit
+        Audit         bool
+        AuditFindings []AuditFinding
+
+        // WAF canary request (skipped with --no-waf-probe) and applying the
+        // rate guidance instead of only suggesting it (--auto-rate)
+        WAFProbe bool
+        AutoRate bool
+
+        // Carry on when the pre-flight check cannot reach the target
+        Force bool
+
+        // Add ffuf's -http2 when the target negotiated HTTP/2
+        AutoHTTP2 bool
+
+        // Proxy for the target probes, also passed to ffuf as -x (--proxy)
+        Proxy string
+
+        // Client certificate for targets that require mTLS (--client-cert and
+        // --client-key), and the decrypted key ffuf gets when the key file is
+        // encrypted
+        ClientCert        string
+        ClientKey         string
+        ClientCertificate *tls.Certificate
+        ClientKeyPEM      []byte
+
+        // PEM file of extra root CAs the target probes trust (--cacert), the
+        // system roots plus its certificates, and how many it held
+        CACert      string
+        CACerts     *x509.CertPool
+        CACertCount int
+
+        // Headers from ffuf's -H and --headers-file sent with every target
+        // probe; those holding a fuzz keyword are left to ffuf
+        ProbeHeaders []RequestHeader
+
+        // Basic auth credentials from --auth or --auth-file, as user:pass
+        Auth string
+
+        // Cookies for the target probes, passed to ffuf as -b (--cookie)
+        Cookie string
+
+        // User-Agent of the probes and ffuf, from --user-agent or picked by
+        // --random-agent; empty keeps ffufai's and ffuf's own
+        UserAgent string
+
+        // Addresses the probes connect to instead of resolving, from
+        // --resolve, and the DNS server resolving everything else
+        // (--dns-server), as ip:port
+        Resolve   []ResolveOverride
+        DNSServer string
+
+        // TLS server name of the probes to the target, also passed to ffuf
+        // as -sni (--sni); empty uses the URL's host
+        SNI string
+
+        // Raw HTTP request ffuf fuzzes instead of -u (--request, or ffuf's
+        // -request), the scheme for a request line without one
+        // (--request-proto), and the copy ffuf reads instead when the
+        // request came from stdin or had to be rewritten
+        RequestFile  string
+        RequestProto string
+        RequestCopy  string
+        Request      *RawRequest
+
+        // Time limit of each target probe and of each AI request
+        // (--probe-timeout and --ai-timeout)
+        ProbeTimeout time.Duration
+        AITimeout    time.Duration
+
+        // Time ffuf gets to write its results and stop after the first
+        // Ctrl+C before it is killed (--interrupt-grace); zero kills it at
+        // once
+        InterruptGrace time.Duration
+
+        // Retries of the header probe after a network error or a 502, 503
+        // or 504 (--probe-retries), and of an AI request after a 429 or 5xx
+        // (--ai-retries)
+        ProbeRetries int
+        AIRetries    int
+
+        // DNS phase, unless --no-dns, and what it found
+        DNS     bool
+        DNSInfo *DNSInfo
+
+        // Models the bench command compares, requests per model, and the
+        // recorded snapshots it uses instead of a live target
+        BenchModels    []BenchModel
+        BenchRounds    int
+        BenchSnapshots []string
+
+        // AWS region for Bedrock
+        AWSRegion string
+
+        // Azure OpenAI settings; the deployment determines the model
+        AzureEndpoint   string
+        AzureDeployment string
+        AzureAPIVersion string
+}
+
+// Display wolf banner with colors
+func displayBanner() {
+        fmt.Fprint(textOutput, wolfBanner)
+}
+
+// Name of the environment variable holding the API key, honoring --api-key-env
+func apiKeyEnv(config *Config) string {
+        if config.APIKeyEnv != "" {
+                return config.APIKeyEnv
+        }
+
+        switch config.Provider {
+        case ProviderAnthropic:
+                return "ANTHROPIC_API_KEY"
+        case ProviderOllama, ProviderBedrock:
+                return ""
+        case ProviderGemini:
+                return "GEMINI_API_KEY"
+        case ProviderAzure:
+                return "AZURE_OPENAI_API_KEY"
+        case ProviderOpenRouter:
+                return "OPENROUTER_API_KEY"
+        case ProviderGroq:
+                return "GROQ_API_KEY"
+        case ProviderOpenAI:
+                return "OPENAI_API_KEY"
+        case ProviderMistral:
+                return "MISTRAL_API_KEY"
+        default:
+                return "PERPLEXITY_API_KEY"
+        }
+}
+
+// Read an environment variable, falling back to a default when unset
+func envOrDefault(name string, fallback string) string {
+        if value := os.Getenv(name); value != "" {
+                return value
+        }
+        return fallback
+}
+
+// Deadline of a phase that makes AI calls, long enough for a few attempts
+// at --ai-timeout each; two minutes with the default
+func aiPhaseTimeout(config *Config) time.Duration {
+        return max(2*time.Minute, 4*config.AITimeout)
+}
+
+// Longest --probe-timeout or --ai-timeout that does not get a warning
+const maxSensibleTimeout = 10 * time.Minute
+
+// Duration from an environment variable, or fallback when it is unset
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+        value := os.Getenv(name)
+        if value == "" {
+                return fallback, nil
+        }
+        duration, err := time.ParseDuration(value)
+        if err != nil {
+                return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+        }
+        return duration, nil
+}
+
+// Check whether a provider name is one we know how to talk to
+func isSupportedProvider(provider string) bool {
+        return containsString(supportedProviders, provider)
+}
+
+// Check whether a slice contains a string
+func containsString(values []string, value string) bool {
+        for _, v := range values {
+                if v == value {
+                        return true
+                }
+        }
+        return false
+}
+
+// Default model for a provider when --model is not given
+func defaultModel(provider string) string {
+        switch provider {
+        case ProviderAnthropic:
+                return DefaultAnthropicModel
+        case ProviderOllama:
+                return DefaultOllamaModel
+        case ProviderGemini:
+                return DefaultGeminiModel
+        case ProviderAzure:
+                // The deployment carries the model
+                return ""
+        case ProviderOpenRouter:
+                return DefaultOpenRouterModel
+        case ProviderGroq:
+                return DefaultGroqModel
+        case ProviderOpenAI:
+                return DefaultOpenAIModel
+        case ProviderMistral:
+                return DefaultMistralModel
+        case ProviderBedrock:
+                return DefaultBedrockModel
+        default:
+                return DefaultModel
+        }
+}
+
+// Get the API keys for the selected provider. Keys come from --api-key-file,
+// a comma-separated <NAME>S variable (e.g. PERPLEXITY_API_KEYS) and the
+// single-key variable, in that order.
+func getAPIKeys(config *Config) ([]string, error) {
+        envName := apiKeyEnv(config)
+        if envName == "" && len(config.APIKeyFiles) == 0 {
+                // Local servers need no key and Bedrock signs with AWS credentials
+                return []string{""}, nil
+        }
+
+        var keys []string
+        addKey := func(key string) {
+                if key = strings.TrimSpace(key); key != "" && !containsString(keys, key) {
+                        keys = append(keys, key)
+                }
+        }
+
+        for _, path := range config.APIKeyFiles {
+                data, err := os.ReadFile(path)
+                if err != nil {
+                        return nil, fmt.Errorf("reading API key file: %w", err)
+                }
+                for _, line := range strings.Split(string(data), "\n") {
+                        if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+                                addKey(line)
+                        }
+                }
+        }
+        if envName != "" {
+                for _, key := range strings.Split(os.Getenv(envName+"S"), ",") {
+                        addKey(key)
+                }
+                addKey(os.Getenv(envName))
+        }
+
+        if len(keys) > 0 {
+                return keys, nil
+        }
+        // Self-hosted servers behind --api-base often run without authentication
+        if config.APIBase != "" && config.APIKeyEnv == "" {
+                return []string{""}, nil
+        }
+        if envName == "" {
+                return nil, fmt.Errorf("no API keys found in --api-key-file")
+        }
+        return nil, fmt.Errorf("%s environment variable not set", envName)
+}
+
+// Pool of API keys shared by every request in the process. Keys that are
+// rejected or rate limited are marked dead so later calls skip them.
+type keyPool struct {
+        mu      sync.Mutex
+        keys    []string
+        current int
+        dead    map[int]string
+}
+
+var (
+        keyPoolsMu sync.Mutex
+        keyPools   = make(map[string]*keyPool)
+)
+
+// Get the process-wide pool for a set of keys, creating it on first use
+func sharedKeyPool(id string, keys []string) *keyPool {
+        keyPoolsMu.Lock()
+        defer keyPoolsMu.Unlock()
+
+        pool, ok := keyPools[id]
+        if !ok {
+                pool = &keyPool{keys: keys, dead: make(map[int]string)}
+                keyPools[id] = pool
+        }
+        return pool
+}
+
+// Return the current live key and its index, or false when all keys are dead
+func (p *keyPool) acquire() (int, string, bool) {
+        p.mu.Lock()
+        defer p.mu.Unlock()
+
+        for i := 0; i < len(p.keys); i++ {
+                index := (p.current + i) % len(p.keys)
+                if _, isDead := p.dead[index]; !isDead {
+                        p.current = index
+                        return index, p.keys[index], true
+                }
+        }
+        return 0, "", false
+}
+
+// Mark a key as failed so it is not used again in this process
+func (p *keyPool) markDead(index int, reason string) {
+        p.mu.Lock()
+        defer p.mu.Unlock()
+
+        p.dead[index] = reason
+        p.current = (index + 1) % len(p.keys)
+}
+
+// Describe why each key failed, without revealing the keys
+func (p *keyPool) summary() string {
+        p.mu.Lock()
+        defer p.mu.Unlock()
+
+        var reasons []string
+        for i := range p.keys {
+                if reason, isDead := p.dead[i]; isDead {
+                        reasons = append(reasons, fmt.Sprintf("key #%d: %s", i+1, reason))
+                }
+        }
+        return strings.Join(reasons, "; ")
+}
+
+// Returned once every key in a pool has failed. It unwraps to the last
+// failure so provider fallback still sees the status code.
+type keysExhaustedError struct {
+        count   int
+        summary string
+        last    error
+}
+
+func (e *keysExhaustedError) Error() string {
+        return fmt.Sprintf("all %d API keys failed (%s)", e.count, e.summary)
+}
+
+func (e *keysExhaustedError) Unwrap() error {
+        return e.last
+}
+
+// Provider that rotates through a key pool when a key is rejected (401) or rate limited (429)
+type rotatingProvider struct {
+        pool    *keyPool
+        build   func(apiKey string) (AIProvider, error)
+        name    string
+        verbose bool
+}
+
+func (p *rotatingProvider) Name() string {
+        return p.name
+}
+
+func (p *rotatingProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        var lastErr error
+        for {
+                index, apiKey, ok := p.pool.acquire()
+                if !ok {
+                        return RawCompletion{}, &keysExhaustedError{count: len(p.pool.keys), summary: p.pool.summary(), last: lastErr}
+                }
+
+                provider, err := p.build(apiKey)
+                if err != nil {
+                        return RawCompletion{}, err
+                }
+
+                if p.verbose {
+                        fmt.Fprintf(textOutput, "Using API key #%d of %d\n", index+1, len(p.pool.keys))
+                }
+
+                completion, err := provider.Suggest(ctx, input)
+                var apiErr *APIError
+                if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusTooManyRequests) {
+                        p.pool.markDead(index, err.Error())
+                        fmt.Fprintf(os.Stderr, "%sWarning: API key #%d failed with status %d, rotating to the next key%s\n", ColorYellow, index+1, apiErr.StatusCode, ColorReset)
+                        lastErr = err
+                        continue
+                }
+                return completion, err
+        }
+}
+
+// First wait before a failed AI request is retried without a Retry-After; it
+// doubles per attempt
+const aiRetryDelay = time.Second
+
+// Longest Retry-After wait honored; a longer one is cut to this
+const maxRetryAfter = time.Minute
+
+// Most --ai-retries
+const maxAIRetries = 10
+
+// Provider that retries rate limits (429) and server errors (5xx) up to
+// --ai-retries times. It waits as long as the response's Retry-After asks, up
+// to maxRetryAfter, or backs off exponentially with jitter when there is
+// none. Other errors are returned at once.
+type retryingProvider struct {
+        provider AIProvider
+        retries  int
+}
+
+func (p *retryingProvider) Name() string {
+        return p.provider.Name()
+}
+
+func (p *retryingProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        for attempt := 1; ; attempt++ {
+                completion, err := p.provider.Suggest(ctx, input)
+                var apiErr *APIError
+                if err == nil || !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusTooManyRequests && apiErr.StatusCode < 500) {
+                        return completion, err
+                }
+                if attempt > p.retries {
+                        if p.retries == 0 {
+                                return completion, err
+                        }
+                        return completion, fmt.Errorf("%w (gave up after %d retries)%s", err, p.retries, apiErrorBody(err))
+                }
+
+                delay := min(apiErr.RetryAfter, maxRetryAfter)
+                source := "Retry-After"
+                if delay == 0 {
+                        // Equal jitter: half the backoff, plus up to the other half
+                        delay = aiRetryDelay << (attempt - 1)
+                        delay = delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+                        source = "backoff"
+                }
+                if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+                        return completion, fmt.Errorf("%w (no time left to wait %s for a retry)%s", err, delay.Round(time.Millisecond), apiErrorBody(err))
+                }
+                fmt.Fprintf(os.Stderr, "%sWarning: %s answered %d, retrying in %s (%s, retry %d of %d)%s\n", ColorYellow, p.provider.Name(), apiErr.StatusCode, delay.Round(time.Millisecond), source, attempt, p.retries, ColorReset)
+                select {
+                case <-ctx.Done():
+                        return completion, err
+                case <-time.After(delay):
+                }
+        }
+}
+
+// Fast, cheap model raced against the configured one by --hedge; empty when
+// the provider has no obvious choice (local and deployment-based providers)
+func hedgeModel(provider string) string {
+        switch provider {
+        case ProviderPerplexity:
+                return "sonar"
+        case ProviderOpenAI:
+                return "gpt-4.1-nano"
+        case ProviderAnthropic:
+                return "claude-3-haiku-20240307"
+        case ProviderGemini:
+                return "gemini-1.5-flash-8b"
+        case ProviderOpenRouter:
+                return "openai/gpt-4.1-nano"
+        case ProviderGroq:
+                return "llama-3.1-8b-instant"
+        case ProviderMistral:
+                return "ministral-3b-latest"
+        default:
+                return ""
+        }
+}
+
+// Provider that sends the same prompt to the configured model and a fast model
+// and returns the first reply that parses into extensions
+type hedgedProvider struct {
+        primary      AIProvider
+        fast         AIProvider
+        primaryModel string
+        fastModel    string
+        verbose      bool
+}
+
+// Wrap a provider for --hedge, or return it unchanged when there is nothing to race
+func newHedgedProvider(config *Config, primary AIProvider) AIProvider {
+        fastModel := config.HedgeModel
+        if fastModel == "" {
+                fastModel = hedgeModel(config.Provider)
+        }
+        if fastModel == "" || fastModel == config.Model {
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "No separate hedge model for %s, sending a single request\n", config.Provider)
+                }
+                return primary
+        }
+
+        fastConfig := *config
+        fastConfig.Model = fastModel
+        fast, err := newProvider(&fastConfig)
+        if err != nil {
+                return primary
+        }
+
+        return &hedgedProvider{primary: primary, fast: fast, primaryModel: config.Model, fastModel: fastModel, verbose: config.Verbose}
+}
+
+func (p *hedgedProvider) Name() string {
+        return p.primary.Name()
+}
+
+func (p *hedgedProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        type hedgeResult struct {
+                model      string
+                completion RawCompletion
+                err        error
+                latency    time.Duration
+        }
+
+        raceCtx, cancel := context.WithCancel(ctx)
+        defer cancel()
+
+        // Buffered so the losing request can always finish and exit
+        results := make(chan hedgeResult, 2)
+        race := func(provider AIProvider, model string) {
+                start := time.Now()
+                completion, err := provider.Suggest(raceCtx, input)
+                if err == nil {
+                        _, err = extractExtensions(raceCtx, provider, input, completion)
+                }
+                results <- hedgeResult{model: model, completion: completion, err: err, latency: time.Since(start).Round(time.Millisecond)}
+        }
+        go race(p.primary, p.primaryModel)
+        go race(p.fast, p.fastModel)
+
+        var winner *hedgeResult
+        var failures []hedgeResult
+        for i := 0; i < 2; i++ {
+                result := <-results
+                switch {
+                case winner != nil:
+                        // The loser returns promptly once its context is cancelled
+                        if p.verbose {
+                                fmt.Fprintf(textOutput, "Hedge: %s cancelled after %s\n", displayModel(result.model), result.latency)
+                        }
+                case result.err == nil:
+                        winner = &result
+                        cancel()
+                        if p.verbose {
+                                fmt.Fprintf(textOutput, "Hedge: %s won in %s\n", displayModel(result.model), result.latency)
+                        }
+                default:
+                        failures = append(failures, result)
+                        if p.verbose {
+                                fmt.Fprintf(textOutput, "Hedge: %s failed after %s: %v\n", displayModel(result.model), result.latency, result.err)
+                        }
+                }
+        }
+
+        if winner == nil {
+                // Report the configured model's error; it is the one the user asked for
+                for _, failure := range failures {
+                        if failure.model == p.primaryModel {
+                                return RawCompletion{}, failure.err
+                        }
+                }
+                return RawCompletion{}, failures[0].err
+        }
+
+        completion := winner.completion
+        if winner.model != p.primaryModel {
+                completion.Model = winner.model
+        }
+        return completion, nil
+}
+
+// One AI exchange written by --record and read back by --replay
+type Exchange struct {
+        Timestamp  time.Time         `json:"timestamp"`
+        TargetURL  string            `json:"target_url"`
+        Headers    map[string]string `json:"headers"`
+        Provider   string            `json:"provider"`
+        Model      string            `json:"model"`
+        PromptHash string            `json:"prompt_hash"`
+        Calls      []RecordedCall    `json:"calls"`
+        Content    string            `json:"content"`
+        Structured bool              `json:"structured,omitempty"`
+        Extensions []string          `json:"extensions"`
+        Error      string            `json:"error,omitempty"`
+        // Stage-one stack and page hints the prompt was built with, reused on
+        // replay
+        Stack     *StackDescriptor `json:"stack,omitempty"`
+        PageHints string           `json:"page_hints,omitempty"`
+        Robots    string           `json:"robots,omitempty"`
+        Sitemap   string           `json:"sitemap,omitempty"`
+        Favicon   string           `json:"favicon,omitempty"`
+        // Target certificate, also kept for its names of other virtual hosts
+        Certificate *CertInfo `json:"certificate,omitempty"`
+        // Stack guessed by the local fingerprint rules
+        Technologies *StackDescriptor `json:"technologies,omitempty"`
+        // Distinguishing headers of well-known paths
+        PathProbes string `json:"path_probes,omitempty"`
+        // Endpoints and extensions named in the page's scripts
+        Scripts string `json:"scripts,omitempty"`
+        // Hosts and paths named by the Content-Security-Policy
+        CSP string `json:"csp,omitempty"`
+        // Link header entries that hint at a CMS API, API description or OAuth
+        Links []HeaderLink `json:"links,omitempty"`
+        // Mount point of a single-page app's shell found by calibration
+        SPAShell string `json:"spa_shell,omitempty"`
+        // Canonical name, reverse DNS names and hosting platform of the host
+        DNS *DNSInfo `json:"dns,omitempty"`
+        // Security header audit of the base response
+        Audit []AuditFinding `json:"audit,omitempty"`
+}
+
+// Raw HTTP request and response bodies of one API call. Request headers are
+// never recorded so API keys stay out of the files.
+type RecordedCall struct {
+        Endpoint   string `json:"endpoint"`
+        StatusCode int    `json:"status_code"`
+        Request    string `json:"request"`
+        Response   string `json:"response"`
+}
+
+// Context key under which getAIExtensions stores its exchangeRecorder
+type recorderKey struct{}
+
+// Collects the calls made while answering one prompt
+type exchangeRecorder struct {
+        mu    sync.Mutex
+        calls []RecordedCall
+}
+
+func (r *exchangeRecorder) add(call RecordedCall) {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        r.calls = append(r.calls, call)
+}
+
+// Assemble the exchange for a completion and its extraction result
+func (r *exchangeRecorder) exchange(urlStr string, headers map[string]string, provider string, model string, input PromptInput, completion RawCompletion, extensionsResp *ExtensionsResponse, extractErr error) Exchange {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+
+        exchange := Exchange{
+                Timestamp:  time.Now().UTC(),
+                TargetURL:  urlStr,
+                Headers:    headers,
+                Provider:   provider,
+                Model:      model,
+                PromptHash: promptHash(input),
+                Calls:      append([]RecordedCall(nil), r.calls...),
+                Content:    completion.Content,
+                Structured: completion.Structured,
+        }
+        if extensionsResp != nil {
+                exchange.Extensions = extensionsResp.Extensions
+        }
+        if extractErr != nil {
+                exchange.Error = extractErr.Error()
+        }
+        return exchange
+}
+
+// Transport for AI API calls that copies request and response bodies into
+// the exchangeRecorder carried by the request context, if any
+type recordingTransport struct{}
+
+func (recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        recorder, _ := req.Context().Value(recorderKey{}).(*exchangeRecorder)
+        if recorder == nil {
+                return http.DefaultTransport.RoundTrip(req)
+        }
+
+        var reqBody []byte
+        if req.GetBody != nil {
+                if body, err := req.GetBody(); err == nil {
+                        reqBody, _ = io.ReadAll(body)
+                        body.Close()
+                }
+        }
+
+        resp, err := http.DefaultTransport.RoundTrip(req)
+        if err != nil {
+                return nil, err
+        }
+
+        call := RecordedCall{Endpoint: req.URL.Redacted(), StatusCode: resp.StatusCode, Request: string(reqBody)}
+        resp.Body = &recordedBody{ReadCloser: resp.Body, onClose: func(body []byte) {
+                call.Response = string(body)
+                recorder.add(call)
+        }}
+        return resp, nil
+}
+
+// Response body that keeps a copy of everything read and hands it over on Close
+type recordedBody struct {
+        io.ReadCloser
+        buf     bytes.Buffer
+        onClose func(body []byte)
+        once    sync.Once
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+        n, err := b.ReadCloser.Read(p)
+        b.buf.Write(p[:n])
+        return n, err
+}
+
+func (b *recordedBody) Close() error {
+        b.once.Do(func() { b.onClose(b.buf.Bytes()) })
+        return b.ReadCloser.Close()
+}
+
+// Hash identifying the prompt an exchange answered
+func promptHash(input PromptInput) string {
+        return sha256Hex([]byte(input.System + "\n" + input.Prompt))
+}
+
+// Write an exchange to a new timestamped file in dir
+func writeExchange(dir string, exchange Exchange) (string, error) {
+        if err := os.MkdirAll(dir, 0o755); err != nil {
+                return "", fmt.Errorf("creating record directory: %w", err)
+        }
+        data, err := json.MarshalIndent(exchange, "", "  ")
+        if err != nil {
+                return "", fmt.Errorf("marshaling exchange: %w", err)
+        }
+
+        name := fmt.Sprintf("ffufai-%s-%s.json", exchange.Timestamp.Format("20060102T150405.000000"), exchange.Provider)
+        path := filepath.Join(dir, name)
+        if err := os.WriteFile(path, data, 0o600); err != nil {
+                return "", fmt.Errorf("writing exchange: %w", err)
+        }
+        return path, nil
+}
+
+// Read an exchange written by --record
+func loadExchange(path string) (*Exchange, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, fmt.Errorf("reading replay file: %w", err)
+        }
+        var exchange Exchange
+        if err := json.Unmarshal(data, &exchange); err != nil {
+                return nil, fmt.Errorf("parsing replay file %s: %w", path, err)
+        }
+        return &exchange, nil
+}
+
+// Provider that answers with the content of a recorded exchange
+type replayProvider struct {
+        exchange *Exchange
+}
+
+func (p *replayProvider) Name() string {
+        return "replay"
+}
+
+func (p *replayProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        return RawCompletion{Content: p.exchange.Content, Structured: p.exchange.Structured}, nil
+}
+
+// Run a recorded reply through the normal extraction and validation path
+func replayExtensions(ctx context.Context, config *Config, input PromptInput) (*ExtensionsResponse, error) {
+        exchange := config.Replay
+        if hash := promptHash(input); hash != exchange.PromptHash {
+                if !config.ReplayForce {
+                        return nil, fmt.Errorf("recorded prompt does not match the current prompt (check -u and --max-extensions), use --replay-force to replay anyway")
+                }
+                fmt.Fprintf(os.Stderr, "%sWarning: recorded prompt differs from the current prompt, replaying anyway%s\n", ColorYellow, ColorReset)
+        }
+
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "Replaying %s exchange recorded at %s\n", exchange.Provider, exchange.Timestamp.Format(time.RFC3339))
+                fmt.Fprintf(textOutput, "AI Response: %s\n", exchange.Content)
+        }
+
+        provider := &replayProvider{exchange: exchange}
+        completion, _ := provider.Suggest(ctx, input)
+        extensionsResp, err := extractExtensions(ctx, provider, input, completion)
+        if err != nil {
+                return nil, err
+        }
+        extensionsResp.Provider = exchange.Provider
+        extensionsResp.Model = exchange.Model
+        return extensionsResp, nil
+}
+
+// Headers every server sends that say nothing about the target
+var uninformativeHeaders = []string{"Date", "Content-Length", "Connection", "Keep-Alive", "Transfer-Encoding", "Cache-Control", "Expires", "Pragma", "Vary", "Accept-Ranges"}
+
+// A HEAD response with fewer informative headers than this is retried as a GET
+const minUsefulHeaders = 2
+
+// Most body bytes read from the GET fallback before the connection is closed
+const headerProbeBodyMax = 4096
+
+// Get HTTP headers for a URL with proper timeout and context. Servers that
+// reject HEAD (405, 501) or answer it with next to no headers are asked again
+// with a GET, whose body is read only briefly and discarded.
+// An OPTIONS request runs alongside under the same timeout and its allowed
+// methods are added as OptionsAllowHeader. Redirects within the target's host
+// are followed; one to another host is only followed with
+// --follow-host-redirects, otherwise its response is analyzed. Also returns
+// the leaf certificate of HTTPS targets.
+func getHeaders(ctx context.Context, config *Config, urlStr string) (map[string]string, *CertInfo, error) {
+        target, err := url.Parse(urlStr)
+        if err != nil {
+                return nil, nil, fmt.Errorf("parsing URL: %w", err)
+        }
+        client := targetClient(config)
+        var hops []string
+        offHost := ""
+        client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+                if len(via) >= maxProbeRedirects {
+                        return fmt.Errorf("stopped after %d redirects", maxProbeRedirects)
+                }
+                hops = append(hops, fmt.Sprintf("%s: %s -> %s", req.Response.Status, via[len(via)-1].URL, req.URL))
+                if !strings.EqualFold(req.URL.Hostname(), target.Hostname()) {
+                        offHost = req.URL.String()
+                        if !config.FollowHostRedirects {
+                                return http.ErrUseLastResponse
+                        }
+                }
+                return nil
+        }
+
+        optionsDone := make(chan string, 1)
+        if config.OptionsProbe {
+                // Its own client, since the redirect tracking above is not safe
+                // to share between goroutines
+                optionsClient := *client
+                optionsClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+                        return http.ErrUseLastResponse
+                }
+                go func() {
+                        optionsDone <- probeOptions(ctx, &optionsClient, urlStr)
+                }()
+        } else {
+                optionsDone <- ""
+        }
+        withOptions := func(headers map[string]string) map[string]string {
+                reportRedirects(config, urlStr, hops, offHost)
+                if allow := <-optionsDone; allow != "" {
+                        headers[OptionsAllowHeader] = allow
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "OPTIONS allows %s\n", allow)
+                        }
+                }
+                return headers
+        }
+
+        headers, status, cert, err := probeWithRetries(ctx, config, client, "HEAD", urlStr)
+        if err != nil {
+                if certificateError(err) {
+                        return nil, nil, fmt.Errorf("%w (pass --cacert with the issuing CA, or -k, to probe it anyway)", err)
+                }
+                return nil, nil, err
+        }
+        if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented && usefulHeaderCount(headers) >= minUsefulHeaders {
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "Headers retrieved with HEAD\n")
+                }
+                return withOptions(headers), cert, nil
+        }
+
+        headHops, headOffHost := hops, offHost
+        hops, offHost = nil, ""
+        fallback, _, _, err := probeHeaders(ctx, client, "GET", urlStr)
+        if err != nil {
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "GET fallback failed, using the HEAD response: %v\n", err)
+                }
+                hops, offHost = headHops, headOffHost
+                return withOptions(headers), cert, nil
+        }
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "HEAD answered %s with %d informative headers, headers retrieved with GET\n", headers["Status-Code"], usefulHeaderCount(headers))
+        }
+        return withOptions(fallback), cert, nil
+}
+
+// First delay before the header probe is retried; it doubles per attempt
+const probeRetryDelay = 500 * time.Millisecond
+
+// Most --probe-retries
+const maxProbeRetries = 10
+
+// Send a probe and retry it up to --probe-retries times after a network
+// error or a 502, 503 or 504, waiting with exponential backoff and jitter in
+// between. Certificate errors, TLS alerts and 4xx answers are final. Every
+// attempt has the client's --probe-timeout, and no retry starts after the
+// context's deadline. The last answer or error is returned as it was.
+func probeWithRetries(ctx context.Context, config *Config, client *http.Client, method string, urlStr string) (map[string]string, int, *CertInfo, error) {
+        for attempt := 1; ; attempt++ {
+                headers, status, cert, err := probeHeaders(ctx, client, method, urlStr)
+                if attempt > config.ProbeRetries || !transientProbeFailure(status, err) || ctx.Err() != nil {
+                        return headers, status, cert, err
+                }
+
+                // Equal jitter: half the backoff, plus up to the other half
+                delay := probeRetryDelay << (attempt - 1)
+                delay = delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+                if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+                        return headers, status, cert, err
+                }
+                if config.Verbose {
+                        failure := fmt.Sprint(err)
+                        if err == nil {
+                                failure = "answered " + headers["Status-Code"]
+                        }
+                        fmt.Fprintf(textOutput, "%s attempt %d of %d failed (%s), retrying in %s\n", method, attempt, config.ProbeRetries+1, failure, delay.Round(time.Millisecond))
+                }
+                select {
+                case <-ctx.Done():
+                        return headers, status, cert, err
+                case <-time.After(delay):
+                }
+        }
+}
+
+// Whether a probe failed in a way another attempt may not: a network error
+// other than a certificate or TLS alert, or a gateway status
+func transientProbeFailure(status int, err error) bool {
+        if err == nil {
+                return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+        }
+        var urlErr *url.Error
+        var alert tls.AlertError
+        return errors.As(err, &urlErr) && !certificateError(err) && !errors.As(err, &alert)
+}
+
+// Whether the target's certificate failed verification
+func certificateError(err error) bool {
+        var unknownAuthority x509.UnknownAuthorityError
+        var invalid x509.CertificateInvalidError
+        var hostname x509.HostnameError
+        return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
+// Most redirects the header probe follows
+const maxProbeRedirects = 10
+
+// Header naming the URL whose response the other headers came from
+const ResponseURLHeader = "Response-URL"
+
+// Header holding the protocol the response came over, such as HTTP/2.0
+const ProtocolHeader = "Protocol"
+
+// Show the redirect hops in verbose mode and warn when the probe left the
+// target's host
+func reportRedirects(config *Config, urlStr string, hops []string, offHost string) {
+        if config.Verbose {
+                for _, hop := range hops {
+                        fmt.Fprintf(textOutput, "Redirect %s\n", hop)
+                }
+        }
+        if offHost == "" {
+                return
+        }
+        if config.FollowHostRedirects {
+                fmt.Fprintf(os.Stderr, "%s%sWarning: %s redirects to another host, analyzing the headers of %s%s\n", ColorYellow, ColorBold, urlStr, offHost, ColorReset)
+        } else {
+                fmt.Fprintf(os.Stderr, "%s%sWarning: %s redirects to another host (%s), analyzing the redirect response itself; pass --follow-host-redirects to analyze the destination%s\n", ColorYellow, ColorBold, urlStr, offHost, ColorReset)
+        }
+        fmt.Fprintf(os.Stderr, "%sffuf still fuzzes %s; the FUZZ URL is never rewritten%s\n", ColorYellow, config.URL, ColorReset)
+}
+
+// Header the OPTIONS probe's allowed methods are stored under, apart from
+// any Allow header of the HEAD or GET response
+const OptionsAllowHeader = "Options-Allow"
+
+// Methods an OPTIONS request says the URL accepts, from its Allow and
+// Access-Control-Allow-Methods headers, or "" when it answers nothing useful
+func probeOptions(ctx context.Context, client *http.Client, urlStr string) string {
+        req, err := http.NewRequestWithContext(ctx, "OPTIONS", urlStr, nil)
+        if err != nil {
+                return ""
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return ""
+        }
+        defer resp.Body.Close()
+        io.Copy(io.Discard, io.LimitReader(resp.Body, headerProbeBodyMax))
+        if resp.StatusCode >= 400 {
+                return ""
+        }
+
+        var methods []string
+        for _, name := range []string{"Allow", "Access-Control-Allow-Methods"} {
+                for _, method := range strings.Split(resp.Header.Get(name), ",") {
+                        if method = strings.ToUpper(strings.TrimSpace(method)); method != "" && !containsString(methods, method) {
+                                methods = append(methods, method)
+                        }
+                }
+        }
+        return strings.Join(methods, ", ")
+}
+
+// Whether the OPTIONS probe's allowed methods, if any, include method
+func optionsAllows(headers map[string]string, method string) bool {
+        allow, ok := headers[OptionsAllowHeader]
+        if !ok || strings.Contains(allow, "*") {
+                return true
+        }
+        return optionsLists(headers, method)
+}
+
+// Whether the OPTIONS probe named method itself; a missing list or a
+// wildcard does not count
+func optionsLists(headers map[string]string, method string) bool {
+        for _, allowed := range strings.Split(headers[OptionsAllowHeader], ",") {
+                if strings.EqualFold(strings.TrimSpace(allowed), method) {
+                        return true
+                }
+        }
+        return false
+}
+
+// Paths next to the FUZZ directory that often reveal the stack when the
+// directory itself answers with generic headers (--probe-paths)
+const defaultProbePaths = "index.php,index.html,index.jsp,default.aspx,login,admin/,api/,api/health"
+
+// Requests in flight and total time for the well-known path probes
+const (
+        maxPathProbes       = 4
+        pathProbeTimeout    = 2 * time.Second
+        maxPathProbeEntries = 16
+)
+
+// Response of one well-known path, with only the headers that differ from the
+// base page
+type PathProbe struct {
+        Path    string
+        Status  int
+        Headers map[string]string
+}
+
+// Headers of a well-known path worth reporting
+var pathProbeHeaders = []string{"Server", "X-Powered-By", "Content-Type", "Set-Cookie", "X-AspNet-Version", "X-Generator", "Location", "Www-Authenticate"}
+
+// Request the well-known paths relative to baseURL a few at a time, all under
+// one short deadline. Paths that fail or time out are left out.
+func probePaths(ctx context.Context, config *Config, baseURL string, base map[string]string) []PathProbe {
+        target, err := url.Parse(baseURL)
+        if err != nil {
+                return nil
+        }
+        ctx, cancel := context.WithTimeout(ctx, pathProbeTimeout)
+        defer cancel()
+        client := targetClient(config)
+        client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+                return http.ErrUseLastResponse
+        }
+
+        results := make([]*PathProbe, len(config.ProbePaths))
+        slots := make(chan struct{}, maxPathProbes)
+        var wg sync.WaitGroup
+        for i, probePath := range config.ProbePaths {
+                wg.Add(1)
+                go func(i int, probePath string) {
+                        defer wg.Done()
+                        slots <- struct{}{}
+                        defer func() { <-slots }()
+
+                        probeURL, err := target.Parse(probePath)
+                        if err != nil {
+                                return
+                        }
+                        req, err := http.NewRequestWithContext(ctx, "HEAD", probeURL.String(), nil)
+                        if err != nil {
+                                return
+                        }
+                        req.Header.Set("User-Agent", "ffufai/"+Version)
+                        resp, err := client.Do(req)
+                        if err != nil {
+                                return
+                        }
+                        resp.Body.Close()
+
+                        probe := &PathProbe{Path: probeURL.Path, Status: resp.StatusCode, Headers: make(map[string]string)}
+                        for _, name := range pathProbeHeaders {
+                                value := resp.Header.Get(name)
+                                if name == "Set-Cookie" {
+                                        value = cookieNames(strings.Join(resp.Header.Values(name), "\n"))
+                                        if value == cookieNames(base[name]) {
+                                                continue
+                                        }
+                                } else if value == base[name] {
+                                        continue
+                                }
+                                if value != "" {
+                                        probe.Headers[name] = truncateRunes(value, DefaultHeaderValueMax)
+                                }
+                        }
+                        results[i] = probe
+                }(i, probePath)
+        }
+        wg.Wait()
+
+        var probes []PathProbe
+        for _, probe := range results {
+                if probe != nil {
+                        probes = append(probes, *probe)
+                }
+        }
+        return probes
+}
+
+// One line per path for the prompt, naming each path's distinguishing
+// headers; paths without any are left out
+func pathProbeSummary(probes []PathProbe) string {
+        var lines []string
+        for _, probe := range probes {
+                if len(probe.Headers) == 0 {
+                        continue
+                }
+                names := make([]string, 0, len(probe.Headers))
+                for name := range probe.Headers {
+                        names = append(names, name)
+                }
+                sort.Strings(names)
+                var parts []string
+                for _, name := range names {
+                        label := name
+                        if name == "Set-Cookie" {
+                                label = "cookies"
+                        }
+                        parts = append(parts, label+": "+sanitizeHint(probe.Headers[name]))
+                }
+                lines = append(lines, fmt.Sprintf("%s (%d): %s", probe.Path, probe.Status, strings.Join(parts, "; ")))
+                if len(lines) == maxPathProbeEntries {
+                        break
+                }
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Parse the comma-separated --probe-paths list into paths relative to the
+// FUZZ directory
+func parseProbePaths(list string) []string {
+        var paths []string
+        for _, probePath := range strings.Split(list, ",") {
+                if probePath = strings.TrimLeft(strings.TrimSpace(probePath), "/"); probePath != "" {
+                        paths = append(paths, probePath)
+                }
+        }
+        return paths
+}
+
+// How long the DNS phase may take, and how many of the target's addresses
+// get a reverse lookup
+const (
+        dnsTimeout    = 3 * time.Second
+        maxPTRLookups = 2
+)
+
+// Hosting platforms recognized by the suffix of a canonical name or a
+// reverse DNS name, with the stack each one implies
+var hostingPlatforms = []struct {
+        Suffix string
+        Stack  StackDescriptor
+}{
+        {"azurewebsites.net", StackDescriptor{Hosting: "Azure App Service"}},
+        {"cloudapp.azure.com", StackDescriptor{Hosting: "Azure"}},
+        {"cloudapp.net", StackDescriptor{Hosting: "Azure"}},
+        {"azureedge.net", StackDescriptor{Hosting: "Azure CDN"}},
+        {"azurefd.net", StackDescriptor{Hosting: "Azure Front Door"}},
+        {"trafficmanager.net", StackDescriptor{Hosting: "Azure Traffic Manager"}},
+        {"herokuapp.com", StackDescriptor{Hosting: "Heroku"}},
+        {"herokudns.com", StackDescriptor{Hosting: "Heroku"}},
+        {"github.io", StackDescriptor{Hosting: "GitHub Pages", Server: "github.com", Framework: "static site"}},
+        {"netlify.app", StackDescriptor{Hosting: "Netlify", Framework: "static site"}},
+        {"netlify.com", StackDescriptor{Hosting: "Netlify", Framework: "static site"}},
+        {"vercel.app", StackDescriptor{Hosting: "Vercel"}},
+        {"vercel-dns.com", StackDescriptor{Hosting: "Vercel"}},
+        {"pages.dev", StackDescriptor{Hosting: "Cloudflare Pages", Framework: "static site"}},
+        {"workers.dev", StackDescriptor{Hosting: "Cloudflare Workers"}},
+        {"appspot.com", StackDescriptor{Hosting: "Google App Engine"}},
+        {"run.app", StackDescriptor{Hosting: "Google Cloud Run"}},
+        {"web.app", StackDescriptor{Hosting: "Firebase Hosting", Framework: "static site"}},
+        {"firebaseapp.com", StackDescriptor{Hosting: "Firebase Hosting", Framework: "static site"}},
+        {"ghs.googlehosted.com", StackDescriptor{Hosting: "Google Sites"}},
+        {"bc.googleusercontent.com", StackDescriptor{Hosting: "Google Cloud"}},
+        {"1e100.net", StackDescriptor{Hosting: "Google"}},
+        {"cloudfront.net", StackDescriptor{Hosting: "Amazon CloudFront"}},
+        {"elb.amazonaws.com", StackDescriptor{Hosting: "AWS Elastic Load Balancing"}},
+        {"elasticbeanstalk.com", StackDescriptor{Hosting: "AWS Elastic Beanstalk"}},
+        {"s3-website.amazonaws.com", StackDescriptor{Hosting: "Amazon S3", Framework: "static site"}},
+        {"s3.amazonaws.com", StackDescriptor{Hosting: "Amazon S3", Framework: "static site"}},
+        {"amplifyapp.com", StackDescriptor{Hosting: "AWS Amplify"}},
+        {"compute.amazonaws.com", StackDescriptor{Hosting: "AWS EC2"}},
+        {"compute-1.amazonaws.com", StackDescriptor{Hosting: "AWS EC2"}},
+        {"fastly.net", StackDescriptor{Hosting: "Fastly"}},
+        {"edgekey.net", StackDescriptor{Hosting: "Akamai"}},
+        {"edgesuite.net", StackDescriptor{Hosting: "Akamai"}},
+        {"akamaiedge.net", StackDescriptor{Hosting: "Akamai"}},
+        {"akamaitechnologies.com", StackDescriptor{Hosting: "Akamai"}},
+        {"fly.dev", StackDescriptor{Hosting: "Fly.io"}},
+        {"onrender.com", StackDescriptor{Hosting: "Render"}},
+        {"wpengine.com", StackDescriptor{Hosting: "WP Engine", Language: "php", CMS: "wordpress"}},
+        {"wpenginepowered.com", StackDescriptor{Hosting: "WP Engine", Language: "php", CMS: "wordpress"}},
+        {"kinsta.cloud", StackDescriptor{Hosting: "Kinsta", Language: "php", CMS: "wordpress"}},
+        {"wordpress.com", StackDescriptor{Hosting: "WordPress.com", Language: "php", CMS: "wordpress"}},
+        {"pantheonsite.io", StackDescriptor{Hosting: "Pantheon", Language: "php"}},
+        {"acquia-sites.com", StackDescriptor{Hosting: "Acquia", Language: "php", CMS: "drupal"}},
+        {"myshopify.com", StackDescriptor{Hosting: "Shopify", CMS: "shopify"}},
+        {"squarespace.com", StackDescriptor{Hosting: "Squarespace", CMS: "squarespace"}},
+        {"wixdns.net", StackDescriptor{Hosting: "Wix", CMS: "wix"}},
+        {"readthedocs.io", StackDescriptor{Hosting: "Read the Docs", Framework: "sphinx"}},
+        {"zendesk.com", StackDescriptor{Hosting: "Zendesk"}},
+        {"your-server.de", StackDescriptor{Hosting: "Hetzner"}},
+        {"linodeusercontent.com", StackDescriptor{Hosting: "Linode"}},
+        {"vultrusercontent.com", StackDescriptor{Hosting: "Vultr"}},
+}
+
+// What DNS says about the target's host: the canonical name its CNAME chain
+// ends at, the reverse DNS names of its addresses, and the hosting platform
+// either of them points to
+type DNSInfo struct {
+        CanonicalName string           `json:"canonical_name,omitempty"`
+        PTR           []string         `json:"ptr,omitempty"`
+        Platform      *StackDescriptor `json:"platform,omitempty"`
+}
+
+func (d *DNSInfo) String() string {
+        var lines []string
+        if d.CanonicalName != "" {
+                lines = append(lines, "canonical name: "+d.CanonicalName)
+        }
+        if len(d.PTR) > 0 {
+                lines = append(lines, "reverse DNS: "+strings.Join(d.PTR, " "))
+        }
+        if d.Platform != nil {
+                lines = append(lines, "hosting: "+d.Platform.Hosting)
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Platform a DNS name belongs to, nil when its suffix is not in the table
+func hostingPlatform(name string) *StackDescriptor {
+        name = strings.ToLower(strings.TrimSuffix(name, "."))
+        for _, platform := range hostingPlatforms {
+                if name == platform.Suffix || strings.HasSuffix(name, "."+platform.Suffix) {
+                        stack := platform.Stack
+                        return &stack
+                }
+        }
+        return nil
+}
+
+// Look up the canonical name of the target's host and the reverse DNS names
+// of its first addresses, all within dnsTimeout. Lookups that fail are left
+// out; nil when none found anything.
+func lookupDNS(ctx context.Context, resolver *net.Resolver, host string) *DNSInfo {
+        ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+        defer cancel()
+
+        info := &DNSInfo{}
+        addrs := []string{host}
+        if net.ParseIP(host) == nil {
+                if cname, err := resolver.LookupCNAME(ctx, host); err == nil && !strings.EqualFold(strings.TrimSuffix(cname, "."), host) {
+                        info.CanonicalName = strings.TrimSuffix(cname, ".")
+                }
+                addrs, _ = resolver.LookupHost(ctx, host)
+        }
+        for i, addr := range addrs {
+                if i == maxPTRLookups {
+                        break
+                }
+                names, _ := resolver.LookupAddr(ctx, addr)
+                for _, name := range names {
+                        if name = strings.TrimSuffix(name, "."); !containsString(info.PTR, name) {
+                                info.PTR = append(info.PTR, name)
+                        }
+                }
+        }
+
+        for _, name := range append([]string{info.CanonicalName}, info.PTR...) {
+                if info.Platform = hostingPlatform(name); info.Platform != nil {
+                        break
+                }
+        }
+        if info.CanonicalName == "" && len(info.PTR) == 0 {
+                return nil
+        }
+        return info
+}
+
+// HTTP client for probing the target. It goes through ffuf's -x proxy when
+// one is given, or the proxy from the environment, skips certificate
+// verification with --insecure, and sends the user's -H headers.
+func targetClient(config *Config) *http.Client {
+        var transport http.RoundTripper = targetTransport(config)
+        if config.SNI != "" {
+                sni := targetTransport(config)
+                sni.TLSClientConfig.ServerName = config.SNI
+                transport = sniTransport{host: targetHost(config.URL), target: sni, other: transport}
+        }
+        if len(config.ProbeHeaders) > 0 {
+                transport = headerTransport{base: transport, host: targetHost(config.URL), headers: config.ProbeHeaders}
+        }
+        return &http.Client{Timeout: config.ProbeTimeout, Transport: transport}
+}
+
+// Transport of the target client that connects to the target host with
+// the --sni name. Requests to other hosts, such as a redirect elsewhere,
+// keep their own name.
+type sniTransport struct {
+        host   string
+        target http.RoundTripper
+        other  http.RoundTripper
+}
+
+func (t sniTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        if strings.EqualFold(req.URL.Hostname(), t.host) {
+                return t.target.RoundTrip(req)
+        }
+        return t.other.RoundTrip(req)
+}
+
+// Request header given with -H or in --headers-file
+type RequestHeader struct {
+        Name  string
+        Value string
+}
+
+// Transport of the target client that adds the probe headers to requests
+// for the target host. Requests to other hosts, such as a favicon on a CDN
+// or a redirect elsewhere, only get the User-Agent, so cookies and tokens
+// stay with the target.
+type headerTransport struct {
+        base    http.RoundTripper
+        host    string
+        headers []RequestHeader
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+        sameHost := strings.EqualFold(req.URL.Hostname(), t.host)
+        req = req.Clone(req.Context())
+        for _, header := range t.headers {
+                if !sameHost && !strings.EqualFold(header.Name, "User-Agent") {
+                        continue
+                }
+                if strings.EqualFold(header.Name, "Host") {
+                        req.Host = header.Value
+                        continue
+                }
+                req.Header.Set(header.Name, header.Value)
+        }
+        return t.base.RoundTrip(req)
+}
+
+// Keyword marking the fuzzed position: --keyword when given, FUZZ when the
+// URL holds it, otherwise the keyword of a -w file:KEYWORD in the URL path,
+// preferring one in its last segment
+func urlKeyword(keywordFlag, target string, wordlists map[string]string) string {
+        if keywordFlag != "" {
+                return keywordFlag
+        }
+        if strings.Contains(target, "FUZZ") {
+                return "FUZZ"
+        }
+        path := target
+        if parsed, err := url.Parse(target); err == nil {
+                path = parsed.Path
+        }
+        var found []string
+        for keyword := range wordlists {
+                if strings.Contains(path, keyword) {
+                        found = append(found, keyword)
+                }
+        }
+        if len(found) == 0 {
+                return "FUZZ"
+        }
+        sort.Strings(found)
+        last := path[strings.LastIndex(path, "/")+1:]
+        for _, keyword := range found {
+                if strings.Contains(last, keyword) {
+                        return keyword
+                }
+        }
+        return found[0]
+}
+
+// Hostname of a target URL, with FUZZ in the URL treated as empty
+func targetHost(target string) string {
+        targetURL, err := url.Parse(strings.Replace(target, "FUZZ", "", 1))
+        if err != nil {
+                return ""
+        }
+        return targetURL.Hostname()
+}
+
+// Split a "Name: value" header line as ffuf's -H takes it. The name must be
+// a token, and no part of the line may hold CR, LF or another control
+// character but tab, which would let a value smuggle in extra headers. The
+// value is kept byte for byte apart from the spaces after the colon.
+func parseHeaderLine(line string) (RequestHeader, error) {
+        for _, c := range []byte(line) {
+                switch {
+                case c == '\r' || c == '\n':
+                        return RequestHeader{}, fmt.Errorf("header %q contains CR or LF", line)
+                case (c < ' ' && c != '\t') || c == 0x7f:
+                        return RequestHeader{}, fmt.Errorf("header %q contains a control character", line)
+                }
+        }
+        name, value, ok := strings.Cut(line, ":")
+        if !ok || !headerNameRegex.MatchString(name) {
+                return RequestHeader{}, fmt.Errorf("header %q is not \"Name: value\"", line)
+        }
+        return RequestHeader{Name: name, Value: strings.TrimLeft(value, " \t")}, nil
+}
+
+// Header lines of a --headers-file: one "Name: value" per line, with blank
+// lines and lines starting with # skipped. A CRLF line ending is not part
+// of the value, and a malformed line fails with its line number.
+func readHeadersFile(path string) ([]string, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+        var lines []string
+        for i, line := range strings.Split(string(data), "\n") {
+                line = strings.TrimSuffix(line, "\r")
+                if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                if _, err := parseHeaderLine(line); err != nil {
+                        return nil, fmt.Errorf("line %d: %w", i+1, err)
+                }
+                lines = append(lines, line)
+        }
+        return lines, nil
+}
+
+// A raw HTTP request as --request reads it
+type RawRequest struct {
+        Method  string
+        Target  string // path, or an absolute URL
+        Proto   string
+        Headers []RequestHeader
+        Body    string
+
+        // A chunked body or folded header lines, which ffuf reads verbatim,
+        // so ffuf gets a rewritten copy
+        Rewritten bool
+}
+
+// Parse a raw HTTP request as Burp saves it: a request line, headers up to
+// the first empty line and the body. CRLF and LF line endings both work,
+// folded header lines are joined and a chunked body is decoded. Like ffuf,
+// a single line ending after the body is not part of it.
+func parseRawRequest(data []byte) (*RawRequest, error) {
+        rest := strings.TrimLeft(string(data), "\r\n")
+        var lines []string
+        for rest != "" {
+                line, after, found := strings.Cut(rest, "\n")
+                line = strings.TrimSuffix(line, "\r")
+                rest = after
+                if line == "" {
+                        break
+                }
+                lines = append(lines, line)
+                if !found {
+                        break
+                }
+        }
+        if len(lines) == 0 {
+                return nil, fmt.Errorf("the request is empty")
+        }
+        parts := strings.Fields(lines[0])
+        if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+                return nil, fmt.Errorf("line 1: %q is not \"METHOD TARGET HTTP/VERSION\"", lines[0])
+        }
+        request := &RawRequest{Method: parts[0], Target: parts[1], Proto: parts[2]}
+
+        chunked := false
+        for i, line := range lines[1:] {
+                if (line[0] == ' ' || line[0] == '\t') && len(request.Headers) > 0 {
+                        last := &request.Headers[len(request.Headers)-1]
+                        last.Value += " " + strings.TrimSpace(line)
+                        request.Rewritten = true
+                        continue
+                }
+                header, err := parseHeaderLine(line)
+                if err != nil {
+                        return nil, fmt.Errorf("line %d: %w", i+2, err)
+                }
+                if strings.EqualFold(header.Name, "Transfer-Encoding") && strings.Contains(strings.ToLower(header.Value), "chunked") {
+                        chunked = true
+                }
+                request.Headers = append(request.Headers, header)
+        }
+
+        if chunked {
+                body, err := decodeChunked(rest)
+                if err != nil {
+                        return nil, fmt.Errorf("chunked body: %w", err)
+                }
+                request.Body, request.Rewritten = body, true
+        } else if strings.HasSuffix(rest, "\r\n") {
+                request.Body = strings.TrimSuffix(rest, "\r\n")
+        } else {
+                request.Body = strings.TrimSuffix(rest, "\n")
+        }
+        return request, nil
+}
+
+// Decode a chunked body. Each size line and chunk may end in CRLF or LF,
+// and trailers after the last chunk are dropped.
+func decodeChunked(body string) (string, error) {
+        var decoded strings.Builder
+        for {
+                if body == "" {
+                        return "", fmt.Errorf("no last chunk")
+                }
+                line, rest, _ := strings.Cut(body, "\n")
+                sizeField, _, _ := strings.Cut(line, ";")
+                size, err := strconv.ParseUint(strings.TrimSpace(sizeField), 16, 31)
+                if err != nil {
+                        return "", fmt.Errorf("chunk size %q is not hexadecimal", strings.TrimSpace(sizeField))
+                }
+                if size == 0 {
+                        return decoded.String(), nil
+                }
+                if uint64(len(rest)) < size {
+                        return "", fmt.Errorf("chunk of %d bytes is cut short", size)
+                }
+                decoded.WriteString(rest[:size])
+                rest = rest[size:]
+                if !strings.HasPrefix(rest, "\r\n") && !strings.HasPrefix(rest, "\n") {
+                        return "", fmt.Errorf("chunk of %d bytes is longer than its size", size)
+                }
+                body = strings.TrimPrefix(strings.TrimPrefix(rest, "\r"), "\n")
+        }
+}
+
+// Target URL of a raw request: its request line when that holds an absolute
+// URL, otherwise proto://Host followed by the path, as ffuf builds it
+func rawRequestURL(request *RawRequest, proto string) (string, error) {
+        if strings.HasPrefix(request.Target, "http://") || strings.HasPrefix(request.Target, "https://") {
+                return request.Target, nil
+        }
+        if !strings.HasPrefix(request.Target, "/") {
+                return "", fmt.Errorf("request target %q is neither a path nor an absolute URL", request.Target)
+        }
+        for _, header := range request.Headers {
+                if strings.EqualFold(header.Name, "Host") {
+                        return proto + "://" + header.Value + request.Target, nil
+                }
+        }
+        return "", fmt.Errorf("the request has no Host header, so its target is unknown")
+}
+
+// Parts of a raw request holding the keyword: the URL, a header or the body
+func requestKeywordPlaces(request *RawRequest, keyword string) []string {
+        var places []string
+        if strings.Contains(request.Target, keyword) {
+                places = append(places, "the URL")
+        }
+        for _, header := range request.Headers {
+                if strings.Contains(header.Name+":"+header.Value, keyword) {
+                        places = append(places, "the "+header.Name+" header")
+                }
+        }
+        if strings.Contains(request.Body, keyword) {
+                places = append(places, "the body")
+        }
+        return places
+}
+
+// The request as ffuf options, for the checks that read -X, -H and -d
+func rawRequestArgs(request *RawRequest) []string {
+        args := []string{"-X", request.Method}
+        for _, header := range request.Headers {
+                args = append(args, "-H", header.Name+": "+header.Value)
+        }
+        if request.Body != "" {
+                args = append(args, "-d", request.Body)
+        }
+        return args
+}
+
+// Headers of a raw request for the prompts, which get them instead of the
+// target's response headers. Credentials are redacted and cookies keep
+// only their names, as in a printed command.
+func requestPromptHeaders(request *RawRequest) map[string]string {
+        headers := map[string]string{"Request": request.Method + " " + request.Target}
+        args := redactArgs(rawRequestArgs(request))
+        for i := 2; i+1 < len(args); i += 2 {
+                if args[i] != "-H" {
+                        continue
+                }
+                name, value, _ := strings.Cut(args[i+1], ":")
+                value = strings.TrimSpace(value)
+                if previous, ok := headers[name]; ok {
+                        value = previous + ", " + value
+                }
+                headers[name] = value
+        }
+        return headers
+}
+
+// Read and check the --request file and return the target URL it names.
+// The keyword is looked for in the whole request. ffuf reads the file
+// itself unless it came from stdin or was rewritten.
+func loadRequest(config *Config, urlFlag, keywordFlag string, wordlists map[string]string) (string, error) {
+        switch {
+        case urlFlag != "":
+                return "", fmt.Errorf("--request and -u cannot be combined; the request names the target")
+        case config.RequestProto != "http" && config.RequestProto != "https":
+                return "", fmt.Errorf("--request-proto must be http or https, got %q", config.RequestProto)
+        case config.RequestFile == "-" && config.Command == CommandChat:
+                return "", fmt.Errorf("chat reads your answers from stdin, so --request cannot read it")
+        case len(config.Resolve) > 0:
+                return "", fmt.Errorf("--resolve rewrites ffuf's -u, which --request replaces")
+        case config.BypassPass || config.SmartRecursion > 0:
+                return "", fmt.Errorf("--bypass-pass and --smart-recursion fuzz URLs of their own and need -u instead of --request")
+        }
+
+        var data []byte
+        var err error
+        if config.RequestFile == "-" {
+                data, err = io.ReadAll(os.Stdin)
+        } else {
+                data, err = os.ReadFile(config.RequestFile)
+        }
+        if err != nil {
+                return "", fmt.Errorf("reading --request: %w", err)
+        }
+        if config.Request, err = parseRawRequest(data); err != nil {
+                return "", fmt.Errorf("--request %s: %w", config.RequestFile, err)
+        }
+        target, err := rawRequestURL(config.Request, config.RequestProto)
+        if err != nil {
+                return "", fmt.Errorf("--request %s: %w", config.RequestFile, err)
+        }
+        if parsed, err := url.Parse(target); err != nil || parsed.Host == "" {
+                return "", fmt.Errorf("--request %s: %q is not a valid target URL", config.RequestFile, target)
+        }
+
+        config.Keyword = urlKeyword(keywordFlag, target, wordlists)
+        places := requestKeywordPlaces(config.Request, config.Keyword)
+        if len(places) == 0 {
+                return "", fmt.Errorf("--request %s: the request must contain the %s keyword", config.RequestFile, config.Keyword)
+        }
+        if !containsString(places, "the URL") {
+                fmt.Fprintf(os.Stderr, "%sWarning: %s is only in %s, so the suggested extensions are appended there%s\n", ColorYellow, config.Keyword, strings.Join(places, " and "), ColorReset)
+        }
+
+        if config.RequestFile == "-" || config.Request.Rewritten {
+                if config.RequestCopy, err = writeRequestCopy(config.Request); err != nil {
+                        return "", err
+                }
+        }
+        return target, nil
+}
+
+// Write a raw request to a temporary file for ffuf's -request, with a
+// chunked body decoded and folded headers joined. ffuf computes the
+// Content-Length itself.
+func writeRequestCopy(request *RawRequest) (string, error) {
+        file, err := os.CreateTemp("", "ffufai-request-*.txt")
+        if err != nil {
+                return "", fmt.Errorf("creating request file: %w", err)
+        }
+        defer file.Close()
+
+        var b strings.Builder
+        fmt.Fprintf(&b, "%s %s %s\r\n", request.Method, request.Target, request.Proto)
+        for _, header := range request.Headers {
+                if !strings.EqualFold(header.Name, "Transfer-Encoding") {
+                        fmt.Fprintf(&b, "%s: %s\r\n", header.Name, header.Value)
+                }
+        }
+        b.WriteString("\r\n" + request.Body)
+        if _, err := file.WriteString(b.String()); err != nil {
+                os.Remove(file.Name())
+                return "", fmt.Errorf("writing request file: %w", err)
+        }
+        return file.Name(), nil
+}
+
+// Authorization header line for --auth credentials in user:pass form
+func basicAuthHeader(credentials string) (string, error) {
+        user, _, ok := strings.Cut(credentials, ":")
+        if !ok || user == "" {
+                return "", fmt.Errorf("--auth must be user:pass")
+        }
+        return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)), nil
+}
+
+// Whether the probes send a Cookie header of the user's
+func probesWithCookies(config *Config) bool {
+        for _, header := range config.ProbeHeaders {
+                if strings.EqualFold(header.Name, "Cookie") {
+                        return true
+                }
+        }
+        return false
+}
+
+// Cookie values given to ffuf with -b or its -cookie alias
+func ffufCookies(args []string) []string {
+        return append(ffufFlagValues(args, "-b"), ffufFlagValues(args, "-cookie")...)
+}
+
+// Headers whose values are credentials
+var credentialHeaders = []string{"authorization", "proxy-authorization"}
+
+// Copy of ffuf arguments with credentials hidden, for printing a command or
+// sending it to the AI. Credential -H headers keep their auth scheme, and
+// cookies from -b or a Cookie header keep their names.
+func redactArgs(args []string) []string {
+        redacted := append([]string{}, args...)
+        redactHeader := func(line string) string {
+                name, value, ok := strings.Cut(line, ":")
+                switch {
+                case !ok:
+                        return line
+                case strings.EqualFold(strings.TrimSpace(name), "Cookie"):
+                        return name + ": " + redactCookies(value)
+                case !containsString(credentialHeaders, strings.ToLower(strings.TrimSpace(name))):
+                        return line
+                }
+                if scheme, _, ok := strings.Cut(strings.TrimSpace(value), " "); ok {
+                        return name + ": " + scheme + " [REDACTED]"
+                }
+                return name + ": [REDACTED]"
+        }
+        for i, arg := range redacted {
+                switch {
+                case arg == "-H" && i+1 < len(redacted):
+                        redacted[i+1] = redactHeader(redacted[i+1])
+                case strings.HasPrefix(arg, "-H="):
+                        redacted[i] = "-H=" + redactHeader(strings.TrimPrefix(arg, "-H="))
+                case (arg == "-b" || arg == "-cookie") && i+1 < len(redacted):
+                        redacted[i+1] = redactCookies(redacted[i+1])
+                case strings.HasPrefix(arg, "-b=") || strings.HasPrefix(arg, "-cookie="):
+                        flagName, value, _ := strings.Cut(arg, "=")
+                        redacted[i] = flagName + "=" + redactCookies(value)
+                }
+        }
+        return redacted
+}
+
+// Cookie string with each value replaced, keeping the names
+func redactCookies(value string) string {
+        var cookies []string
+        for _, cookie := range strings.Split(value, ";") {
+                if name, _, _ := strings.Cut(strings.TrimSpace(cookie), "="); name != "" {
+                        cookies = append(cookies, name+"=[REDACTED]")
+                }
+        }
+        return strings.Join(cookies, "; ")
+}
+
+// Say whether the --auth credentials got past the login: the base probe is
+// expected to no longer answer 401
+func reportAuth(config *Config, headers map[string]string) {
+        user, _, _ := strings.Cut(config.Auth, ":")
+        switch status := probeStatus(headers); status {
+        case 0:
+        case http.StatusUnauthorized:
+                fmt.Fprintf(os.Stderr, "%sWarning: the target still answers 401 with --auth as %s; the credentials may be wrong%s\n", ColorYellow, user, ColorReset)
+        default:
+                fmt.Fprintf(textOutput, "%sAuthenticated as %s, the target answered %d%s\n", ColorGreen, user, status, ColorReset)
+        }
+}
+
+// Common desktop browser User-Agents, one picked per run by --random-agent
+var browserUserAgents = []string{
+        "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+        "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36 Edg/128.0.0.0",
+        "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:131.0) Gecko/20100101 Firefox/131.0",
+        "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+        "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.0 Safari/605.1.15",
+        "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.7; rv:131.0) Gecko/20100101 Firefox/131.0",
+        "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36",
+        "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:131.0) Gecko/20100101 Firefox/131.0",
+}
+
+// Values of a repeatable single-dash ffuf option such as -H, in order
+func ffufFlagValues(args []string, name string) []string {
+        var values []string
+        for i, arg := range args {
+                if arg == name && i+1 < len(args) {
+                        values = append(values, args[i+1])
+                } else if strings.HasPrefix(arg, name+"=") {
+                        values = append(values, strings.TrimPrefix(arg, name+"="))
+                }
+        }
+        return values
+}
+
+// Collect the headers for the target probes from ffuf's -H and -b and the
+// --headers-file lines, and forward the file's headers to ffuf as -H unless
+// -H or -b already sets that name. Headers holding a fuzz keyword only make
+// sense in ffuf's requests, so the probes skip them.
+func setProbeHeaders(config *Config, fileLines []string) error {
+        given := make(map[string]bool)
+        var lines []string
+        for _, line := range ffufFlagValues(config.FfufArgs, "-H") {
+                header, err := parseHeaderLine(line)
+                if err != nil {
+                        return fmt.Errorf("-H: %w", err)
+                }
+                given[strings.ToLower(header.Name)] = true
+                lines = append(lines, line)
+        }
+        // ffuf joins repeated -b values into one Cookie header
+        if cookies := ffufCookies(config.FfufArgs); len(cookies) > 0 && !given["cookie"] {
+                line := "Cookie: " + strings.Join(cookies, "; ")
+                if _, err := parseHeaderLine(line); err != nil {
+                        return fmt.Errorf("-b: %w", err)
+                }
+                given["cookie"] = true
+                lines = append(lines, line)
+        }
+        for _, line := range fileLines {
+                header, err := parseHeaderLine(line)
+                if err != nil {
+                        return err
+                }
+                if given[strings.ToLower(header.Name)] {
+                        continue
+                }
+                config.FfufArgs = append(config.FfufArgs, "-H", line)
+                lines = append(lines, line)
+        }
+
+        keywords := []string{"FUZZ"}
+        for keyword := range ffufWordlists(config.FfufArgs) {
+                keywords = append(keywords, keyword)
+        }
+        config.ProbeHeaders = nil
+        for _, line := range lines {
+                fuzzed := false
+                for _, keyword := range keywords {
+                        fuzzed = fuzzed || strings.Contains(line, keyword)
+                }
+                if fuzzed {
+                        continue
+                }
+                header, _ := parseHeaderLine(line)
+                config.ProbeHeaders = append(config.ProbeHeaders, header)
+        }
+        return nil
+}
+
+// Headers of a --request that the probes send too, unless -H or another
+// option set them: not those naming the target or framing the body, and not
+// those holding a wordlist keyword
+func addRequestProbeHeaders(config *Config) {
+        keywords := []string{"FUZZ"}
+        for keyword := range ffufWordlists(config.FfufArgs) {
+                keywords = append(keywords, keyword)
+        }
+        given := make(map[string]bool)
+        for _, header := range config.ProbeHeaders {
+                given[strings.ToLower(header.Name)] = true
+        }
+        skipped := []string{"host", "content-length", "transfer-encoding", "connection"}
+        for _, header := range config.Request.Headers {
+                name := strings.ToLower(header.Name)
+                fuzzed := false
+                for _, keyword := range keywords {
+                        fuzzed = fuzzed || strings.Contains(header.Name+":"+header.Value, keyword)
+                }
+                if fuzzed || given[name] || containsString(skipped, name) {
+                        continue
+                }
+                config.ProbeHeaders = append(config.ProbeHeaders, header)
+        }
+}
+
+// Proxy schemes both ffuf's -x and the target client understand
+var proxySchemes = []string{"http", "https", "socks5"}
+
+// Parse a --proxy or -x URL, rejecting anything but an http, https or socks5
+// URL with a host
+func parseProxyURL(value string) (*url.URL, error) {
+        proxyURL, err := url.Parse(value)
+        if err != nil {
+                return nil, fmt.Errorf("invalid proxy URL %q: %w", value, err)
+        }
+        if !containsString(proxySchemes, strings.ToLower(proxyURL.Scheme)) || proxyURL.Host == "" {
+                return nil, fmt.Errorf("invalid proxy URL %q: want scheme://host:port with scheme %s", value, strings.Join(proxySchemes, ", "))
+        }
+        return proxyURL, nil
+}
+
+// Proxy the target client sends requests for urlStr through, nil when it
+// connects directly
+func targetProxy(config *Config, urlStr string) *url.URL {
+        req, err := http.NewRequest(http.MethodGet, strings.Replace(urlStr, "FUZZ", "", 1), nil)
+        if err != nil {
+                return nil
+        }
+        proxyURL, err := targetTransport(config).Proxy(req)
+        if err != nil {
+                return nil
+        }
+        return proxyURL
+}
+
+// Print which parts of the run go through a proxy: the target probes, ffuf,
+// and the AI requests, which only follow the proxy environment variables
+func reportProxies(config *Config) {
+        probes := "direct"
+        if proxyURL := targetProxy(config, config.URL); proxyURL != nil {
+                probes = proxyURL.Redacted()
+        }
+        ffuf := "direct, no -x"
+        if proxy := ffufFlagValue(effectiveFfufArgs(config), "-x"); proxy != "" {
+                if proxyURL, err := parseProxyURL(proxy); err == nil {
+                        proxy = proxyURL.Redacted()
+                }
+                ffuf = proxy + " (-x)"
+        }
+        fmt.Fprintf(textOutput, "Target probes: %s\n", probes)
+        fmt.Fprintf(textOutput, "ffuf: %s\n", ffuf)
+        if config.Proxy != "" {
+                fmt.Fprintf(textOutput, "AI requests: not sent through --proxy\n")
+        }
+}
+
+// Transport of the target client. It is built field by field rather than
+// cloned from the default, so that HTTP/2 is offered over TLS even with the
+// custom TLS settings and the protocol each probe negotiates is predictable.
+func targetTransport(config *Config) *http.Transport {
+        proxy := http.ProxyFromEnvironment
+        value := config.Proxy
+        if value == "" {
+                value = ffufFlagValue(effectiveFfufArgs(config), "-x")
+        }
+        if value != "" {
+                if proxyURL, err := parseProxyURL(value); err == nil {
+                        proxy = http.ProxyURL(proxyURL)
+                }
+        }
+        dialer := &net.Dialer{Timeout: config.ProbeTimeout, KeepAlive: 30 * time.Second, Resolver: targetResolver}
+        dial := dialer.DialContext
+        if len(config.Resolve) > 0 {
+                // The URL keeps the hostname, so TLS still verifies against it
+                dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+                        if host, port, err := net.SplitHostPort(address); err == nil {
+                                if ip := resolvedIP(config, host, port); ip != "" {
+                                        address = net.JoinHostPort(ip, port)
+                                }
+                        }
+                        return dialer.DialContext(ctx, network, address)
+                }
+        }
+        return &http.Transport{
+                Proxy:                 proxy,
+                DialContext:           dial,
+                TLSClientConfig:       targetTLSConfig(config),
+                ForceAttemptHTTP2:     true,
+                MaxIdleConns:          100,
+                IdleConnTimeout:       90 * time.Second,
+                TLSHandshakeTimeout:   config.ProbeTimeout,
+                ExpectContinueTimeout: time.Second,
+        }
+}
+
+// TLS settings of every connection to the target: --insecure, the
+// --cacert roots and the --client-cert certificate
+func targetTLSConfig(config *Config) *tls.Config {
+        tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure, RootCAs: config.CACerts}
+        if config.ClientCertificate != nil {
+                tlsConfig.Certificates = []tls.Certificate{*config.ClientCertificate}
+        }
+        return tlsConfig
+}
+
+// Copy of a TLS config that accepts any certificate, for reading the
+// certificate rather than trusting the connection
+func withoutVerify(tlsConfig *tls.Config) *tls.Config {
+        tlsConfig = tlsConfig.Clone()
+        tlsConfig.InsecureSkipVerify = true
+        return tlsConfig
+}
+
+// Load the --cacert certificates on top of the system roots, so a private
+// CA is trusted without losing the public ones. A file that holds anything
+// but parseable certificates fails the run before a request is sent.
+func loadCACerts(path string) (*x509.CertPool, int, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, 0, fmt.Errorf("reading --cacert: %w", err)
+        }
+        pool, err := x509.SystemCertPool()
+        if err != nil {
+                pool = x509.NewCertPool()
+        }
+        count := 0
+        for rest := data; ; {
+                var block *pem.Block
+                if block, rest = pem.Decode(rest); block == nil {
+                        break
+                }
+                if block.Type != "CERTIFICATE" {
+                        return nil, 0, fmt.Errorf("--cacert %s holds a %s block; only certificates belong in it", path, block.Type)
+                }
+                cert, err := x509.ParseCertificate(block.Bytes)
+                if err != nil {
+                        return nil, 0, fmt.Errorf("--cacert %s: certificate %d: %w", path, count+1, err)
+                }
+                pool.AddCert(cert)
+                count++
+        }
+        if count == 0 {
+                return nil, 0, fmt.Errorf("--cacert %s holds no PEM certificates", path)
+        }
+        return pool, count, nil
+}
+
+// Environment variable holding the passphrase of an encrypted --client-key
+const ClientKeyPassphraseEnv = "FFUFAI_CLIENT_KEY_PASSPHRASE"
+
+// Load the --client-cert and --client-key pair before anything is sent. An
+// encrypted key is decrypted with the passphrase from
+// FFUFAI_CLIENT_KEY_PASSPHRASE, or one typed at the terminal, and the
+// decrypted PEM is returned for ffuf, which cannot read encrypted keys.
+func loadClientCertificate(certPath, keyPath string) (*tls.Certificate, []byte, error) {
+        if certPath == "" || keyPath == "" {
+                return nil, nil, fmt.Errorf("--client-cert and --client-key must be given together")
+        }
+        certPEM, err := os.ReadFile(certPath)
+        if err != nil {
+                return nil, nil, fmt.Errorf("reading --client-cert: %w", err)
+        }
+        keyPEM, err := os.ReadFile(keyPath)
+        if err != nil {
+                return nil, nil, fmt.Errorf("reading --client-key: %w", err)
+        }
+
+        var decrypted []byte
+        block, _ := pem.Decode(keyPEM)
+        switch {
+        case block == nil:
+                return nil, nil, fmt.Errorf("--client-key %s holds no PEM key", keyPath)
+        case block.Type == "ENCRYPTED PRIVATE KEY":
+                return nil, nil, fmt.Errorf("--client-key %s is an encrypted PKCS#8 key, which ffufai cannot read; convert it with: openssl pkey -in %s -out client-legacy.key -traditional -aes256", keyPath, keyPath)
+        case x509.IsEncryptedPEMBlock(block):
+                passphrase := []byte(os.Getenv(ClientKeyPassphraseEnv))
+                if len(passphrase) == 0 {
+                        if passphrase, err = readPassphrase(fmt.Sprintf("Passphrase for %s: ", keyPath)); err != nil {
+                                return nil, nil, err
+                        }
+                }
+                der, err := x509.DecryptPEMBlock(block, passphrase)
+                if err != nil {
+                        return nil, nil, fmt.Errorf("decrypting --client-key %s: %w", keyPath, err)
+                }
+                decrypted = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der})
+                keyPEM = decrypted
+        }
+
+        cert, err := tls.X509KeyPair(certPEM, keyPEM)
+        if err != nil {
+                return nil, nil, fmt.Errorf("--client-cert %s and --client-key %s are not a pair: %w", certPath, keyPath, err)
+        }
+        return &cert, decrypted, nil
+}
+
+// Ask for a passphrase on the terminal with echo turned off
+func readPassphrase(prompt string) ([]byte, error) {
+        tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+        if err != nil {
+                return nil, fmt.Errorf("the client key is encrypted and there is no terminal to ask for its passphrase; set %s", ClientKeyPassphraseEnv)
+        }
+        defer tty.Close()
+
+        stty := func(arg string) {
+                cmd := exec.Command("stty", arg)
+                cmd.Stdin = tty
+                cmd.Run()
+        }
+        fmt.Fprint(tty, prompt)
+        stty("-echo")
+        line, err := bufio.NewReader(tty).ReadString('\n')
+        stty("echo")
+        fmt.Fprintln(tty)
+        if err != nil && line == "" {
+                return nil, fmt.Errorf("reading the passphrase: %w", err)
+        }
+        return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// Write the decrypted client key to a private temporary file and point
+// ffuf's -ck at it. The caller removes the file when ffuf is done.
+func writeClientKey(config *Config) (string, error) {
+        file, err := os.CreateTemp("", "ffufai-client-key-*.pem")
+        if err != nil {
+                return "", err
+        }
+        if _, err := file.Write(config.ClientKeyPEM); err != nil {
+                file.Close()
+                os.Remove(file.Name())
+                return "", err
+        }
+        if err := file.Close(); err != nil {
+                os.Remove(file.Name())
+                return "", err
+        }
+        for i, arg := range config.FfufArgs {
+                if arg == "-ck" && i+1 < len(config.FfufArgs) && config.FfufArgs[i+1] == config.ClientKey {
+                        config.FfufArgs[i+1] = file.Name()
+                }
+        }
+        return file.Name(), nil
+}
+
+// How long each pre-flight step may take
+const preflightTimeout = 5 * time.Second
+
+// Resolves the target's hostname in the probes, the pre-flight check and
+// the DNS phase; --dns-server replaces it
+var targetResolver = net.DefaultResolver
+
+// Where --print-argv writes the ffuf commands
+var argvOutput io.Writer = os.Stdout
+
+// Where the human-readable output goes: stdout, or stderr with --print-argv
+// so that the argv is all a script reads from stdout
+var textOutput io.Writer = os.Stdout
+
+// Address given with --resolve host:port:ip, as in curl, that the probes
+// connect to instead of resolving host
+type ResolveOverride struct {
+        Host string
+        Port string
+        IP   string
+}
+
+// Parse a --resolve value. An IPv6 address may be written with or without
+// brackets.
+func parseResolve(value string) (ResolveOverride, error) {
+        host, rest, _ := strings.Cut(value, ":")
+        port, ip, _ := strings.Cut(rest, ":")
+        ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+        if host == "" || port == "" || ip == "" {
+                return ResolveOverride{}, fmt.Errorf("--resolve %q: expected host:port:ip", value)
+        }
+        if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+                return ResolveOverride{}, fmt.Errorf("--resolve %q: invalid port %q", value, port)
+        }
+        if net.ParseIP(ip) == nil {
+                return ResolveOverride{}, fmt.Errorf("--resolve %q: %q is not an IP address", value, ip)
+        }
+        return ResolveOverride{Host: host, Port: port, IP: ip}, nil
+}
+
+// IP given with --resolve for host and port, or "" when there is none
+func resolvedIP(config *Config, host, port string) string {
+        for _, override := range config.Resolve {
+                if strings.EqualFold(override.Host, host) && override.Port == port {
+                        return override.IP
+                }
+        }
+        return ""
+}
+
+// Parse a --dns-server value, an IP address with an optional port, into
+// the ip:port the resolver dials
+func parseDNSServer(value string) (string, error) {
+        if net.ParseIP(strings.Trim(value, "[]")) != nil {
+                return net.JoinHostPort(strings.Trim(value, "[]"), "53"), nil
+        }
+        host, port, err := net.SplitHostPort(value)
+        if err != nil || net.ParseIP(host) == nil {
+                return "", fmt.Errorf("--dns-server %q: expected an IP address, optionally with a port", value)
+        }
+        if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+                return "", fmt.Errorf("--dns-server %q: invalid port %q", value, port)
+        }
+        return value, nil
+}
+
+// Resolver that asks the --dns-server instead of the system's servers
+func dnsResolver(server string) *net.Resolver {
+        return &net.Resolver{
+                PreferGo: true,
+                Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+                        dialer := net.Dialer{Timeout: dnsTimeout}
+                        return dialer.DialContext(ctx, network, server)
+                },
+        }
+}
+
+// ffuf has no --resolve, so when one covers the fuzz target ffuf is pointed
+// at the IP instead: the -u host becomes the IP, the hostname goes in a Host
+// header unless -H sets one and, for https, in -sni. What changed is printed.
+func resolveFfufTarget(config *Config) {
+        target, err := url.Parse(config.URL)
+        if err != nil {
+                return
+        }
+        port := target.Port()
+        if port == "" {
+                port = "80"
+                if target.Scheme == "https" {
+                        port = "443"
+                }
+        }
+        ip := resolvedIP(config, target.Hostname(), port)
+        if ip == "" {
+                return
+        }
+
+        host := ip
+        if target.Port() != "" {
+                host = net.JoinHostPort(ip, port)
+        } else if strings.Contains(ip, ":") {
+                host = "[" + ip + "]"
+        }
+        scheme, rest, _ := strings.Cut(config.URL, "://")
+        end := strings.IndexAny(rest, "/?#")
+        if end < 0 {
+                end = len(rest)
+        }
+        userinfo := ""
+        if at := strings.LastIndex(rest[:end], "@"); at >= 0 {
+                userinfo = rest[:at+1]
+        }
+        ffufURL := scheme + "://" + userinfo + host + rest[end:]
+        config.FfufArgs = withURL(config.FfufArgs, ffufURL)
+
+        var added []string
+        hostGiven := false
+        for _, value := range ffufFlagValues(config.FfufArgs, "-H") {
+                name, _, _ := strings.Cut(value, ":")
+                hostGiven = hostGiven || strings.EqualFold(strings.TrimSpace(name), "Host")
+        }
+        if !hostGiven {
+                added = append(added, "-H", "Host: "+target.Host)
+        }
+        if target.Scheme == "https" && !hasFfufFlag(effectiveFfufArgs(config), "-sni") {
+                if ffufSupports(config, "-sni") {
+                        added = append(added, "-sni", target.Hostname())
+                } else {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %s has no -sni option, so ffuf sends no SNI to %s; update ffuf to 2.1 or later%s\n", ColorYellow, config.FfufPath, ip, ColorReset)
+                }
+        }
+        config.FfufArgs = append(config.FfufArgs, added...)
+        fmt.Fprintf(textOutput, "%s--resolve %s:%s:%s covers the target, ffuf gets %s%s\n", ColorGreen, target.Hostname(), port, ip, formatCommand(append([]string{"-u", ffufURL}, added...)), ColorReset)
+}
+
+// Check that the target can be reached before any AI call is paid for:
+// resolve its hostname, connect to it and, for https, complete a TLS
+// handshake. The error says which step failed and why. Through a proxy only
+// the proxy is checked, and a hostname holding the keyword is not checked
+// at all.
+func preflight(ctx context.Context, config *Config, resolver *net.Resolver, target string) error {
+        targetURL, err := url.Parse(target)
+        if err != nil {
+                return fmt.Errorf("parsing %s: %w", target, err)
+        }
+        host, port := targetURL.Hostname(), targetURL.Port()
+        if strings.Contains(host, config.Keyword) {
+                return nil
+        }
+        if port == "" {
+                port = "80"
+                if targetURL.Scheme == "https" {
+                        port = "443"
+                }
+        }
+        handshake := targetURL.Scheme == "https"
+
+        if proxyURL := targetProxy(config, target); proxyURL != nil {
+                host, port, handshake = proxyURL.Hostname(), proxyURL.Port(), false
+                if port == "" {
+                        port = "80"
+                        if proxyURL.Scheme == "https" {
+                                port = "443"
+                        }
+                }
+        }
+
+        address := net.JoinHostPort(host, port)
+        lookupCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+        defer cancel()
+        if ip := resolvedIP(config, host, port); ip != "" {
+                address = net.JoinHostPort(ip, port)
+        } else if _, err := resolver.LookupHost(lookupCtx, host); err != nil {
+                var dnsErr *net.DNSError
+                switch {
+                case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+                        return fmt.Errorf("%s does not resolve (NXDOMAIN); check the hostname for typos", host)
+                case errors.As(err, &dnsErr) && dnsErr.IsTimeout, errors.Is(err, context.DeadlineExceeded):
+                        return fmt.Errorf("resolving %s timed out after %s; check your DNS server", host, preflightTimeout)
+                default:
+                        return fmt.Errorf("resolving %s: %w", host, err)
+                }
+        }
+
+        dialer := net.Dialer{Timeout: preflightTimeout, Resolver: resolver}
+        conn, err := dialer.DialContext(ctx, "tcp", address)
+        if err != nil {
+                var netErr net.Error
+                switch {
+                case errors.Is(err, syscall.ECONNREFUSED):
+                        return fmt.Errorf("connection to %s refused; nothing listens on port %s", address, port)
+                case errors.As(err, &netErr) && netErr.Timeout():
+                        return fmt.Errorf("connecting to %s timed out after %s; the host may be down or firewalled", address, preflightTimeout)
+                default:
+                        return fmt.Errorf("connecting to %s: %w", address, err)
+                }
+        }
+        defer conn.Close()
+        if !handshake {
+                return nil
+        }
+
+        handshakeCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+        defer cancel()
+        tlsConfig := targetTLSConfig(config)
+        tlsConfig.ServerName = host
+        if config.SNI != "" {
+                tlsConfig.ServerName = config.SNI
+        }
+        tlsConn := tls.Client(conn, tlsConfig)
+        if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
+                switch {
+                case certificateError(err):
+                        return fmt.Errorf("certificate error for %s: %w (pass --cacert with the issuing CA, or -k, to probe it anyway)", host, err)
+                case errors.Is(err, context.DeadlineExceeded):
+                        return fmt.Errorf("TLS handshake with %s timed out after %s; the port may not speak TLS", host, preflightTimeout)
+                default:
+                        return fmt.Errorf("TLS handshake with %s: %w", host, err)
+                }
+        }
+        return nil
+}
+
+// Send one request and collect the first value of each response header,
+// plus the response status as "Status-Code"
+func probeHeaders(ctx context.Context, client *http.Client, method string, urlStr string) (map[string]string, int, *CertInfo, error) {
+        req, err := http.NewRequestWithContext(ctx, method, urlStr, nil)
+        if err != nil {
+                return nil, 0, nil, fmt.Errorf("creating %s request: %w", method, err)
+        }
+
+        // Set a common User-Agent to avoid blocking
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, 0, nil, fmt.Errorf("executing %s request: %w", method, err)
+        }
+        defer resp.Body.Close()
+        io.Copy(io.Discard, io.LimitReader(resp.Body, headerProbeBodyMax))
+
+        headers := make(map[string]string)
+        for key, values := range resp.Header {
+                if len(values) > 0 {
+                        headers[key] = values[0]
+                }
+        }
+        // Every cookie name counts for fingerprinting
+        if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 1 {
+                headers["Set-Cookie"] = strings.Join(cookies, "\n")
+        }
+        // Each CSP header is a policy of its own and each Link header a list of
+        // links; a comma joins both
+        for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only", "Link"} {
+                if policies := resp.Header.Values(name); len(policies) > 1 {
+                        headers[name] = strings.Join(policies, ", ")
+                }
+        }
+
+        // Add response status and the URL that answered for context
+        headers["Status-Code"] = resp.Status
+        headers[ResponseURLHeader] = resp.Request.URL.String()
+        headers[ProtocolHeader] = resp.Proto
+
+        return headers, resp.StatusCode, certInfo(resp.TLS), nil
+}
+
+// Leaf certificate of an HTTPS target. Behind a CONNECT proxy this is still
+// the target's own certificate.
+type CertInfo struct {
+        Subject    string    `json:"subject"`
+        SANs       []string  `json:"sans"`
+        Issuer     string    `json:"issuer"`
+        NotBefore  time.Time `json:"not_before"`
+        NotAfter   time.Time `json:"not_after"`
+        SelfSigned bool      `json:"self_signed,omitempty"`
+}
+
+// Most SANs quoted in a prompt; wildcard and multi-tenant certificates can
+// carry hundreds
+const maxPromptSANs = 20
+
+func certInfo(state *tls.ConnectionState) *CertInfo {
+        if state == nil || len(state.PeerCertificates) == 0 {
+                return nil
+        }
+        leaf := state.PeerCertificates[0]
+        info := &CertInfo{
+                Subject:   leaf.Subject.CommonName,
+                SANs:      leaf.DNSNames,
+                Issuer:    leaf.Issuer.CommonName,
+                NotBefore: leaf.NotBefore,
+                NotAfter:  leaf.NotAfter,
+        }
+        for _, ip := range leaf.IPAddresses {
+                info.SANs = append(info.SANs, ip.String())
+        }
+        if info.Issuer == "" {
+                info.Issuer = leaf.Issuer.String()
+        }
+        if bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignatureFrom(leaf) == nil {
+                info.SelfSigned = true
+        }
+        return info
+}
+
+// Whether the certificate is outside its validity window now
+func (c *CertInfo) Expired() bool {
+        now := time.Now()
+        return now.Before(c.NotBefore) || now.After(c.NotAfter)
+}
+
+// The issuer and names for the prompt
+func (c *CertInfo) String() string {
+        var lines []string
+        issuer := c.Issuer
+        if c.SelfSigned {
+                issuer = "self-signed"
+        }
+        lines = append(lines, "issuer: "+sanitizeHint(issuer))
+        sans := c.SANs
+        if len(sans) > maxPromptSANs {
+                sans = sans[:maxPromptSANs]
+        }
+        var names []string
+        for _, san := range sans {
+                names = append(names, sanitizeHint(san))
+        }
+        if len(names) > 0 {
+                lines = append(lines, "names: "+strings.Join(names, " "))
+        } else if c.Subject != "" {
+                lines = append(lines, "names: "+sanitizeHint(c.Subject))
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Warn about a certificate browsers would reject
+func warnCertificate(cert *CertInfo) {
+        if cert == nil {
+                return
+        }
+        if cert.Expired() {
+                fmt.Fprintf(os.Stderr, "%sWarning: the target's certificate is outside its validity window (%s to %s)%s\n", ColorYellow, cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"), ColorReset)
+        }
+        if cert.SelfSigned {
+                fmt.Fprintf(os.Stderr, "%sWarning: the target's certificate is self-signed%s\n", ColorYellow, ColorReset)
+        }
+}
+
+// Number of headers that tell something about the target
+func usefulHeaderCount(headers map[string]string) int {
+        count := 0
+        for name := range headers {
+                if name != "Status-Code" && name != ResponseURLHeader && name != ProtocolHeader && !containsString(uninformativeHeaders, name) {
+                        count++
+                }
+        }
+        return count
+}
+
+// Headers that tell the AI something about the target, most informative
+// first. When the header block is over its cap the later ones are dropped.
+var promptHeaderAllowlist = []string{
+        "Status-Code",
+        ResponseURLHeader,
+        ProtocolHeader,
+        "Server",
+        "X-Powered-By",
+        "Content-Type",
+        "Location",
+        "Set-Cookie",
+        "X-Aspnet-Version",
+        "X-Aspnetmvc-Version",
+        "X-Generator",
+        "X-Pingback",
+        "Link",
+        "Www-Authenticate",
+        "X-Application-Context",
+        "X-Drupal-Cache",
+        "X-Drupal-Dynamic-Cache",
+        "X-Runtime",
+        "X-Jenkins",
+        "Liferay-Portal",
+        "X-Magento-Cache-Debug",
+        "X-Shopify-Stage",
+        "X-Litespeed-Cache",
+        "X-Backend-Server",
+        "Via",
+        "Alt-Svc",
+        "Allow",
+        OptionsAllowHeader,
+        "Content-Location",
+}
+
+// Default longest header value and header block sent to the AI
+const (
+        DefaultHeaderValueMax = 200
+        DefaultHeaderBlockMax = 2048
+)
+
+// Keep the informative headers for the prompts: allowlisted names only,
+// cookie names without their values, long values truncated and the whole
+// block capped. --full-headers skips this.
+func compactHeaders(config *Config, headers map[string]string) map[string]string {
+        compact := make(map[string]string)
+        block := 0
+        for _, name := range promptHeaderAllowlist {
+                value, ok := headers[name]
+                if !ok {
+                        continue
+                }
+                if name == "Set-Cookie" {
+                        value = cookieNames(value)
+                }
+                if len(value) > config.HeaderValueMax {
+                        value = truncateRunes(value, config.HeaderValueMax) + "..."
+                }
+                // Roughly the JSON the header takes up in the prompt
+                size := len(name) + len(value) + 6
+                if block+size > config.HeaderBlockMax {
+                        break
+                }
+                block += size
+                compact[name] = value
+        }
+
+        if config.Verbose {
+                total := 0
+                for name, value := range headers {
+                        total += len(name) + len(value) + 6
+                }
+                if dropped := len(headers) - len(compact); dropped > 0 || total > block {
+                        fmt.Fprintf(textOutput, "Prompt headers: kept %d of %d, dropped %d headers and %d bytes (--full-headers keeps them)\n",
+                                len(compact), len(headers), dropped, total-block)
+                }
+        }
+        return compact
+}
+
+// Names of the cookies in a Set-Cookie value, without values or attributes
+func cookieNames(value string) string {
+        var names []string
+        for _, cookie := range strings.Split(value, "\n") {
+                if name, _, _ := strings.Cut(strings.TrimSpace(cookie), "="); name != "" {
+                        names = append(names, name)
+                }
+        }
+        return strings.Join(names, ", ")
+}
+
+// Technology stack of the target, from stage one or from --stack
+type StackDescriptor struct {
+        Server    string `json:"server"`
+        Language  string `json:"language"`
+        Framework string `json:"framework"`
+        CMS       string `json:"cms"`
+        // Hosting platform, from DNS names or fingerprint rules
+        Hosting string `json:"hosting,omitempty"`
+        // Technologies named with --stack, in place of the fields above
+        Technologies []string `json:"technologies,omitempty"`
+}
+
+func (s *StackDescriptor) String() string {
+        if len(s.Technologies) > 0 {
+                return strings.Join(s.Technologies, ", ")
+        }
+        var parts []string
+        for _, field := range []struct{ name, value string }{
+                {"server", s.Server}, {"language", s.Language}, {"framework", s.Framework}, {"cms", s.CMS}, {"hosting", s.Hosting},
+        } {
+                if value := strings.TrimSpace(field.value); value != "" && !strings.EqualFold(value, "unknown") {
+                        parts = append(parts, field.name+" "+value)
+                }
+        }
+        return strings.Join(parts, ", ")
+}
+
+// Parse the comma-separated --stack list
+func parseStack(list string) *StackDescriptor {
+        var technologies []string
+        for _, name := range strings.Split(list, ",") {
+                if name = strings.TrimSpace(name); name != "" {
+                        technologies = append(technologies, name)
+                }
+        }
+        if len(technologies) == 0 {
+                return nil
+        }
+        return &StackDescriptor{Technologies: technologies}
+}
+
+var (
+        titleRegex     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+        generatorRegex = regexp.MustCompile(`(?is)<meta[^>]+name=["']generator["'][^>]*content=["']([^"']+)`)
+        assetRegex     = regexp.MustCompile(`(?i)(?:src|href)=["']([^"'?#]+\.(?:js|css|php|aspx|jsp|do|action))`)
+        commentRegex   = regexp.MustCompile(`(?s)<!--(.*?)-->`)
+        iconLinkRegex  = regexp.MustCompile(`(?is)<link[^>]+rel=["'][^"']*\bicon\b[^"']*["'][^>]*>`)
+        hrefRegex      = regexp.MustCompile(`(?is)href=["']([^"']+)["']`)
+        scriptSrcRegex = regexp.MustCompile(`(?is)<script[^>]+src=["']([^"']+)["']`)
+)
+
+// Most bytes of the base page read for page hints
+const bodyHintsMax = 16 * 1024
+
+// Page content that gives a framework away, matched case-insensitively
+var frameworkMarkers = []struct {
+        marker string
+        name   string
+}{
+        {"/wp-content/", "WordPress"},
+        {"/wp-includes/", "WordPress"},
+        {"drupal-settings-json", "Drupal"},
+        {"/sites/default/files/", "Drupal"},
+        {"/media/jui/", "Joomla"},
+        {"__next_data__", "Next.js"},
+        {"__nuxt__", "Nuxt"},
+        {"ng-version=", "Angular"},
+        {"data-reactroot", "React"},
+        {"__viewstate", "ASP.NET Web Forms"},
+        {"javax.faces.viewstate", "JavaServer Faces"},
+        {"csrfmiddlewaretoken", "Django"},
+        {`name="csrf-param"`, "Ruby on Rails"},
+        {"laravel", "Laravel"},
+        {"cdn.shopify.com", "Shopify"},
+        {"mage/cookies", "Magento"},
+        {"/typo3conf/", "TYPO3"},
+}
+
+// Technology hints from the start of the base page: the title, the generator
+// meta tag, framework markers, short comment banners and a few script, style
+// and page paths. Also returns the icons and scripts the page links to.
+// Pages that are not HTML give no hints.
+func getBodyHints(ctx context.Context, config *Config, urlStr string) (string, *PageLinks, error) {
+        client := targetClient(config)
+        req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+        if err != nil {
+                return "", nil, fmt.Errorf("creating GET request: %w", err)
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return "", nil, fmt.Errorf("executing GET request: %w", err)
+        }
+        defer resp.Body.Close()
+        body, err := io.ReadAll(io.LimitReader(resp.Body, bodyHintsMax))
+        if err != nil {
+                return "", nil, fmt.Errorf("reading response: %w", err)
+        }
+
+        contentType := resp.Header.Get("Content-Type")
+        if contentType == "" {
+                contentType = http.DetectContentType(body)
+        }
+        if !strings.Contains(strings.ToLower(contentType), "html") {
+                return "", nil, fmt.Errorf("skipping %s content", contentType)
+        }
+
+        var hints []string
+        if match := titleRegex.FindSubmatch(body); match != nil {
+                if title := sanitizeHint(string(match[1])); title != "" {
+                        hints = append(hints, "title: "+title)
+                }
+        }
+        if match := generatorRegex.FindSubmatch(body); match != nil {
+                hints = append(hints, "generator: "+sanitizeHint(string(match[1])))
+        }
+        lower := bytes.ToLower(body)
+        var frameworks []string
+        for _, marker := range frameworkMarkers {
+                if bytes.Contains(lower, []byte(marker.marker)) && !containsString(frameworks, marker.name) {
+                        frameworks = append(frameworks, marker.name)
+                }
+        }
+        if len(frameworks) > 0 {
+                hints = append(hints, "markers: "+strings.Join(frameworks, ", "))
+        }
+        // Banners like "<!-- Powered by Foo 2.1 -->"; long comments are usually markup
+        comments := 0
+        for _, match := range commentRegex.FindAllSubmatch(body, -1) {
+                comment := sanitizeHint(string(match[1]))
+                if len(comment) < 4 || len(comment) > 120 || strings.HasPrefix(comment, "[if") {
+                        continue
+                }
+                hints = append(hints, "comment: "+comment)
+                if comments++; comments == 3 {
+                        break
+                }
+        }
+        var assets []string
+        for _, match := range assetRegex.FindAllSubmatch(body, -1) {
+                if asset := sanitizeHint(string(match[1])); !containsString(assets, asset) && len(assets) < 10 {
+                        assets = append(assets, asset)
+                }
+        }
+        if len(assets) > 0 {
+                hints = append(hints, "assets: "+strings.Join(assets, " "))
+        }
+
+        links := &PageLinks{}
+        for _, link := range iconLinkRegex.FindAll(body, 3) {
+                if match := hrefRegex.FindSubmatch(link); match != nil {
+                        if icon, err := resp.Request.URL.Parse(html.UnescapeString(string(match[1]))); err == nil && (icon.Scheme == "http" || icon.Scheme == "https") {
+                                links.Icons = append(links.Icons, icon.String())
+                        }
+                }
+        }
+        for _, match := range scriptSrcRegex.FindAllSubmatch(body, -1) {
+                script, err := resp.Request.URL.Parse(html.UnescapeString(string(match[1])))
+                if err == nil && (script.Scheme == "http" || script.Scheme == "https") && !containsString(links.Scripts, script.String()) {
+                        links.Scripts = append(links.Scripts, script.String())
+                }
+        }
+        return strings.Join(hints, "\n"), links, nil
+}
+
+// Icons and scripts a page links to, as absolute URLs
+type PageLinks struct {
+        Icons   []string
+        Scripts []string
+}
+
+// Favicon hashes of well-known products, as Shodan's http.favicon.hash
+// computes them; the config file's favicon_hashes add to these
+var defaultFaviconHashes = map[int32]string{
+        116323821:  "Spring Boot",
+        81586312:   "Jenkins",
+        -297069493: "Apache Tomcat",
+        1278323681: "GitLab",
+        2123863676: "Grafana",
+        -305179312: "Atlassian Confluence",
+        945408572:  "Fortinet FortiGate",
+        1485257654: "SonarQube",
+        892542951:  "Zabbix",
+}
+
+// Most bytes of an icon read for hashing
+const faviconMax = 1024 * 1024
+
+// Hash /favicon.ico and the icons the page links to and look the hashes up.
+// Returns the product of the first match, or "" and the hashes of the icons
+// that matched nothing.
+func fingerprintFavicon(ctx context.Context, config *Config, baseURL string, icons []string) (string, []int32) {
+        target, err := url.Parse(baseURL)
+        if err != nil {
+                return "", nil
+        }
+        candidates := append([]string{(&url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/favicon.ico"}).String()}, icons...)
+
+        client := targetClient(config)
+        var misses []int32
+        seen := make(map[string]bool)
+        for _, icon := range candidates {
+                if seen[icon] {
+                        continue
+                }
+                seen[icon] = true
+                data, err := fetchFavicon(ctx, client, icon)
+                if err != nil || len(data) == 0 {
+                        continue
+                }
+                hash := faviconHash(data)
+                if product, ok := config.FaviconHashes[hash]; ok {
+                        return fmt.Sprintf("%s (hash %d)", product, hash), nil
+                }
+                if product, ok := defaultFaviconHashes[hash]; ok {
+                        return fmt.Sprintf("%s (hash %d)", product, hash), nil
+                }
+                misses = append(misses, hash)
+        }
+        return "", misses
+}
+
+func fetchFavicon(ctx context.Context, client *http.Client, iconURL string) ([]byte, error) {
+        req, err := http.NewRequestWithContext(ctx, "GET", iconURL, nil)
+        if err != nil {
+                return nil, err
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("%s answered %s", iconURL, resp.Status)
+        }
+        return io.ReadAll(io.LimitReader(resp.Body, faviconMax))
+}
+
+// Shodan's favicon hash: MurmurHash3 of the base64 text with a newline after
+// every 76 characters and at the end, as Python's base64.encodebytes writes it
+func faviconHash(data []byte) int32 {
+        encoded := base64.StdEncoding.EncodeToString(data)
+        var text strings.Builder
+        for len(encoded) > 76 {
+                text.WriteString(encoded[:76] + "\n")
+                encoded = encoded[76:]
+        }
+        text.WriteString(encoded + "\n")
+        return murmur3([]byte(text.String()))
+}
+
+// 32-bit MurmurHash3 (x86 variant, seed 0) as a signed integer
+func murmur3(data []byte) int32 {
+        const c1, c2 = 0xcc9e2d51, 0x1b873593
+        var h uint32
+        n := len(data)
+        for i := 0; i+4 <= n; i += 4 {
+                k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+                k *= c1
+                k = bits.RotateLeft32(k, 15)
+                k *= c2
+                h ^= k
+                h = bits.RotateLeft32(h, 13)
+                h = h*5 + 0xe6546b64
+        }
+
+        var k uint32
+        tail := data[n&^3:]
+        switch len(tail) {
+        case 3:
+                k ^= uint32(tail[2]) << 16
+                fallthrough
+        case 2:
+                k ^= uint32(tail[1]) << 8
+                fallthrough
+        case 1:
+                k ^= uint32(tail[0])
+                k *= c1
+                k = bits.RotateLeft32(k, 15)
+                k *= c2
+                h ^= k
+        }
+
+        h ^= uint32(n)
+        h ^= h >> 16
+        h *= 0x85ebca6b
+        h ^= h >> 13
+        h *= 0xc2b2ae35
+        h ^= h >> 16
+        return int32(h)
+}
+
+// Make page text safe to quote in a prompt: entities decoded, angle brackets
+// and control characters removed, whitespace collapsed and length limited
+func sanitizeHint(text string) string {
+        text = strings.Map(func(r rune) rune {
+                if r == '<' || r == '>' || unicode.IsControl(r) {
+                        return ' '
+                }
+                return r
+        }, html.UnescapeString(strings.ToValidUTF8(text, "")))
+        return truncateRunes(strings.Join(strings.Fields(text), " "), 200)
+}
+
+// Most bytes of robots.txt read, and the most paths of each kind kept
+const (
+        robotsMax      = 64 * 1024
+        maxRobotsPaths = 20
+)
+
+// Directives of a robots.txt file, condensed for the prompt
+type RobotsSummary struct {
+        Disallow    []string
+        Allow       []string
+        SitemapURLs []string
+        Extensions  []string
+}
+
+func (r *RobotsSummary) String() string {
+        var lines []string
+        if len(r.Disallow) > 0 {
+                lines = append(lines, "disallow: "+strings.Join(r.Disallow, " "))
+        }
+        if len(r.Allow) > 0 {
+                lines = append(lines, "allow: "+strings.Join(r.Allow, " "))
+        }
+        if len(r.SitemapURLs) > 0 {
+                lines = append(lines, fmt.Sprintf("sitemaps: %d", len(r.SitemapURLs)))
+        }
+        if len(r.Extensions) > 0 {
+                lines = append(lines, "extensions: "+strings.Join(r.Extensions, " "))
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Sitemap URLs robots.txt names, if it was read
+func robotsSitemaps(robots *RobotsSummary) []string {
+        if robots == nil {
+                return nil
+        }
+        return robots.SitemapURLs
+}
+
+// Fetch and condense /robots.txt of the target's host. A missing file, any
+// non-200 answer or an HTML error page yields nil without an error.
+func getRobots(ctx context.Context, config *Config, urlStr string) (*RobotsSummary, error) {
+        target, err := url.Parse(urlStr)
+        if err != nil {
+                return nil, err
+        }
+        robotsURL := url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/robots.txt"}
+
+        client := targetClient(config)
+        req, err := http.NewRequestWithContext(ctx, "GET", robotsURL.String(), nil)
+        if err != nil {
+                return nil, fmt.Errorf("creating GET request: %w", err)
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, fmt.Errorf("executing GET request: %w", err)
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                return nil, nil
+        }
+        body, err := io.ReadAll(io.LimitReader(resp.Body, robotsMax))
+        if err != nil {
+                return nil, fmt.Errorf("reading robots.txt: %w", err)
+        }
+        // Catch-all routes answer 200 with an HTML page
+        if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "html") ||
+                strings.HasPrefix(strings.TrimSpace(string(body)), "<") {
+                return nil, nil
+        }
+        return parseRobots(string(body)), nil
+}
+
+// Collect the Disallow, Allow and Sitemap lines of a robots.txt body and the
+// extensions its paths use. Nil when the body holds no directives.
+func parseRobots(body string) *RobotsSummary {
+        summary := &RobotsSummary{}
+        directives := 0
+        for _, line := range strings.Split(body, "\n") {
+                if index := strings.Index(line, "#"); index >= 0 {
+                        line = line[:index]
+                }
+                field, value, ok := strings.Cut(line, ":")
+                if !ok {
+                        continue
+                }
+                value = sanitizeHint(value)
+                if strings.EqualFold(strings.TrimSpace(field), "sitemap") {
+                        if value != "" && len(summary.SitemapURLs) < maxSitemapFiles {
+                                summary.SitemapURLs = append(summary.SitemapURLs, value)
+                        }
+                        directives++
+                        continue
+                }
+                var paths *[]string
+                switch strings.ToLower(strings.TrimSpace(field)) {
+                case "disallow":
+                        paths = &summary.Disallow
+                case "allow":
+                        paths = &summary.Allow
+                case "user-agent", "crawl-delay", "host":
+                        directives++
+                        continue
+                default:
+                        continue
+                }
+                directives++
+                if value == "" || value == "/" || containsString(*paths, value) {
+                        continue
+                }
+                if ext := path.Ext(strings.TrimRight(value, "*$")); len(ext) > 1 && !strings.ContainsAny(ext, "*?=&/") && !containsString(summary.Extensions, ext) {
+                        summary.Extensions = append(summary.Extensions, ext)
+                }
+                if len(*paths) < maxRobotsPaths {
+                        *paths = append(*paths, value)
+                }
+        }
+        if directives == 0 {
+                return nil
+        }
+        return summary
+}
+
+// Bounds on reading sitemaps: how deep indexes are followed, how many files
+// are fetched, the bytes read from each after decompression and the page
+// paths kept
+const (
+        maxSitemapDepth    = 2
+        maxSitemapFiles    = 10
+        sitemapMax         = 5 * 1024 * 1024
+        maxSitemapPaths    = 5000
+        maxSitemapTopLevel = 20
+)
+
+// Extensions and paths of the pages the target's sitemaps list
+type SitemapSummary struct {
+        Paths      []string
+        Extensions []string
+        TopLevel   []string
+}
+
+func (s *SitemapSummary) String() string {
+        var lines []string
+        if len(s.Extensions) > 0 {
+                lines = append(lines, "extensions known to exist: "+strings.Join(s.Extensions, " "))
+        }
+        if len(s.TopLevel) > 0 {
+                lines = append(lines, "top-level paths: /"+strings.Join(s.TopLevel, " /"))
+        }
+        return strings.Join(lines, "\n")
+}
+
+// A <urlset> of pages or a <sitemapindex> of further sitemaps
+type sitemapDocument struct {
+        URLs []struct {
+                Loc string `xml:"loc"`
+        } `xml:"url"`
+        Sitemaps []struct {
+                Loc string `xml:"loc"`
+        } `xml:"sitemap"`
+}
+
+var sitemapExtensionRegex = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// Read /sitemap.xml and the sitemaps robots.txt names, following sitemap
+// indexes on the target's host up to maxSitemapDepth. Sitemaps that are
+// missing or unreadable are skipped; nil when none lists a page.
+func getSitemap(ctx context.Context, config *Config, urlStr string, robotsSitemaps []string) (*SitemapSummary, error) {
+        target, err := url.Parse(urlStr)
+        if err != nil {
+                return nil, err
+        }
+        type queued struct {
+                url   string
+                depth int
+        }
+        queue := []queued{{url: (&url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/sitemap.xml"}).String()}}
+        for _, sitemap := range robotsSitemaps {
+                queue = append(queue, queued{url: sitemap})
+        }
+
+        client := targetClient(config)
+        summary := &SitemapSummary{}
+        seen := make(map[string]bool)
+        seenPaths := make(map[string]bool)
+        for fetched := 0; len(queue) > 0 && fetched < maxSitemapFiles; {
+                item := queue[0]
+                queue = queue[1:]
+                // Only sitemaps on the target's own host are fetched
+                if parsed, err := url.Parse(item.url); err != nil || !strings.EqualFold(parsed.Host, target.Host) || seen[item.url] {
+                        continue
+                }
+                seen[item.url] = true
+                fetched++
+
+                doc, err := fetchSitemap(ctx, client, item.url)
+                if err != nil {
+                        continue
+                }
+                if item.depth < maxSitemapDepth {
+                        for _, child := range doc.Sitemaps {
+                                queue = append(queue, queued{url: strings.TrimSpace(child.Loc), depth: item.depth + 1})
+                        }
+                }
+                for _, page := range doc.URLs {
+                        parsed, err := url.Parse(strings.TrimSpace(page.Loc))
+                        if err != nil {
+                                continue
+                        }
+                        pagePath := strings.Trim(parsed.EscapedPath(), "/")
+                        if pagePath == "" || seenPaths[pagePath] {
+                                continue
+                        }
+                        seenPaths[pagePath] = true
+                        if len(summary.Paths) < maxSitemapPaths {
+                                summary.Paths = append(summary.Paths, pagePath)
+                        }
+                        if ext := strings.ToLower(path.Ext(pagePath)); sitemapExtensionRegex.MatchString(ext) && !containsString(summary.Extensions, ext) {
+                                summary.Extensions = append(summary.Extensions, ext)
+                        }
+                        if top, _, nested := strings.Cut(pagePath, "/"); nested && !containsString(summary.TopLevel, top) && len(summary.TopLevel) < maxSitemapTopLevel {
+                                summary.TopLevel = append(summary.TopLevel, top)
+                        }
+                }
+        }
+        if len(summary.Paths) == 0 {
+                return nil, nil
+        }
+        return summary, nil
+}
+
+// Fetch one sitemap, decompressing it when it is gzipped
+func fetchSitemap(ctx context.Context, client *http.Client, sitemapURL string) (*sitemapDocument, error) {
+        req, err := http.NewRequestWithContext(ctx, "GET", sitemapURL, nil)
+        if err != nil {
+                return nil, err
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+                return nil, fmt.Errorf("%s answered %s", sitemapURL, resp.Status)
+        }
+
+        body, err := io.ReadAll(io.LimitReader(resp.Body, sitemapMax))
+        if err != nil {
+                return nil, err
+        }
+        if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+                reader, err := gzip.NewReader(bytes.NewReader(body))
+                if err != nil {
+                        return nil, err
+                }
+                if body, err = io.ReadAll(io.LimitReader(reader, sitemapMax)); err != nil {
+                        return nil, err
+                }
+        }
+
+        var doc sitemapDocument
+        if err := xml.Unmarshal(body, &doc); err != nil {
+                return nil, fmt.Errorf("parsing %s: %w", sitemapURL, err)
+        }
+        return &doc, nil
+}
+
+// Limits for the scripts read from the base page (--js-files)
+const (
+        DefaultScriptFiles = 5
+        maxScriptFiles     = 20
+        scriptMax          = 2 * 1024 * 1024
+        scriptsTimeout     = 5 * time.Second
+        maxScriptEndpoints = 500
+        maxPromptEndpoints = 25
+)
+
+// Quoted absolute paths in JavaScript such as "/api/v1/users" or `/graphql`
+var scriptPathRegex = regexp.MustCompile("[\"'`](/[A-Za-z0-9_\\-.~/{}:$]{1,120})[\"'`]")
+
+// Endpoints and file extensions found in the page's scripts
+type ScriptSummary struct {
+        Endpoints  []string
+        Extensions []string
+}
+
+func (s *ScriptSummary) String() string {
+        var lines []string
+        if len(s.Extensions) > 0 {
+                lines = append(lines, "extensions referenced: "+strings.Join(s.Extensions, " "))
+        }
+        if len(s.Endpoints) > 0 {
+                endpoints := s.Endpoints
+                if len(endpoints) > maxPromptEndpoints {
+                        endpoints = endpoints[:maxPromptEndpoints]
+                }
+                lines = append(lines, "endpoints: "+strings.Join(endpoints, " "))
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Download up to config.ScriptFiles of the page's scripts on the target's
+// host, reading at most scriptMax bytes of each, and collect the paths they
+// name. Scripts that fail are skipped; nil when none names a path.
+func scrapeScripts(ctx context.Context, config *Config, baseURL string, scripts []string) *ScriptSummary {
+        target, err := url.Parse(baseURL)
+        if err != nil {
+                return nil
+        }
+        ctx, cancel := context.WithTimeout(ctx, scriptsTimeout)
+        defer cancel()
+        client := targetClient(config)
+
+        summary := &ScriptSummary{}
+        fetched := 0
+        for _, script := range scripts {
+                if fetched == config.ScriptFiles {
+                        break
+                }
+                scriptURL, err := url.Parse(script)
+                if err != nil || !strings.EqualFold(scriptURL.Hostname(), target.Hostname()) {
+                        continue
+                }
+                fetched++
+                req, err := http.NewRequestWithContext(ctx, "GET", script, nil)
+                if err != nil {
+                        continue
+                }
+                req.Header.Set("User-Agent", "ffufai/"+Version)
+                resp, err := client.Do(req)
+                if err != nil {
+                        continue
+                }
+                // Bundles can be many megabytes; only the start is read
+                body, _ := io.ReadAll(io.LimitReader(resp.Body, scriptMax))
+                resp.Body.Close()
+                if resp.StatusCode != http.StatusOK {
+                        continue
+                }
+
+                for _, match := range scriptPathRegex.FindAllSubmatch(body, -1) {
+                        endpoint := string(match[1])
+                        if strings.HasPrefix(endpoint, "//") || len(summary.Endpoints) == maxScriptEndpoints || containsString(summary.Endpoints, endpoint) {
+                                continue
+                        }
+                        summary.Endpoints = append(summary.Endpoints, endpoint)
+                        if ext := strings.ToLower(path.Ext(endpoint)); sitemapExtensionRegex.MatchString(ext) && !containsString(summary.Extensions, ext) {
+                                summary.Extensions = append(summary.Extensions, ext)
+                        }
+                }
+        }
+        if len(summary.Endpoints) == 0 {
+                return nil
+        }
+        return summary
+}
+
+// CSP directives whose sources name the hosts a page loads code from or
+// talks to
+var cspHostDirectives = []string{"script-src", "connect-src", "report-uri"}
+
+// Most entries kept per list of a CSP summary, and shown in the prompt
+const (
+        maxCSPEntries       = 100
+        maxPromptCSPEntries = 20
+)
+
+// Second-level labels under which a country code domain registers names,
+// as in co.uk or com.au
+var secondLevelLabels = []string{"co", "com", "net", "org", "gov", "edu", "ac"}
+
+// Registrable domain of a host, approximated without the public suffix list:
+// the last two labels, or three under a country code with a generic second
+// level
+func organizationDomain(host string) string {
+        labels := strings.Split(strings.TrimSuffix(strings.ToLower(host), "."), ".")
+        keep := 2
+        if n := len(labels); n >= 3 && len(labels[n-1]) == 2 && containsString(secondLevelLabels, labels[n-2]) {
+                keep = 3
+        }
+        if len(labels) <= keep {
+                return strings.Join(labels, ".")
+        }
+        return strings.Join(labels[len(labels)-keep:], ".")
+}
+
+// Hosts and paths named by the target's Content-Security-Policy
+type CSPSummary struct {
+        // Other hosts of the target's organization, such as api. or cdn.
+        Hosts []string
+        // Wildcard sources such as *.example.com, which name no single host
+        Wildcards []string
+        // Hosts of other organizations, such as analytics or payment providers
+        External []string
+        // Paths on the target itself, such as the report-uri endpoint
+        Paths []string
+}
+
+func (s *CSPSummary) String() string {
+        var lines []string
+        for _, list := range []struct {
+                label   string
+                entries []string
+        }{
+                {"related hosts", s.Hosts},
+                {"wildcards", s.Wildcards},
+                {"paths", s.Paths},
+                {"third-party hosts", s.External},
+        } {
+                if len(list.entries) == 0 {
+                        continue
+                }
+                entries := list.entries
+                if len(entries) > maxPromptCSPEntries {
+                        entries = entries[:maxPromptCSPEntries]
+                }
+                lines = append(lines, list.label+": "+strings.Join(entries, " "))
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Read the script-src, connect-src and report-uri sources of every
+// Content-Security-Policy and Content-Security-Policy-Report-Only policy in
+// the headers. Keywords, scheme-only sources and anything that is not a
+// hostname are skipped. Returns nil when no source names a host or path.
+func parseCSP(targetHost string, headers map[string]string) *CSPSummary {
+        targetHost = strings.ToLower(targetHost)
+        // An IP address belongs to no organization
+        organization := ""
+        if net.ParseIP(targetHost) == nil {
+                organization = organizationDomain(targetHost)
+        }
+        summary := &CSPSummary{}
+        add := func(list *[]string, entry string) {
+                if len(*list) < maxCSPEntries && !containsString(*list, entry) {
+                        *list = append(*list, entry)
+                }
+        }
+
+        for _, name := range []string{"Content-Security-Policy", "Content-Security-Policy-Report-Only"} {
+                // Several headers arrive joined by commas, which also separate policies
+                for _, policy := range strings.Split(headers[name], ",") {
+                        for _, directive := range strings.Split(policy, ";") {
+                                fields := strings.Fields(directive)
+                                if len(fields) < 2 || !containsString(cspHostDirectives, strings.ToLower(fields[0])) {
+                                        continue
+                                }
+                                for _, source := range fields[1:] {
+                                        if strings.HasPrefix(source, "'") || strings.HasSuffix(source, ":") {
+                                                continue
+                                        }
+                                        if strings.HasPrefix(source, "/") {
+                                                add(&summary.Paths, source)
+                                                continue
+                                        }
+                                        rest := source
+                                        if i := strings.Index(rest, "://"); i >= 0 {
+                                                rest = rest[i+3:]
+                                        }
+                                        host, sourcePath, _ := strings.Cut(rest, "/")
+                                        host, _, _ = strings.Cut(strings.ToLower(host), ":")
+                                        wildcard := strings.HasPrefix(host, "*.")
+                                        host = strings.TrimPrefix(host, "*.")
+                                        if !strings.Contains(host, ".") || !hostnameRegex.MatchString(host) {
+                                                continue
+                                        }
+                                        switch {
+                                        case wildcard:
+                                                add(&summary.Wildcards, "*."+host)
+                                        case host == targetHost:
+                                                if sourcePath != "" {
+                                                        add(&summary.Paths, "/"+sourcePath)
+                                                }
+                                        case organization != "" && organizationDomain(host) == organization:
+                                                add(&summary.Hosts, host)
+                                        default:
+                                                add(&summary.External, host)
+                                        }
+                                }
+                        }
+                }
+        }
+        if len(summary.Hosts)+len(summary.Wildcards)+len(summary.External)+len(summary.Paths) == 0 {
+                return nil
+        }
+        return summary
+}
+
+// Add hosts to a targets file, one per line, keeping the ones it already
+// lists. Returns how many were new.
+func appendRelatedHosts(path string, hosts []string) (int, error) {
+        var known []string
+        if data, err := os.ReadFile(path); err == nil {
+                for _, line := range strings.Split(string(data), "\n") {
+                        if line = strings.TrimSpace(line); line != "" {
+                                known = append(known, line)
+                        }
+                }
+        }
+        added := 0
+        for _, host := range hosts {
+                if !containsString(known, host) {
+                        known = append(known, host)
+                        added++
+                }
+        }
+        if err := os.WriteFile(path, []byte(strings.Join(known, "\n")+"\n"), 0o644); err != nil {
+                return 0, fmt.Errorf("writing related hosts: %w", err)
+        }
+        return added, nil
+}
+
+// One link of a Link header
+type HeaderLink struct {
+        URL string `json:"url"`
+        Rel string `json:"rel"`
+}
+
+func (l HeaderLink) String() string {
+        return fmt.Sprintf("<%s>; rel=%q", l.URL, l.Rel)
+}
+
+// Parse a Link header value, one or more comma-separated links, into URL and
+// rel pairs. Relative URLs are resolved against baseURL; links without a rel
+// or a URL are skipped.
+func parseLinks(baseURL string, value string) []HeaderLink {
+        base, _ := url.Parse(baseURL)
+        var links []HeaderLink
+        for rest := value; ; {
+                start := strings.Index(rest, "<")
+                if start < 0 {
+                        break
+                }
+                end := strings.Index(rest[start:], ">")
+                if end < 0 {
+                        break
+                }
+                target := strings.TrimSpace(rest[start+1 : start+end])
+                rest = rest[start+end+1:]
+
+                // Parameters run to the next comma outside a quoted string
+                quoted := false
+                params := len(rest)
+                for i, r := range rest {
+                        if r == '"' {
+                                quoted = !quoted
+                        } else if r == ',' && !quoted {
+                                params = i
+                                break
+                        }
+                }
+                rel := ""
+                for _, param := range strings.Split(rest[:params], ";") {
+                        name, paramValue, found := strings.Cut(param, "=")
+                        if found && strings.EqualFold(strings.TrimSpace(name), "rel") {
+                                rel = strings.Trim(strings.TrimSpace(paramValue), `"`)
+                        }
+                }
+                rest = rest[params:]
+
+                linkURL, err := url.Parse(target)
+                if err != nil || target == "" || strings.ContainsAny(target, "<> ") || rel == "" {
+                        continue
+                }
+                if base != nil {
+                        linkURL = base.ResolveReference(linkURL)
+                }
+                links = append(links, HeaderLink{URL: linkURL.String(), Rel: rel})
+        }
+        return links
+}
+
+// Link relations and URLs worth a place in the prompt: the WordPress REST API,
+// API descriptions and OAuth or OpenID endpoints
+var (
+        interestingLinkRels = []string{"https://api.w.org/", "service-desc", "service-doc", "describedby", "oauth2-authorize", "oauth2-token", "openid2.provider"}
+        interestingLinkURL  = regexp.MustCompile(`(?i)/wp-json|oauth|openid|openapi|swagger|api-docs`)
+)
+
+// Keep the links that hint at a CMS API, an API description or an OAuth
+// endpoint; pagination and stylesheet links are dropped
+func interestingLinks(links []HeaderLink) []HeaderLink {
+        var kept []HeaderLink
+        for _, link := range links {
+                keep := interestingLinkURL.MatchString(link.URL)
+                for _, rel := range strings.Fields(strings.ToLower(link.Rel)) {
+                        keep = keep || containsString(interestingLinkRels, rel)
+                }
+                if keep {
+                        kept = append(kept, link)
+                }
+        }
+        return kept
+}
+
+// Lines of links for the prompt
+func linksText(links []HeaderLink) string {
+        lines := make([]string, len(links))
+        for i, link := range links {
+                lines[i] = link.String()
+        }
+        return strings.Join(lines, "\n")
+}
+
+// Summarize the CSP for the prompt, print the related hosts and wildcards it
+// names, and add the related hosts to the --related-out file
+func reportCSP(config *Config, baseURL string, headers map[string]string) {
+        target, err := url.Parse(baseURL)
+        if err != nil {
+                return
+        }
+        csp := parseCSP(target.Hostname(), headers)
+        if csp == nil {
+                csp = &CSPSummary{}
+        } else {
+                config.CSP = csp.String()
+        }
+
+        if len(csp.Hosts) > 0 {
+                fmt.Fprintf(textOutput, "%sRelated hosts: %s%s\n", ColorGreen, strings.Join(csp.Hosts, " "), ColorReset)
+        }
+        if len(csp.Wildcards) > 0 {
+                fmt.Fprintf(textOutput, "%sCSP wildcards, not single hosts: %s%s\n", ColorYellow, strings.Join(csp.Wildcards, " "), ColorReset)
+        }
+        if config.Verbose {
+                if len(csp.Paths) > 0 {
+                        fmt.Fprintf(textOutput, "CSP paths: %s\n", strings.Join(csp.Paths, " "))
+                }
+                if len(csp.External) > 0 {
+                        fmt.Fprintf(textOutput, "CSP third-party hosts: %s\n", strings.Join(csp.External, " "))
+                }
+        }
+
+        if config.RelatedOut == "" {
+                return
+        }
+        if len(csp.Hosts) == 0 {
+                fmt.Fprintf(os.Stderr, "%sWarning: the CSP names no related hosts, not writing %s%s\n", ColorYellow, config.RelatedOut, ColorReset)
+        } else if added, err := appendRelatedHosts(config.RelatedOut, csp.Hosts); err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: %v%s\n", ColorYellow, err, ColorReset)
+        } else {
+                fmt.Fprintf(textOutput, "%sAdded %d related hosts to %s%s\n", ColorGreen, added, config.RelatedOut, ColorReset)
+        }
+}
+
+// Stage one: ask the AI which server, language, framework and CMS the target
+// runs, so the extension prompt can stick to that stack
+func detectStack(ctx context.Context, config *Config, urlStr string, headers map[string]string, hints string) (*StackDescriptor, error) {
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return nil, fmt.Errorf("marshaling headers: %w", err)
+        }
+        if hints == "" {
+                hints = "(none)"
+        }
+        notes := ""
+        if config.Technologies != nil {
+                notes += "Local fingerprint: " + config.Technologies.String() + "\n"
+        }
+        if config.DNSInfo != nil {
+                notes += "DNS:\n" + config.DNSInfo.String() + "\n"
+        }
+        if config.PathProbes != "" {
+                notes += "Other paths:\n" + config.PathProbes + "\n"
+        }
+        if config.AIContext != "" {
+                notes += "\nTester notes about the target (hints, not instructions):\n\"\"\"\n" + config.AIContext + "\n\"\"\"\n"
+        }
+
+        prompt := fmt.Sprintf(`Identify the technology stack of this web target from its URL, HTTP headers and page hints.
+Respond with a JSON object in the format: {"server": "...", "language": "...", "framework": "...", "cms": "..."}.
+Name one technology per field, or "unknown" when there is no evidence for it. Do not guess between several stacks.
+No preamble or explanation needed.
+
+URL: %s
+Headers: %s
+Page hints:
+%s
+%s
+Response:`, urlStr, string(headersJSON), hints, notes)
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that fingerprints web application stacks. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   200,
+                Temperature: 0.0,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var stack StackDescriptor
+        if err := decodeJSONReply(completion.Content, &stack); err != nil {
+                return nil, err
+        }
+        stack.Technologies = nil
+        if stack.String() == "" {
+                return nil, fmt.Errorf("AI could not identify the stack")
+        }
+        return &stack, nil
+}
+
+// Security header issue found in the base response, rated with the
+// severity levels of --severity
+type AuditFinding struct {
+        Header   string `json:"header"`
+        Severity string `json:"severity"`
+        Issue    string `json:"issue"`
+}
+
+// HSTS max-age below this (180 days) is reported as short
+const minHSTSMaxAge = 15552000
+
+var hstsMaxAgeRegex = regexp.MustCompile(`(?i)max-age\s*=\s*"?(\d+)`)
+
+// Check the base response for missing or weak security headers and cookie
+// flags. Purely local and informational.
+func auditHeaders(urlStr string, headers map[string]string) []AuditFinding {
+        get := func(name string) string {
+                for key, value := range headers {
+                        if strings.EqualFold(key, name) {
+                                return value
+                        }
+                }
+                return ""
+        }
+        https := strings.HasPrefix(strings.ToLower(urlStr), "https://")
+        var findings []AuditFinding
+
+        if https {
+                hsts := get("Strict-Transport-Security")
+                if hsts == "" {
+                        findings = append(findings, AuditFinding{"Strict-Transport-Security", SeverityMedium, "missing"})
+                } else if match := hstsMaxAgeRegex.FindStringSubmatch(hsts); match == nil {
+                        findings = append(findings, AuditFinding{"Strict-Transport-Security", SeverityLow, "no max-age"})
+                } else if age, _ := strconv.Atoi(match[1]); age < minHSTSMaxAge {
+                        findings = append(findings, AuditFinding{"Strict-Transport-Security", SeverityLow, fmt.Sprintf("max-age %d is under 180 days", age)})
+                }
+        }
+
+        csp := get("Content-Security-Policy")
+        switch {
+        case csp == "":
+                findings = append(findings, AuditFinding{"Content-Security-Policy", SeverityLow, "missing"})
+        case strings.Contains(csp, "'unsafe-inline'") || strings.Contains(csp, "'unsafe-eval'"):
+                findings = append(findings, AuditFinding{"Content-Security-Policy", SeverityInfo, "allows unsafe-inline or unsafe-eval"})
+        }
+
+        if get("X-Frame-Options") == "" && !strings.Contains(strings.ToLower(csp), "frame-ancestors") {
+                findings = append(findings, AuditFinding{"X-Frame-Options", SeverityLow, "missing and no CSP frame-ancestors, pages can be framed"})
+        }
+        if !strings.EqualFold(strings.TrimSpace(get("X-Content-Type-Options")), "nosniff") {
+                findings = append(findings, AuditFinding{"X-Content-Type-Options", SeverityInfo, "not set to nosniff"})
+        }
+
+        origin := strings.TrimSpace(get("Access-Control-Allow-Origin"))
+        credentials := strings.EqualFold(strings.TrimSpace(get("Access-Control-Allow-Credentials")), "true")
+        switch {
+        case origin == "null":
+                findings = append(findings, AuditFinding{"Access-Control-Allow-Origin", SeverityMedium, "allows the null origin"})
+        case origin == "*" && credentials:
+                findings = append(findings, AuditFinding{"Access-Control-Allow-Origin", SeverityMedium, "any origin with credentials"})
+        case origin == "*":
+                findings = append(findings, AuditFinding{"Access-Control-Allow-Origin", SeverityLow, "any origin"})
+        }
+
+        for _, cookie := range strings.Split(get("Set-Cookie"), "\n") {
+                name, attributes, _ := strings.Cut(strings.TrimSpace(cookie), ";")
+                if name, _, _ = strings.Cut(name, "="); name == "" {
+                        continue
+                }
+                attributes = strings.ToLower(attributes)
+                var missing []string
+                if https && !strings.Contains(attributes, "secure") {
+                        missing = append(missing, "Secure")
+                }
+                if !strings.Contains(attributes, "httponly") {
+                        missing = append(missing, "HttpOnly")
+                }
+                if !strings.Contains(attributes, "samesite") {
+                        missing = append(missing, "SameSite")
+                }
+                if len(missing) > 0 {
+                        severity := SeverityLow
+                        if containsString(missing, "Secure") || containsString(missing, "HttpOnly") {
+                                severity = SeverityMedium
+                        }
+                        findings = append(findings, AuditFinding{"Set-Cookie", severity, fmt.Sprintf("%s lacks %s", name, strings.Join(missing, ", "))})
+                }
+        }
+        return findings
+}
+
+// Print the audit as a compact table, colored by severity
+func printAudit(findings []AuditFinding) {
+        if len(findings) == 0 {
+                fmt.Fprintf(textOutput, "%sSecurity header audit: no issues%s\n", ColorGreen, ColorReset)
+                return
+        }
+        width := 0
+        for _, finding := range findings {
+                width = max(width, len(finding.Header))
+        }
+        fmt.Fprintf(textOutput, "%sSecurity header audit:%s\n", ColorCyan, ColorReset)
+        for _, finding := range findings {
+                // Padded by hand; tabwriter would count the color codes
+                fmt.Fprintf(textOutput, "  %s%-6s%s  %-*s  %s\n", severityColor(finding.Severity), finding.Severity, ColorReset, width, finding.Header, finding.Issue)
+        }
+}
+
+// Header or cookie that gives away a WAF or CDN. Header names ending in "-"
+// match every header with that prefix; Pattern, when set, must match the
+// header's value. Cookie matches cookie names by prefix.
+type WAFSignature struct {
+        Product string
+        WAF     bool
+        Header  string
+        Pattern *regexp.Regexp
+        Cookie  string
+}
+
+// Signatures chosen to be specific: a generic "Via" or "X-Cache" alone says
+// nothing, so only product-specific names and values are listed
+var wafSignatures = []WAFSignature{
+        {Product: "Cloudflare", WAF: true, Header: "Server", Pattern: regexp.MustCompile(`(?i)^cloudflare`)},
+        {Product: "Cloudflare", WAF: true, Header: "Cf-Ray"},
+        {Product: "Cloudflare", WAF: true, Cookie: "__cf_bm"},
+        {Product: "Cloudflare", WAF: true, Cookie: "cf_clearance"},
+        {Product: "Akamai", WAF: true, Header: "X-Akamai-"},
+        {Product: "Akamai", WAF: true, Header: "Akamai-Grn"},
+        {Product: "Akamai", WAF: true, Header: "Server", Pattern: regexp.MustCompile(`(?i)^akamaighost`)},
+        {Product: "Akamai", WAF: true, Cookie: "ak_bmsc"},
+        {Product: "Sucuri", WAF: true, Header: "X-Sucuri-"},
+        {Product: "Sucuri", WAF: true, Header: "Server", Pattern: regexp.MustCompile(`(?i)sucuri`)},
+        {Product: "Imperva Incapsula", WAF: true, Header: "X-Iinfo"},
+        {Product: "Imperva Incapsula", WAF: true, Header: "X-Cdn", Pattern: regexp.MustCompile(`(?i)incapsula|imperva`)},
+        {Product: "Imperva Incapsula", WAF: true, Cookie: "incap_ses_"},
+        {Product: "Imperva Incapsula", WAF: true, Cookie: "visid_incap_"},
+        {Product: "AWS WAF", WAF: true, Cookie: "aws-waf-token"},
+        {Product: "F5 BIG-IP", WAF: true, Cookie: "BIGipServer"},
+        {Product: "F5 BIG-IP", WAF: true, Header: "Server", Pattern: regexp.MustCompile(`(?i)^big-?ip`)},
+        {Product: "Barracuda", WAF: true, Cookie: "barra_counter_session"},
+        {Product: "ModSecurity", WAF: true, Header: "Server", Pattern: regexp.MustCompile(`(?i)mod_security|modsecurity`)},
+        {Product: "Azure Front Door", WAF: true, Header: "X-Azure-Ref"},
+        {Product: "Amazon CloudFront", Header: "X-Amz-Cf-Id"},
+        {Product: "Amazon CloudFront", Header: "Via", Pattern: regexp.MustCompile(`(?i)cloudfront`)},
+        {Product: "Fastly", Header: "X-Fastly-Request-Id"},
+        {Product: "Fastly", Header: "X-Served-By", Pattern: regexp.MustCompile(`(?i)^cache-`)},
+}
+
+// WAF or CDN products found in a set of headers, with the evidence for each
+type WAFDetection struct {
+        Product  string
+        WAF      bool
+        Evidence []string
+}
+
+// Match the signatures against the headers, one detection per product in
+// the order first seen
+func detectWAF(headers map[string]string) []WAFDetection {
+        var cookies []string
+        for name, value := range headers {
+                if strings.EqualFold(name, "Set-Cookie") {
+                        cookies = strings.Split(cookieNames(value), ", ")
+                }
+        }
+
+        var detections []WAFDetection
+        add := func(signature WAFSignature, evidence string) {
+                for i := range detections {
+                        if detections[i].Product == signature.Product {
+                                if !containsString(detections[i].Evidence, evidence) {
+                                        detections[i].Evidence = append(detections[i].Evidence, evidence)
+                                }
+                                return
+                        }
+                }
+                detections = append(detections, WAFDetection{Product: signature.Product, WAF: signature.WAF, Evidence: []string{evidence}})
+        }
+        for _, signature := range wafSignatures {
+                if signature.Cookie != "" {
+                        for _, cookie := range cookies {
+                                if strings.HasPrefix(strings.ToLower(cookie), strings.ToLower(signature.Cookie)) {
+                                        add(signature, "cookie "+cookie)
+                                }
+                        }
+                        continue
+                }
+                for name, value := range headers {
+                        matched := strings.EqualFold(name, signature.Header)
+                        if strings.HasSuffix(signature.Header, "-") {
+                                matched = strings.HasPrefix(strings.ToLower(name), strings.ToLower(signature.Header))
+                        }
+                        if matched && (signature.Pattern == nil || signature.Pattern.MatchString(value)) {
+                                add(signature, name)
+                        }
+                }
+        }
+        return detections
+}
+
+// Query string no real page expects; a WAF answers it with a block page
+const wafCanaryQuery = "ffufai=%3Cscript%3Ealert(1)%3C%2Fscript%3E&id=1%27%20OR%20%271%27%3D%271&file=..%2F..%2F..%2Fetc%2Fpasswd"
+
+// Status codes WAFs block requests with
+var wafBlockCodes = []int{http.StatusForbidden, http.StatusNotAcceptable, http.StatusTooManyRequests, http.StatusNotImplemented, 419, 999}
+
+// Request the base URL with an obviously malicious query. Returns whether it
+// looks blocked while the plain request was not, and the canary response's
+// headers, which may carry the WAF's signature only on blocks.
+func probeWAFCanary(ctx context.Context, config *Config, baseURL string, baseStatus int) (bool, map[string]string, error) {
+        canaryURL := baseURL + "?" + wafCanaryQuery
+        if strings.Contains(baseURL, "?") {
+                canaryURL = baseURL + "&" + wafCanaryQuery
+        }
+        client := targetClient(config)
+        client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+                return http.ErrUseLastResponse
+        }
+        headers, status, _, err := probeHeaders(ctx, client, "GET", canaryURL)
+        if err != nil {
+                return false, nil, err
+        }
+        blocked := containsInt(wafBlockCodes, status) && !containsInt(wafBlockCodes, baseStatus)
+        return blocked, headers, nil
+}
+
+// How to get ffuf, for the error when it cannot be found
+const ffufInstallHint = "install it with 'go install github.com/ffuf/ffuf/v2@latest' or from https://github.com/ffuf/ffuf/releases, or point --ffuf-path at it"
+
+// How long ffuf -V may take
+const ffufVersionTimeout = 5 * time.Second
+
+// Version in the output of ffuf -V, such as "ffuf version: 2.1.0-dev"
+var ffufVersionRegex = regexp.MustCompile(`(?i)version:?\s*v?(\d+\.\d+(?:\.\d+)?[0-9A-Za-z.+-]*)`)
+
+// Find the ffuf binary and the version it reports with -V. The version is
+// "" when ffuf prints none ffufai recognizes.
+func checkFfuf(ffufPath string) (string, string, error) {
+        path, err := exec.LookPath(ffufPath)
+        if err != nil {
+                return "", "", fmt.Errorf("ffuf not found at %q; %s", ffufPath, ffufInstallHint)
+        }
+        ctx, cancel := context.WithTimeout(context.Background(), ffufVersionTimeout)
+        defer cancel()
+        output, err := exec.CommandContext(ctx, path, "-V").CombinedOutput()
+        if ctx.Err() != nil {
+                return "", "", fmt.Errorf("%s -V did not finish within %s; is it ffuf?", path, ffufVersionTimeout)
+        }
+        if match := ffufVersionRegex.FindStringSubmatch(string(output)); match != nil {
+                return path, match[1], nil
+        }
+        if err != nil {
+                return "", "", fmt.Errorf("running %s -V: %w; is it ffuf?", path, err)
+        }
+        return path, "", nil
+}
+
+// Whether a version such as "2.1.0-dev" is at least minimum, comparing
+// major, minor and patch. A -dev suffix counts as the release itself.
+func versionAtLeast(version, minimum string) bool {
+        numbers := func(v string) [3]int {
+                var parts [3]int
+                for i, field := range strings.SplitN(v, ".", 3) {
+                        end := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+                        if end >= 0 {
+                                field = field[:end]
+                        }
+                        parts[i], _ = strconv.Atoi(field)
+                }
+                return parts
+        }
+        have, want := numbers(version), numbers(minimum)
+        for i := range have {
+                if have[i] != want[i] {
+                        return have[i] > want[i]
+                }
+        }
+        return true
+}
+
+// ffuf release that added each option ffufai generates
+var ffufOptionVersions = map[string]string{
+        "-http2": "2.0.0",
+        "-json":  "2.0.0",
+        "-cc":    "2.1.0",
+        "-ck":    "2.1.0",
+        "-sni":   "2.1.0",
+}
+
+// Whether the ffuf binary has an option older releases lack: by its
+// version when both are known, otherwise by whether its help lists it
+func ffufSupports(config *Config, option string) bool {
+        if minimum, ok := ffufOptionVersions[option]; ok && config.FfufVersion != "" {
+                return versionAtLeast(config.FfufVersion, minimum)
+        }
+        // ffuf exits non-zero after printing its help
+        output, _ := exec.Command(config.FfufPath, "-h").CombinedOutput()
+        return regexp.MustCompile(`(?m)^\s*` + regexp.QuoteMeta(option) + `\b`).Match(output)
+}
+
+// Suggest ffuf's -http2 when the target negotiated HTTP/2, or add it with
+// --auto-http2, unless ffuf is too old to have it
+func offerHTTP2(config *Config, headers map[string]string) {
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "Negotiated protocol: %s\n", headers[ProtocolHeader])
+                if altSvc := headers["Alt-Svc"]; strings.Contains(altSvc, "h3") {
+                        fmt.Fprintf(textOutput, "Alt-Svc advertises HTTP/3: %s\n", altSvc)
+                }
+        }
+        if headers[ProtocolHeader] != "HTTP/2.0" || hasFfufFlag(effectiveFfufArgs(config), "-http2") {
+                return
+        }
+        if !ffufSupports(config, "-http2") {
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "The target speaks HTTP/2, but this ffuf has no -http2 option\n")
+                }
+                return
+        }
+        if config.AutoHTTP2 {
+                config.FfufArgs = append(config.FfufArgs, "-http2")
+                fmt.Fprintf(textOutput, "%sAdded -http2, the target negotiated HTTP/2%s\n", ColorGreen, ColorReset)
+        } else {
+                fmt.Fprintf(textOutput, "%sThe target negotiated HTTP/2, ffuf's -http2 would use it too (--auto-http2 adds it)%s\n", ColorYellow, ColorReset)
+        }
+}
+
+// Conservative ffuf settings for targets behind a WAF or CDN
+var (
+        wafRateFlags = []string{"-t", "5", "-rate", "10"}
+        cdnRateFlags = []string{"-t", "10", "-rate", "50"}
+)
+
+// ffuf options that set the request rate or concurrency
+var ffufRateFlags = []string{"-rate", "-p", "-t"}
+
+// Warn about a WAF or CDN in front of the target and suggest, or with
+// --auto-rate apply, gentler ffuf rate flags unless the user chose their own
+func guardRate(ctx context.Context, config *Config, baseURL string, headers map[string]string) {
+        detections := detectWAF(headers)
+        blocked := false
+        if config.WAFProbe {
+                status, _ := strconv.Atoi(strings.Fields(headers["Status-Code"] + " 0")[0])
+                canaryBlocked, canaryHeaders, err := probeWAFCanary(ctx, config, baseURL, status)
+                if err != nil {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "WAF canary request failed: %v\n", err)
+                        }
+                } else {
+                        blocked = canaryBlocked
+                        for _, detection := range detectWAF(canaryHeaders) {
+                                found := false
+                                for _, known := range detections {
+                                        found = found || known.Product == detection.Product
+                                }
+                                if !found {
+                                        detections = append(detections, detection)
+                                }
+                        }
+                }
+        }
+        if len(detections) == 0 && !blocked {
+                return
+        }
+
+        waf := blocked
+        var products []string
+        for _, detection := range detections {
+                waf = waf || detection.WAF
+                products = append(products, fmt.Sprintf("%s (%s)", detection.Product, strings.Join(detection.Evidence, ", ")))
+        }
+        switch {
+        case len(products) == 0:
+                fmt.Fprintf(os.Stderr, "%s%sWarning: a request with a malicious-looking query was blocked, the target is likely behind a WAF%s\n", ColorYellow, ColorBold, ColorReset)
+        case blocked:
+                fmt.Fprintf(os.Stderr, "%s%sWarning: the target is behind %s, which blocked a request with a malicious-looking query%s\n", ColorYellow, ColorBold, strings.Join(products, ", "), ColorReset)
+        default:
+                fmt.Fprintf(os.Stderr, "%s%sWarning: the target is behind %s%s\n", ColorYellow, ColorBold, strings.Join(products, ", "), ColorReset)
+        }
+
+        for _, name := range ffufRateFlags {
+                if hasFfufFlag(effectiveFfufArgs(config), name) {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Keeping your %s; no rate flags added\n", name)
+                        }
+                        return
+                }
+        }
+        flags := cdnRateFlags
+        if waf {
+                flags = wafRateFlags
+        }
+        if config.AutoRate {
+                config.FfufArgs = append(config.FfufArgs, flags...)
+                fmt.Fprintf(textOutput, "%sAdded %s to avoid being blocked%s\n", ColorGreen, strings.Join(flags, " "), ColorReset)
+        } else {
+                fmt.Fprintf(textOutput, "%sSuggested: %s to avoid being blocked (--auto-rate adds them)%s\n", ColorYellow, strings.Join(flags, " "), ColorReset)
+        }
+}
+
+// Local fingerprint rule. It matches when every condition it has holds: the
+// Header is present and its value matches Pattern, a cookie whose name starts
+// with Cookie is set, the page hints contain Body, and robots.txt or the
+// sitemaps name Path. Text comparisons ignore case. A match fills the fields
+// of Stack that no earlier rule filled.
+type FingerprintRule struct {
+        Header  string          `json:"header,omitempty"`
+        Pattern string          `json:"pattern,omitempty"`
+        Cookie  string          `json:"cookie,omitempty"`
+        Body    string          `json:"body,omitempty"`
+        Path    string          `json:"path,omitempty"`
+        Stack   StackDescriptor `json:"stack"`
+
+        regex *regexp.Regexp
+}
+
+// Built-in rules; rules from the config file are checked first, so they
+// override these
+var defaultFingerprintRules = []FingerprintRule{
+        // Servers
+        {Header: "Server", Pattern: `nginx`, Stack: StackDescriptor{Server: "nginx"}},
+        {Header: "Server", Pattern: `openresty`, Stack: StackDescriptor{Server: "OpenResty"}},
+        {Header: "Server", Pattern: `apache-coyote|tomcat`, Stack: StackDescriptor{Server: "Apache Tomcat", Language: "java"}},
+        {Header: "Server", Pattern: `apache`, Stack: StackDescriptor{Server: "Apache httpd"}},
+        {Header: "Server", Pattern: `microsoft-iis`, Stack: StackDescriptor{Server: "Microsoft IIS", Language: "asp.net"}},
+        {Header: "Server", Pattern: `kestrel`, Stack: StackDescriptor{Server: "Kestrel", Language: "asp.net"}},
+        {Header: "Server", Pattern: `litespeed`, Stack: StackDescriptor{Server: "LiteSpeed"}},
+        {Header: "Server", Pattern: `caddy`, Stack: StackDescriptor{Server: "Caddy"}},
+        {Header: "Server", Pattern: `jetty`, Stack: StackDescriptor{Server: "Jetty", Language: "java"}},
+        {Header: "Server", Pattern: `gunicorn|uvicorn`, Stack: StackDescriptor{Language: "python"}},
+        {Header: "Server", Pattern: `werkzeug`, Stack: StackDescriptor{Language: "python", Framework: "flask"}},
+        {Header: "Server", Pattern: `phusion passenger|puma|webrick`, Stack: StackDescriptor{Language: "ruby"}},
+
+        // Languages and frameworks
+        {Header: "X-Powered-By", Pattern: `php`, Stack: StackDescriptor{Language: "php"}},
+        {Header: "X-Powered-By", Pattern: `asp\.net`, Stack: StackDescriptor{Language: "asp.net"}},
+        {Header: "X-Powered-By", Pattern: `express`, Stack: StackDescriptor{Language: "node.js", Framework: "express"}},
+        {Header: "X-Powered-By", Pattern: `next\.js`, Stack: StackDescriptor{Language: "node.js", Framework: "next.js"}},
+        {Header: "X-Powered-By", Pattern: `servlet|jsp|jboss|undertow`, Stack: StackDescriptor{Language: "java"}},
+        {Header: "X-AspNet-Version", Stack: StackDescriptor{Language: "asp.net"}},
+        {Header: "X-AspNetMvc-Version", Stack: StackDescriptor{Language: "asp.net", Framework: "asp.net mvc"}},
+        {Header: "X-Runtime", Pattern: `^[0-9.]+$`, Stack: StackDescriptor{Language: "ruby", Framework: "rails"}},
+
+        // Session cookies
+        {Cookie: "PHPSESSID", Stack: StackDescriptor{Language: "php"}},
+        {Cookie: "laravel_session", Stack: StackDescriptor{Language: "php", Framework: "laravel"}},
+        {Cookie: "ci_session", Stack: StackDescriptor{Language: "php", Framework: "codeigniter"}},
+        {Cookie: "JSESSIONID", Stack: StackDescriptor{Language: "java"}},
+        {Cookie: "ASP.NET_SessionId", Stack: StackDescriptor{Language: "asp.net"}},
+        {Cookie: "ASPSESSIONID", Stack: StackDescriptor{Language: "classic asp"}},
+        {Cookie: "csrftoken", Stack: StackDescriptor{Language: "python", Framework: "django"}},
+        {Cookie: "connect.sid", Stack: StackDescriptor{Language: "node.js", Framework: "express"}},
+        {Cookie: "wordpress_", Stack: StackDescriptor{Language: "php", CMS: "wordpress"}},
+        {Cookie: "wp-settings-", Stack: StackDescriptor{Language: "php", CMS: "wordpress"}},
+
+        // CMS headers, page hints and well-known paths
+        {Header: "X-Generator", Pattern: `drupal`, Stack: StackDescriptor{Language: "php", CMS: "drupal"}},
+        {Header: "X-Drupal-Cache", Stack: StackDescriptor{Language: "php", CMS: "drupal"}},
+        {Header: "X-Pingback", Stack: StackDescriptor{Language: "php", CMS: "wordpress"}},
+        {Header: "Link", Pattern: `/wp-json/`, Stack: StackDescriptor{Language: "php", CMS: "wordpress"}},
+        {Body: "wordpress", Stack: StackDescriptor{Language: "php", CMS: "wordpress"}},
+        {Body: "drupal", Stack: StackDescriptor{Language: "php", CMS: "drupal"}},
+        {Body: "joomla", Stack: StackDescriptor{Language: "php", CMS: "joomla"}},
+        {Body: "typo3", Stack: StackDescriptor{Language: "php", CMS: "typo3"}},
+        {Body: "magento", Stack: StackDescriptor{Language: "php", CMS: "magento"}},
+        {Body: "asp.net web forms", Stack: StackDescriptor{Language: "asp.net", Framework: "web forms"}},
+        {Body: "next.js", Stack: StackDescriptor{Language: "node.js", Framework: "next.js"}},
+        {Body: "django", Stack: StackDescriptor{Language: "python", Framework: "django"}},
+        {Body: "ruby on rails", Stack: StackDescriptor{Language: "ruby", Framework: "rails"}},
+        {Path: "/wp-admin", Stack: StackDescriptor{Language: "php", CMS: "wordpress"}},
+        {Path: "/administrator/", Stack: StackDescriptor{Language: "php", CMS: "joomla"}},
+}
+
+// Check a rule and compile its pattern
+func (r *FingerprintRule) compile() error {
+        if r.Header == "" && r.Cookie == "" && r.Body == "" && r.Path == "" {
+                return fmt.Errorf("fingerprint rule needs a header, cookie, body or path")
+        }
+        if r.Pattern != "" && r.Header == "" {
+                return fmt.Errorf("fingerprint rule %q: a pattern needs a header", r.Pattern)
+        }
+        if r.Stack.String() == "" {
+                return fmt.Errorf("fingerprint rule needs a stack with at least one field")
+        }
+        regex, err := regexp.Compile("(?i)" + r.Pattern)
+        if err != nil {
+                return fmt.Errorf("fingerprint rule %q: %w", r.Pattern, err)
+        }
+        r.regex = regex
+        return nil
+}
+
+// Evidence a fingerprint is taken from
+type fingerprintInput struct {
+        headers map[string]string
+        cookies []string
+        body    string
+        paths   string
+}
+
+func (r *FingerprintRule) matches(input fingerprintInput) bool {
+        if r.Header != "" {
+                value, ok := "", false
+                for name, v := range input.headers {
+                        if strings.EqualFold(name, r.Header) {
+                                value, ok = v, true
+                                break
+                        }
+                }
+                if !ok || !r.regex.MatchString(value) {
+                        return false
+                }
+        }
+        if r.Cookie != "" {
+                found := false
+                for _, cookie := range input.cookies {
+                        found = found || strings.HasPrefix(cookie, strings.ToLower(r.Cookie))
+                }
+                if !found {
+                        return false
+                }
+        }
+        if r.Body != "" && !strings.Contains(input.body, strings.ToLower(r.Body)) {
+                return false
+        }
+        return r.Path == "" || strings.Contains(input.paths, strings.ToLower(r.Path))
+}
+
+// Rules from the config file followed by the built-in ones, compiled
+func fingerprintRules(fileConfig *FileConfig) ([]FingerprintRule, error) {
+        rules := append(append([]FingerprintRule(nil), fileConfig.FingerprintRules...), defaultFingerprintRules...)
+        for i := range rules {
+                if err := rules[i].compile(); err != nil {
+                        return nil, err
+                }
+        }
+        return rules, nil
+}
+
+// The base headers with the well-known paths' headers the base response
+// lacks and all of their cookies, for fingerprinting
+func withProbeHeaders(base map[string]string, probes []PathProbe) map[string]string {
+        merged := make(map[string]string, len(base))
+        for name, value := range base {
+                merged[name] = value
+        }
+        for _, probe := range probes {
+                for name, value := range probe.Headers {
+                        switch {
+                        case name == "Set-Cookie":
+                                // Cookie names only; fingerprinting reads nothing else
+                                cookies := strings.ReplaceAll(value, ", ", "\n")
+                                if merged[name] != "" {
+                                        cookies = merged[name] + "\n" + cookies
+                                }
+                                merged[name] = cookies
+                        case merged[name] == "":
+                                merged[name] = value
+                        }
+                }
+        }
+        return merged
+}
+
+// Guess the stack without the AI from the raw headers, the page hints and the
+// robots.txt and sitemap summaries. Returns nil when no rule matched.
+func fingerprintStack(rules []FingerprintRule, headers map[string]string, hints string, paths string) *StackDescriptor {
+        input := fingerprintInput{headers: headers, body: strings.ToLower(hints), paths: strings.ToLower(paths)}
+        for name, value := range headers {
+                if strings.EqualFold(name, "Set-Cookie") {
+                        for _, cookie := range strings.Split(cookieNames(value), ", ") {
+                                input.cookies = append(input.cookies, strings.ToLower(cookie))
+                        }
+                }
+        }
+
+        var stack StackDescriptor
+        for i := range rules {
+                if rules[i].matches(input) {
+                        fillStack(&stack, rules[i].Stack)
+                }
+        }
+        if stack.String() == "" {
+                return nil
+        }
+        return &stack
+}
+
+// Fill the fields of stack that are still empty from another descriptor;
+// the first source to name a field wins
+func fillStack(stack *StackDescriptor, from StackDescriptor) {
+        for _, field := range []struct {
+                target *string
+                value  string
+        }{
+                {&stack.Server, from.Server},
+                {&stack.Language, from.Language},
+                {&stack.Framework, from.Framework},
+                {&stack.CMS, from.CMS},
+                {&stack.Hosting, from.Hosting},
+        } {
+                if *field.target == "" {
+                        *field.target = field.value
+                }
+        }
+}
+
+// Token usage and estimated cost of every AI call in the run. Replayed and
+// cached replies are never added.
+var (
+        tokenUsageMu sync.Mutex
+        tokenUsage   Usage
+        tokenCost    float64
+)
+
+// Rough USD prices per million prompt and completion tokens of each
+// provider's default model, used for --max-cost and the usage summary
+var providerPrices = map[string][2]float64{
+        ProviderPerplexity: {3, 15},
+        ProviderAnthropic:  {0.8, 4},
+        ProviderOllama:     {0, 0},
+        ProviderGemini:     {0.075, 0.3},
+        ProviderAzure:      {0.15, 0.6},
+        ProviderOpenRouter: {0.15, 0.6},
+        ProviderGroq:       {0.05, 0.08},
+        ProviderOpenAI:     {0.15, 0.6},
+        ProviderMistral:    {0.2, 0.6},
+        ProviderBedrock:    {0.8, 4},
+}
+
+func addTokenUsage(provider string, usage Usage) {
+        tokenUsageMu.Lock()
+        defer tokenUsageMu.Unlock()
+        tokenUsage.PromptTokens += usage.PromptTokens
+        tokenUsage.CompletionTokens += usage.CompletionTokens
+        tokenUsage.TotalTokens += usage.TotalTokens
+        price := providerPrices[provider]
+        tokenCost += (float64(usage.PromptTokens)*price[0] + float64(usage.CompletionTokens)*price[1]) / 1e6
+}
+
+func totalTokenUsage() Usage {
+        tokenUsageMu.Lock()
+        defer tokenUsageMu.Unlock()
+        return tokenUsage
+}
+
+func totalTokenCost() float64 {
+        tokenUsageMu.Lock()
+        defer tokenUsageMu.Unlock()
+        return tokenCost
+}
+
+// Returned instead of calling the AI once --max-total-tokens or --max-cost
+// is spent
+type budgetError struct {
+        reason string
+}
+
+func (e *budgetError) Error() string {
+        return "AI budget exhausted: " + e.reason
+}
+
+// Warn only once when the budget runs out
+var budgetWarning sync.Once
+
+// Check the run's AI spend against --max-total-tokens and --max-cost before
+// another call. A call already under way may overshoot a cap.
+func checkBudget(config *Config) error {
+        var reason string
+        if usage := totalTokenUsage(); config.MaxTotalTokens > 0 && usage.TotalTokens >= config.MaxTotalTokens {
+                reason = fmt.Sprintf("%d of %d tokens used", usage.TotalTokens, config.MaxTotalTokens)
+        } else if cost := totalTokenCost(); config.MaxCost > 0 && cost >= config.MaxCost {
+                reason = fmt.Sprintf("$%.4f of $%.4f spent", cost, config.MaxCost)
+        }
+        if reason == "" {
+                return nil
+        }
+        budgetWarning.Do(func() {
+                fmt.Fprintf(os.Stderr, "%sWarning: AI budget exhausted (%s), skipping further AI calls%s\n", ColorYellow, reason, ColorReset)
+        })
+        return &budgetError{reason: reason}
+}
+
+// Print the run's token usage and estimated cost
+func printTokenUsage() {
+        if usage := totalTokenUsage(); usage.TotalTokens > 0 {
+                fmt.Fprintf(textOutput, "%sAI usage: %d prompt + %d completion = %d tokens, about $%.4f%s\n",
+                        ColorCyan, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, totalTokenCost(), ColorReset)
+        }
+}
+
+// Statistics of a run as --stats-out writes them
+type RunStats struct {
+        Target     string      `json:"target"`
+        Extensions []string    `json:"extensions"`
+        Results    int         `json:"results"`
+        Statuses   map[int]int `json:"statuses"`
+        Usage      Usage       `json:"usage"`
+        CostUSD    float64     `json:"estimated_cost_usd"`
+}
+
+// Write the --stats-out file: the fuzzed extensions, the results of every
+// pass by status and the run's token usage and estimated cost
+func writeRunStats(config *Config, extensions []string) error {
+        stats := RunStats{
+                Target:     config.URL,
+                Extensions: extensions,
+                Statuses:   make(map[int]int),
+                Usage:      totalTokenUsage(),
+                CostUSD:    totalTokenCost(),
+        }
+        if config.PassResults != nil {
+                for _, result := range config.PassResults.Results {
+                        stats.Results++
+                        stats.Statuses[result.Status]++
+                }
+        }
+        data, err := json.MarshalIndent(stats, "", "  ")
+        if err != nil {
+                return err
+        }
+        return os.WriteFile(config.StatsOut, append(data, '\n'), 0o644)
+}
+
+// Get AI-suggested extensions from the configured AI provider
+func getAIExtensions(ctx context.Context, urlStr string, headers map[string]string, config *Config) (*ExtensionsResponse, error) {
+        prompt, err := buildPrompt(config, urlStr, headers)
+        if err != nil {
+                return nil, err
+        }
+
+        input := PromptInput{
+                System:      config.SystemPrompt,
+                Prompt:      prompt,
+                MaxTokens:   500,
+                Temperature: 0.1, // Low temperature for consistent results
+                Schema:      newExtensionsSchema(config.Explain, config.AskMethod, config.AskMatchCodes),
+        }
+        if config.Explain {
+                // Room for a sentence per extension
+                input.MaxTokens += config.MaxExtensions * 50
+        }
+
+        if config.Replay != nil {
+                return replayExtensions(ctx, config, input)
+        }
+        if err := checkBudget(config); err != nil {
+                return nil, err
+        }
+
+        // Capture the raw API traffic for --record
+        var recorder *exchangeRecorder
+        if config.RecordDir != "" {
+                recorder = &exchangeRecorder{}
+                ctx = context.WithValue(ctx, recorderKey{}, recorder)
+        }
+
+        // Try each provider in the chain until one answers
+        var lastErr error
+        for i, name := range config.Providers {
+                attempt := providerConfig(config, name)
+                hasNext := i < len(config.Providers)-1
+
+                provider, err := newProvider(attempt)
+                if err != nil {
+                        lastErr = err
+                        if !hasNext {
+                                break
+                        }
+                        fmt.Fprintf(os.Stderr, "%sWarning: skipping provider %s: %v%s\n", ColorYellow, name, err, ColorReset)
+                        continue
+                }
+
+                if config.Hedge && i == 0 {
+                        provider = newHedgedProvider(attempt, provider)
+                }
+
+                if config.Verbose && len(config.Providers) > 1 {
+                        fmt.Fprintf(textOutput, "Trying provider %s (model %s)...\n", name, displayModel(attempt.Model))
+                }
+
+                start := time.Now()
+                completion, err := provider.Suggest(ctx, input)
+
+                // A renamed or mistyped model gets one retry with the provider default
+                if fallbackModel := defaultModel(name); isInvalidModelError(err) && fallbackModel != "" && fallbackModel != attempt.Model {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %s rejected model %q, retrying with %q. Pin a model explicitly with --model NAME (see '%s models --provider %s').%s\n",
+                                ColorYellow, name, attempt.Model, fallbackModel, os.Args[0], name, ColorReset)
+                        attempt.Model = fallbackModel
+                        if provider, err = newProvider(attempt); err == nil {
+                                completion, err = provider.Suggest(ctx, input)
+                        }
+                        if err != nil {
+                                err = fmt.Errorf("fallback model %s also failed: %w%s", fallbackModel, err, apiErrorBody(err))
+                        }
+                }
+                latency := time.Since(start).Round(time.Millisecond)
+
+                if err != nil {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Provider %s failed after %s: %v\n", name, latency, err)
+                        }
+                        lastErr = err
+                        if hasNext && shouldFallback(err) {
+                                fmt.Fprintf(os.Stderr, "%sWarning: provider %s failed (%v), falling back to %s%s\n", ColorYellow, name, err, config.Providers[i+1], ColorReset)
+                                continue
+                        }
+                        if len(config.Providers) > 1 && !shouldFallback(err) {
+                                return nil, fmt.Errorf("provider %s: %w", name, err)
+                        }
+                        break
+                }
+
+                addTokenUsage(name, completion.Usage)
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "Provider %s answered in %s\n", name, latency)
+                        if usage := completion.Usage; usage.TotalTokens > 0 {
+                                fmt.Fprintf(textOutput, "Token usage: %d prompt + %d completion = %d total\n", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+                        }
+                        fmt.Fprintf(textOutput, "AI Response: %s\n", completion.Content)
+                }
+                if len(config.Providers) > 1 {
+                        fmt.Fprintf(textOutput, "%sSuggestions provided by %s%s\n", ColorCyan, name, ColorReset)
+                }
+
+                extensionsResp, err := extractExtensions(ctx, provider, input, completion)
+                model := attempt.Model
+                if completion.Model != "" {
+                        model = completion.Model
+                }
+                if recorder != nil {
+                        exchange := recorder.exchange(urlStr, headers, name, model, input, completion, extensionsResp, err)
+                        exchange.Stack = config.Stack
+                        exchange.PageHints = config.PageHints
+                        exchange.Robots = config.Robots
+                        exchange.Sitemap = config.Sitemap
+                        exchange.Favicon = config.FaviconMatch
+                        exchange.Certificate = config.Certificate
+                        exchange.Technologies = config.Technologies
+                        exchange.PathProbes = config.PathProbes
+                        exchange.Scripts = config.Scripts
+                        exchange.CSP = config.CSP
+                        exchange.Links = config.Links
+                        exchange.SPAShell = config.SPAShell
+                        exchange.DNS = config.DNSInfo
+                        exchange.Audit = config.AuditFindings
+                        if path, recordErr := writeExchange(config.RecordDir, exchange); recordErr != nil {
+                                fmt.Fprintf(os.Stderr, "%sWarning: could not record exchange: %v%s\n", ColorYellow, recordErr, ColorReset)
+                        } else if config.Verbose {
+                                fmt.Fprintf(textOutput, "Recorded exchange to %s\n", path)
+                        }
+                }
+                if err != nil {
+                        return nil, err
+                }
+                extensionsResp.Provider = name
+                extensionsResp.Model = model
+                return extensionsResp, nil
+        }
+
+        if len(config.Providers) > 1 {
+                return nil, fmt.Errorf("all providers failed, last error: %w", lastErr)
+        }
+        return nil, lastErr
+}
+
+// Ask every --ensemble provider concurrently and merge the suggestions.
+// A failed provider is skipped with a warning as long as one answers.
+func getEnsembleExtensions(ctx context.Context, urlStr string, headers map[string]string, config *Config) (*ExtensionsResponse, error) {
+        type ensembleResult struct {
+                resp *ExtensionsResponse
+                err  error
+        }
+
+        results := make([]ensembleResult, len(config.Providers))
+        var wg sync.WaitGroup
+        for i, name := range config.Providers {
+                wg.Add(1)
+                go func(i int, name string) {
+                        defer wg.Done()
+                        // Each member runs as a chain of one so it keeps its own settings
+                        member := providerConfig(config, name)
+                        member.Providers = []string{name}
+                        resp, err := getAIExtensions(ctx, urlStr, headers, member)
+                        results[i] = ensembleResult{resp: resp, err: err}
+                }(i, name)
+        }
+        wg.Wait()
+
+        var lists [][]string
+        var names, models []string
+        reasons := make(map[string]string)
+        // The first provider that answers decides the method and match codes
+        var method *ExtensionsResponse
+        var lastErr error
+        for i, result := range results {
+                name := config.Providers[i]
+                if result.err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: ensemble provider %s failed: %v%s\n", ColorYellow, name, result.err, ColorReset)
+                        lastErr = result.err
+                        continue
+                }
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "Ensemble %s suggested: %s\n", name, formatConfidences(result.resp.Extensions, result.resp.Confidence))
+                }
+                lists = append(lists, selectExtensions(result.resp, config.MinConfidence, len(result.resp.Extensions)))
+                // mergeExtensions lowercases extensions; the first member's reason wins
+                for ext, reason := range result.resp.Reasons {
+                        if _, ok := reasons[strings.ToLower(ext)]; !ok {
+                                reasons[strings.ToLower(ext)] = reason
+                        }
+                }
+                if method == nil {
+                        method = result.resp
+                }
+                names = append(names, name)
+                models = append(models, displayModel(result.resp.Model))
+        }
+
+        if len(lists) == 0 {
+                return nil, fmt.Errorf("all ensemble providers failed, last error: %w", lastErr)
+        }
+        if len(lists) == 1 {
+                fmt.Fprintf(os.Stderr, "%sWarning: only %s answered, using its suggestions alone%s\n", ColorYellow, names[0], ColorReset)
+        }
+
+        merged, agreed := mergeExtensions(lists, config.EnsembleMode, config.MaxExtensions)
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "Ensemble %s of %d lists: %v (agreed by all: %v)\n", config.EnsembleMode, len(lists), merged, agreed)
+        }
+
+        if len(reasons) == 0 {
+                reasons = nil
+        }
+        return &ExtensionsResponse{
+                Extensions:   merged,
+                Reasons:      reasons,
+                Method:       method.Method,
+                MethodReason: method.MethodReason,
+                MatchCodes:   method.MatchCodes,
+                MatchReason:  method.MatchReason,
+                Provider:     strings.Join(names, "+"),
+                Model:        strings.Join(models, "+"),
+        }, nil
+}
+
+// Merge suggestion lists by union or intersection. Extensions more lists agree
+// on come first, so the maxExtensions cap drops the least supported ones.
+// Also returns the extensions every list contained.
+func mergeExtensions(lists [][]string, mode string, maxExtensions int) ([]string, []string) {
+        votes := make(map[string]int)
+        var order []string
+        for _, list := range lists {
+                seen := make(map[string]bool)
+                for _, ext := range list {
+                        ext = strings.ToLower(ext)
+                        if seen[ext] {
+                                continue
+                        }
+                        seen[ext] = true
+                        if votes[ext] == 0 {
+                                order = append(order, ext)
+                        }
+                        votes[ext]++
+                }
+        }
+
+        // Stable sort keeps each provider's own ranking among equal votes
+        sort.SliceStable(order, func(i, j int) bool { return votes[order[i]] > votes[order[j]] })
+
+        var merged, agreed []string
+        for _, ext := range order {
+                if votes[ext] == len(lists) {
+                        agreed = append(agreed, ext)
+                } else if mode == EnsembleIntersect {
+                        continue
+                }
+                merged = append(merged, ext)
+        }
+
+        if len(merged) > maxExtensions {
+                merged = merged[:maxExtensions]
+        }
+        return merged, agreed
+}
+
+// Most follow-up turns kept in the chat history; older ones are dropped so
+// long sessions stay within the model's context window
+const maxChatTurns = 6
+
+// Follow-up typed in chat mode and the extensions the model answered with
+type chatTurn struct {
+        Request string
+        Reply   string
+}
+
+// Extension list as the compact JSON reply kept in the chat history
+func chatReply(extensions []string) string {
+        data, _ := json.Marshal(map[string][]string{"extensions": extensions})
+        return string(data)
+}
+
+// Read follow-ups from stdin and ask the AI for an updated extension list
+// after each one. Returns the latest response and list, and whether the user
+// accepted it ("accept") rather than quitting ("quit" or end of input).
+// Ctrl+C only cancels the request in flight; the handler is removed before
+// ffuf runs.
+func chatExtensions(ctx context.Context, config *Config, headers map[string]string, resp *ExtensionsResponse, extensions []string) (*ExtensionsResponse, []string, bool) {
+        initial, err := buildPrompt(config, config.URL, headers)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                return resp, extensions, false
+        }
+        first := chatReply(extensions)
+
+        var (
+                mu         sync.Mutex
+                cancelTurn context.CancelFunc
+        )
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, os.Interrupt)
+        defer func() {
+                signal.Stop(sigChan)
+                close(sigChan)
+        }()
+        go func() {
+                for range sigChan {
+                        mu.Lock()
+                        if cancelTurn != nil {
+                                cancelTurn()
+                        } else {
+                                fmt.Fprint(textOutput, "\n(type accept to run ffuf or quit to exit)\nffufai> ")
+                        }
+                        mu.Unlock()
+                }
+        }()
+
+        fmt.Fprintf(textOutput, "%sDescribe changes to the list, then type accept to run ffuf or quit to exit.%s\n", ColorCyan, ColorReset)
+        var turns []chatTurn
+        scanner := bufio.NewScanner(os.Stdin)
+        for {
+                fmt.Fprint(textOutput, "ffufai> ")
+                if !scanner.Scan() {
+                        fmt.Fprintln(textOutput)
+                        return resp, extensions, false
+                }
+                line := strings.TrimSpace(scanner.Text())
+                switch strings.ToLower(line) {
+                case "":
+                        continue
+                case "quit", "exit":
+                        return resp, extensions, false
+                case "accept":
+                        if len(extensions) == 0 {
+                                fmt.Fprintf(textOutput, "%sThe list is empty, ask for some extensions first%s\n", ColorYellow, ColorReset)
+                                continue
+                        }
+                        return resp, extensions, true
+                }
+
+                var prompt strings.Builder
+                prompt.WriteString(initial + " " + first)
+                for _, turn := range turns {
+                        fmt.Fprintf(&prompt, "\n\nFollow-up: %s\nResponse: %s", turn.Request, turn.Reply)
+                }
+                fmt.Fprintf(&prompt, "\n\nFollow-up: %s\nGive the complete updated answer in the same JSON format.\nResponse:", line)
+
+                turnCtx, cancel := context.WithTimeout(ctx, config.AITimeout)
+                mu.Lock()
+                cancelTurn = cancel
+                mu.Unlock()
+                completion, err := askProviders(turnCtx, config, PromptInput{
+                        System:      config.SystemPrompt,
+                        Prompt:      prompt.String(),
+                        MaxTokens:   500 + config.MaxExtensions*50,
+                        Temperature: 0.1,
+                        Schema:      newExtensionsSchema(false, config.AskMethod, config.AskMatchCodes),
+                })
+                mu.Lock()
+                cancelTurn = nil
+                mu.Unlock()
+                cancel()
+
+                var updated *ExtensionsResponse
+                if err == nil {
+                        updated, err = parseExtensions(completion.Content)
+                }
+                if err != nil {
+                        if turnCtx.Err() == context.Canceled {
+                                err = fmt.Errorf("request cancelled")
+                        }
+                        fmt.Fprintf(os.Stderr, "%sWarning: %v, the list is unchanged%s\n", ColorYellow, err, ColorReset)
+                        continue
+                }
+
+                resp = updated
+                extensions = fuzzExtensions(config, updated)
+                fmt.Fprintf(textOutput, "%s%sExtensions: %v%s\n", ColorGreen, ColorBold, extensions, ColorReset)
+
+                turns = append(turns, chatTurn{Request: line, Reply: chatReply(extensions)})
+                if len(turns) > maxChatTurns {
+                        turns = turns[len(turns)-maxChatTurns:]
+                }
+        }
+}
+
+// Check whether stdin is a terminal. /dev/null is a character device too,
+// so it is ruled out by name.
+func stdinIsTerminal() bool {
+        info, err := os.Stdin.Stat()
+        if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+                return false
+        }
+        null, err := os.Stat(os.DevNull)
+        return err != nil || !os.SameFile(info, null)
+}
+
+// Read one line, up to and including the newline, a byte at a time: a
+// buffered reader would take input past the line that was never used
+func readLine(r io.Reader) (string, error) {
+        var line []byte
+        b := make([]byte, 1)
+        for {
+                n, err := r.Read(b)
+                if n == 1 {
+                        line = append(line, b[0])
+                        if b[0] == '\n' {
+                                return string(line), nil
+                        }
+                }
+                if err != nil {
+                        return string(line), err
+                }
+        }
+}
+
+// Reason recorded for an extension the user added at the --interactive prompt
+const addedExtensionReason = "added at the --interactive prompt"
+
+// Let the user toggle the extensions by number and add their own before ffuf
+// runs. Returns the accepted list, or false when the user aborted or closed
+// stdin. Ctrl+C returns an interruptedError.
+func editExtensions(extensions []string) ([]string, bool, error) {
+        entries := append([]string{}, extensions...)
+        enabled := make([]bool, len(entries))
+        for i := range enabled {
+                enabled[i] = true
+        }
+
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, os.Interrupt)
+        defer signal.Stop(sigChan)
+
+        // Read one line per prompt and nothing past it, so what follows on
+        // stdin is left for ffuf's own interactive mode
+        type readResult struct {
+                line string
+                err  error
+        }
+        lines := make(chan readResult, 1)
+
+        fmt.Fprintf(textOutput, "%sNumbers toggle extensions, +ext adds one, Enter or a accepts, q aborts.%s\n", ColorCyan, ColorReset)
+        for {
+                for i, ext := range entries {
+                        mark := "x"
+                        if !enabled[i] {
+                                mark = " "
+                        }
+                        fmt.Fprintf(textOutput, "  [%s] %d %s\n", mark, i+1, ext)
+                }
+                fmt.Fprint(textOutput, "extensions> ")
+
+                go func() {
+                        line, err := readLine(os.Stdin)
+                        lines <- readResult{line, err}
+                }()
+                var read readResult
+                select {
+                case <-sigChan:
+                        fmt.Fprintln(textOutput)
+                        return nil, false, &interruptedError{what: "the extension prompt"}
+                case read = <-lines:
+                }
+                line := strings.TrimSpace(read.line)
+                if read.err != nil && line == "" {
+                        fmt.Fprintln(textOutput)
+                        return nil, false, nil
+                }
+
+                switch strings.ToLower(line) {
+                case "", "a", "accept":
+                        var accepted []string
+                        for i, ext := range entries {
+                                if enabled[i] {
+                                        accepted = append(accepted, ext)
+                                }
+                        }
+                        if len(accepted) == 0 {
+                                fmt.Fprintf(textOutput, "%sNo extension is selected, toggle or add one first%s\n", ColorYellow, ColorReset)
+                                continue
+                        }
+                        return accepted, true, nil
+                case "q", "quit", "abort":
+                        return nil, false, nil
+                }
+
+                for _, field := range strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == ',' }) {
+                        if strings.HasPrefix(field, "+") {
+                                added := cleanExtensions([]string{strings.TrimPrefix(field, "+")})
+                                if len(added) == 0 {
+                                        fmt.Fprintf(textOutput, "%s%q is not an extension like .inc%s\n", ColorYellow, field[1:], ColorReset)
+                                        continue
+                                }
+                                // Adding a listed extension switches it back on
+                                listed := false
+                                for i, ext := range entries {
+                                        if strings.EqualFold(ext, added[0]) {
+                                                enabled[i], listed = true, true
+                                        }
+                                }
+                                if !listed {
+                                        entries = append(entries, added[0])
+                                        enabled = append(enabled, true)
+                                }
+                                continue
+                        }
+                        n, err := strconv.Atoi(field)
+                        if err != nil || n < 1 || n > len(entries) {
+                                fmt.Fprintf(textOutput, "%s%q is neither a number from 1 to %d nor +ext%s\n", ColorYellow, field, len(entries), ColorReset)
+                                continue
+                        }
+                        enabled[n-1] = !enabled[n-1]
+                }
+        }
+}
+
+// Send a prompt through the provider chain, falling back like getAIExtensions.
+// Used for the secondary prompts that don't produce extensions.
+func askProviders(ctx context.Context, config *Config, input PromptInput) (RawCompletion, error) {
+        if err := checkBudget(config); err != nil {
+                return RawCompletion{}, err
+        }
+        var lastErr error
+        for i, name := range config.Providers {
+                provider, err := newProvider(providerConfig(config, name))
+                if err == nil {
+                        var completion RawCompletion
+                        if completion, err = provider.Suggest(ctx, input); err == nil {
+                                addTokenUsage(name, completion.Usage)
+                                if config.Verbose {
+                                        if usage := completion.Usage; usage.TotalTokens > 0 {
+                                                fmt.Fprintf(textOutput, "Token usage: %d prompt + %d completion = %d total\n", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+                                        }
+                                        fmt.Fprintf(textOutput, "AI Response: %s\n", completion.Content)
+                                }
+                                return completion, nil
+                        }
+                }
+                lastErr = err
+                if i < len(config.Providers)-1 && shouldFallback(err) {
+                        fmt.Fprintf(os.Stderr, "%sWarning: provider %s failed (%v), falling back to %s%s\n", ColorYellow, name, err, config.Providers[i+1], ColorReset)
+                        continue
+                }
+                break
+        }
+        return RawCompletion{}, lastErr
+}
+
+// Decode the first JSON object in a model reply, tolerating surrounding text
+func decodeJSONReply(content string, out interface{}) error {
+        start := strings.Index(content, "{")
+        end := strings.LastIndex(content, "}")
+        if start == -1 || end <= start {
+                return fmt.Errorf("no valid JSON found in AI response")
+        }
+        if err := json.Unmarshal([]byte(content[start:end+1]), out); err != nil {
+                return fmt.Errorf("parsing AI response JSON: %w", err)
+        }
+        return nil
+}
+
+// Build the extension suggestion prompt for a URL and its headers
+func buildPrompt(config *Config, urlStr string, headers map[string]string) (string, error) {
+        // Convert headers to JSON string for the prompt
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return "", fmt.Errorf("marshaling headers: %w", err)
+        }
+
+        tmpl := config.PromptTemplate
+        if tmpl == nil {
+                tmpl = defaultPrompt
+        }
+        stackText := ""
+        if config.Stack != nil {
+                stackText = config.Stack.String()
+        }
+        certText := ""
+        if config.Certificate != nil {
+                certText = config.Certificate.String()
+        }
+        fingerprintText := ""
+        if config.Technologies != nil {
+                fingerprintText = config.Technologies.String()
+        }
+        dnsText := ""
+        if config.DNSInfo != nil {
+                dnsText = config.DNSInfo.String()
+        }
+        var prompt strings.Builder
+        err = tmpl.Execute(&prompt, PromptData{
+                URL:           urlStr,
+                Headers:       string(headersJSON),
+                Stack:         stackText,
+                Context:       config.AIContext,
+                RequestMethod: config.RequestMethod,
+                BodyType:      config.BodyType,
+                Wordlist:      config.WordlistSample,
+                PageHints:     config.PageHints,
+                Robots:        config.Robots,
+                Sitemap:       config.Sitemap,
+                Favicon:       config.FaviconMatch,
+                Certificate:   certText,
+                Fingerprint:   fingerprintText,
+                PathProbes:    config.PathProbes,
+                DNS:           dnsText,
+                Scripts:       config.Scripts,
+                CSP:           config.CSP,
+                Links:         linksText(config.Links),
+                SPA:           config.SPAShell != "",
+                MaxExtensions: config.MaxExtensions,
+                Methods:       strings.Join(allowedMethods, ", "),
+                Explain:       config.Explain,
+                AskMethod:     config.AskMethod,
+                AskMatchCodes: config.AskMatchCodes,
+        })
+        if err != nil {
+                return "", fmt.Errorf("rendering prompt: %w", err)
+        }
+
+        return prompt.String(), nil
+}
+
+// Method and body type of the fuzz requests from ffuf's -X, -d and -H. Like
+// ffuf, -d without -X means POST. GET and HEAD requests give empty values,
+// which keeps the prompt on static files.
+func requestMethod(args []string) (string, string) {
+        method := strings.ToUpper(ffufFlagValue(args, "-X"))
+        data := ffufFlagValue(args, "-d")
+        if method == "" && data != "" {
+                method = "POST"
+        }
+        if method == "" || method == "GET" || method == "HEAD" {
+                return "", ""
+        }
+
+        contentType := ""
+        for i, arg := range args {
+                if arg == "-H" && i+1 < len(args) {
+                        if name, value, ok := strings.Cut(args[i+1], ":"); ok && strings.EqualFold(strings.TrimSpace(name), "Content-Type") {
+                                contentType = strings.ToLower(strings.TrimSpace(value))
+                        }
+                }
+        }
+        trimmed := strings.TrimSpace(data)
+        switch {
+        case strings.Contains(contentType, "json"):
+                return method, "JSON"
+        case strings.Contains(contentType, "xml"):
+                return method, "XML"
+        case strings.Contains(contentType, "multipart"):
+                return method, "multipart"
+        case strings.Contains(contentType, "x-www-form-urlencoded"):
+                return method, "form"
+        case contentType != "":
+                return method, contentType
+        case trimmed == "":
+                return method, ""
+        case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["):
+                return method, "JSON"
+        case strings.HasPrefix(trimmed, "<"):
+                return method, "XML"
+        case strings.Contains(trimmed, "="):
+                return method, "form"
+        default:
+                return method, "raw"
+        }
+}
+
+// Entries --wordlist-context reads from the start of the wordlist and from
+// points spread through the rest of it
+const (
+        wordlistHeadEntries   = 10
+        wordlistSampleEntries = 10
+)
+
+// Describe a wordlist for the extension prompt: its name and size, its first
+// entries and a sample from further in. Only small chunks are read, never the
+// whole file. The sample points are evenly spaced rather than random so the
+// prompt stays the same between runs, which --record/--replay rely on.
+func wordlistCharacteristics(path string) (string, error) {
+        file, err := os.Open(path)
+        if err != nil {
+                return "", err
+        }
+        defer file.Close()
+        info, err := file.Stat()
+        if err != nil {
+                return "", err
+        }
+        if !info.Mode().IsRegular() {
+                return "", fmt.Errorf("%s is not a regular file", path)
+        }
+
+        head := make([]byte, 4096)
+        n, err := io.ReadFull(file, head)
+        if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+                return "", err
+        }
+        head = head[:n]
+        // Drop a partial last line so it does not look like an entry or broken UTF-8
+        if int64(n) < info.Size() {
+                if end := bytes.LastIndexByte(head, '\n'); end >= 0 {
+                        head = head[:end]
+                }
+        }
+        if bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(head) {
+                return "", fmt.Errorf("%s does not look like a text wordlist", path)
+        }
+
+        seen := make(map[string]bool)
+        entry := func(line string) string {
+                line = strings.TrimSpace(line)
+                if line == "" || strings.HasPrefix(line, "#") || seen[line] || len(line) > 64 {
+                        return ""
+                }
+                seen[line] = true
+                return line
+        }
+
+        var first []string
+        for _, line := range strings.Split(string(head), "\n") {
+                if len(first) == wordlistHeadEntries {
+                        break
+                }
+                if e := entry(line); e != "" {
+                        first = append(first, e)
+                }
+        }
+
+        var sample []string
+        if rest := info.Size() - int64(len(head)); rest > 0 {
+                chunk := make([]byte, 256)
+                for i := 1; i <= wordlistSampleEntries; i++ {
+                        offset := int64(len(head)) + rest*int64(i)/int64(wordlistSampleEntries+1)
+                        n, _ := file.ReadAt(chunk, offset)
+                        // Skip the line the offset landed in and take the next one
+                        lines := strings.SplitN(string(chunk[:n]), "\n", 3)
+                        if len(lines) == 3 && utf8.ValidString(lines[1]) {
+                                if e := entry(lines[1]); e != "" {
+                                        sample = append(sample, e)
+                                }
+                        }
+                }
+        }
+
+        var description strings.Builder
+        fmt.Fprintf(&description, "- file: %s (%d bytes)\n", filepath.Base(path), info.Size())
+        fmt.Fprintf(&description, "- first entries: %s", strings.Join(first, ", "))
+        if len(sample) > 0 {
+                fmt.Fprintf(&description, "\n- entries from further in: %s", strings.Join(sample, ", "))
+        }
+        return description.String(), nil
+}
+
+// Variables available to prompt templates
+type PromptData struct {
+        URL           string
+        Headers       string
+        MaxExtensions int
+        Methods       string
+        // Ask for a reason per extension (--explain)
+        Explain bool
+        // Ask for a method, which is only applied with --suggest-method and
+        // no -X of the user's
+        AskMethod bool
+        // Ask for match codes, which are only applied with no matcher or
+        // filter of the user's and without --no-auto-matchers
+        AskMatchCodes bool
+        // Technology stack from stage one or --stack, empty when unknown
+        Stack string
+        // Free-form hint from --ai-context, empty when not given
+        Context string
+        // Method of the fuzz requests when ffuf's -X or -d makes them other
+        // than GET or HEAD, and the type of their body
+        RequestMethod string
+        BodyType      string
+        // Name, size and sample entries of the FUZZ wordlist (--wordlist-context)
+        Wordlist string
+        // Title, generator and framework markers of the base page (--body-hints)
+        PageHints string
+        // Condensed robots.txt directives (--robots)
+        Robots string
+        // Extensions and top-level paths from the sitemaps (--sitemap)
+        Sitemap string
+        // Product the favicon hash matched (--favicon)
+        Favicon string
+        // Issuer and names of the target's TLS certificate
+        Certificate string
+        // Stack guessed by the local fingerprint rules (--fingerprint)
+        Fingerprint string
+        // Distinguishing headers of well-known paths (--probe-paths)
+        PathProbes string
+        // Endpoints and extensions named in the page's scripts (--js-files)
+        Scripts string
+        // Hosts and paths named by the Content-Security-Policy
+        CSP string
+        // API, CMS and OAuth links from the Link headers
+        Links string
+        // The target is a single-page app serving its shell for every path
+        SPA bool
+        // Canonical name, reverse DNS names and hosting platform of the host
+        DNS string
+}
+
+// Built-in extension prompt; --prompt-file replaces it
+const defaultPromptTemplate = `Given the following URL and HTTP headers, suggest the most likely file extensions for fuzzing this endpoint.
+Respond with a JSON object containing a list of extensions. The response will be parsed with json.Unmarshal(),
+so it must be valid JSON. No preamble or explanation needed. Use the format:
+{"extensions": [{"ext": ".ext1", "confidence": 0.9{{if .Explain}}, "reason": "why .ext1 fits"{{end}}}, ...]{{if .AskMethod}}, "method": "GET", "method_reason": "one sentence"{{end}}{{if .AskMatchCodes}},
+ "match_codes": "200,301,302,403", "match_reason": "one sentence"{{end}}}.
+
+Guidelines:
+- Suggest up to {{.MaxExtensions}} extensions maximum
+- Only suggest extensions that make logical sense for this URL path and headers  
+- If the path contains specific technology indicators (like /js/, /css/, /api/, /admin/), prioritize related extensions
+- Consider the Server header and other technology indicators in headers
+- Prefer commonly exploited file types if the path suggests admin/config areas
+- For generic paths, suggest a mix of web technologies (.php, .html, .js, .css, .txt, .xml, .json)
+- Give each extension a confidence between 0 and 1 that files with it exist at this path
+{{- if .RequestMethod}}
+- The scan sends {{.RequestMethod}} requests{{if .BodyType}} with a {{.BodyType}} body{{end}}, so it probes server-side handlers rather than static files:
+  prefer handler extensions such as .php, .do, .action, .asmx, .aspx, .jsp and .cgi over documents, images and stylesheets
+{{- end}}
+{{- if .Wordlist}}
+- Fit the extensions to the kind of names in the wordlist: API operation names, file names or directory names call for different extensions
+{{- end}}
+{{- if .Stack}}
+- The target's technology stack is known: suggest extensions for that stack and do not hedge across other stacks
+{{- end}}
+{{- if .Robots}}
+- robots.txt shows what the site owner keeps from crawlers: weigh the file types and areas it names
+{{- end}}
+{{- if .Sitemap}}
+- The sitemap's extensions are known to exist on the site: prefer them where they fit this path
+{{- end}}
+{{- if .Scripts}}
+- Endpoints in the page's scripts show its API surface: if they are API routes, favor API-style extensions
+{{- end}}
+{{- if .Links}}
+- Link headers name a CMS API (wp-json is WordPress) or an API description: fit the extensions to that
+{{- end}}
+{{- if .SPA}}
+- The target is a single-page app that answers every path with the same HTML shell, so page and document extensions
+  find nothing: favor API-style extensions such as .json, as the routes worth fuzzing sit under prefixes like /api
+{{- end}}
+{{- if .Explain}}
+- Give each extension a short reason (one sentence) naming the evidence in the URL or headers
+{{- end}}
+{{- if .AskMethod}}
+
+HTTP method:
+- Also pick the method ffuf should use, one of: {{.Methods}}
+- Use the Allow header or the Options-Allow methods an OPTIONS request returned if present, the path semantics (e.g. /api/upload, /graphql) and the response status
+  (405 Method Not Allowed on GET is a strong hint)
+- Answer GET unless there is clear evidence that another method is needed
+{{- end}}
+{{- if .AskMatchCodes}}
+
+Match codes:
+- Also pick the status codes ffuf should report (its -mc option) as a comma-separated list of 3-digit codes, or "all"
+- Admin, internal and API paths often answer 401 or 403 for resources that exist, so include those codes there
+- Otherwise prefer ffuf's default set: 200,204,301,302,307,401,403,405,500
+{{- end}}
+
+Examples:{{if .RequestMethod}}
+1. URL: https://example.com/app/FUZZ
+   Method: POST with a form body
+   Headers: {"Server": "Apache-Coyote/1.1", "Set-Cookie": "JSESSIONID=1A2B3C"}
+   Response: {"extensions": [{"ext": ".do", "confidence": 0.9}, {"ext": ".action", "confidence": 0.8}, {"ext": ".jsp", "confidence": 0.6}, {"ext": ".json", "confidence": 0.3}]{{if .AskMethod}}, "method": "POST", "method_reason": "The scan posts a form to the handlers."{{end}}{{if .AskMatchCodes}}, "match_codes": "200,201,204,301,302,400,401,403,405,500", "match_reason": "Handlers answer bad form data with 400 or 500."{{end}}}{{else}}
+1. URL: https://example.com/presentations/FUZZ
+   Headers: {"Content-Type": "application/pdf", "Server": "Apache"}
+   Response: {"extensions": [{"ext": ".pdf", "confidence": 0.95}, {"ext": ".pptx", "confidence": 0.8}, {"ext": ".ppt", "confidence": 0.6}, {"ext": ".doc", "confidence": 0.4}]{{if .AskMethod}}, "method": "GET", "method_reason": "Static documents are fetched with GET."{{end}}{{if .AskMatchCodes}}, "match_codes": "200,204,301,302,307", "match_reason": "Public documents either exist or redirect."{{end}}}{{end}}
+
+2. URL: https://example.com/admin/FUZZ  
+   Headers: {"Server": "Microsoft-IIS/10.0", "X-Powered-By": "ASP.NET"}
+   Response: {"extensions": [{"ext": ".aspx", "confidence": 0.9}, {"ext": ".config", "confidence": 0.6}, {"ext": ".asp", "confidence": 0.5}, {"ext": ".xml", "confidence": 0.3}]{{if .AskMethod}}, "method": "GET", "method_reason": "Admin pages are served over GET."{{end}}{{if .AskMatchCodes}}, "match_codes": "200,204,301,302,307,401,403", "match_reason": "Protected admin pages answer 401 or 403."{{end}}}
+
+3. URL: https://example.com/api/FUZZ
+   Headers: {"Content-Type": "application/json", "Server": "nginx"}
+   Response: {"extensions": [{"ext": ".json", "confidence": 0.85}, {"ext": ".xml", "confidence": 0.5}, {"ext": ".php", "confidence": 0.3}, {"ext": ".py", "confidence": 0.2}]{{if .AskMethod}}, "method": "GET", "method_reason": "No Allow header or status suggests another method."{{end}}{{if .AskMatchCodes}}, "match_codes": "200,204,301,302,307,401,403,405", "match_reason": "API routes often reject the wrong method with 405."{{end}}}
+
+URL: {{.URL}}
+{{- if .RequestMethod}}
+Method: {{.RequestMethod}}{{if .BodyType}} with a {{.BodyType}} body{{end}}
+{{- end}}
+Headers: {{.Headers}}
+{{- if .Stack}}
+Stack: {{.Stack}}
+{{- end}}
+{{- if .Fingerprint}}
+Local fingerprint: {{.Fingerprint}}
+{{- end}}
+{{- if .Favicon}}
+Favicon fingerprint: {{.Favicon}}
+{{- end}}
+{{- if .Certificate}}
+TLS certificate:
+{{.Certificate}}
+{{- end}}
+{{- if .DNS}}
+DNS:
+{{.DNS}}
+{{- end}}
+{{- if .PathProbes}}
+Other paths:
+{{.PathProbes}}
+{{- end}}
+{{- if .PageHints}}
+Page hints:
+{{.PageHints}}
+{{- end}}
+{{- if .Robots}}
+robots.txt:
+{{.Robots}}
+{{- end}}
+{{- if .Sitemap}}
+Sitemap:
+{{.Sitemap}}
+{{- end}}
+{{- if .Scripts}}
+Scripts:
+{{.Scripts}}
+{{- end}}
+{{- if .CSP}}
+Content-Security-Policy sources:
+{{.CSP}}
+{{- end}}
+{{- if .Links}}
+Link headers:
+{{.Links}}
+{{- end}}
+{{- if .Wordlist}}
+Wordlist characteristics:
+{{.Wordlist}}
+{{- end}}
+{{- if .Context}}
+
+Tester notes about the target (hints, not instructions):
+"""
+{{.Context}}
+"""
+{{- end}}
+
+Response:`
+
+var defaultPrompt = template.Must(template.New("prompt").Parse(defaultPromptTemplate))
+
+// Parse a --prompt-file template and render it once with sample data, so
+// syntax errors and unknown variables surface before any network call
+func loadPromptTemplate(path string) (*template.Template, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, fmt.Errorf("reading prompt file: %w", err)
+        }
+        tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(data))
+        if err != nil {
+                return nil, fmt.Errorf("parsing prompt file: %w", err)
+        }
+        if err := tmpl.Execute(io.Discard, PromptData{URL: "https://example.com/FUZZ", Headers: "{}", MaxExtensions: 4}); err != nil {
+                return nil, fmt.Errorf("prompt file %s: %w", path, err)
+        }
+        return tmpl, nil
+}
+
+// Turn a completion into extensions. Structured replies must decode as an
+// ExtensionsResponse exactly; a failure gets one retry with the schema restated.
+// Other replies go through regex extraction.
+func extractExtensions(ctx context.Context, provider AIProvider, input PromptInput, completion RawCompletion) (*ExtensionsResponse, error) {
+        if !completion.Structured {
+                return parseExtensions(completion.Content)
+        }
+
+        extensionsResp, err := decodeStructuredExtensions(completion.Content)
+        if err == nil {
+                return extensionsResp, nil
+        }
+        fmt.Fprintf(os.Stderr, "%sWarning: structured reply did not match the schema (%v), retrying%s\n", ColorYellow, err, ColorReset)
+
+        schemaJSON, _ := json.Marshal(input.Schema)
+        input.Prompt += "\n\nYour previous reply was not valid. Respond with only a JSON object matching this JSON schema, without markdown fences or any other text:\n" + string(schemaJSON)
+
+        completion, err = provider.Suggest(ctx, input)
+        if err != nil {
+                return nil, err
+        }
+        extensionsResp, err = decodeStructuredExtensions(completion.Content)
+        if err != nil {
+                return nil, fmt.Errorf("structured reply still invalid after retry: %w", err)
+        }
+        return extensionsResp, nil
+}
+
+// Decode a reply produced under extensionsSchema without any text extraction
+func decodeStructuredExtensions(content string) (*ExtensionsResponse, error) {
+        // ExtensionsResponse decodes itself, so unknown fields are checked here
+        var fields map[string]json.RawMessage
+        if err := json.Unmarshal([]byte(content), &fields); err != nil {
+                return nil, fmt.Errorf("parsing AI response JSON: %w", err)
+        }
+        properties := extensionsSchema["properties"].(map[string]interface{})
+        for name := range fields {
+                if _, ok := properties[name]; !ok {
+                        return nil, fmt.Errorf("parsing AI response JSON: unknown field %q", name)
+                }
+        }
+
+        var extensionsResp ExtensionsResponse
+        if err := json.Unmarshal([]byte(content), &extensionsResp); err != nil {
+                return nil, fmt.Errorf("parsing AI response JSON: %w", err)
+        }
+        if extensionsResp.Extensions == nil {
+                return nil, fmt.Errorf("AI response JSON has no extensions array")
+        }
+
+        extensionsResp.Extensions = cleanExtensions(extensionsResp.Extensions)
+        return &extensionsResp, nil
+}
+
+// Extract and validate the extensions JSON from a model reply
+func parseExtensions(content string) (*ExtensionsResponse, error) {
+        // Extract JSON from the response using regex
+        jsonRegex := regexp.MustCompile(`\{[^{}]*"extensions"\s*:\s*\[(?:[^\[\]{}]|\{[^{}]*\})*\][^{}]*\}`)
+        matches := jsonRegex.FindAllString(content, -1)
+
+        if len(matches) == 0 {
+                return nil, fmt.Errorf("no valid JSON found in AI response")
+        }
+
+        // Try to parse the first match
+        var extensionsResp ExtensionsResponse
+        if err := json.Unmarshal([]byte(matches[0]), &extensionsResp); err != nil {
+                return nil, fmt.Errorf("parsing AI response JSON: %w", err)
+        }
+
+        extensionsResp.Extensions = cleanExtensions(extensionsResp.Extensions)
+        return &extensionsResp, nil
+}
+
+// Drop extensions scored below minConfidence and keep the maxExtensions most
+// confident. Unscored extensions are never dropped and keep their order.
+func selectExtensions(resp *ExtensionsResponse, minConfidence float64, maxExtensions int) []string {
+        score := func(ext string) float64 {
+                if confidence, ok := resp.Confidence[ext]; ok {
+                        return confidence
+                }
+                return 1
+        }
+
+        var kept []string
+        for _, ext := range resp.Extensions {
+                if score(ext) >= minConfidence {
+                        kept = append(kept, ext)
+                }
+        }
+        sort.SliceStable(kept, func(i, j int) bool { return score(kept[i]) > score(kept[j]) })
+
+        if len(kept) > maxExtensions {
+                kept = kept[:maxExtensions]
+        }
+        return kept
+}
+
+// Extensions guessed from the headers and stack once the AI budget is spent.
+// The first rule with a marker in the lowercased headers or stack wins.
+var heuristicRules = []struct {
+        markers    []string
+        extensions []string
+}{
+        {[]string{"asp.net", "aspnet", "microsoft-iis"}, []string{".aspx", ".asp", ".ashx", ".config"}},
+        {[]string{"php", "wordpress", "drupal", "joomla"}, []string{".php", ".inc", ".phtml", ".bak"}},
+        {[]string{"jsessionid", "tomcat", "jboss", "weblogic", "servlet", "jsp"}, []string{".jsp", ".do", ".action", ".jsf"}},
+        {[]string{"express", "next.js", "node"}, []string{".js", ".json", ".map", ".bak"}},
+        {[]string{"python", "django", "flask", "werkzeug", "gunicorn"}, []string{".py", ".json", ".txt", ".bak"}},
+        {[]string{"ruby", "rails", "passenger", "puma"}, []string{".rb", ".json", ".yml", ".bak"}},
+}
+
+// Extensions when no heuristic rule matches
+var defaultHeuristicExtensions = []string{".php", ".html", ".txt", ".bak"}
+
+// Suggest extensions without the AI. The local fingerprint and the stack from
+// --stack or stage one are checked first, then the header names and values.
+func heuristicExtensions(config *Config, headers map[string]string) *ExtensionsResponse {
+        var stacks []string
+        for _, stack := range []*StackDescriptor{config.Technologies, config.Stack} {
+                if stack != nil {
+                        stacks = append(stacks, stack.String())
+                }
+        }
+        var headerText strings.Builder
+        for name, value := range headers {
+                fmt.Fprintf(&headerText, "%s: %s\n", name, value)
+        }
+
+        for _, text := range []string{strings.Join(stacks, "\n"), headerText.String()} {
+                text = strings.ToLower(text)
+                for _, rule := range heuristicRules {
+                        for _, marker := range rule.markers {
+                                if strings.Contains(text, marker) {
+                                        return &ExtensionsResponse{Extensions: rule.extensions, Provider: "heuristics"}
+                                }
+                        }
+                }
+        }
+        return &ExtensionsResponse{Extensions: defaultHeuristicExtensions, Provider: "heuristics"}
+}
+
+// Extension with the AI's reason for suggesting it (--explain)
+type ExtensionRationale struct {
+        Ext    string
+        Reason string
+}
+
+// Pair the chosen extensions with their reasons
+func explainExtensions(extensions []string, reasons map[string]string) []ExtensionRationale {
+        rationale := make([]ExtensionRationale, len(extensions))
+        for i, ext := range extensions {
+                reason, ok := reasons[ext]
+                if !ok {
+                        reason, ok = reasons[strings.ToLower(ext)]
+                }
+                if !ok || strings.TrimSpace(reason) == "" {
+                        reason = "(no reason given)"
+                }
+                rationale[i] = ExtensionRationale{Ext: ext, Reason: strings.TrimSpace(reason)}
+        }
+        return rationale
+}
+
+// Print extensions and their reasons as a two-column table
+func printRationale(rationale []ExtensionRationale) {
+        writer := tabwriter.NewWriter(textOutput, 0, 0, 2, ' ', 0)
+        fmt.Fprintln(writer, "EXTENSION\tRATIONALE")
+        for _, row := range rationale {
+                fmt.Fprintf(writer, "%s\t%s\n", row.Ext, row.Reason)
+        }
+        writer.Flush()
+}
+
+// Format extensions with their confidence, e.g. "[.aspx (0.90) .config (0.60)]"
+func formatConfidences(extensions []string, confidence map[string]float64) string {
+        parts := make([]string, len(extensions))
+        for i, ext := range extensions {
+                if score, ok := confidence[ext]; ok {
+                        parts[i] = fmt.Sprintf("%s (%.2f)", ext, score)
+                } else {
+                        parts[i] = ext
+                }
+        }
+        return "[" + strings.Join(parts, " ") + "]"
+}
+
+// Combine the user's -e extensions with the AI's: the user's are all kept
+// in their order, then up to maxAdded AI extensions they lack follow
+func withUserExtensions(user, ai []string, maxAdded int) []string {
+        seen := make(map[string]bool)
+        var merged []string
+        for _, ext := range user {
+                if !seen[ext] {
+                        seen[ext] = true
+                        merged = append(merged, ext)
+                }
+        }
+        added := 0
+        for _, ext := range ai {
+                if added == maxAdded {
+                        break
+                }
+                if !seen[ext] {
+                        seen[ext] = true
+                        merged = append(merged, ext)
+                        added++
+                }
+        }
+        return merged
+}
+
+// Extensions to fuzz for an AI response: the user's -e extensions and the
+// AI's most confident, with --max-extensions counting only the AI's
+func fuzzExtensions(config *Config, resp *ExtensionsResponse) []string {
+        ranked := selectExtensions(resp, config.MinConfidence, len(resp.Extensions))
+        return withUserExtensions(config.UserExtensions, ranked, config.MaxExtensions)
+}
+
+// Normalize extensions to start with a dot and drop anything that isn't a plain extension
+func cleanExtensions(extensions []string) []string {
+        var validExtensions []string
+        for _, ext := range extensions {
+                // Ensure extension starts with dot
+                if !strings.HasPrefix(ext, ".") {
+                        ext = "." + ext
+                }
+                // Basic validation: only alphanumeric and common symbols
+                if matched, _ := regexp.MatchString(`^\.[a-zA-Z0-9]+$`, ext); matched {
+                        validExtensions = append(validExtensions, ext)
+                }
+        }
+        return validExtensions
+}
+
+// Settings for one provider in the chain. --model, --api-base, --api-key-env
+// and --api-key-file only apply to the primary provider; fallbacks use defaults.
+func providerConfig(config *Config, provider string) *Config {
+        attempt := *config
+        attempt.Provider = provider
+        if provider != config.Providers[0] {
+                attempt.Model = defaultModel(provider)
+                attempt.APIBase = ""
+                attempt.APIKeyEnv = ""
+                attempt.APIKeyFiles = nil
+        }
+        return &attempt
+}
+
+// Raw API response body of an APIError, formatted for appending to an error message
+func apiErrorBody(err error) string {
+        var apiErr *APIError
+        if !errors.As(err, &apiErr) || apiErr.Body == "" {
+                return ""
+        }
+        return "\nAPI response: " + strings.TrimSpace(apiErr.Body)
+}
+
+// Decide whether a provider failure should move on to the next provider.
+// Server errors, auth failures, rate limits and network errors fall back;
+// other 4xx errors are caused by our request and would fail everywhere.
+func shouldFallback(err error) bool {
+        var apiErr *APIError
+        if errors.As(err, &apiErr) {
+                return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusUnauthorized ||
+                        apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusTooManyRequests
+        }
+        var urlErr *url.Error
+        return errors.As(err, &urlErr)
+}
+
+// Model name for display; Azure deployments carry their own model
+func displayModel(model string) string {
+        if model == "" {
+                return "deployment default"
+        }
+        return model
+}
+
+// Create the AIProvider selected in config, resolving its API keys.
+// Several keys are wrapped in a rotatingProvider.
+func newProvider(config *Config) (AIProvider, error) {
+        keys, err := getAPIKeys(config)
+        if err != nil {
+                return nil, err
+        }
+
+        // Several keys rotate on a 429 instead of waiting it out
+        if len(keys) == 1 {
+                provider, err := buildProvider(config, keys[0])
+                if err != nil || config.AIRetries == 0 {
+                        return provider, err
+                }
+                return &retryingProvider{provider: provider, retries: config.AIRetries}, nil
+        }
+
+        provider, err := buildProvider(config, keys[0])
+        if err != nil {
+                return nil, err
+        }
+        poolID := config.Provider + "|" + apiKeyEnv(config) + "|" + strings.Join(config.APIKeyFiles, ",")
+        return &rotatingProvider{
+                pool:    sharedKeyPool(poolID, keys),
+                build:   func(apiKey string) (AIProvider, error) { return buildProvider(config, apiKey) },
+                name:    provider.Name(),
+                verbose: config.Verbose,
+        }, nil
+}
+
+// Create the AIProvider selected in config with a specific API key
+func buildProvider(config *Config, apiKey string) (AIProvider, error) {
+        switch config.Provider {
+        case ProviderAnthropic:
+                return &anthropicProvider{apiKey: apiKey, model: config.Model, verbose: config.Verbose, timeout: config.AITimeout}, nil
+        case ProviderOllama:
+                return &ollamaProvider{endpoint: ollamaURL(config), model: config.Model, headers: bearerAuth(apiKey), verbose: config.Verbose, timeout: config.AITimeout}, nil
+        case ProviderGemini:
+                return &geminiProvider{apiKey: apiKey, model: config.Model, verbose: config.Verbose, timeout: config.AITimeout}, nil
+        case ProviderAzure:
+                headers := map[string]string{"api-key": apiKey}
+                return &chatCompletionProvider{name: "Azure OpenAI", endpoint: azureURL(config), model: config.Model, headers: headers, verbose: config.Verbose, timeout: config.AITimeout, stream: config.Stream}, nil
+        case ProviderOpenRouter:
+                headers := bearerAuth(apiKey)
+                // Attribution headers recommended by OpenRouter
+                headers["HTTP-Referer"] = ProjectURL
+                headers["X-Title"] = "ffufai"
+                return &chatCompletionProvider{name: "OpenRouter", endpoint: chatEndpoint(config, OpenRouterURL), model: config.Model, headers: headers, verbose: config.Verbose, timeout: config.AITimeout, stream: config.Stream}, nil
+        case ProviderGroq:
+                return &chatCompletionProvider{name: "Groq", endpoint: chatEndpoint(config, GroqURL), model: config.Model, headers: bearerAuth(apiKey), verbose: config.Verbose, timeout: config.AITimeout, stream: config.Stream}, nil
+        case ProviderOpenAI:
+                // Self-hosted servers behind --api-base may not support response_format
+                return &chatCompletionProvider{name: "OpenAI", endpoint: chatEndpoint(config, OpenAIURL), model: config.Model, headers: bearerAuth(apiKey), verbose: config.Verbose, timeout: config.AITimeout, stream: config.Stream,
+                        structured: config.APIBase == ""}, nil
+        case ProviderMistral:
+                // Some Mistral models reject a temperature of 0.0
+                return &chatCompletionProvider{name: "Mistral", endpoint: chatEndpoint(config, MistralURL), model: config.Model, headers: bearerAuth(apiKey),
+                        minTemperature: 0.1, decodeError: decodeMistralError, verbose: config.Verbose, timeout: config.AITimeout, stream: config.Stream}, nil
+        case ProviderBedrock:
+                return newBedrockProvider(config)
+        default:
+                return &chatCompletionProvider{name: "Perplexity", endpoint: chatEndpoint(config, PerplexityURL), model: config.Model, headers: bearerAuth(apiKey), verbose: config.Verbose, timeout: config.AITimeout, stream: config.Stream,
+                        structured: config.APIBase == ""}, nil
+        }
+}
+
+// Build the OpenAI-style chat request; other providers map its fields onto their own format
+func newChatRequest(model string, input PromptInput) PerplexityRequest {
+        return PerplexityRequest{
+                Model: model,
+                Messages: []Message{
+                        {
+                                Role:    "system",
+                                Content: input.System,
+                        },
+                        {
+                                Role:    "user",
+                                Content: input.Prompt,
+                        },
+                },
+                MaxTokens:   input.MaxTokens,
+                Temperature: input.Temperature,
+        }
+}
+
+// POST a JSON body to an AI API with the standard headers and request timeout
+func postJSON(ctx context.Context, endpoint string, headers map[string]string, body interface{}, timeout time.Duration) (*http.Response, error) {
+        req, err := newJSONRequest(ctx, endpoint, headers, body)
+        if err != nil {
+                return nil, err
+        }
+
+        // Make the request with timeout
+        client := &http.Client{
+                Timeout:   timeout,
+                Transport: recordingTransport{},
+        }
+
+        resp, err := client.Do(req)
+        if err != nil {
+                return nil, fmt.Errorf("executing API request: %w", err)
+        }
+        return resp, nil
+}
+
+// Build a JSON POST request to an AI API with the standard headers
+func newJSONRequest(ctx context.Context, endpoint string, headers map[string]string, body interface{}) (*http.Request, error) {
+        // Marshal the request body
+        jsonData, err := json.Marshal(body)
+        if err != nil {
+                return nil, fmt.Errorf("marshaling API request: %w", err)
+        }
+
+        // Create HTTP request with context
+        req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(jsonData))
+        if err != nil {
+                return nil, fmt.Errorf("creating API request: %w", err)
+        }
+
+        // Set headers
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+        for key, value := range headers {
+                req.Header.Set(key, value)
+        }
+        return req, nil
+}
+
+// Resolve the chat completions URL, preferring --api-base over the provider default
+func chatEndpoint(config *Config, defaultURL string) string {
+        if config.APIBase == "" {
+                return defaultURL
+        }
+        base := strings.TrimRight(config.APIBase, "/")
+        if strings.HasSuffix(base, "/chat/completions") {
+                return base
+        }
+        return base + "/chat/completions"
+}
+
+// Authorization header for bearer-token APIs; omitted when no key is configured
+func bearerAuth(apiKey string) map[string]string {
+        if apiKey == "" {
+                return map[string]string{}
+        }
+        return map[string]string{"Authorization": "Bearer " + apiKey}
+}
+
+// Build the Azure OpenAI chat completions URL for the configured deployment
+func azureURL(config *Config) string {
+        endpoint := config.AzureEndpoint
+        // A bare resource name expands to the standard Azure hostname
+        if !strings.Contains(endpoint, "://") {
+                endpoint = "https://" + endpoint + ".openai.azure.com"
+        }
+        return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+                strings.TrimRight(endpoint, "/"), url.PathEscape(config.AzureDeployment), url.QueryEscape(config.AzureAPIVersion))
+}
+
+// Print x-ratelimit-* response headers so users can see how close they are to their quota
+func logRateLimits(header http.Header) {
+        var names []string
+        for name := range header {
+                if strings.HasPrefix(strings.ToLower(name), "x-ratelimit-") {
+                        names = append(names, name)
+                }
+        }
+        sort.Strings(names)
+
+        for _, name := range names {
+                fmt.Fprintf(textOutput, "Rate limit %s: %s\n", strings.TrimPrefix(strings.ToLower(name), "x-ratelimit-"), header.Get(name))
+        }
+}
+
+// Provider for OpenAI-compatible chat completions APIs (Perplexity, OpenAI, Azure, OpenRouter, Groq, Mistral)
+type chatCompletionProvider struct {
+        name     string
+        endpoint string
+        model    string
+        headers  map[string]string
+        verbose  bool
+        timeout  time.Duration
+
+        // Lowest temperature the API accepts; zero means no floor
+        minTemperature float64
+        // Extracts a readable message from an error body; defaults to decodeChatError
+        decodeError func(body []byte) string
+        // Request a server-sent event stream instead of a single response
+        stream bool
+        // Send the prompt's schema as response_format
+        structured bool
+}
+
+func (p *chatCompletionProvider) Name() string {
+        return p.name
+}
+
+func (p *chatCompletionProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        if p.verbose {
+                fmt.Fprintf(textOutput, "Making %s API request...\n", p.name)
+        }
+
+        reqBody := newChatRequest(p.model, input)
+        if reqBody.Temperature < p.minTemperature {
+                reqBody.Temperature = p.minTemperature
+        }
+        structured := p.structured && input.Schema != nil
+        if structured {
+                reqBody.ResponseFormat = &ResponseFormat{
+                        Type:       "json_schema",
+                        JSONSchema: &JSONSchemaFormat{Name: "extensions", Strict: true, Schema: input.Schema},
+                }
+        }
+
+        if p.stream {
+                completion, err := p.suggestStream(ctx, reqBody)
+                completion.Structured = structured
+                var streamErr *streamInterruptedError
+                if !errors.As(err, &streamErr) || ctx.Err() != nil {
+                        return completion, err
+                }
+                fmt.Fprintf(os.Stderr, "%sWarning: %v, retrying without streaming%s\n", ColorYellow, err, ColorReset)
+        }
+
+        resp, err := postJSON(ctx, p.endpoint, p.headers, reqBody, p.timeout)
+        if err != nil {
+                return RawCompletion{}, err
+        }
+        defer resp.Body.Close()
+
+        if err := p.checkResponse(resp); err != nil {
+                return RawCompletion{}, err
+        }
+
+        completion, err := decodeChatResponse(resp.Body)
+        completion.Structured = structured
+        return completion, err
+}
+
+// Log rate limits and turn a non-200 response into an APIError,
+// surfacing the provider's error message when present
+func (p *chatCompletionProvider) checkResponse(resp *http.Response) error {
+        if p.verbose {
+                logRateLimits(resp.Header)
+        }
+
+        if resp.StatusCode != http.StatusOK {
+                body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+                decodeError := p.decodeError
+                if decodeError == nil {
+                        decodeError = decodeChatError
+                }
+                apiErr := responseError(resp, "API request failed with status %d: %s", resp.StatusCode, resp.Status)
+                if message := decodeError(body); message != "" {
+                        apiErr = responseError(resp, "API request failed with status %d: %s", resp.StatusCode, message)
+                }
+                apiErr.Body = string(body)
+                return apiErr
+        }
+        return nil
+}
+
+// Decode a non-streaming chat completions response
+func decodeChatResponse(body io.Reader) (RawCompletion, error) {
+        var perplexityResp PerplexityResponse
+        if err := json.NewDecoder(body).Decode(&perplexityResp); err != nil {
+                return RawCompletion{}, fmt.Errorf("parsing API response: %w", err)
+        }
+
+        if len(perplexityResp.Choices) == 0 {
+                return RawCompletion{}, fmt.Errorf("no choices in API response")
+        }
+
+        return RawCompletion{Content: perplexityResp.Choices[0].Message.Content, Usage: perplexityResp.Usage}, nil
+}
+
+// Returned when Ctrl+C or SIGTERM stops an ffuf run or the passes after it
+type interruptedError struct {
+        what string
+}
+
+func (e *interruptedError) Error() string {
+        return e.what + " was interrupted"
+}
+
+// Exit code for a failed run: ffuf's own when ffuf failed, 128 plus the
+// signal when a signal killed it, ExitInterrupted after Ctrl+C and
+// ExitFailure otherwise
+func exitCode(err error) int {
+        var interrupted *interruptedError
+        var exitErr *exec.ExitError
+        switch {
+        case errors.As(err, &interrupted):
+                return ExitInterrupted
+        case errors.As(err, &exitErr):
+                if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+                        return 128 + int(status.Signal())
+                }
+                if code := exitErr.ExitCode(); code > 0 {
+                        return code
+                }
+        }
+        return ExitFailure
+}
+
+// Returned when a stream stops before the server signals completion, either
+// because the connection dropped or no chunk arrived within --ai-timeout
+type streamInterruptedError struct {
+        reason string
+}
+
+func (e *streamInterruptedError) Error() string {
+        return "stream interrupted: " + e.reason
+}
+
+// Send the request with stream:true and accumulate the server-sent deltas.
+// The provider's timeout applies between chunks rather than to the whole
+// response.
+func (p *chatCompletionProvider) suggestStream(ctx context.Context, reqBody PerplexityRequest) (RawCompletion, error) {
+        reqBody.Stream = true
+
+        streamCtx, cancel := context.WithCancel(ctx)
+        defer cancel()
+
+        // Cancel the request when the server goes quiet for too long
+        var idle bool
+        var idleMu sync.Mutex
+        idleTimer := time.AfterFunc(p.timeout, func() {
+                idleMu.Lock()
+                idle = true
+                idleMu.Unlock()
+                cancel()
+        })
+        defer idleTimer.Stop()
+        timedOut := func() bool {
+                idleMu.Lock()
+                defer idleMu.Unlock()
+                return idle
+        }
+        idleErr := &streamInterruptedError{reason: fmt.Sprintf("no data for %s", p.timeout)}
+
+        req, err := newJSONRequest(streamCtx, p.endpoint, p.headers, reqBody)
+        if err != nil {
+                return RawCompletion{}, err
+        }
+        req.Header.Set("Accept", "text/event-stream")
+
+        resp, err := (&http.Client{Transport: recordingTransport{}}).Do(req)
+        if err != nil {
+                if timedOut() {
+                        return RawCompletion{}, idleErr
+                }
+                return RawCompletion{}, fmt.Errorf("executing API request: %w", err)
+        }
+        defer resp.Body.Close()
+
+        if err := p.checkResponse(resp); err != nil {
+                return RawCompletion{}, err
+        }
+
+        // Some OpenAI-compatible servers ignore stream:true and answer normally
+        if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+                idleTimer.Stop()
+                return decodeChatResponse(resp.Body)
+        }
+
+        progress := newStreamProgress(p.verbose)
+        defer progress.done()
+
+        var content strings.Builder
+        var usage Usage
+        finished := false
+
+        scanner := bufio.NewScanner(resp.Body)
+        scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+        for scanner.Scan() {
+                idleTimer.Reset(p.timeout)
+
+                line := strings.TrimSpace(scanner.Text())
+                if !strings.HasPrefix(line, "data:") {
+                        // Blank separators, comments and event names carry no content
+                        continue
+                }
+                data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+                if data == "[DONE]" {
+                        finished = true
+                        break
+                }
+
+                var chunk StreamChunk
+                if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+                        return RawCompletion{}, fmt.Errorf("parsing stream chunk: %w", err)
+                }
+                if chunk.Usage != nil {
+                        usage = *chunk.Usage
+                }
+                for _, choice := range chunk.Choices {
+                        content.WriteString(choice.Delta.Content)
+                        progress.update(choice.Delta.Content)
+                        if choice.FinishReason != "" {
+                                finished = true
+                        }
+                }
+        }
+
+        if timedOut() {
+                return RawCompletion{}, idleErr
+        }
+        if ctx.Err() != nil {
+                return RawCompletion{}, fmt.Errorf("reading API stream: %w", ctx.Err())
+        }
+        if err := scanner.Err(); err != nil {
+                return RawCompletion{}, &streamInterruptedError{reason: err.Error()}
+        }
+        if !finished {
+                return RawCompletion{}, &streamInterruptedError{reason: "connection closed before the response finished"}
+        }
+
+        return RawCompletion{Content: content.String(), Usage: usage}, nil
+}
+
+// Shows that a streamed response is still arriving: the tokens themselves in
+// verbose mode, otherwise a spinner on stderr when it is a terminal
+type streamProgress struct {
+        verbose bool
+        spinner bool
+        frame   int
+        printed bool
+}
+
+func newStreamProgress(verbose bool) *streamProgress {
+        progress := &streamProgress{verbose: verbose}
+        if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+                progress.spinner = !verbose
+        }
+        return progress
+}
+
+func (s *streamProgress) update(token string) {
+        if token == "" {
+                return
+        }
+        switch {
+        case s.verbose:
+                if !s.printed {
+                        fmt.Fprint(textOutput, "Streaming: ")
+                }
+                fmt.Fprint(textOutput, token)
+        case s.spinner:
+                frames := `|/-\`
+                fmt.Fprintf(os.Stderr, "\r%s%c Receiving AI response...%s", ColorCyan, frames[s.frame%len(frames)], ColorReset)
+                s.frame++
+        }
+        s.printed = true
+}
+
+func (s *streamProgress) done() {
+        if !s.printed {
+                return
+        }
+        if s.verbose {
+                fmt.Fprintln(textOutput)
+        } else if s.spinner {
+                // Clear the spinner line
+                fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", 40))
+        }
+}
+
+// Extract the message from an OpenAI-style error envelope, including OpenRouter metadata
+func decodeChatError(body []byte) string {
+        var errResp ChatErrorResponse
+        if json.Unmarshal(body, &errResp) != nil {
+                return ""
+        }
+        if errResp.Error.Message == "" {
+                return errResp.Message
+        }
+        if len(errResp.Error.Metadata) > 0 && string(errResp.Error.Metadata) != "null" {
+                return fmt.Sprintf("%s (metadata: %s)", errResp.Error.Message, errResp.Error.Metadata)
+        }
+        return errResp.Error.Message
+}
+
+// Extract the message from a Mistral error body. Mistral reports most errors
+// as a top-level message (sometimes an object) and validation errors as a detail list.
+func decodeMistralError(body []byte) string {
+        var errResp MistralErrorResponse
+        if json.Unmarshal(body, &errResp) != nil {
+                return ""
+        }
+
+        var message string
+        if json.Unmarshal(errResp.Message, &message) != nil && len(errResp.Message) > 0 {
+                message = string(errResp.Message)
+        }
+
+        var details []string
+        for _, detail := range errResp.Detail {
+                var loc []string
+                for _, part := range detail.Loc {
+                        loc = append(loc, fmt.Sprint(part))
+                }
+                details = append(details, fmt.Sprintf("%s: %s", strings.Join(loc, "."), detail.Msg))
+        }
+
+        switch {
+        case message != "" && len(details) > 0:
+                return message + " (" + strings.Join(details, "; ") + ")"
+        case len(details) > 0:
+                return strings.Join(details, "; ")
+        case message != "":
+                return message
+        default:
+                return decodeChatError(body)
+        }
+}
+
+// Provider for the Anthropic messages API
+type anthropicProvider struct {
+        apiKey  string
+        model   string
+        verbose bool
+        timeout time.Duration
+}
+
+func (p *anthropicProvider) Name() string {
+        return "Anthropic"
+}
+
+func (p *anthropicProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        // Anthropic takes the system message as a top-level field
+        reqBody := AnthropicRequest{
+                Model:  p.model,
+                System: input.System,
+                Messages: []Message{
+                        {
+                                Role:    "user",
+                                Content: input.Prompt,
+                        },
+                },
+                MaxTokens:   input.MaxTokens,
+                Temperature: input.Temperature,
+        }
+
+        headers := map[string]string{
+                "x-api-key":         p.apiKey,
+                "anthropic-version": AnthropicVersion,
+        }
+
+        if p.verbose {
+                fmt.Fprintf(textOutput, "Making Anthropic API request...\n")
+        }
+
+        resp, err := postJSON(ctx, AnthropicURL, headers, reqBody, p.timeout)
+        if err != nil {
+                return RawCompletion{}, err
+        }
+        defer resp.Body.Close()
+
+        // Check response status; Anthropic errors use the same error.message envelope
+        if resp.StatusCode != http.StatusOK {
+                body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+                apiErr := responseError(resp, "API request failed with status %d: %s", resp.StatusCode, resp.Status)
+                if message := decodeChatError(body); message != "" {
+                        apiErr = responseError(resp, "API request failed with status %d: %s", resp.StatusCode, message)
+                }
+                apiErr.Body = string(body)
+                return RawCompletion{}, apiErr
+        }
+
+        // Parse the response
+        var anthropicResp AnthropicResponse
+        if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+                return RawCompletion{}, fmt.Errorf("parsing API response: %w", err)
+        }
+
+        // Join the text blocks; other block types carry no suggestion text
+        var content strings.Builder
+        for _, block := range anthropicResp.Content {
+                if block.Type == "text" {
+                        content.WriteString(block.Text)
+                }
+        }
+
+        if content.Len() == 0 {
+                return RawCompletion{}, fmt.Errorf("no text content in API response")
+        }
+
+        usage := Usage{
+                PromptTokens:     anthropicResp.Usage.InputTokens,
+                CompletionTokens: anthropicResp.Usage.OutputTokens,
+                TotalTokens:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
+        }
+        return RawCompletion{Content: content.String(), Usage: usage}, nil
+}
+
+// Provider for the Google Gemini generateContent API
+type geminiProvider struct {
+        apiKey  string
+        model   string
+        verbose bool
+        timeout time.Duration
+}
+
+func (p *geminiProvider) Name() string {
+        return "Gemini"
+}
+
+func (p *geminiProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        // Map the prompt onto Gemini's contents/parts format
+        reqBody := GeminiRequest{
+                SystemInstruction: &GeminiContent{Parts: []GeminiPart{{Text: input.System}}},
+                Contents: []GeminiContent{
+                        {
+                                Role:  "user",
+                                Parts: []GeminiPart{{Text: input.Prompt}},
+                        },
+                },
+                GenerationConfig: GeminiGenerationConfig{
+                        Temperature:     input.Temperature,
+                        MaxOutputTokens: input.MaxTokens,
+                },
+        }
+
+        // The model is part of the endpoint path
+        endpoint := GeminiBaseURL + url.PathEscape(p.model) + ":generateContent"
+
+        if p.verbose {
+                fmt.Fprintf(textOutput, "Making Gemini API request...\n")
+        }
+
+        resp, err := postJSON(ctx, endpoint, map[string]string{"x-goog-api-key": p.apiKey}, reqBody, p.timeout)
+        if err != nil {
+                return RawCompletion{}, err
+        }
+        defer resp.Body.Close()
+
+        // Check response status, decoding Google's error envelope when present
+        if resp.StatusCode != http.StatusOK {
+                var errResp GeminiErrorResponse
+                if json.NewDecoder(resp.Body).Decode(&errResp) != nil || errResp.Error.Message == "" {
+                        return RawCompletion{}, responseError(resp, "API request failed with status %d: %s", resp.StatusCode, resp.Status)
+                }
+
+                if resp.StatusCode == http.StatusTooManyRequests {
+                        retryDelay := ""
+                        for _, detail := range errResp.Error.Details {
+                                if detail.RetryDelay != "" {
+                                        retryDelay = detail.RetryDelay
+                                        break
+                                }
+                        }
+                        if retryDelay != "" {
+                                apiErr := responseError(resp, "Gemini quota exceeded, retry in %s: %s", retryDelay, errResp.Error.Message)
+                                if delay, err := time.ParseDuration(retryDelay); err == nil && apiErr.RetryAfter == 0 {
+                                        apiErr.RetryAfter = delay
+                                }
+                                return RawCompletion{}, apiErr
+                        }
+                        return RawCompletion{}, responseError(resp, "Gemini quota exceeded: %s", errResp.Error.Message)
+                }
+
+                return RawCompletion{}, responseError(resp, "API request failed with status %d (%s): %s", resp.StatusCode, errResp.Error.Status, errResp.Error.Message)
+        }
+
+        // Parse the response
+        var geminiResp GeminiResponse
+        if err := json.NewDecoder(resp.Body).Decode(&geminiResp); err != nil {
+                return RawCompletion{}, fmt.Errorf("parsing API response: %w", err)
+        }
+
+        if len(geminiResp.Candidates) == 0 || len(geminiResp.Candidates[0].Content.Parts) == 0 {
+                return RawCompletion{}, fmt.Errorf("no candidates in API response")
+        }
+
+        usage := Usage{
+                PromptTokens:     geminiResp.UsageMetadata.PromptTokenCount,
+                CompletionTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
+                TotalTokens:      geminiResp.UsageMetadata.TotalTokenCount,
+        }
+        return RawCompletion{Content: geminiResp.Candidates[0].Content.Parts[0].Text, Usage: usage}, nil
+}
+
+// Resolve the Ollama chat endpoint from --api-base, OLLAMA_HOST or the default
+func ollamaURL(config *Config) string {
+        base := config.APIBase
+        if base == "" {
+                base = os.Getenv("OLLAMA_HOST")
+        }
+        if base == "" {
+                base = OllamaDefaultHost
+        }
+        // OLLAMA_HOST is commonly set as host:port without a scheme
+        if !strings.Contains(base, "://") {
+                base = "http://" + base
+        }
+        return strings.TrimRight(base, "/") + "/api/chat"
+}
+
+// Provider for a local Ollama server
+type ollamaProvider struct {
+        endpoint string
+        model    string
+        headers  map[string]string
+        verbose  bool
+        timeout  time.Duration
+}
+
+func (p *ollamaProvider) Name() string {
+        return "Ollama"
+}
+
+func (p *ollamaProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        // Streaming is disabled so the reply arrives as a single JSON object
+        chatReq := newChatRequest(p.model, input)
+        reqBody := OllamaRequest{
+                Model:    p.model,
+                Messages: chatReq.Messages,
+                Stream:   false,
+                Options: OllamaOptions{
+                        Temperature: input.Temperature,
+                        NumPredict:  input.MaxTokens,
+                },
+                Format: input.Schema,
+        }
+
+        if p.verbose {
+                fmt.Fprintf(textOutput, "Making Ollama API request to %s...\n", p.endpoint)
+        }
+
+        resp, err := postJSON(ctx, p.endpoint, p.headers, reqBody, p.timeout)
+        if err != nil {
+                return RawCompletion{}, err
+        }
+        defer resp.Body.Close()
+
+        // Parse the response; Ollama reports errors such as unknown models in the body
+        var ollamaResp OllamaResponse
+        decodeErr := json.NewDecoder(resp.Body).Decode(&ollamaResp)
+
+        if resp.StatusCode != http.StatusOK {
+                if decodeErr == nil && ollamaResp.Error != "" {
+                        return RawCompletion{}, responseError(resp, "API request failed with status %d: %s", resp.StatusCode, ollamaResp.Error)
+                }
+                return RawCompletion{}, responseError(resp, "API request failed with status %d: %s", resp.StatusCode, resp.Status)
+        }
+
+        if decodeErr != nil {
+                return RawCompletion{}, fmt.Errorf("parsing API response: %w", decodeErr)
+        }
+
+        if ollamaResp.Message.Content == "" {
+                return RawCompletion{}, fmt.Errorf("no message content in API response")
+        }
+
+        usage := Usage{
+                PromptTokens:     ollamaResp.PromptEvalCount,
+                CompletionTokens: ollamaResp.EvalCount,
+                TotalTokens:      ollamaResp.PromptEvalCount + ollamaResp.EvalCount,
+        }
+        return RawCompletion{Content: ollamaResp.Message.Content, Usage: usage, Structured: input.Schema != nil}, nil
+}
+
+// AWS credentials used to sign Bedrock requests
+type awsCredentials struct {
+        AccessKeyID     string
+        SecretAccessKey string
+        SessionToken    string
+}
+
+// Error body returned by AWS JSON APIs
+type AWSErrorResponse struct {
+        Message      string `json:"message"`
+        MessageUpper string `json:"Message"`
+}
+
+// Provider for AWS Bedrock InvokeModel with SigV4 signing
+type bedrockProvider struct {
+        region  string
+        model   string
+        creds   awsCredentials
+        verbose bool
+        timeout time.Duration
+}
+
+// Resolve AWS credentials and region, then create the Bedrock provider
+func newBedrockProvider(config *Config) (AIProvider, error) {
+        profile := envOrDefault("AWS_PROFILE", "default")
+
+        creds, err := loadAWSCredentials(profile)
+        if err != nil {
+                return nil, err
+        }
+
+        region := config.AWSRegion
+        if region == "" {
+                region = os.Getenv("AWS_REGION")
+        }
+        if region == "" {
+                region = os.Getenv("AWS_DEFAULT_REGION")
+        }
+        if region == "" {
+                region = loadAWSRegion(profile)
+        }
+        if region == "" {
+                return nil, fmt.Errorf("no AWS region configured; pass --aws-region or set AWS_REGION")
+        }
+
+        return &bedrockProvider{region: region, model: config.Model, creds: creds, verbose: config.Verbose, timeout: config.AITimeout}, nil
+}
+
+// Load credentials from the environment, then the shared credentials file
+func loadAWSCredentials(profile string) (awsCredentials, error) {
+        creds := awsCredentials{
+                AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+                SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+                SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+        }
+        if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+                return creds, nil
+        }
+
+        path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+        if path == "" {
+                home, _ := os.UserHomeDir()
+                path = filepath.Join(home, ".aws", "credentials")
+        }
+
+        values := readINISection(path, profile)
+        creds = awsCredentials{
+                AccessKeyID:     values["aws_access_key_id"],
+                SecretAccessKey: values["aws_secret_access_key"],
+                SessionToken:    values["aws_session_token"],
+        }
+        if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+                return awsCredentials{}, fmt.Errorf("no AWS credentials found (set AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY or configure profile %q in %s)", profile, path)
+        }
+        return creds, nil
+}
+
+// Read the region for a profile from the shared config file
+func loadAWSRegion(profile string) string {
+        path := os.Getenv("AWS_CONFIG_FILE")
+        if path == "" {
+                home, _ := os.UserHomeDir()
+                path = filepath.Join(home, ".aws", "config")
+        }
+
+        // Non-default profiles are written as [profile name] in the config file
+        section := profile
+        if profile != "default" {
+                section = "profile " + profile
+        }
+        return readINISection(path, section)["region"]
+}
+
+// Read key = value pairs from one [section] of an AWS-style INI file
+func readINISection(path string, section string) map[string]string {
+        values := make(map[string]string)
+
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return values
+        }
+
+        inSection := false
+        for _, line := range strings.Split(string(data), "\n") {
+                line = strings.TrimSpace(line)
+                if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+                        continue
+                }
+                if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+                        inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+                        continue
+                }
+                if key, value, ok := strings.Cut(line, "="); ok && inSection {
+                        values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+                }
+        }
+        return values
+}
+
+func (p *bedrockProvider) Name() string {
+        return "Bedrock"
+}
+
+func (p *bedrockProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        // Each model family on Bedrock has its own request body
+        var reqBody interface{}
+        switch {
+        case strings.Contains(p.model, "anthropic."):
+                reqBody = map[string]interface{}{
+                        "anthropic_version": BedrockAnthropicVer,
+                        "system":            input.System,
+                        "messages":          []Message{{Role: "user", Content: input.Prompt}},
+                        "max_tokens":        input.MaxTokens,
+                        "temperature":       input.Temperature,
+                }
+        case strings.Contains(p.model, "meta."):
+                reqBody = map[string]interface{}{
+                        "prompt":      llamaChatPrompt(input),
+                        "max_gen_len": input.MaxTokens,
+                        "temperature": input.Temperature,
+                }
+        default:
+                return RawCompletion{}, fmt.Errorf("unsupported Bedrock model %q (supported families: anthropic.*, meta.*)", p.model)
+        }
+
+        jsonData, err := json.Marshal(reqBody)
+        if err != nil {
+                return RawCompletion{}, fmt.Errorf("marshaling API request: %w", err)
+        }
+
+        host := "bedrock-runtime." + p.region + ".amazonaws.com"
+        endpoint := "https://" + host + "/model/" + awsURIEncode(p.model) + "/invoke"
+
+        req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+        if err != nil {
+                return RawCompletion{}, fmt.Errorf("creating API request: %w", err)
+        }
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("Accept", "application/json")
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+        signAWSRequest(req, jsonData, p.creds, p.region, "bedrock", time.Now())
+
+        if p.verbose {
+                fmt.Fprintf(textOutput, "Making Bedrock API request to %s...\n", host)
+        }
+
+        client := &http.Client{
+                Timeout:   p.timeout,
+                Transport: recordingTransport{},
+        }
+        resp, err := client.Do(req)
+        if err != nil {
+                var dnsErr *net.DNSError
+                if errors.As(err, &dnsErr) {
+                        return RawCompletion{}, fmt.Errorf("no Bedrock endpoint for region %q (%s): %w", p.region, host, err)
+                }
+                return RawCompletion{}, fmt.Errorf("executing API request: %w", err)
+        }
+        defer resp.Body.Close()
+
+        // AWS names the failure in a header and explains it in the body
+        if resp.StatusCode != http.StatusOK {
+                var errResp AWSErrorResponse
+                json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&errResp)
+                message := errResp.Message
+                if message == "" {
+                        message = errResp.MessageUpper
+                }
+                if message == "" {
+                        message = resp.Status
+                }
+                if errType := resp.Header.Get("X-Amzn-ErrorType"); errType != "" {
+                        // The header looks like "ValidationException:http://internal.amazon.com/..."
+                        errType, _, _ = strings.Cut(errType, ":")
+                        message = errType + ": " + message
+                }
+                return RawCompletion{}, responseError(resp, "AWS request failed with status %d: %s", resp.StatusCode, message)
+        }
+
+        if strings.Contains(p.model, "anthropic.") {
+                var anthropicResp AnthropicResponse
+                if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+                        return RawCompletion{}, fmt.Errorf("parsing API response: %w", err)
+                }
+                var content strings.Builder
+                for _, block := range anthropicResp.Content {
+                        if block.Type == "text" {
+                                content.WriteString(block.Text)
+                        }
+                }
+                usage := Usage{
+                        PromptTokens:     anthropicResp.Usage.InputTokens,
+                        CompletionTokens: anthropicResp.Usage.OutputTokens,
+                        TotalTokens:      anthropicResp.Usage.InputTokens + anthropicResp.Usage.OutputTokens,
+                }
+                return RawCompletion{Content: content.String(), Usage: usage}, nil
+        }
+
+        var llamaResp struct {
+                Generation           string `json:"generation"`
+                PromptTokenCount     int    `json:"prompt_token_count"`
+                GenerationTokenCount int    `json:"generation_token_count"`
+        }
+        if err := json.NewDecoder(resp.Body).Decode(&llamaResp); err != nil {
+                return RawCompletion{}, fmt.Errorf("parsing API response: %w", err)
+        }
+        usage := Usage{
+                PromptTokens:     llamaResp.PromptTokenCount,
+                CompletionTokens: llamaResp.GenerationTokenCount,
+                TotalTokens:      llamaResp.PromptTokenCount + llamaResp.GenerationTokenCount,
+        }
+        return RawCompletion{Content: llamaResp.Generation, Usage: usage}, nil
+}
+
+// Format a prompt using the Llama 3 chat template expected by Bedrock's meta models
+func llamaChatPrompt(input PromptInput) string {
+        return "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\n" + input.System +
+                "<|eot_id|><|start_header_id|>user<|end_header_id|>\n\n" + input.Prompt +
+                "<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n"
+}
+
+// URI-encode a string the way SigV4 expects: everything except unreserved characters
+func awsURIEncode(value string) string {
+        var b strings.Builder
+        for _, c := range []byte(value) {
+                if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+                        c == '-' || c == '_' || c == '.' || c == '~' {
+                        b.WriteByte(c)
+                } else {
+                        fmt.Fprintf(&b, "%%%02X", c)
+                }
+        }
+        return b.String()
+}
+
+// Sign a request with AWS Signature Version 4
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region string, service string, now time.Time) {
+        amzDate := now.UTC().Format("20060102T150405Z")
+        date := amzDate[:8]
+        payloadHash := sha256Hex(body)
+
+        req.Header.Set("X-Amz-Date", amzDate)
+        req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+        if creds.SessionToken != "" {
+                req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+        }
+
+        // Canonical headers must be lowercase and sorted
+        signed := map[string]string{"host": req.URL.Host}
+        for name, values := range req.Header {
+                lower := strings.ToLower(name)
+                if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+                        signed[lower] = strings.TrimSpace(strings.Join(values, ","))
+                }
+        }
+        var names []string
+        for name := range signed {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var canonicalHeaders strings.Builder
+        for _, name := range names {
+                canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+        }
+        signedHeaders := strings.Join(names, ";")
+
+        // Non-S3 services encode each path segment twice; the request path is already encoded once
+        segments := strings.Split(req.URL.EscapedPath(), "/")
+        for i, segment := range segments {
+                segments[i] = awsURIEncode(segment)
+        }
+        canonicalURI := strings.Join(segments, "/")
+
+        canonicalRequest := strings.Join([]string{
+                req.Method,
+                canonicalURI,
+                req.URL.RawQuery,
+                canonicalHeaders.String(),
+                signedHeaders,
+                payloadHash,
+        }, "\n")
+
+        scope := date + "/" + region + "/" + service + "/aws4_request"
+        stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+        key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+        key = hmacSHA256(key, region)
+        key = hmacSHA256(key, service)
+        key = hmacSHA256(key, "aws4_request")
+        signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+        req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+                creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+        sum := sha256.Sum256(data)
+        return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+        mac := hmac.New(sha256.New, key)
+        mac.Write([]byte(data))
+        return mac.Sum(nil)
+}
+
+// Model entry printed by the models command
+type ModelInfo struct {
+        Name          string `json:"name"`
+        ContextWindow int    `json:"context_window,omitempty"`
+        Selectable    bool   `json:"selectable"`
+}
+
+// Perplexity has no model-listing endpoint, so its models are bundled
+var perplexityModels = []ModelInfo{
+        {Name: "sonar", ContextWindow: 127072, Selectable: true},
+        {Name: "sonar-pro", ContextWindow: 200000, Selectable: true},
+        {Name: "sonar-reasoning", ContextWindow: 127072, Selectable: true},
+        {Name: "sonar-reasoning-pro", ContextWindow: 127072, Selectable: true},
+        {Name: "sonar-deep-research", ContextWindow: 127072, Selectable: true},
+}
+
+// Model list returned by OpenAI-compatible and Anthropic /models endpoints.
+// Each service names the context window differently.
+type ModelListResponse struct {
+        Data []ModelListEntry `json:"data"`
+}
+
+type ModelListEntry struct {
+        ID               string `json:"id"`
+        ContextLength    int    `json:"context_length"`     // OpenRouter
+        ContextWindow    int    `json:"context_window"`     // Groq
+        MaxContextLength int    `json:"max_context_length"` // Mistral
+        Active           *bool  `json:"active"`             // Groq
+        Capabilities     *struct {
+                CompletionChat bool `json:"completion_chat"`
+        } `json:"capabilities"` // Mistral
+}
+
+// Local models reported by Ollama's /api/tags
+type OllamaTagsResponse struct {
+        Models []struct {
+                Name string `json:"name"`
+        } `json:"models"`
+}
+
+// Models reported by the Gemini models endpoint
+type GeminiModelsResponse struct {
+        Models []struct {
+                Name                       string   `json:"name"`
+                InputTokenLimit            int      `json:"inputTokenLimit"`
+                SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+        } `json:"models"`
+}
+
+// OpenAI lists every model family under /models; these can't answer chat requests
+var nonChatModelPrefixes = []string{"text-embedding", "whisper", "tts", "dall-e", "omni-moderation", "text-moderation", "babbage", "davinci", "gpt-image"}
+
+// GET a JSON document from an AI API and decode it into out
+func getJSON(ctx context.Context, endpoint string, headers map[string]string, out interface{}, timeout time.Duration) error {
+        req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+        if err != nil {
+                return fmt.Errorf("creating API request: %w", err)
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+        for key, value := range headers {
+                req.Header.Set(key, value)
+        }
+
+        client := &http.Client{
+                Timeout: timeout,
+        }
+        resp, err := client.Do(req)
+        if err != nil {
+                return fmt.Errorf("executing API request: %w", err)
+        }
+        defer resp.Body.Close()
+
+        if resp.StatusCode != http.StatusOK {
+                body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+                if message := decodeChatError(body); message != "" {
+                        return responseError(resp, "API request failed with status %d: %s", resp.StatusCode, message)
+                }
+                return responseError(resp, "API request failed with status %d: %s", resp.StatusCode, resp.Status)
+        }
+
+        if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+                return fmt.Errorf("parsing API response: %w", err)
+        }
+        return nil
+}
+
+// Fetch the models the configured provider offers
+func listModels(ctx context.Context, config *Config) ([]ModelInfo, error) {
+        if config.Provider == ProviderPerplexity && config.APIBase == "" {
+                return perplexityModels, nil
+        }
+        if config.Provider == ProviderAzure || config.Provider == ProviderBedrock {
+                return nil, fmt.Errorf("listing models is not supported for provider %s", config.Provider)
+        }
+
+        keys, err := getAPIKeys(config)
+        if err != nil {
+                return nil, err
+        }
+        apiKey := keys[0]
+
+        var models []ModelInfo
+        switch config.Provider {
+        case ProviderOllama:
+                var tags OllamaTagsResponse
+                endpoint := strings.TrimSuffix(ollamaURL(config), "/chat") + "/tags"
+                if err := getJSON(ctx, endpoint, bearerAuth(apiKey), &tags, config.AITimeout); err != nil {
+                        return nil, err
+                }
+                for _, model := range tags.Models {
+                        models = append(models, ModelInfo{Name: model.Name, Selectable: true})
+                }
+        case ProviderGemini:
+                var list GeminiModelsResponse
+                if err := getJSON(ctx, strings.TrimSuffix(GeminiBaseURL, "/")+"?pageSize=1000", map[string]string{"x-goog-api-key": apiKey}, &list, config.AITimeout); err != nil {
+                        return nil, err
+                }
+                for _, model := range list.Models {
+                        models = append(models, ModelInfo{
+                                Name:          strings.TrimPrefix(model.Name, "models/"),
+                                ContextWindow: model.InputTokenLimit,
+                                Selectable:    containsString(model.SupportedGenerationMethods, "generateContent"),
+                        })
+                }
+        default:
+                endpoint := strings.TrimSuffix(chatEndpoint(config, openAICompatibleURL(config.Provider)), "/chat/completions") + "/models"
+                headers := bearerAuth(apiKey)
+                if config.Provider == ProviderAnthropic {
+                        endpoint = strings.TrimSuffix(AnthropicURL, "/messages") + "/models?limit=1000"
+                        headers = map[string]string{"x-api-key": apiKey, "anthropic-version": AnthropicVersion}
+                }
+
+                var list ModelListResponse
+                if err := getJSON(ctx, endpoint, headers, &list, config.AITimeout); err != nil {
+                        return nil, err
+                }
+                for _, entry := range list.Data {
+                        models = append(models, modelFromEntry(entry))
+                }
+        }
+
+        sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+        return models, nil
+}
+
+// Default chat completions URL for the OpenAI-compatible providers
+func openAICompatibleURL(provider string) string {
+        switch provider {
+        case ProviderOpenRouter:
+                return OpenRouterURL
+        case ProviderGroq:
+                return GroqURL
+        case ProviderMistral:
+                return MistralURL
+        case ProviderPerplexity:
+                return PerplexityURL
+        default:
+                return OpenAIURL
+        }
+}
+
+// Convert a /models entry, deciding whether the model can be used for chat
+func modelFromEntry(entry ModelListEntry) ModelInfo {
+        info := ModelInfo{Name: entry.ID, Selectable: true}
+
+        for _, window := range []int{entry.ContextLength, entry.ContextWindow, entry.MaxContextLength} {
+                if window > 0 {
+                        info.ContextWindow = window
+                        break
+                }
+        }
+
+        switch {
+        case entry.Active != nil:
+                info.Selectable = *entry.Active
+        case entry.Capabilities != nil:
+                info.Selectable = entry.Capabilities.CompletionChat
+        }
+        for _, prefix := range nonChatModelPrefixes {
+                if strings.HasPrefix(entry.ID, prefix) {
+                        info.Selectable = false
+                }
+        }
+        return info
+}
+
+// List the configured provider's models as a table or JSON
+func runModels(config *Config) error {
+        ctx, cancel := context.WithTimeout(context.Background(), config.AITimeout)
+        defer cancel()
+
+        models, err := listModels(ctx, config)
+        if err != nil {
+                return err
+        }
+
+        if config.JSONOutput {
+                encoder := json.NewEncoder(os.Stdout)
+                encoder.SetIndent("", "  ")
+                return encoder.Encode(models)
+        }
+
+        displayBanner()
+        fmt.Fprintf(textOutput, "%sModels for provider %s%s\n\n", ColorCyan, config.Provider, ColorReset)
+
+        writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+        fmt.Fprintln(writer, "NAME\tCONTEXT\tSELECTABLE")
+        for _, model := range models {
+                name := model.Name
+                if name == config.Model {
+                        name += " (selected)"
+                }
+                window := "-"
+                if model.ContextWindow > 0 {
+                        window = fmt.Sprintf("%d", model.ContextWindow)
+                }
+                selectable := "no"
+                if model.Selectable {
+                        selectable = "yes"
+                }
+                fmt.Fprintf(writer, "%s\t%s\t%s\n", name, window, selectable)
+        }
+        return writer.Flush()
+}
+
+// Check what a run depends on: the ffuf binary, the credentials of every
+// configured provider and, given -u, that the target can be reached. Every
+// check runs even when an earlier one fails.
+func runDoctor(config *Config) error {
+        failed := 0
+        report := func(name string, err error, detail string) {
+                if err != nil {
+                        failed++
+                        fmt.Fprintf(textOutput, "%s[FAIL]%s %s: %v\n", ColorRed, ColorReset, name, err)
+                        return
+                }
+                fmt.Fprintf(textOutput, "%s[ OK ]%s %s: %s\n", ColorGreen, ColorReset, name, detail)
+        }
+
+        ffufPath, version, err := checkFfuf(config.FfufPath)
+        if version == "" {
+                version = "unknown version"
+        }
+        report("ffuf", err, ffufPath+", "+version)
+
+        for _, provider := range config.Providers {
+                _, err := newProvider(providerConfig(config, provider))
+                report("provider "+provider, err, "credentials found")
+        }
+
+        if config.URL != "" {
+                if err := validateURL(config.URL, config.Keyword); err != nil {
+                        report("target", err, "")
+                } else {
+                        err := preflight(context.Background(), config, targetResolver, config.URL)
+                        report("target", err, "reachable")
+                }
+        }
+
+        if failed > 0 {
+                return fmt.Errorf("%d of the checks failed", failed)
+        }
+        return nil
+}
+
+// Most requests per model in the bench command
+const maxBenchRounds = 20
+
+// A provider and model compared by the bench command
+type BenchModel struct {
+        Provider string
+        Model    string
+}
+
+// A target and its headers the bench command sends to each model. Files
+// written by --record hold the same fields.
+type HeaderSnapshot struct {
+        TargetURL string            `json:"target_url"`
+        Headers   map[string]string `json:"headers"`
+        Stack     *StackDescriptor  `json:"stack,omitempty"`
+        PageHints string            `json:"page_hints,omitempty"`
+        Robots    string            `json:"robots,omitempty"`
+        Sitemap   string            `json:"sitemap,omitempty"`
+        Favicon   string            `json:"favicon,omitempty"`
+        // Target certificate; its SANs also appear in the --json results
+        Certificate *CertInfo `json:"certificate,omitempty"`
+        // Stack guessed by the local fingerprint rules
+        Technologies *StackDescriptor `json:"technologies,omitempty"`
+        // Distinguishing headers of well-known paths
+        PathProbes string `json:"path_probes,omitempty"`
+        // Endpoints and extensions named in the page's scripts
+        Scripts string `json:"scripts,omitempty"`
+        // Hosts and paths named by the Content-Security-Policy
+        CSP string `json:"csp,omitempty"`
+        // Link header entries that hint at a CMS API, API description or OAuth
+        Links []HeaderLink `json:"links,omitempty"`
+        // Mount point of a single-page app's shell found by calibration
+        SPAShell string `json:"spa_shell,omitempty"`
+        // Canonical name, reverse DNS names and hosting platform of the host
+        DNS *DNSInfo `json:"dns,omitempty"`
+        // Security header audit of the base response
+        Audit []AuditFinding `json:"audit,omitempty"`
+}
+
+// Outcome of one model on one target in the bench command. Latency and
+// tokens are averaged over the rounds that succeeded; the extensions are
+// those of the first of them.
+type BenchResult struct {
+        Target     string   `json:"target"`
+        Provider   string   `json:"provider"`
+        Model      string   `json:"model"`
+        Rounds     int      `json:"rounds"`
+        Failures   int      `json:"failures"`
+        LatencyMS  int64    `json:"latency_ms"`
+        Tokens     int      `json:"tokens"`
+        Valid      int      `json:"valid_extensions"`
+        Extensions []string `json:"extensions"`
+        Error      string   `json:"error,omitempty"`
+        // Names on the target's TLS certificate, useful for vhost work
+        SANs []string `json:"sans,omitempty"`
+        // Security header audit of the target
+        Audit []AuditFinding `json:"audit,omitempty"`
+        // API, CMS and OAuth links from the target's Link headers
+        Links []HeaderLink `json:"links,omitempty"`
+        // Canonical name, reverse DNS names and hosting platform of the target
+        DNS *DNSInfo `json:"dns,omitempty"`
+}
+
+// Validate the bench command's options. The models come from --bench-models,
+// or the provider chain with each provider's model when it is not given.
+func parseBenchArgs(config *Config, models string, urlFlag string, args []string) error {
+        if config.ReplayFile != "" || config.Ensemble || config.Hedge {
+                return fmt.Errorf("bench cannot be combined with --replay, --ensemble or --hedge")
+        }
+        if config.BenchRounds < 1 || config.BenchRounds > maxBenchRounds {
+                return fmt.Errorf("--rounds must be between 1 and %d", maxBenchRounds)
+        }
+        for _, arg := range args {
+                if strings.HasPrefix(arg, "-") {
+                        return fmt.Errorf("bench runs no ffuf, unexpected option %s", arg)
+                }
+        }
+        if (urlFlag == "") == (len(args) == 0) {
+                return fmt.Errorf("bench needs either -u URL or recorded snapshot files")
+        }
+        config.URL = urlFlag
+        config.BenchSnapshots = args
+
+        for _, entry := range strings.Split(models, ",") {
+                if entry = strings.TrimSpace(entry); entry == "" {
+                        continue
+                }
+                // Model names may contain colons themselves (llama3:8b), so only
+                // a known provider name before the first one is split off
+                model := BenchModel{Provider: config.Provider, Model: entry}
+                if index := strings.Index(entry, ":"); index > 0 && isSupportedProvider(strings.ToLower(entry[:index])) {
+                        model = BenchModel{Provider: strings.ToLower(entry[:index]), Model: entry[index+1:]}
+                }
+                config.BenchModels = append(config.BenchModels, model)
+        }
+        if len(config.BenchModels) == 0 {
+                for _, provider := range config.Providers {
+                        config.BenchModels = append(config.BenchModels, BenchModel{Provider: provider})
+                }
+        }
+        return nil
+}
+
+// Read recorded snapshots from a file holding one --record exchange or a
+// JSON array of snapshots
+func loadSnapshots(path string) ([]HeaderSnapshot, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, fmt.Errorf("reading snapshot file: %w", err)
+        }
+        var snapshots []HeaderSnapshot
+        if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+                err = json.Unmarshal(trimmed, &snapshots)
+        } else {
+                var snapshot HeaderSnapshot
+                err = json.Unmarshal(trimmed, &snapshot)
+                snapshots = []HeaderSnapshot{snapshot}
+        }
+        if err != nil {
+                return nil, fmt.Errorf("parsing snapshot file %s: %w", path, err)
+        }
+        for i, snapshot := range snapshots {
+                if snapshot.TargetURL == "" {
+                        return nil, fmt.Errorf("snapshot %d in %s has no target_url", i+1, path)
+                }
+        }
+        return snapshots, nil
+}
+
+// Run the extension prompt for every target against every model and report
+// latency, token usage and the extensions each returned. ffuf is never run.
+func runBench(config *Config) error {
+        var snapshots []HeaderSnapshot
+        if config.URL != "" {
+                if err := validateURL(config.URL, config.Keyword); err != nil {
+                        return err
+                }
+                ctx, cancel := context.WithTimeout(context.Background(), config.ProbeTimeout)
+                headers, cert, err := getHeaders(ctx, config, strings.Replace(config.URL, config.Keyword, "", 1))
+                cancel()
+                warnCertificate(cert)
+                rawHeaders := headers
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: Could not fetch headers from %s: %v%s\n", ColorYellow, config.URL, err, ColorReset)
+                        headers = map[string]string{"Header": "Error fetching headers"}
+                } else if !config.FullHeaders {
+                        headers = compactHeaders(config, headers)
+                }
+                snapshot := HeaderSnapshot{TargetURL: config.URL, Headers: headers, Stack: config.Stack, Certificate: cert}
+                ctx, cancel = context.WithTimeout(context.Background(), config.ProbeTimeout)
+                links := &PageLinks{}
+                if config.BodyHints {
+                        if hints, pageLinks, err := getBodyHints(ctx, config, strings.Replace(config.URL, config.Keyword, "", 1)); err == nil {
+                                snapshot.PageHints, links = hints, pageLinks
+                        }
+                }
+                if config.Favicon {
+                        snapshot.Favicon, _ = fingerprintFavicon(ctx, config, config.URL, links.Icons)
+                }
+                if config.ScriptFiles > 0 {
+                        if scripts := scrapeScripts(ctx, config, config.URL, links.Scripts); scripts != nil {
+                                snapshot.Scripts = scripts.String()
+                        }
+                }
+                var robots *RobotsSummary
+                if config.FetchRobots {
+                        if robots, _ = getRobots(ctx, config, config.URL); robots != nil {
+                                snapshot.Robots = robots.String()
+                        }
+                }
+                if config.FetchSitemap {
+                        if sitemap, _ := getSitemap(ctx, config, config.URL, robotsSitemaps(robots)); sitemap != nil {
+                                snapshot.Sitemap = sitemap.String()
+                        }
+                }
+                if config.Audit && err == nil {
+                        snapshot.Audit = auditHeaders(config.URL, rawHeaders)
+                }
+                if target, parseErr := url.Parse(config.URL); parseErr == nil && err == nil {
+                        if csp := parseCSP(target.Hostname(), rawHeaders); csp != nil {
+                                snapshot.CSP = csp.String()
+                        }
+                        snapshot.Links = interestingLinks(parseLinks(rawHeaders[ResponseURLHeader], rawHeaders["Link"]))
+                }
+                fingerprintHeaders := rawHeaders
+                if len(config.ProbePaths) > 0 && err == nil {
+                        probes := probePaths(ctx, config, strings.Replace(config.URL, config.Keyword, "", 1), rawHeaders)
+                        snapshot.PathProbes = pathProbeSummary(probes)
+                        fingerprintHeaders = withProbeHeaders(rawHeaders, probes)
+                }
+                if config.Fingerprint {
+                        snapshot.Technologies = fingerprintStack(config.FingerprintRules, fingerprintHeaders, snapshot.PageHints, snapshot.Robots+"\n"+snapshot.Sitemap)
+                }
+                if target, parseErr := url.Parse(config.URL); parseErr == nil && config.DNS && targetProxy(config, config.URL) == nil {
+                        if snapshot.DNS = lookupDNS(ctx, targetResolver, target.Hostname()); snapshot.DNS != nil && snapshot.DNS.Platform != nil {
+                                if snapshot.Technologies == nil {
+                                        snapshot.Technologies = &StackDescriptor{}
+                                }
+                                fillStack(snapshot.Technologies, *snapshot.DNS.Platform)
+                        }
+                }
+                cancel()
+                snapshots = append(snapshots, snapshot)
+        }
+        for _, path := range config.BenchSnapshots {
+                loaded, err := loadSnapshots(path)
+                if err != nil {
+                        return err
+                }
+                snapshots = append(snapshots, loaded...)
+        }
+
+        if !config.JSONOutput {
+                displayBanner()
+                fmt.Fprintf(textOutput, "%sBenchmark: %d models, %d targets, %d rounds each%s\n\n", ColorCyan, len(config.BenchModels), len(snapshots), config.BenchRounds, ColorReset)
+        }
+
+        var results []BenchResult
+        for _, snapshot := range snapshots {
+                for _, model := range config.BenchModels {
+                        results = append(results, benchModel(config, model, snapshot))
+                }
+        }
+
+        if config.JSONOutput {
+                encoder := json.NewEncoder(os.Stdout)
+                encoder.SetIndent("", "  ")
+                return encoder.Encode(results)
+        }
+
+        writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+        fmt.Fprintln(writer, "TARGET\tPROVIDER\tMODEL\tLATENCY\tTOKENS\tVALID\tEXTENSIONS")
+        for _, result := range results {
+                latency, tokens, extensions := "-", "-", fmt.Sprintf("%v", result.Extensions)
+                if result.Failures < result.Rounds {
+                        latency = (time.Duration(result.LatencyMS) * time.Millisecond).String()
+                } else {
+                        extensions = "error: " + result.Error
+                }
+                if result.Tokens > 0 {
+                        tokens = strconv.Itoa(result.Tokens)
+                }
+                if result.Failures > 0 && result.Failures < result.Rounds {
+                        extensions += fmt.Sprintf(" (%d/%d rounds failed)", result.Failures, result.Rounds)
+                }
+                fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", result.Target, result.Provider, displayModel(result.Model), latency, tokens, result.Valid, extensions)
+        }
+        return writer.Flush()
+}
+
+// Send one snapshot to one model for each round. Rounds run one after the
+// other, so the change in total token usage is this model's.
+func benchModel(config *Config, model BenchModel, snapshot HeaderSnapshot) BenchResult {
+        attempt := providerConfig(config, model.Provider)
+        attempt.Providers = []string{model.Provider}
+        if model.Model != "" {
+                attempt.Model = model.Model
+        }
+        attempt.Stack = snapshot.Stack
+        attempt.PageHints = snapshot.PageHints
+        attempt.Robots = snapshot.Robots
+        attempt.Sitemap = snapshot.Sitemap
+        attempt.FaviconMatch = snapshot.Favicon
+        attempt.Certificate = snapshot.Certificate
+        attempt.Technologies = snapshot.Technologies
+        attempt.PathProbes = snapshot.PathProbes
+        attempt.Scripts = snapshot.Scripts
+        attempt.CSP = snapshot.CSP
+        attempt.Links = snapshot.Links
+        attempt.SPAShell = snapshot.SPAShell
+        attempt.DNSInfo = snapshot.DNS
+
+        result := BenchResult{Target: snapshot.TargetURL, Provider: model.Provider, Model: attempt.Model, Rounds: config.BenchRounds}
+        if snapshot.Certificate != nil {
+                result.SANs = snapshot.Certificate.SANs
+        }
+        result.Audit = snapshot.Audit
+        result.Links = snapshot.Links
+        result.DNS = snapshot.DNS
+        var latency time.Duration
+        var tokens int
+        for round := 0; round < config.BenchRounds; round++ {
+                before := totalTokenUsage().TotalTokens
+                ctx, cancel := context.WithTimeout(context.Background(), 2*config.AITimeout)
+                start := time.Now()
+                resp, err := getAIExtensions(ctx, snapshot.TargetURL, snapshot.Headers, attempt)
+                elapsed := time.Since(start)
+                cancel()
+                if err != nil {
+                        result.Failures++
+                        result.Error = err.Error()
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "%s/%s round %d failed: %v\n", model.Provider, displayModel(attempt.Model), round+1, err)
+                        }
+                        continue
+                }
+                // Every earlier round failed, so this is the first that answered
+                if round == result.Failures {
+                        result.Extensions = resp.Extensions
+                        result.Valid = len(resp.Extensions)
+                        // A rejected model is retried with the provider default
+                        result.Model = resp.Model
+                }
+                latency += elapsed
+                tokens += totalTokenUsage().TotalTokens - before
+        }
+        if succeeded := result.Rounds - result.Failures; succeeded > 0 {
+                result.LatencyMS = (latency / time.Duration(succeeded)).Milliseconds()
+                result.Tokens = tokens / succeeded
+                result.Error = ""
+        }
+        return result
+}
+
+// Check whether an API error says the requested model doesn't exist
+func isInvalidModelError(err error) bool {
+        var apiErr *APIError
+        if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusNotFound) {
+                return false
+        }
+
+        message := strings.ToLower(apiErr.Message)
+        if !strings.Contains(message, "model") {
+                return false
+        }
+        for _, hint := range []string{"invalid", "not found", "does not exist", "unknown", "not supported", "no such"} {
+                if strings.Contains(message, hint) {
+                        return true
+                }
+        }
+        return false
+}
+
+// Find the selectable model whose name is closest to --model, or "" when the list is unavailable
+func suggestModel(ctx context.Context, config *Config) string {
+        models, err := listModels(ctx, config)
+        if err != nil {
+                return ""
+        }
+
+        best, bestDistance := "", -1
+        for _, model := range models {
+                // The provider default was already retried by getAIExtensions
+                if !model.Selectable || model.Name == config.Model || model.Name == defaultModel(config.Provider) {
+                        continue
+                }
+                if distance := editDistance(strings.ToLower(config.Model), strings.ToLower(model.Name)); bestDistance < 0 || distance < bestDistance {
+                        best, bestDistance = model.Name, distance
+                }
+        }
+        return best
+}
+
+// Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+        previous := make([]int, len(b)+1)
+        current := make([]int, len(b)+1)
+        for j := range previous {
+                previous[j] = j
+        }
+
+        for i := 1; i <= len(a); i++ {
+                current[0] = i
+                for j := 1; j <= len(b); j++ {
+                        cost := 1
+                        if a[i-1] == b[j-1] {
+                                cost = 0
+                        }
+                        current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+                }
+                previous, current = current, previous
+        }
+        return previous[len(b)]
+}
+
+// Flag value that collects repeated occurrences
+type stringList []string
+
+func (l *stringList) String() string {
+        return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+        *l = append(*l, value)
+        return nil
+}
+
+// Single-dash flags that belong to ffufai; all other ffufai options use "--"
+// so they never collide with ffuf's own single-dash flags
+var shortFlags = map[string]bool{
+        "u": true,
+        "h": true,
+        "k": true,
+}
+
+// Check whether an argument is one of our flags and whether it consumes the next argument
+func ownFlag(fs *flag.FlagSet, arg string) (known bool, takesValue bool) {
+        var name string
+        switch {
+        case strings.HasPrefix(arg, "--"):
+                name = strings.TrimPrefix(arg, "--")
+        case strings.HasPrefix(arg, "-") && shortFlags[strings.TrimPrefix(arg, "-")]:
+                name = strings.TrimPrefix(arg, "-")
+        default:
+                return false, false
+        }
+
+        // --flag=value carries its own value
+        inlineValue := false
+        if idx := strings.Index(name, "="); idx >= 0 {
+                name = name[:idx]
+                inlineValue = true
+        }
+
+        f := fs.Lookup(name)
+        if f == nil {
+                return false, false
+        }
+        if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+                return true, false
+        }
+        return true, !inlineValue
+}
+
+// Parse command line arguments with better error handling
+func parseArgs() (*Config, error) {
+        config := &Config{}
+
+        // Create a custom flag set that exits on help
+        fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+        // Define flags including help flags
+        var urlFlag string
+        var providerChain string
+        var ensemble string
+        var showVersion bool
+        var listBuiltin bool
+        var showHelp bool
+        var noAutoMatchers, noOptionsProbe, noAudit, noWAFProbe, noDNS, noFlagChecks bool
+        var headersFile, authFile string
+        var randomAgent bool
+        var keywordFlag string
+        var resolveFlags stringList
+        var dnsServer string
+        var stackList, probePathList string
+        var systemText, systemFile, systemMode string
+        var aiContext string
+        var benchModels string
+
+        probeTimeout, err := envDuration("FFUFAI_PROBE_TIMEOUT", HeaderTimeout)
+        if err != nil {
+                return nil, err
+        }
+        aiTimeout, err := envDuration("FFUFAI_AI_TIMEOUT", RequestTimeout)
+        if err != nil {
+                return nil, err
+        }
+
+        fs.StringVar(&config.FfufPath, "ffuf-path", "ffuf", "Path to ffuf executable")
+        fs.IntVar(&config.MaxExtensions, "max-extensions", 4, "Maximum number of extensions to suggest (1-10)")
+        fs.BoolVar(&config.Explain, "explain", false, "Ask the AI for a short reason per extension and print them as a table")
+        fs.BoolVar(&config.Interactive, "interactive", false, "Toggle, add or remove the suggested extensions at a prompt before ffuf runs")
+        fs.Float64Var(&config.MinConfidence, "min-confidence", 0, "Drop extensions the AI is less confident about than this (0-1)")
+        fs.StringVar(&config.Provider, "provider", ProviderPerplexity, "AI provider to use ("+strings.Join(supportedProviders, ", ")+")")
+        fs.StringVar(&providerChain, "providers", "", "Comma-separated provider fallback chain (e.g. perplexity,openai,ollama)")
+        fs.StringVar(&ensemble, "ensemble", "", "Query these providers concurrently and merge their suggestions (e.g. perplexity,openai)")
+        fs.StringVar(&config.EnsembleMode, "ensemble-mode", EnsembleUnion, "How to merge --ensemble suggestions: union or intersect")
+        fs.BoolVar(&config.Hedge, "hedge", false, "Race the model against a fast one and use the first valid answer")
+        fs.StringVar(&config.HedgeModel, "hedge-model", "", "Fast model raced by --hedge (default depends on provider)")
+        fs.StringVar(&stackList, "stack", "", "Comma-separated technology stack (e.g. wordpress,php); skips AI stack detection")
+        fs.StringVar(&config.Model, "model", "", "AI model to use (default depends on provider)")
+        fs.StringVar(&config.AzureEndpoint, "azure-endpoint", os.Getenv("AZURE_OPENAI_ENDPOINT"), "Azure OpenAI resource endpoint or resource name")
+        fs.StringVar(&config.AzureDeployment, "azure-deployment", os.Getenv("AZURE_OPENAI_DEPLOYMENT"), "Azure OpenAI deployment name")
+        fs.StringVar(&config.AzureAPIVersion, "azure-api-version", envOrDefault("AZURE_OPENAI_API_VERSION", DefaultAzureVersion), "Azure OpenAI API version")
+        fs.StringVar(&config.AWSRegion, "aws-region", "", "AWS region for Bedrock (default $AWS_REGION or the shared config)")
+        fs.StringVar(&config.APIBase, "api-base", os.Getenv("FFUFAI_API_BASE"), "Base URL of an OpenAI-compatible API or Ollama server (e.g. http://localhost:8000/v1)")
+        fs.StringVar(&config.APIKeyEnv, "api-key-env", "", "Environment variable holding the API key (default depends on provider)")
+        fs.Var((*stringList)(&config.APIKeyFiles), "api-key-file", "File with API keys, one per line (repeatable); keys rotate on 401/429")
+        fs.BoolVar(&config.FullHeaders, "full-headers", false, "Send every target header to the AI instead of the informative ones")
+        fs.IntVar(&config.HeaderValueMax, "header-value-max", DefaultHeaderValueMax, "Truncate header values longer than this many bytes in the prompt")
+        fs.IntVar(&config.HeaderBlockMax, "header-block-max", DefaultHeaderBlockMax, "Cap the headers sent to the AI at about this many bytes")
+        fs.IntVar(&config.MaxTotalTokens, "max-total-tokens", 0, "Stop calling the AI once the run has used this many tokens (0 for no cap)")
+        fs.Float64Var(&config.MaxCost, "max-cost", 0, "Stop calling the AI once the run's estimated cost reaches this many USD (0 for no cap)")
+        fs.StringVar(&config.StatsOut, "stats-out", "", "Write the run's result counts and AI token totals to this JSON file")
+        fs.BoolVar(&config.InsecureAPI, "insecure-api", false, "Allow a plain http:// --api-base")
+        fs.BoolVar(&config.Stream, "stream", false, "Stream the AI response and show progress while it arrives")
+        fs.BoolVar(&config.JSONOutput, "json", false, "Print machine-readable JSON (models and bench commands)")
+        fs.StringVar(&benchModels, "bench-models", "", "Comma-separated provider:model pairs the bench command compares (default: the --providers chain)")
+        fs.IntVar(&config.BenchRounds, "rounds", 1, "Requests per model in the bench command; latency and tokens are averaged (1-20)")
+        fs.StringVar(&config.WordlistDir, "wordlist-dir", "", "Let the AI pick a wordlist from this directory when no -w is given")
+        fs.IntVar(&config.ScriptFiles, "js-files", DefaultScriptFiles, "Read up to N same-host scripts the base page links to for endpoints and extensions (0 to disable)")
+        fs.StringVar(&probePathList, "probe-paths", defaultProbePaths, "Comma-separated paths next to the FUZZ directory whose headers are added to the prompt (\"\" to disable)")
+        fs.BoolVar(&config.FetchSitemap, "sitemap", true, "Add the extensions and paths in the target's sitemaps to the prompt (--sitemap=false to disable)")
+        fs.StringVar(&config.RelatedOut, "related-out", "", "Add the target's related hosts named by its CSP to this targets file")
+        fs.StringVar(&config.SeedPathsOut, "seed-paths-out", "", "Write the page paths from the sitemaps and the endpoints from the scripts to this file for use as a wordlist")
+        fs.BoolVar(&noDNS, "no-dns", false, "Skip the CNAME and reverse DNS lookups that hint at the hosting platform")
+        fs.BoolVar(&config.FetchRobots, "robots", true, "Add the paths and extensions in the target's robots.txt to the prompt (--robots=false to disable)")
+        fs.BoolVar(&config.Fingerprint, "fingerprint", true, "Guess the stack from headers, cookies and page hints without the AI and add the guess to the prompts (--fingerprint=false to disable)")
+        fs.BoolVar(&config.Insecure, "insecure", false, "Skip TLS certificate verification when probing the target")
+        fs.BoolVar(&config.Insecure, "k", false, "Skip TLS certificate verification when probing the target")
+        fs.BoolVar(&config.Favicon, "favicon", true, "Match the target's favicon hash against known products and add the match to the prompt (--favicon=false to disable)")
+        fs.BoolVar(&config.BodyHints, "body-hints", true, "Add the base page's title, generator and framework markers to the prompt (--body-hints=false to disable)")
+        fs.BoolVar(&config.WordlistContext, "wordlist-context", true, "Add the -w wordlist's name and sample entries to the prompt (--wordlist-context=false to disable)")
+        fs.IntVar(&config.GenWordlist, "gen-wordlist", 0, "Generate this many target-specific path words with the AI (up to 500, 0 for none)")
+        fs.StringVar(&config.GenValues, "gen-values", "", "Generate values for this second keyword (e.g. VAL) in the URL or -d body")
+        fs.IntVar(&config.Refine, "refine", 0, "Run up to N extra passes with extensions refined from the hits so far (0-5)")
+        fs.BoolVar(&config.BypassPass, "bypass-pass", false, "Fuzz AI-chosen path-mangling variants of 403 results in a second pass")
+        fs.BoolVar(&config.Mutate, "mutate", false, "Fuzz AI-suggested variants of the found names (admin2, admin_old) in a second pass")
+        fs.BoolVar(&config.Backups, "backups", false, "Run a second pass for backup and leftover files (config.php.bak, index.php~)")
+        fs.BoolVar(&config.AutoCalibrate, "auto-calibrate", true, "Request two random paths and add -fs, -fw or -ac when both return the same page (--auto-calibrate=false to disable)")
+        fs.BoolVar(&config.SuggestFilter, "suggest-filters", false, "Probe nonexistent paths and let the AI add ffuf filter flags")
+        fs.BoolVar(&config.FollowHostRedirects, "follow-host-redirects", false, "Analyze the headers of a redirect destination on another host instead of the redirect itself")
+        fs.BoolVar(&noWAFProbe, "no-waf-probe", false, "Skip the request with a malicious-looking query that checks for a WAF")
+        fs.BoolVar(&config.AutoRate, "auto-rate", false, "Add conservative -t and -rate flags when a WAF or CDN is detected and none were given")
+        fs.DurationVar(&config.ProbeTimeout, "probe-timeout", probeTimeout, "Time limit of each request to the target (default $FFUFAI_PROBE_TIMEOUT or 10s)")
+        fs.DurationVar(&config.AITimeout, "ai-timeout", aiTimeout, "Time limit of each AI request, or of each silence in a stream (default $FFUFAI_AI_TIMEOUT or 30s)")
+        fs.DurationVar(&config.InterruptGrace, "interrupt-grace", 10*time.Second, "Time ffuf gets to write its results and stop after Ctrl+C before it is killed (0 kills it at once)")
+        fs.IntVar(&config.ProbeRetries, "probe-retries", 2, "Retries of the header probe after a network error or a 502, 503 or 504 (0-10)")
+        fs.IntVar(&config.AIRetries, "ai-retries", 2, "Retries of an AI request after a 429 or 5xx, honoring Retry-After (0-10)")
+        fs.StringVar(&config.Proxy, "proxy", "", "Send the target probes through this http, https or socks5 proxy and pass it to ffuf as -x")
+        fs.StringVar(&config.CACert, "cacert", "", "PEM file of root CAs the target probes trust besides the system's")
+        fs.StringVar(&config.ClientCert, "client-cert", "", "PEM client certificate for targets that require mTLS, passed to ffuf as -cc")
+        fs.StringVar(&config.ClientKey, "client-key", "", "PEM key of --client-cert, passed to ffuf as -ck (prompts for the passphrase of an encrypted key)")
+        fs.StringVar(&config.Auth, "auth", "", "Basic auth credentials (user:pass) for the target probes, passed to ffuf as -H Authorization")
+        fs.StringVar(&authFile, "auth-file", "", "Read the --auth user:pass from this file, keeping it out of the process list")
+        fs.StringVar(&config.Cookie, "cookie", "", "Cookies (\"NAME1=VALUE1; NAME2=VALUE2\") for the target probes, passed to ffuf as -b")
+        fs.StringVar(&config.UserAgent, "user-agent", "", "User-Agent for the target probes, passed to ffuf as -H unless it sets one")
+        fs.BoolVar(&randomAgent, "random-agent", false, "Use a common browser User-Agent picked for this run instead of ffufai's")
+        fs.Var(&resolveFlags, "resolve", "Connect the probes to IP for HOST:PORT (HOST:PORT:IP, repeatable); ffuf gets the IP and a Host header")
+        fs.StringVar(&config.SNI, "sni", "", "TLS server name of the target probes, verified against unless -k, passed to ffuf as -sni")
+        fs.StringVar(&dnsServer, "dns-server", "", "DNS server (IP or IP:PORT) resolving the probes' hostnames instead of the system's")
+        fs.StringVar(&headersFile, "headers-file", "", "File of \"Name: value\" headers to send with the target probes and pass to ffuf as -H")
+        fs.StringVar(&config.RequestFile, "request", "", "Raw HTTP request file to fuzz instead of -u, passed to ffuf as -request (- reads stdin)")
+        fs.StringVar(&config.RequestProto, "request-proto", "https", "Scheme of a --request whose request line has no absolute URL: http or https")
+        fs.BoolVar(&config.AutoHTTP2, "auto-http2", false, "Add ffuf's -http2 when the target negotiates HTTP/2 and ffuf supports it")
+        fs.BoolVar(&config.Force, "force", false, "Run even when the pre-flight check cannot reach the target")
+        fs.BoolVar(&noAudit, "no-audit", false, "Skip the security header audit printed before fuzzing")
+        fs.BoolVar(&noOptionsProbe, "no-options-probe", false, "Skip the OPTIONS request that reads the methods the target allows")
+        fs.BoolVar(&config.SuggestMethod, "suggest-method", true, "Use the AI-suggested HTTP method when no -X is given (--suggest-method=false to disable)")
+        fs.BoolVar(&noAutoMatchers, "no-auto-matchers", false, "Never add the AI-suggested -mc match codes")
+        fs.BoolVar(&noFlagChecks, "no-flag-checks", false, "Skip the check for ffuf flags that contradict each other or the URL")
+        fs.BoolVar(&config.SuggestVhosts, "suggest-vhosts", false, "Suggest internal virtual hosts from TLS SANs, CSP and redirects")
+        fs.StringVar(&config.VhostsOut, "vhosts-out", "", "File collecting --suggest-vhosts candidates (default ffufai-vhosts-<host>.txt)")
+        fs.BoolVar(&config.SuggestBypass, "suggest-bypass", false, "On a 401/403 target, let the AI add header-based bypass candidates as -H")
+        fs.BoolVar(&config.Triage, "triage", false, "Ask the AI to pick the most interesting ffuf results after the run")
+        fs.IntVar(&config.TriageTop, "triage-top", 10, "Number of findings --triage reports")
+        fs.StringVar(&config.TriageOut, "triage-out", "", "Also write the triaged findings to this Markdown file")
+        fs.BoolVar(&config.LiveTriage, "live-triage", false, "Stream ffuf's results to the AI while it runs and flag interesting hits immediately")
+        fs.IntVar(&config.LiveTriageBatch, "live-triage-batch", 20, "Results per --live-triage batch")
+        fs.DurationVar(&config.LiveTriageInterval, "live-triage-interval", 10*time.Second, "Longest wait before --live-triage sends a partial batch")
+        fs.BoolVar(&config.RawFfufOutput, "raw-ffuf-output", false, "Pass ffuf's own output through untouched instead of reading its results live")
+        fs.BoolVar(&config.NextSteps, "next-steps", false, "Ask the AI for up to five follow-up ffufai commands after the run")
+        fs.StringVar(&config.NextStepsOut, "next-steps-out", "", "Also write the suggested next steps to this shell script")
+        fs.BoolVar(&config.Severity, "severity", false, "Classify results as info/low/medium/high after the run")
+        fs.BoolVar(&config.SeverityAI, "severity-ai", false, "Also ask the AI about results no local severity rule matches (implies --severity)")
+        fs.StringVar(&config.ConfigFile, "config", "", "Config file with extra severity rules and favicon hashes (default "+defaultConfigFile()+")")
+        fs.BoolVar(&config.RankRecursion, "rank-recursion", false, "Ask the AI which found directories to fuzz next after the run")
+        fs.IntVar(&config.MaxRecursion, "max-recursion-candidates", 10, "Number of directories --rank-recursion keeps")
+        fs.IntVar(&config.SmartRecursion, "smart-recursion", 0, "Fuzz found directories down to this many levels, each with its own probe and AI extensions (0-5)")
+        fs.IntVar(&config.MaxTotalRequests, "max-total-requests", 0, "Stop --smart-recursion before the estimated requests of all passes exceed this (0 for no cap)")
+        fs.StringVar(&config.RecursionOut, "recursion-out", "ffufai-recursion-targets.txt", "Targets file written by --rank-recursion and the recurse command")
+        fs.StringVar(&config.RecordDir, "record", "", "Write each AI exchange to a timestamped JSON file in this directory")
+        fs.StringVar(&config.ReplayFile, "replay", "", "Replay a recorded exchange instead of calling the AI provider")
+        fs.BoolVar(&config.ReplayForce, "replay-force", false, "Replay even if the recorded prompt differs from the current one")
+        fs.StringVar(&config.PromptFile, "prompt-file", "", "Go template replacing the built-in extension prompt ({{.URL}}, {{.Headers}}, {{.Stack}}, {{.MaxExtensions}}, {{.Methods}})")
+        fs.StringVar(&aiContext, "ai-context", "", "Free-form hint about the target added to the prompt (@file reads it from a file, max 1 KB)")
+        fs.StringVar(&systemText, "system-prompt", "", "System message replacing or appended to the built-in one")
+        fs.StringVar(&systemFile, "system-prompt-file", "", "Read the --system-prompt text from this file")
+        fs.StringVar(&systemMode, "system-prompt-mode", SystemPromptReplace, "How --system-prompt combines with the built-in message: replace or append")
+        fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose output")
+        fs.BoolVar(&config.DryRun, "dry-run", false, "Show what would be executed without running ffuf")
+        fs.BoolVar(&listBuiltin, "list-builtin-wordlists", false, "List the wordlists built into ffufai, used when no -w is given")
+        fs.BoolVar(&config.PrintArgv, "print-argv", false, "Print the ffuf command as a JSON array of arguments on stdout instead of running it (implies --dry-run)")
+        fs.BoolVar(&config.KeepArtifacts, "keep-artifacts", false, "Keep the temporary JSON results files ffufai has ffuf write, and print their paths")
+        fs.IntVar(&config.ExtBatch, "ext-batch", 0, "Split the extensions into ffuf runs of this many each and merge their results (0 for one run)")
+        fs.IntVar(&config.ExtParallel, "ext-parallel", 1, "Number of --ext-batch runs at once (1-10)")
+        fs.BoolVar(&config.Teach, "teach", false, "Explain the final ffuf command flag by flag before it runs")
+        fs.StringVar(&urlFlag, "u", "", "Target URL with FUZZ keyword (required)")
+        fs.StringVar(&keywordFlag, "keyword", "", "Keyword marking the fuzzed position in the URL (default FUZZ, or the -w file:KEYWORD keyword found in the URL path)")
+        fs.BoolVar(&showVersion, "version", false, "Show version information")
+        fs.BoolVar(&showHelp, "help", false, "Show usage information")
+        fs.BoolVar(&showHelp, "h", false, "Show usage information")
+
+        // Custom usage function with banner
+        fs.Usage = func() {
+                displayBanner()
+                fmt.Fprintf(os.Stderr, "Usage: %s [options] -u URL [ffuf options]\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "       %s models [--provider NAME] [--json]\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "       %s recurse [--max-recursion-candidates N] RESULTS.json\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "       %s chat [options] -u URL [ffuf options]\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "       %s bench [--bench-models LIST] [--rounds N] [--json] (-u URL | SNAPSHOT.json...)\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "       %s doctor [options] [-u URL [ffuf options]]\n\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "Options:\n")
+                fs.PrintDefaults()
+                fmt.Fprintf(os.Stderr, "\nExamples:\n")
+                fmt.Fprintf(os.Stderr, "  %s -u https://example.com/FUZZ -w /path/to/wordlist.txt\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s --verbose --max-extensions 6 -u https://example.com/admin/FUZZ -w wordlist.txt -fc 404\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s --dry-run -u https://example.com/api/FUZZ -w wordlist.txt -fc 301\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s models --provider openai\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s recurse --recursion-out next.txt results.json\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s chat -u https://example.com/FUZZ -w wordlist.txt\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s bench --bench-models perplexity:sonar,perplexity:sonar-pro --rounds 3 -u https://example.com/FUZZ\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "  %s doctor -u https://example.com/FUZZ\n", os.Args[0])
+                fmt.Fprintf(os.Stderr, "\nCommon ffuf Options:\n")
+                fmt.Fprintf(os.Stderr, "  -w FILE         Wordlist file path\n")
+                fmt.Fprintf(os.Stderr, "  -fc CODE        Filter HTTP status codes (e.g., -fc 404,301)\n")
+                fmt.Fprintf(os.Stderr, "  -mc CODE        Match HTTP status codes only (e.g., -mc 200,403)\n")
+                fmt.Fprintf(os.Stderr, "  -fs SIZE        Filter response size (e.g., -fs 134)\n")
+                fmt.Fprintf(os.Stderr, "  -t NUM          Number of concurrent threads (default: 40)\n")
+                fmt.Fprintf(os.Stderr, "  -X METHOD       HTTP method (GET, POST, etc.)\n")
+                fmt.Fprintf(os.Stderr, "  -o FILE         Output file (json, csv, html)\n")
+                fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
+                fmt.Fprintf(os.Stderr, "  PERPLEXITY_API_KEY    Perplexity AI API key (required)\n")
+                fmt.Fprintf(os.Stderr, "                        Get yours at: https://www.perplexity.ai/settings/api\n")
+                fmt.Fprintf(os.Stderr, "  PERPLEXITY_API_KEYS   Comma-separated keys rotated on 401/429 (any <NAME>_API_KEYS works)\n")
+                fmt.Fprintf(os.Stderr, "  OPENAI_API_KEY        OpenAI API key (required with --provider openai)\n")
+                fmt.Fprintf(os.Stderr, "  ANTHROPIC_API_KEY     Anthropic API key (required with --provider anthropic)\n")
+                fmt.Fprintf(os.Stderr, "  GEMINI_API_KEY        Google Gemini API key (required with --provider gemini)\n")
+                fmt.Fprintf(os.Stderr, "  AZURE_OPENAI_API_KEY  Azure OpenAI API key (required with --provider azure)\n")
+                fmt.Fprintf(os.Stderr, "  AZURE_OPENAI_ENDPOINT, AZURE_OPENAI_DEPLOYMENT, AZURE_OPENAI_API_VERSION\n")
+                fmt.Fprintf(os.Stderr, "                        Defaults for the --azure-* options\n")
+                fmt.Fprintf(os.Stderr, "  OPENROUTER_API_KEY    OpenRouter API key (required with --provider openrouter)\n")
+                fmt.Fprintf(os.Stderr, "  GROQ_API_KEY          Groq API key (required with --provider groq)\n")
+                fmt.Fprintf(os.Stderr, "  MISTRAL_API_KEY       Mistral API key (required with --provider mistral)\n")
+                fmt.Fprintf(os.Stderr, "  AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_PROFILE, AWS_REGION\n")
+                fmt.Fprintf(os.Stderr, "                        AWS credentials for --provider bedrock (or ~/.aws/credentials)\n")
+                fmt.Fprintf(os.Stderr, "  OLLAMA_HOST           Ollama server address (used with --provider ollama, no key needed)\n")
+                fmt.Fprintf(os.Stderr, "  FFUFAI_API_BASE       Default for --api-base\n")
+                fmt.Fprintf(os.Stderr, "  FFUFAI_CLIENT_KEY_PASSPHRASE\n")
+                fmt.Fprintf(os.Stderr, "                        Passphrase of an encrypted --client-key\n\n")
+                fmt.Fprintf(os.Stderr, "Note: All ffuf options can be passed after the -u URL argument.\n")
+        }
+
+        // Parse only our known flags, ignore unknown ones for help/version
         var knownArgs []string
         var ffufArgs []string
 
-        // Check for help or version first (before requiring -u)
-        for _, arg := range os.Args[1:] {
-                if arg == "-h" || arg == "--help" || arg == "--version" {
-                        knownArgs = append(knownArgs, arg)
-                }
+        // A leading subcommand replaces the fuzzing run
+        args := os.Args[1:]
+        if len(args) > 0 && (args[0] == CommandModels || args[0] == CommandRecurse || args[0] == CommandChat || args[0] == CommandBench || args[0] == CommandDoctor) {
+                config.Command = args[0]
+                args = args[1:]
+        }
+
+        // Check for help or version first (before requiring -u)
+        for _, arg := range args {
+                if arg == "-h" || arg == "--help" || arg == "--version" {
+                        knownArgs = append(knownArgs, arg)
+                }
+        }
+
+        // If help or version requested, parse and handle immediately
+        if len(knownArgs) > 0 {
+                if err := fs.Parse(knownArgs); err != nil {
+                        return nil, err
+                }
+
+                if showHelp {
+                        fs.Usage()
+                        os.Exit(0)
+                }
+
+                if showVersion {
+                        displayBanner()
+                        fmt.Fprintf(textOutput, "ffufai version %s\n", Version)
+                        os.Exit(0)
+                }
+        }
+
+        // Normal argument parsing for actual execution
+        for i := 0; i < len(args); i++ {
+                arg := args[i]
+
+                // Check if this is one of our flags
+                if known, takesValue := ownFlag(fs, arg); known {
+                        knownArgs = append(knownArgs, arg)
+                        // If flag takes a value, include the next argument too
+                        if takesValue {
+                                if i+1 < len(args) {
+                                        i++
+                                        knownArgs = append(knownArgs, args[i])
+                                }
+                        }
+                } else {
+                        // This is an ffuf argument
+                        ffufArgs = append(ffufArgs, arg)
+                }
+        }
+
+        // Parse our known arguments
+        if err := fs.Parse(knownArgs); err != nil {
+                return nil, err
+        }
+
+        // Handle help and version (shouldn't reach here due to early check, but safety)
+        if showHelp {
+                fs.Usage()
+                os.Exit(0)
+        }
+
+        if showVersion {
+                displayBanner()
+                fmt.Fprintf(textOutput, "ffufai version %s\n", Version)
+                os.Exit(0)
+        }
+
+        // Display the banner before anything else; the models and bench
+        // commands print their own so --json stays clean, and --print-argv
+        // keeps stdout for the argv
+        if config.Command != CommandModels && config.Command != CommandBench && !config.PrintArgv {
+                displayBanner()
+        }
+
+        if listBuiltin {
+                if err := printBuiltinWordlists(); err != nil {
+                        return nil, err
+                }
+                os.Exit(0)
+        }
+
+        // Validate max extensions
+        if config.MaxExtensions < 1 || config.MaxExtensions > 10 {
+                return nil, fmt.Errorf("max-extensions must be between 1 and 10")
+        }
+
+        if config.ProbeRetries < 0 || config.ProbeRetries > maxProbeRetries {
+                return nil, fmt.Errorf("probe-retries must be between 0 and %d", maxProbeRetries)
+        }
+
+        if config.AIRetries < 0 || config.AIRetries > maxAIRetries {
+                return nil, fmt.Errorf("ai-retries must be between 0 and %d", maxAIRetries)
+        }
+
+        // A zero timeout would fail every request; a huge one is likely a typo
+        timeouts := []struct {
+                flag  string
+                value time.Duration
+        }{{"--probe-timeout", config.ProbeTimeout}, {"--ai-timeout", config.AITimeout}}
+        for _, timeout := range timeouts {
+                if timeout.value <= 0 {
+                        return nil, fmt.Errorf("%s must be positive, got %s", timeout.flag, timeout.value)
+                }
+                if timeout.value > maxSensibleTimeout {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %s of %s is unusually long%s\n", ColorYellow, timeout.flag, timeout.value, ColorReset)
+                }
+        }
+        if config.InterruptGrace < 0 {
+                return nil, fmt.Errorf("--interrupt-grace must not be negative, got %s", config.InterruptGrace)
+        }
+
+        config.AutoMatchers = !noAutoMatchers
+        config.OptionsProbe = !noOptionsProbe
+        config.Audit = !noAudit
+        config.WAFProbe = !noWAFProbe
+        config.DNS = !noDNS
+        config.Stack = parseStack(stackList)
+        config.ProbePaths = parseProbePaths(probePathList)
+        if config.ScriptFiles < 0 || config.ScriptFiles > maxScriptFiles {
+                return nil, fmt.Errorf("js-files must be between 0 and %d", maxScriptFiles)
+        }
+        if config.MinConfidence < 0 || config.MinConfidence > 1 {
+                return nil, fmt.Errorf("min-confidence must be between 0 and 1")
+        }
+        if config.HeaderValueMax < 1 || config.HeaderBlockMax < 1 {
+                return nil, fmt.Errorf("--header-value-max and --header-block-max must be positive")
+        }
+        if config.MaxTotalTokens < 0 || config.MaxCost < 0 {
+                return nil, fmt.Errorf("--max-total-tokens and --max-cost cannot be negative")
+        }
+
+        // Build the provider chain; --providers overrides --provider and
+        // --ensemble queries its providers side by side instead of in turn
+        if ensemble != "" {
+                if providerChain != "" {
+                        return nil, fmt.Errorf("--ensemble and --providers cannot be combined")
+                }
+                config.Ensemble = true
+                providerChain = ensemble
+        }
+        if config.EnsembleMode != EnsembleUnion && config.EnsembleMode != EnsembleIntersect {
+                return nil, fmt.Errorf("--ensemble-mode must be %s or %s", EnsembleUnion, EnsembleIntersect)
+        }
+        if providerChain != "" {
+                for _, provider := range strings.Split(providerChain, ",") {
+                        if provider = strings.TrimSpace(provider); provider != "" {
+                                config.Providers = append(config.Providers, strings.ToLower(provider))
+                        }
+                }
+        } else {
+                config.Providers = []string{strings.ToLower(config.Provider)}
+        }
+        if len(config.Providers) == 0 {
+                return nil, fmt.Errorf("--providers must name at least one provider")
+        }
+        if config.Ensemble && len(config.Providers) < 2 {
+                return nil, fmt.Errorf("--ensemble needs at least two providers")
+        }
+        if config.GenWordlist < 0 || config.GenWordlist > 500 {
+                return nil, fmt.Errorf("gen-wordlist must be between 0 and 500")
+        }
+        if keywordFlag != "" && !wordlistKeywordRegex.MatchString(keywordFlag) {
+                return nil, fmt.Errorf("--keyword must be an uppercase keyword such as EXT, got %q", keywordFlag)
+        }
+        if config.GenValues != "" && (!wordlistKeywordRegex.MatchString(config.GenValues) || config.GenValues == "FUZZ") {
+                return nil, fmt.Errorf("--gen-values must be an uppercase keyword other than FUZZ, such as VAL")
+        }
+        if config.TriageTop < 1 || config.TriageTop > 50 {
+                return nil, fmt.Errorf("triage-top must be between 1 and 50")
+        }
+        if config.TriageOut != "" {
+                config.Triage = true
+        }
+        if config.NextStepsOut != "" {
+                config.NextSteps = true
+        }
+        if config.LiveTriageBatch < 1 || config.LiveTriageBatch > 100 {
+                return nil, fmt.Errorf("live-triage-batch must be between 1 and 100")
+        }
+        if config.LiveTriageInterval < time.Second {
+                return nil, fmt.Errorf("live-triage-interval must be at least 1s")
+        }
+        if config.PrintArgv {
+                config.DryRun = true
+        }
+        if config.SeverityAI {
+                config.Severity = true
+        }
+        if config.Severity || config.Favicon || config.Fingerprint {
+                path, explicit := config.ConfigFile, config.ConfigFile != ""
+                if !explicit {
+                        path = defaultConfigFile()
+                }
+                fileConfig, err := loadFileConfig(path, explicit)
+                if err != nil {
+                        return nil, err
+                }
+                if config.SeverityRules, err = severityRules(fileConfig); err != nil {
+                        return nil, fmt.Errorf("config file %s: %w", path, err)
+                }
+                if config.FaviconHashes, err = faviconHashes(fileConfig); err != nil {
+                        return nil, fmt.Errorf("config file %s: %w", path, err)
+                }
+                if config.FingerprintRules, err = fingerprintRules(fileConfig); err != nil {
+                        return nil, fmt.Errorf("config file %s: %w", path, err)
+                }
+        }
+        ffufrc, explicit := ffufFlagValue(ffufArgs, "-config"), true
+        if ffufrc == "" {
+                ffufrc, explicit = defaultFfufrc(), false
+        }
+        if config.FfufrcArgs, err = loadFfufrc(ffufrc, explicit); err != nil {
+                return nil, err
+        }
+        if len(config.FfufrcArgs) > 0 {
+                config.Ffufrc = ffufrc
+        }
+        if config.SmartRecursion < 0 || config.SmartRecursion > 5 {
+                return nil, fmt.Errorf("smart-recursion must be between 0 and 5")
+        }
+        if config.MaxTotalRequests < 0 {
+                return nil, fmt.Errorf("max-total-requests must not be negative")
+        }
+        if config.SmartRecursion > 0 && (hasFfufFlag(ffufArgs, "-recursion") || hasFfufFlag(config.FfufrcArgs, "-recursion")) {
+                return nil, fmt.Errorf("--smart-recursion replaces ffuf's -recursion; pass only one of them")
+        }
+        if config.ExtBatch < 0 {
+                return nil, fmt.Errorf("ext-batch must not be negative")
+        }
+        if config.ExtParallel < 1 || config.ExtParallel > 10 {
+                return nil, fmt.Errorf("ext-parallel must be between 1 and 10")
+        }
+        if format := ffufFlagValue(append(append([]string{}, config.FfufrcArgs...), ffufArgs...), "-of"); config.ExtBatch > 0 && format != "" && format != "json" && format != "ejson" {
+                return nil, fmt.Errorf("--ext-batch writes the merged results as JSON, so -of must be json, not %s", format)
+        }
+        if config.Refine < 0 || config.Refine > 5 {
+                return nil, fmt.Errorf("refine must be between 0 and 5")
+        }
+        if config.MaxRecursion < 1 || config.MaxRecursion > 50 {
+                return nil, fmt.Errorf("max-recursion-candidates must be between 1 and 50")
+        }
+        systemPromptText, err := resolveSystemPrompt(systemText, systemFile, systemMode)
+        if err != nil {
+                return nil, err
+        }
+        config.SystemPrompt = systemPromptText
+        if config.AIContext, err = loadAIContext(aiContext); err != nil {
+                return nil, err
+        }
+        if config.PromptFile != "" {
+                tmpl, err := loadPromptTemplate(config.PromptFile)
+                if err != nil {
+                        return nil, err
+                }
+                config.PromptTemplate = tmpl
+        }
+        if config.WordlistDir != "" {
+                if info, err := os.Stat(config.WordlistDir); err != nil || !info.IsDir() {
+                        return nil, fmt.Errorf("--wordlist-dir %s is not a directory", config.WordlistDir)
+                }
+        }
+        if config.ReplayFile != "" && (config.Ensemble || config.Hedge) {
+                return nil, fmt.Errorf("--replay cannot be combined with --ensemble or --hedge")
+        }
+        for _, provider := range config.Providers {
+                if !isSupportedProvider(provider) {
+                        return nil, fmt.Errorf("unknown provider %q (supported: %s)", provider, strings.Join(supportedProviders, ", "))
+                }
+        }
+
+        // The primary provider receives --model, --api-base and --api-key-env
+        config.Provider = config.Providers[0]
+        if config.Model == "" {
+                config.Model = defaultModel(config.Provider)
+        }
+
+        // Validate --api-base for the providers that support it
+        if config.APIBase != "" {
+                if config.Provider == ProviderAnthropic || config.Provider == ProviderGemini || config.Provider == ProviderAzure ||
+                        config.Provider == ProviderBedrock {
+                        return nil, fmt.Errorf("--api-base is not supported with --provider %s", config.Provider)
+                }
+                if config.Provider != ProviderOllama {
+                        baseURL, err := url.Parse(config.APIBase)
+                        if err != nil || baseURL.Host == "" || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+                                return nil, fmt.Errorf("invalid --api-base %q: must be an http(s) URL", config.APIBase)
+                        }
+                        if baseURL.Scheme == "http" && !config.InsecureAPI {
+                                return nil, fmt.Errorf("--api-base %s uses plain http; pass --insecure-api to allow it", config.APIBase)
+                        }
+                }
+        }
+
+        // Azure needs a resource and deployment; never fall back to another endpoint
+        if containsString(config.Providers, ProviderAzure) {
+                if config.AzureEndpoint == "" {
+                        return nil, fmt.Errorf("--azure-endpoint (or AZURE_OPENAI_ENDPOINT) is required with --provider azure")
+                }
+                if config.AzureDeployment == "" {
+                        return nil, fmt.Errorf("--azure-deployment (or AZURE_OPENAI_DEPLOYMENT) is required with --provider azure")
+                }
+        }
+
+        // A bad proxy would send the probes straight to the target
+        if config.Proxy != "" {
+                if _, err := parseProxyURL(config.Proxy); err != nil {
+                        return nil, fmt.Errorf("--proxy: %w", err)
+                }
+        }
+        if proxy := ffufFlagValue(ffufArgs, "-x"); proxy != "" {
+                if _, err := parseProxyURL(proxy); err != nil {
+                        return nil, fmt.Errorf("-x: %w", err)
+                }
+        }
+
+        for _, value := range resolveFlags {
+                override, err := parseResolve(value)
+                if err != nil {
+                        return nil, err
+                }
+                config.Resolve = append(config.Resolve, override)
+        }
+        if dnsServer != "" {
+                if config.DNSServer, err = parseDNSServer(dnsServer); err != nil {
+                        return nil, err
+                }
+                targetResolver = dnsResolver(config.DNSServer)
+        }
+
+        if config.CACert != "" {
+                if config.CACerts, config.CACertCount, err = loadCACerts(config.CACert); err != nil {
+                        return nil, err
+                }
+        }
+
+        // A broken certificate pair fails here, before any request is sent.
+        // Without --client-cert the probes use ffuf's own -cc and -ck.
+        if config.ClientCert == "" && config.ClientKey == "" {
+                config.ClientCert, config.ClientKey = ffufFlagValue(ffufArgs, "-cc"), ffufFlagValue(ffufArgs, "-ck")
+        }
+        if config.ClientCert != "" || config.ClientKey != "" {
+                cert, keyPEM, err := loadClientCertificate(config.ClientCert, config.ClientKey)
+                if err != nil {
+                        return nil, err
+                }
+                config.ClientCertificate, config.ClientKeyPEM = cert, keyPEM
+        }
+
+        wordlists := ffufWordlists(append(append([]string{}, config.FfufrcArgs...), ffufArgs...))
+        config.Keyword = urlKeyword(keywordFlag, urlFlag, wordlists)
+
+        // Subcommands don't fuzz, so they need no URL
+        if config.Command == CommandRecurse {
+                if len(ffufArgs) != 1 {
+                        return nil, fmt.Errorf("recurse needs exactly one ffuf JSON results file")
+                }
+                config.ResultsFile = ffufArgs[0]
+        }
+        if config.Command == CommandBench {
+                if err := parseBenchArgs(config, benchModels, urlFlag, ffufArgs); err != nil {
+                        return nil, err
+                }
+        }
+        if config.Command == CommandDoctor && urlFlag != "" {
+                config.URL = urlFlag
+                config.FfufArgs = append([]string{"-u", urlFlag}, ffufArgs...)
+        }
+        if config.Command == CommandModels || config.Command == CommandRecurse || config.Command == CommandBench || config.Command == CommandDoctor {
+                return config, nil
+        }
+        if config.Command == CommandChat && config.ReplayFile != "" {
+                return nil, fmt.Errorf("chat cannot replay a recorded exchange")
+        }
+        if config.Command == CommandChat && config.Interactive {
+                return nil, fmt.Errorf("--interactive cannot be combined with chat, which already lets you edit the list")
+        }
+
+        // A mistyped wordlist fails here, not after the probes and AI calls
+        if config.WordlistLines, err = checkWordlists(wordlists); err != nil {
+                return nil, err
+        }
+
+        // A raw request, from --request or ffuf's own -request, names the
+        // target instead of -u
+        if config.RequestFile == "" {
+                config.RequestFile = ffufFlagValue(ffufArgs, "-request")
+        }
+        if hasFfufFlag(ffufArgs, "-request-proto") {
+                config.RequestProto = ffufFlagValue(ffufArgs, "-request-proto")
+        }
+        ffufArgs = withoutFfufFlags(ffufArgs, "-request", "-request-proto")
+        if config.RequestFile != "" {
+                if urlFlag, err = loadRequest(config, urlFlag, keywordFlag, wordlists); err != nil {
+                        return nil, err
+                }
+        }
+
+        // Check if URL was provided
+        if urlFlag == "" {
+                return nil, fmt.Errorf("-u URL argument is required")
+        }
+
+        config.URL = urlFlag
+        if config.Keyword != "FUZZ" {
+                if _, bound := wordlists[config.Keyword]; !bound {
+                        return nil, fmt.Errorf("no wordlist is bound to the keyword %s; pass it with -w FILE:%s", config.Keyword, config.Keyword)
+                }
+                // These add or swap the FUZZ wordlist and URL
+                if config.GenWordlist > 0 || config.WordlistDir != "" || config.BypassPass || config.SmartRecursion > 0 {
+                        return nil, fmt.Errorf("--gen-wordlist, --wordlist-dir, --bypass-pass and --smart-recursion fuzz FUZZ and cannot be used with the keyword %s", config.Keyword)
+                }
+        }
+
+        // A missing ffuf fails now, not after the probes and the AI calls; a
+        // dry run never starts it
+        if _, config.FfufVersion, err = checkFfuf(config.FfufPath); err != nil {
+                if !config.DryRun {
+                        return nil, err
+                }
+                fmt.Fprintf(os.Stderr, "%sWarning: %v%s\n", ColorYellow, err, ColorReset)
+        }
+        if config.LiveTriage && !ffufSupports(config, "-json") {
+                return nil, fmt.Errorf("%s has no -json option, which --live-triage reads; update ffuf to 2.0 or later", config.FfufPath)
+        }
+        if config.LiveTriage && config.RawFfufOutput {
+                return nil, fmt.Errorf("--live-triage reads ffuf's -json lines, so it cannot be combined with --raw-ffuf-output")
+        }
+        // A -json of the user's own asks for ffuf's JSON lines as they are
+        given := append(append([]string{}, config.FfufrcArgs...), ffufArgs...)
+        config.StreamResults = config.LiveTriage || (!config.RawFfufOutput && !hasFfufFlag(given, "-json") && ffufSupports(config, "-json"))
+
+        // Build ffuf arguments: add back the -u URL, or the request, and the
+        // remaining ffuf args
+        target := []string{"-u", urlFlag}
+        if config.Request != nil {
+                path := config.RequestFile
+                if config.RequestCopy != "" {
+                        path = config.RequestCopy
+                }
+                target = []string{"-request", path, "-request-proto", config.RequestProto}
+        }
+        config.FfufArgs = append([]string{}, target...)
+        config.FfufArgs = append(config.FfufArgs, ffufArgs...)
+        if config.Proxy != "" && !hasFfufFlag(ffufArgs, "-x") {
+                config.FfufArgs = append(config.FfufArgs, "-x", config.Proxy)
+        }
+        if config.ClientCertificate != nil && !hasFfufFlag(ffufArgs, "-cc") {
+                if !ffufSupports(config, "-cc") {
+                        return nil, fmt.Errorf("%s has no -cc/-ck client certificate options, so ffuf could not use --client-cert; update ffuf to 2.1 or later", config.FfufPath)
+                }
+                config.FfufArgs = append(config.FfufArgs, "-cc", config.ClientCert, "-ck", config.ClientKey)
+        }
+        var headerLines []string
+        if headersFile != "" {
+                lines, err := readHeadersFile(headersFile)
+                if err != nil {
+                        return nil, fmt.Errorf("--headers-file %s: %w", headersFile, err)
+                }
+                headerLines = lines
+        }
+        if authFile != "" {
+                if config.Auth != "" {
+                        return nil, fmt.Errorf("--auth and --auth-file cannot be combined")
+                }
+                data, err := os.ReadFile(authFile)
+                if err != nil {
+                        return nil, fmt.Errorf("reading --auth-file: %w", err)
+                }
+                config.Auth, _, _ = strings.Cut(string(data), "\n")
+                config.Auth = strings.TrimSuffix(config.Auth, "\r")
+        }
+        if config.Auth != "" {
+                line, err := basicAuthHeader(config.Auth)
+                if err != nil {
+                        return nil, err
+                }
+                for _, value := range ffufFlagValues(ffufArgs, "-H") {
+                        if name, _, _ := strings.Cut(value, ":"); strings.EqualFold(strings.TrimSpace(name), "Authorization") {
+                                return nil, fmt.Errorf("--auth conflicts with the Authorization header given with -H")
+                        }
+                }
+                headerLines = append(headerLines, line)
+        }
+        if config.Cookie != "" {
+                config.FfufArgs = append(config.FfufArgs, "-b", config.Cookie)
+        }
+        if randomAgent {
+                if config.UserAgent != "" {
+                        return nil, fmt.Errorf("--user-agent and --random-agent cannot be combined")
+                }
+                config.UserAgent = browserUserAgents[mathrand.Intn(len(browserUserAgents))]
+        }
+        if config.UserAgent != "" {
+                headerLines = append(headerLines, "User-Agent: "+config.UserAgent)
+        }
+        if config.SNI != "" {
+                if !hostnameRegex.MatchString(config.SNI) {
+                        return nil, fmt.Errorf("--sni %q is not a hostname", config.SNI)
+                }
+                if !hasFfufFlag(ffufArgs, "-sni") {
+                        if !ffufSupports(config, "-sni") {
+                                return nil, fmt.Errorf("%s has no -sni option, so ffuf could not use --sni; update ffuf to 2.1 or later", config.FfufPath)
+                        }
+                        config.FfufArgs = append(config.FfufArgs, "-sni", config.SNI)
+                }
+                // A server picking the site by SNI usually picks it by Host too,
+                // and an IP in the URL says nothing about either
+                if target, err := url.Parse(urlFlag); err == nil && net.ParseIP(target.Hostname()) != nil {
+                        headerLines = append(headerLines, "Host: "+config.SNI)
+                }
+        }
+        if err := setProbeHeaders(config, headerLines); err != nil {
+                return nil, err
+        }
+        methodArgs := config.FfufArgs[len(target):]
+        if config.Request != nil {
+                addRequestProbeHeaders(config)
+                methodArgs = append(rawRequestArgs(config.Request), methodArgs...)
+        }
+        config.RequestMethod, config.BodyType = requestMethod(methodArgs)
+
+        // Contradicting ffuf flags are cheaper to catch before the AI call
+        // than in an empty ffuf run
+        if !noFlagChecks {
+                checkArgs := effectiveFfufArgs(config)
+                if config.Request != nil {
+                        checkArgs = append(rawRequestArgs(config.Request), checkArgs...)
+                }
+                var fatal []string
+                for _, problem := range checkFfufFlags(config, checkArgs) {
+                        if problem.fatal {
+                                fatal = append(fatal, problem.message)
+                        } else {
+                                fmt.Fprintf(os.Stderr, "%sWarning: %s%s\n", ColorYellow, problem.message, ColorReset)
+                        }
+                }
+                if len(fatal) > 0 {
+                        return nil, fmt.Errorf("%s (pass --no-flag-checks to run anyway)", strings.Join(fatal, "; "))
+                }
+        }
+
+        // ffuf honors only one -e, so the user's are merged into the AI's;
+        // the ffufrc's count only without any, as in ffuf
+        config.UserExtensions, config.FfufArgs = splitExtensions(config.FfufArgs)
+        if len(config.UserExtensions) == 0 {
+                config.UserExtensions, _ = splitExtensions(config.FfufrcArgs)
+        }
+        config.AskMethod = config.SuggestMethod && !hasFfufFlag(effectiveFfufArgs(config), "-X")
+        config.AskMatchCodes = config.AutoMatchers && !hasUserFilters(config)
+
+        return config, nil
+}
+
+// Copy of ffuf arguments without the given single-dash flags and their values
+func withoutFfufFlags(args []string, names ...string) []string {
+        var out []string
+        for i := 0; i < len(args); i++ {
+                switch {
+                case containsString(names, args[i]) && i+1 < len(args):
+                        i++
+                case strings.Contains(args[i], "=") && containsString(names, args[i][:strings.Index(args[i], "=")]):
+                default:
+                        out = append(out, args[i])
+                }
+        }
+        return out
+}
+
+// A bare word such as "php", which ffuf would append without a dot
+var bareExtensionRegex = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+
+// Take every -e out of ffuf arguments, returning the extensions they list,
+// without repeats, and the remaining arguments. A bare word gets the dot the
+// AI's extensions have, so -e php and a suggested .php are the same; other
+// suffixes such as ~ are kept as given.
+func splitExtensions(args []string) ([]string, []string) {
+        var extensions, rest []string
+        for i := 0; i < len(args); i++ {
+                var value string
+                switch {
+                case args[i] == "-e" && i+1 < len(args):
+                        i++
+                        value = args[i]
+                case strings.HasPrefix(args[i], "-e="):
+                        value = strings.TrimPrefix(args[i], "-e=")
+                default:
+                        rest = append(rest, args[i])
+                        continue
+                }
+                for _, ext := range strings.Split(value, ",") {
+                        if ext = strings.TrimSpace(ext); bareExtensionRegex.MatchString(ext) {
+                                ext = "." + ext
+                        }
+                        if ext != "" && !containsString(extensions, ext) {
+                                extensions = append(extensions, ext)
+                        }
+                }
+        }
+        return extensions, rest
+}
+
+// Check whether ffuf arguments already contain a single-dash flag such as -w
+func hasFfufFlag(args []string, name string) bool {
+        for _, arg := range args {
+                if arg == name || strings.HasPrefix(arg, name+"=") {
+                        return true
+                }
+        }
+        return false
+}
+
+// Wordlist found under --wordlist-dir
+type wordlistEntry struct {
+        Path  string // relative to the directory, with forward slashes
+        Lines int
+}
+
+// Caps the inventory sent to the model so huge collections still fit in the prompt
+const maxWordlistInventory = 1000
+
+// List the wordlists under dir with their line counts, skipping hidden entries and docs
+func wordlistInventory(dir string) ([]wordlistEntry, error) {
+        var entries []wordlistEntry
+        err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+                if err != nil {
+                        return err
+                }
+                if strings.HasPrefix(d.Name(), ".") && path != dir {
+                        if d.IsDir() {
+                                return filepath.SkipDir
+                        }
+                        return nil
+                }
+                if !d.Type().IsRegular() {
+                        return nil
+                }
+                switch strings.ToLower(filepath.Ext(d.Name())) {
+                case ".md", ".png", ".jpg", ".gif", ".zip", ".gz", ".py", ".sh":
+                        return nil
+                }
+                if len(entries) >= maxWordlistInventory {
+                        return filepath.SkipAll
+                }
+
+                lines, err := countLines(path)
+                if err != nil {
+                        return nil
+                }
+                rel, err := filepath.Rel(dir, path)
+                if err != nil {
+                        return nil
+                }
+                entries = append(entries, wordlistEntry{Path: filepath.ToSlash(rel), Lines: lines})
+                return nil
+        })
+        if err != nil {
+                return nil, fmt.Errorf("reading wordlist directory: %w", err)
+        }
+        if len(entries) >= maxWordlistInventory {
+                fmt.Fprintf(os.Stderr, "%sWarning: only the first %d wordlists in %s are considered%s\n", ColorYellow, maxWordlistInventory, dir, ColorReset)
+        }
+        return entries, nil
+}
+
+// Count newline-terminated lines without loading the whole file
+func countLines(path string) (int, error) {
+        file, err := os.Open(path)
+        if err != nil {
+                return 0, err
+        }
+        defer file.Close()
+
+        lines := 0
+        buf := make([]byte, 32*1024)
+        for {
+                n, err := file.Read(buf)
+                lines += bytes.Count(buf[:n], []byte{'\n'})
+                if err == io.EOF {
+                        return lines, nil
+                }
+                if err != nil {
+                        return 0, err
+                }
+        }
+}
+
+// Build the wordlist selection prompt. Only file names and line counts are sent.
+func buildWordlistPrompt(urlStr string, headers map[string]string, stack *StackDescriptor, inventory []wordlistEntry) (string, error) {
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return "", fmt.Errorf("marshaling headers: %w", err)
+        }
+
+        var list strings.Builder
+        for _, entry := range inventory {
+                fmt.Fprintf(&list, "%s (%d lines)\n", entry.Path, entry.Lines)
+        }
+        stackLine := ""
+        if stack != nil {
+                stackLine = "\nStack: " + stack.String()
+        }
+
+        return fmt.Sprintf(`Pick the single most appropriate wordlist for fuzzing the FUZZ position of this URL with ffuf.
+Consider the URL path, the technology revealed by the headers, and the wordlist size (prefer focused lists
+over huge generic ones unless nothing specific fits). Choose only from the list below and copy the path exactly.
+Respond with a JSON object in the format: {"wordlist": "path/from/the/list.txt"}. No preamble or explanation needed.
+
+URL: %s
+Headers: %s%s
+
+Wordlists:
+%s
+Response:`, urlStr, string(headersJSON), stackLine, list.String()), nil
+}
+
+// Ask the AI to choose a wordlist from --wordlist-dir and return its full path.
+// The answer must name a file from the inventory, so invented paths are rejected.
+func selectWordlist(ctx context.Context, config *Config, headers map[string]string) (string, error) {
+        inventory, err := wordlistInventory(config.WordlistDir)
+        if err != nil {
+                return "", err
+        }
+        if len(inventory) == 0 {
+                return "", fmt.Errorf("no wordlists found in %s", config.WordlistDir)
+        }
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "Found %d wordlists in %s\n", len(inventory), config.WordlistDir)
+        }
+
+        prompt, err := buildWordlistPrompt(config.URL, headers, config.Stack, inventory)
+        if err != nil {
+                return "", err
+        }
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that selects wordlists for web application fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   200,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return "", err
+        }
+
+        var choice struct {
+                Wordlist string `json:"wordlist"`
+        }
+        if err := decodeJSONReply(completion.Content, &choice); err != nil {
+                return "", err
+        }
+
+        chosen := filepath.ToSlash(filepath.Clean(strings.TrimSpace(choice.Wordlist)))
+        for _, entry := range inventory {
+                if entry.Path != chosen {
+                        continue
+                }
+                path := filepath.Join(config.WordlistDir, filepath.FromSlash(entry.Path))
+                if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+                        return "", fmt.Errorf("selected wordlist %s is not a readable file", path)
+                }
+                fmt.Fprintf(textOutput, "%sAI selected wordlist: %s (%d lines)%s\n", ColorGreen, entry.Path, entry.Lines, ColorReset)
+                return path, nil
+        }
+        return "", fmt.Errorf("AI chose a wordlist that is not in %s: %q", config.WordlistDir, choice.Wordlist)
+}
+
+// Words accepted into a generated wordlist: printable ASCII without slashes or whitespace
+var wordlistWordRegex = regexp.MustCompile(`^[!-~]+$`)
+
+// Ask the AI for likely path words for this target and return the valid, unique ones
+func generateWordlist(ctx context.Context, config *Config, headers map[string]string) ([]string, error) {
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return nil, fmt.Errorf("marshaling headers: %w", err)
+        }
+
+        prompt := fmt.Sprintf(`Suggest the %d most likely names to find at the FUZZ position of this URL, based on the technology
+revealed by the path and headers (for example WordPress plugin slugs, IIS admin files or framework routes).
+Give bare names only: no slashes, no spaces, and no file extensions unless the extension is part of a well-known name.
+Respond with a JSON object in the format: {"words": ["name1", "name2", ...]}. No preamble or explanation needed.
+
+URL: %s
+Headers: %s
+
+Response:`, config.GenWordlist, config.URL, string(headersJSON))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that builds targeted wordlists for web application fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   config.GenWordlist*8 + 200,
+                Temperature: 0.3,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var reply struct {
+                Words []string `json:"words"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, err
+        }
+
+        var words []string
+        for _, word := range reply.Words {
+                word = strings.TrimSpace(word)
+                if !wordlistWordRegex.MatchString(word) || strings.ContainsAny(word, "/\\") || containsString(words, word) {
+                        continue
+                }
+                words = append(words, word)
+                if len(words) == config.GenWordlist {
+                        break
+                }
+        }
+        if len(words) == 0 {
+                return nil, fmt.Errorf("AI returned no usable words")
+        }
+        return words, nil
+}
+
+// Values requested for a --gen-values keyword, and the most that are kept
+const (
+        genValuesCount = 50
+        maxGenValues   = 100
+)
+
+// Values safe to pass through ffuf and any shell a user copies the command into
+var valueRegex = regexp.MustCompile(`^[A-Za-z0-9._:@+=,-]+$`)
+
+// Ask the AI for plausible values for a second keyword, based on where it appears
+func generateValues(ctx context.Context, config *Config, headers map[string]string) ([]string, error) {
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return nil, fmt.Errorf("marshaling headers: %w", err)
+        }
+        body := ffufFlagValue(config.FfufArgs, "-d")
+        if config.Request != nil {
+                body = config.Request.Body
+        }
+
+        prompt := fmt.Sprintf(`The keyword %[1]s marks a value to fuzz in this request, next to the FUZZ path position.
+Suggest %[2]d plausible values for %[1]s that fit the endpoint, such as numeric IDs, UUIDs, locale codes,
+version strings or usernames, depending on what the URL, body and headers suggest.
+Respond with a JSON object in the format: {"values": ["value1", "value2", ...]}. No preamble or explanation needed.
+
+URL: %[3]s
+Body: %[4]s
+Headers: %[5]s
+
+Response:`, config.GenValues, genValuesCount, config.URL, body, string(headersJSON))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that builds payload lists for web application fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   genValuesCount*12 + 200,
+                Temperature: 0.3,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var reply struct {
+                Values []string `json:"values"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, err
+        }
+
+        var values []string
+        for _, value := range reply.Values {
+                value = strings.TrimSpace(value)
+                if !valueRegex.MatchString(value) || containsString(values, value) {
+                        continue
+                }
+                values = append(values, value)
+                if len(values) == maxGenValues {
+                        break
+                }
+        }
+        if len(values) == 0 {
+                return nil, fmt.Errorf("AI returned no usable values")
+        }
+        return values, nil
+}
+
+// Write generated words to a temporary file and return its path
+func writeTempWordlist(words []string) (string, error) {
+        file, err := os.CreateTemp("", "ffufai-wordlist-*.txt")
+        if err != nil {
+                return "", fmt.Errorf("creating wordlist file: %w", err)
+        }
+        defer file.Close()
+
+        if _, err := file.WriteString(strings.Join(words, "\n") + "\n"); err != nil {
+                os.Remove(file.Name())
+                return "", fmt.Errorf("writing wordlist file: %w", err)
+        }
+        return file.Name(), nil
+}
+
+// Small curated wordlists built into the binary, for runs without -w
+//
+//go:embed wordlists/*.txt
+var builtinWordlistFiles embed.FS
+
+// The built-in wordlists, by name, in wordlists/NAME.txt
+var builtinWordlists = []struct {
+        name        string
+        description string
+}{
+        {"common", "common web paths: admin pages, configs, backups, CMS and framework directories"},
+        {"api", "API routes: versions, auth, users, docs, health and metrics endpoints"},
+}
+
+// Paths that suggest an API rather than a website
+var apiPathRegex = regexp.MustCompile(`(?i)/(api|apis|rest|graphql|rpc|v[0-9]+)(/|$)`)
+
+// Built-in wordlist for the target: api when its path, a JSON or XML request
+// body, a JSON response or the detected stack says API, otherwise common
+func builtinWordlistFor(config *Config, headers map[string]string) string {
+        path := strings.Replace(config.URL, config.Keyword, "", 1)
+        if parsed, err := url.Parse(path); err == nil {
+                path = parsed.Path
+        }
+        stack := ""
+        if config.Stack != nil {
+                stack = strings.ToLower(config.Stack.String())
+        }
+        switch {
+        case apiPathRegex.MatchString(path),
+                config.BodyType == "JSON" || config.BodyType == "XML",
+                strings.Contains(strings.ToLower(headers["Content-Type"]), "json"),
+                strings.Contains(stack, "api") || strings.Contains(stack, "graphql"):
+                return "api"
+        }
+        return "common"
+}
+
+// Copy a built-in wordlist to a temporary file for ffuf's -w and count its words
+func writeBuiltinWordlist(name string) (string, int, error) {
+        data, err := builtinWordlistFiles.ReadFile("wordlists/" + name + ".txt")
+        if err != nil {
+                return "", 0, fmt.Errorf("reading built-in wordlist %s: %w", name, err)
+        }
+        words := strings.Fields(string(data))
+        path, err := writeTempWordlist(words)
+        return path, len(words), err
+}
+
+// Print the built-in wordlists with their sizes for --list-builtin-wordlists
+func printBuiltinWordlists() error {
+        fmt.Fprintln(textOutput, "Built-in wordlists, used when no -w is given:")
+        for _, list := range builtinWordlists {
+                data, err := builtinWordlistFiles.ReadFile("wordlists/" + list.name + ".txt")
+                if err != nil {
+                        return fmt.Errorf("reading built-in wordlist %s: %w", list.name, err)
+                }
+                fmt.Fprintf(textOutput, "  %-8s %5d words  %s\n", list.name, len(strings.Fields(string(data))), list.description)
+        }
+        fmt.Fprintln(textOutput, "ffufai picks api for API-looking targets and common otherwise; pass -w FILE to use your own.")
+        return nil
+}
+
+// Backup patterns requested from the AI, and the most composed names a backup pass fuzzes
+const (
+        maxBackupPatterns = 20
+        maxBackupNames    = 200000
+)
+
+// Backup name patterns: {name} with a plain prefix and/or suffix, never a path
+var backupPatternRegex = regexp.MustCompile(`^[A-Za-z0-9._~-]{0,20}\{name\}[A-Za-z0-9._~-]{0,20}$`)
+
+// Ask the AI for backup and leftover file patterns suited to the detected stack
+func suggestBackupPatterns(ctx context.Context, config *Config, headers map[string]string, extensions []string) ([]string, error) {
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return nil, fmt.Errorf("marshaling headers: %w", err)
+        }
+
+        prompt := fmt.Sprintf(`Suggest up to %d patterns for backup and leftover files that editors, deploy tools and
+administrators leave behind on this stack, such as "{name}.bak", "{name}~", "{name}.old", ".{name}.swp",
+"{name}.save" or date-stamped copies like "{name}.2024-01-01". {name} stands for a file name including its
+extension (for example config.php). Each pattern must contain {name} exactly once, with no slashes or spaces.
+Respond with a JSON object in the format: {"patterns": ["{name}.bak", ...]}. No preamble or explanation needed.
+
+URL: %s
+Extensions in use: %s
+Headers: %s
+
+Response:`, maxBackupPatterns, config.URL, strings.Join(extensions, ", "), string(headersJSON))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that finds exposed backup files during web application fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   500,
+                Temperature: 0.2,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var reply struct {
+                Patterns []string `json:"patterns"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, err
+        }
+
+        var patterns []string
+        for _, pattern := range reply.Patterns {
+                pattern = strings.TrimSpace(pattern)
+                if !backupPatternRegex.MatchString(pattern) || strings.Contains(pattern, "..") || containsString(patterns, pattern) {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Dropping backup pattern %q\n", pattern)
+                        }
+                        continue
+                }
+                patterns = append(patterns, pattern)
+                if len(patterns) == maxBackupPatterns {
+                        break
+                }
+        }
+        if len(patterns) == 0 {
+                return nil, fmt.Errorf("AI returned no usable backup patterns")
+        }
+        return patterns, nil
+}
+
+// Apply backup patterns to every word of a wordlist, alone and with each
+// extension, so "config" becomes config.php.bak, .config.php.swp and so on
+func composeBackupNames(wordlist string, extensions, patterns []string) ([]string, error) {
+        data, err := os.ReadFile(wordlist)
+        if err != nil {
+                return nil, fmt.Errorf("reading wordlist: %w", err)
+        }
+
+        seen := make(map[string]bool)
+        var names []string
+        for _, word := range strings.Split(string(data), "\n") {
+                word = strings.TrimSpace(word)
+                if word == "" || strings.HasPrefix(word, "#") {
+                        continue
+                }
+                bases := []string{word}
+                for _, ext := range extensions {
+                        bases = append(bases, word+ext)
+                }
+                for _, base := range bases {
+                        for _, pattern := range patterns {
+                                name := strings.Replace(pattern, "{name}", base, 1)
+                                if seen[name] {
+                                        continue
+                                }
+                                if len(names) == maxBackupNames {
+                                        return names, nil
+                                }
+                                seen[name] = true
+                                names = append(names, name)
+                        }
+                }
+        }
+        return names, nil
+}
+
+// Wordlists passed to ffuf with -w, mapped from keyword to file. A wordlist
+// without ":KEYWORD" is bound to FUZZ.
+func ffufWordlists(args []string) map[string]string {
+        wordlists := make(map[string]string)
+        for i, arg := range args {
+                var value string
+                switch {
+                case arg == "-w" && i+1 < len(args):
+                        value = args[i+1]
+                case strings.HasPrefix(arg, "-w="):
+                        value = strings.TrimPrefix(arg, "-w=")
+                default:
+                        continue
+                }
+
+                // ffuf splits a -w value on commas, like "-w a.txt:FUZZ,b.txt:EXT"
+                for _, entry := range strings.Split(value, ",") {
+                        keyword := "FUZZ"
+                        if index := strings.LastIndex(entry, ":"); index > 0 && wordlistKeywordRegex.MatchString(entry[index+1:]) {
+                                entry, keyword = entry[:index], entry[index+1:]
+                        }
+                        wordlists[keyword] = entry
+                }
+        }
+        return wordlists
+}
+
+// Check that every wordlist exists, is readable and is not empty, and count
+// its lines. "-" is ffuf's stdin and is not checked. Pipes such as -w <(cmd)
+// stat at size 0 and can be read only once, so for anything but a regular
+// file only its existence is checked.
+func checkWordlists(wordlists map[string]string) (map[string]int, error) {
+        keywords := make([]string, 0, len(wordlists))
+        for keyword := range wordlists {
+                keywords = append(keywords, keyword)
+        }
+        sort.Strings(keywords)
+
+        lines := make(map[string]int)
+        for _, keyword := range keywords {
+                path := wordlists[keyword]
+                if path == "-" {
+                        continue
+                }
+                info, err := os.Stat(path)
+                switch {
+                case errors.Is(err, os.ErrNotExist):
+                        return nil, fmt.Errorf("wordlist %s for %s does not exist", path, keyword)
+                case err != nil:
+                        return nil, fmt.Errorf("wordlist %s for %s: %w", path, keyword, err)
+                case info.IsDir():
+                        return nil, fmt.Errorf("wordlist %s for %s is a directory, not a file", path, keyword)
+                case !info.Mode().IsRegular():
+                        continue
+                case info.Size() == 0:
+                        return nil, fmt.Errorf("wordlist %s for %s is empty", path, keyword)
+                }
+                count, err := countLines(path)
+                if err != nil {
+                        return nil, fmt.Errorf("wordlist %s for %s is not readable: %w", path, keyword, err)
+                }
+                lines[path] = count
+        }
+        return lines, nil
+}
+
+// Keywords ffufai recognizes after the colon in "-w file:KEYWORD"
+var wordlistKeywordRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// Check whether the FUZZ keyword already has a wordlist
+func hasMainWordlist(args []string) bool {
+        _, ok := ffufWordlists(args)["FUZZ"]
+        return ok
+}
+
+// Replace the wordlist of a keyword in ffuf arguments, keeping wordlists
+// bound to other keywords
+func withWordlist(args []string, keyword, wordlist string) []string {
+        var out []string
+        for i := 0; i < len(args); i++ {
+                value := ""
+                switch {
+                case args[i] == "-w" && i+1 < len(args):
+                        value = args[i+1]
+                case strings.HasPrefix(args[i], "-w="):
+                        value = strings.TrimPrefix(args[i], "-w=")
+                default:
+                        out = append(out, args[i])
+                        continue
+                }
+
+                var kept []string
+                for _, entry := range strings.Split(value, ",") {
+                        if _, replaced := ffufWordlists([]string{"-w", entry})[keyword]; !replaced {
+                                kept = append(kept, entry)
+                        }
+                }
+                if len(kept) > 0 {
+                        out = append(out, "-w", strings.Join(kept, ","))
+                }
+                if args[i] == "-w" {
+                        i++
+                }
+        }
+        if keyword != "FUZZ" {
+                wordlist += ":" + keyword
+        }
+        return append(out, "-w", wordlist)
+}
+
+// Response to a request for a path that should not exist, measured the way ffuf does
+type CalibrationProbe struct {
+        Path        string `json:"path"`
+        Status      int    `json:"status"`
+        Length      int    `json:"length"`
+        Words       int    `json:"words"`
+        Lines       int    `json:"lines"`
+        ContentType string `json:"content_type,omitempty"`
+        // Kept to look for a single-page app's shell, not sent to the AI
+        Body string `json:"-"`
+}
+
+// ffuf options that filter or match responses; if the user set any, filters are only suggested
+var ffufFilterFlags = []string{"-fc", "-fl", "-fmode", "-fr", "-fs", "-ft", "-fw", "-mc", "-ml", "-mmode", "-mr", "-ms", "-mt", "-mw", "-ac", "-acc", "-ach", "-acs", "-ack"}
+
+// Whether the ffuf arguments or the ffufrc set a matcher or filter
+func hasUserFilters(config *Config) bool {
+        for _, name := range ffufFilterFlags {
+                if hasFfufFlag(effectiveFfufArgs(config), name) {
+                        return true
+                }
+        }
+        return false
+}
+
+// Match codes the AI may pass to -mc: "all" or a comma list of status codes
+var matchCodesRegex = regexp.MustCompile(`^(all|\d{3}(,\d{3})*)$`)
+
+func validMatchCodes(codes string) bool {
+        if !matchCodesRegex.MatchString(codes) {
+                return false
+        }
+        for _, code := range strings.Split(codes, ",") {
+                if n, err := strconv.Atoi(code); err == nil && (n < 100 || n > 599) {
+                        return false
+                }
+        }
+        return true
+}
+
+// A problem in the ffuf flags found before the AI call. Fatal ones can
+// never give results or make ffuf refuse to start; the rest are warnings.
+type flagProblem struct {
+        fatal   bool
+        message string
+}
+
+// Matchers and the filters that undo them, compared by checkFfufFlags
+var matcherFilterPairs = [][2]string{{"-mc", "-fc"}, {"-ms", "-fs"}, {"-mw", "-fw"}, {"-ml", "-fl"}}
+
+// Parse a matcher or filter list like "200,300-399" into ranges; "all"
+// stands for every value
+func parseValueRanges(name, list string) ([][2]int, error) {
+        var ranges [][2]int
+        for _, item := range strings.Split(list, ",") {
+                item = strings.TrimSpace(item)
+                if item == "all" {
+                        ranges = append(ranges, [2]int{0, math.MaxInt32})
+                        continue
+                }
+                low, high, isRange := strings.Cut(item, "-")
+                from, err := strconv.Atoi(low)
+                to := from
+                if err == nil && isRange {
+                        to, err = strconv.Atoi(high)
+                }
+                if err != nil || from < 0 || to < from {
+                        return nil, fmt.Errorf("%s %q: %q is not a number or a range like 300-399", name, list, item)
+                }
+                ranges = append(ranges, [2]int{from, to})
+        }
+        return ranges, nil
+}
+
+// The parts of the matched ranges the filter ranges cover, and whether
+// they cover all of them
+func rangeOverlap(matched, filtered [][2]int) ([][2]int, bool) {
+        var overlap [][2]int
+        total, covered := 0, 0
+        sorted := append([][2]int{}, filtered...)
+        sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+        for _, m := range matched {
+                total += m[1] - m[0] + 1
+                // Count each matched value once, even where filter ranges overlap
+                next := m[0]
+                for _, f := range sorted {
+                        from, to := max(f[0], next), min(f[1], m[1])
+                        if from > to {
+                                continue
+                        }
+                        overlap = append(overlap, [2]int{from, to})
+                        covered += to - from + 1
+                        next = to + 1
+                }
+        }
+        return overlap, covered == total
+}
+
+// Format ranges the way ffuf's flags take them
+func formatValueRanges(ranges [][2]int) string {
+        var parts []string
+        for _, r := range ranges {
+                switch {
+                case r[0] == 0 && r[1] == math.MaxInt32:
+                        parts = append(parts, "all")
+                case r[0] == r[1]:
+                        parts = append(parts, strconv.Itoa(r[0]))
+                default:
+                        parts = append(parts, fmt.Sprintf("%d-%d", r[0], r[1]))
+                }
+        }
+        return strings.Join(parts, ",")
+}
+
+// Look for ffuf flags that contradict each other or the URL. args are the
+// ffufrc's and the command's flags together, with a raw request's -X and -d.
+func checkFfufFlags(config *Config, args []string) []flagProblem {
+        var problems []flagProblem
+        fatal := func(format string, a ...any) {
+                problems = append(problems, flagProblem{true, fmt.Sprintf(format, a...)})
+        }
+        warn := func(format string, a ...any) {
+                problems = append(problems, flagProblem{false, fmt.Sprintf(format, a...)})
+        }
+
+        for _, name := range []string{"-mmode", "-fmode"} {
+                if mode := ffufFlagValue(args, name); mode != "" && mode != "and" && mode != "or" {
+                        fatal(`%s %q: ffuf's modes are "and" and "or"`, name, mode)
+                }
+        }
+
+        // ffuf filters what its matchers let through, so a value in both
+        // never shows up
+        ranges := make(map[string][][2]int)
+        for _, pair := range matcherFilterPairs {
+                for _, name := range pair {
+                        if list := ffufFlagValue(args, name); list != "" {
+                                parsed, err := parseValueRanges(name, list)
+                                if err != nil {
+                                        fatal("%v", err)
+                                        continue
+                                }
+                                ranges[name] = parsed
+                        }
+                }
+        }
+        otherMatchers := false
+        for _, name := range []string{"-ms", "-mw", "-ml", "-mr", "-mt"} {
+                otherMatchers = otherMatchers || hasFfufFlag(args, name)
+        }
+        for _, pair := range matcherFilterPairs {
+                matched, filtered := ranges[pair[0]], ranges[pair[1]]
+                if matched == nil || filtered == nil {
+                        continue
+                }
+                overlap, all := rangeOverlap(matched, filtered)
+                switch {
+                case len(overlap) == 0:
+                case pair[0] == "-mc" && all && !otherMatchers:
+                        fatal("-mc %s and -fc %s: every status code -mc matches is also filtered by -fc, so ffuf can never report a result",
+                                ffufFlagValue(args, "-mc"), ffufFlagValue(args, "-fc"))
+                case pair[0] == "-mc" && ffufFlagValue(args, "-mc") == "all":
+                        // -mc all with a few filtered codes is the usual way to see everything else
+                default:
+                        warn("%s %s and %s %s both cover %s; ffuf filters after matching, so responses with those values are never shown",
+                                pair[0], ffufFlagValue(args, pair[0]), pair[1], ffufFlagValue(args, pair[1]), formatValueRanges(overlap))
+                }
+        }
+
+        method := strings.ToUpper(ffufFlagValue(args, "-X"))
+        hasData := ffufFlagValue(args, "-d") != ""
+        if sizes, ok := ranges["-fs"]; ok {
+                if _, zero := rangeOverlap([][2]int{{0, 0}}, sizes); zero {
+                        if method == "HEAD" {
+                                fatal("-X HEAD and -fs %s: responses to HEAD have no body, so a size filter with 0 hides every one of them", ffufFlagValue(args, "-fs"))
+                        } else {
+                                warn("-fs %s filters every response with an empty body, such as most redirects and many 401 and 403 answers; use -fc to drop status codes instead", ffufFlagValue(args, "-fs"))
+                        }
+                }
+        }
+
+        switch {
+        case (method == "POST" || method == "PUT" || method == "PATCH") && !hasData:
+                warn("-X %s without -d sends every request with an empty body; add -d with the parameters to fuzz", method)
+        case (method == "GET" || method == "HEAD") && hasData:
+                warn("-X %s with -d sends a body most servers ignore on %s requests; drop -X to let ffuf send a POST", method, method)
+        }
+
+        if hasFfufFlag(args, "-recursion") {
+                if !strings.HasSuffix(config.URL, "FUZZ") {
+                        fatal("-recursion needs the URL to end in FUZZ, but %s does not, and ffuf refuses to start without it", config.URL)
+                }
+        } else {
+                for _, name := range []string{"-recursion-depth", "-recursion-strategy"} {
+                        if hasFfufFlag(args, name) {
+                                warn("%s has no effect without -recursion", name)
+                        }
+                }
+        }
+        return problems
+}
+
+// Filters the AI may add, and the values each accepts
+var (
+        filterNumbersRegex = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+        allowedFilters     = map[string]func(value string) bool{
+                "-fs": filterNumbersRegex.MatchString,
+                "-fw": filterNumbersRegex.MatchString,
+                "-fl": filterNumbersRegex.MatchString,
+                "-fc": filterNumbersRegex.MatchString,
+                "-fr": func(value string) bool {
+                        _, err := regexp.Compile(value)
+                        return value != "" && err == nil && !strings.ContainsAny(value, "\r\n")
+                },
+                "-ac": func(value string) bool { return value == "" },
+        }
+)
+
+// Request random nonexistent paths in place of the keyword to see how the target answers misses
+func probeCalibration(ctx context.Context, config *Config, urlStr string) ([]CalibrationProbe, error) {
+        client := targetClient(config)
+        // ffuf does not follow redirects by default, so neither do the probes
+        client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+                return http.ErrUseLastResponse
+        }
+
+        var probes []CalibrationProbe
+        for i := 0; i < 2; i++ {
+                token := make([]byte, 8)
+                if _, err := rand.Read(token); err != nil {
+                        return nil, fmt.Errorf("generating probe path: %w", err)
+                }
+                word := hex.EncodeToString(token)
+                probeURL := strings.Replace(urlStr, config.Keyword, word, 1)
+
+                req, err := http.NewRequestWithContext(ctx, "GET", probeURL, nil)
+                if err != nil {
+                        return nil, fmt.Errorf("creating probe request: %w", err)
+                }
+                req.Header.Set("User-Agent", "ffufai/"+Version)
+
+                resp, err := client.Do(req)
+                if err != nil {
+                        return nil, fmt.Errorf("executing probe request: %w", err)
+                }
+                body, err := io.ReadAll(io.LimitReader(resp.Body, 5*1024*1024))
+                resp.Body.Close()
+                if err != nil {
+                        return nil, fmt.Errorf("reading probe response: %w", err)
+                }
+
+                // Same word and line counting as ffuf
+                probes = append(probes, CalibrationProbe{
+                        Path:        word,
+                        Status:      resp.StatusCode,
+                        Length:      len(body),
+                        Words:       len(strings.Split(string(body), " ")),
+                        Lines:       len(strings.Split(string(body), "\n")),
+                        ContentType: resp.Header.Get("Content-Type"),
+                        Body:        string(body),
+                })
+        }
+        return probes, nil
+}
+
+// Status codes ffuf reports without -mc; misses answered with any other code
+// need no filter
+var ffufDefaultMatchCodes = []int{200, 201, 202, 203, 204, 205, 206, 207, 208, 226, 301, 302, 307, 401, 403, 405, 500}
+
+// Two calibration sizes further apart than this share are treated as a
+// dynamic page rather than the same page with small changes
+const calibrationSpread = 0.2
+
+// Filters for a wildcard target from the two calibration probes, without the
+// AI: -fs when both misses have the same size, -fw when only the word count
+// matches, -ac when they differ slightly. Returns no filters and a warning
+// when the misses differ too much to filter on a number.
+func calibrationFilters(probes []CalibrationProbe) ([]string, string) {
+        if len(probes) < 2 {
+                return nil, ""
+        }
+        a, b := probes[0], probes[1]
+        switch {
+        case a.Status != b.Status:
+                return nil, fmt.Sprintf("the two random paths answered %d and %d; responses are dynamic, filtering may need -fr", a.Status, b.Status)
+        case !containsInt(ffufDefaultMatchCodes, a.Status):
+                return nil, ""
+        case a.Length == b.Length:
+                return []string{"-fs", strconv.Itoa(a.Length)}, ""
+        case a.Words == b.Words:
+                return []string{"-fw", strconv.Itoa(a.Words)}, ""
+        }
+        spread := float64(absInt(a.Length-b.Length)) / float64(max(a.Length, b.Length))
+        if spread > calibrationSpread {
+                return nil, fmt.Sprintf("the two random paths answered %d with %d and %d bytes; responses are dynamic, filtering may need -fr", a.Status, a.Length, b.Length)
+        }
+        return []string{"-ac"}, ""
+}
+
+// Mount points and attributes of the HTML shell single-page apps serve for
+// every path: React, Vue, Next.js, Nuxt, Svelte and Angular
+var spaShellRegex = regexp.MustCompile(`<div id=["'](?:root|app|__next|__nuxt|svelte)["']|<app-root|data-reactroot|ng-version=`)
+
+// Largest difference in size and in word count, as a share, between the two
+// calibration pages of a single-page app's shell
+const spaSimilarity = 0.05
+
+// Check whether the two calibration probes got the same HTML app shell, which
+// a single-page app serves for any path. Both must answer 200 with HTML of
+// nearly the same size and word count, so custom 200 error pages that differ
+// in content do not count, and both must carry a known mount point. Returns
+// that mount point, or "" when the target does not look like one.
+func detectSPA(probes []CalibrationProbe) string {
+        if len(probes) < 2 {
+                return ""
+        }
+        a, b := probes[0], probes[1]
+        for _, probe := range probes[:2] {
+                if probe.Status != http.StatusOK || !strings.Contains(strings.ToLower(probe.ContentType), "text/html") {
+                        return ""
+                }
+        }
+        if float64(absInt(a.Length-b.Length)) > spaSimilarity*float64(max(a.Length, b.Length)) ||
+                float64(absInt(a.Words-b.Words)) > spaSimilarity*float64(max(a.Words, b.Words)) {
+                return ""
+        }
+        if marker := spaShellRegex.FindString(a.Body); marker != "" && strings.Contains(b.Body, marker) {
+                return marker
+        }
+        return ""
+}
+
+func containsInt(values []int, value int) bool {
+        for _, v := range values {
+                if v == value {
+                        return true
+                }
+        }
+        return false
+}
+
+func absInt(n int) int {
+        if n < 0 {
+                return -n
+        }
+        return n
+}
+
+// Ask the AI for ffuf filter flags that hide the calibration responses.
+// Returns the validated flags as ffuf arguments and the model's reasoning.
+func suggestFilters(ctx context.Context, config *Config, probes []CalibrationProbe) ([]string, string, error) {
+        probesJSON, err := json.MarshalIndent(probes, "", "  ")
+        if err != nil {
+                return nil, "", fmt.Errorf("marshaling probes: %w", err)
+        }
+
+        prompt := fmt.Sprintf(`These are the responses to requests for random paths that should not exist, placed at the FUZZ position of %s.
+Suggest ffuf filter options that hide responses like these without hiding real content. Use only -fs (size),
+-fw (words), -fl (lines), -fc (status codes), -fr (regex) or -ac (auto-calibration, no value). Prefer the most
+specific filter: if the sizes differ between probes, filter by words or lines instead, and use -ac only when
+nothing is stable. Respond with a JSON object in the format:
+{"filters": [{"flag": "-fs", "value": "1234"}], "reason": "one sentence"}. No preamble or explanation needed.
+
+Probes:
+%s
+
+Response:`, config.URL, string(probesJSON))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that tunes ffuf filters for web application fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   300,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return nil, "", err
+        }
+
+        var reply struct {
+                Filters []struct {
+                        Flag  string `json:"flag"`
+                        Value string `json:"value"`
+                } `json:"filters"`
+                Reason string `json:"reason"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, "", err
+        }
+
+        var args []string
+        for _, filter := range reply.Filters {
+                flagName := strings.TrimSpace(filter.Flag)
+                value := strings.TrimSpace(filter.Value)
+                valid, ok := allowedFilters[flagName]
+                if !ok || !valid(value) || hasFfufFlag(args, flagName) {
+                        fmt.Fprintf(os.Stderr, "%sWarning: ignoring suggested filter %s %s%s\n", ColorYellow, flagName, value, ColorReset)
+                        continue
+                }
+                args = append(args, flagName)
+                if value != "" {
+                        args = append(args, value)
+                }
+        }
+        return args, reply.Reason, nil
+}
+
+// Header names are RFC 9110 tokens; values must be printable and on one line
+var (
+        headerNameRegex  = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+        headerValueRegex = regexp.MustCompile(`^[ -~]*$`)
+)
+
+// Most bypass headers added to one ffuf run
+const maxBypassHeaders = 8
+
+// Status code of the base URL probe, recorded by getHeaders as "Status-Code"
+func probeStatus(headers map[string]string) int {
+        var status int
+        fmt.Sscanf(headers["Status-Code"], "%d", &status)
+        return status
+}
+
+// Ask the AI for header-based access control bypass candidates for a 401/403
+// target. Returns validated "Name: value" pairs ready for ffuf's -H.
+func suggestBypassHeaders(ctx context.Context, config *Config, headers map[string]string) ([]string, error) {
+        headersJSON, err := json.MarshalIndent(headers, "", "  ")
+        if err != nil {
+                return nil, fmt.Errorf("marshaling headers: %w", err)
+        }
+
+        prompt := fmt.Sprintf(`This URL answered %s. Suggest HTTP request headers that commonly bypass access controls
+on this kind of server or proxy (for example X-Forwarded-For: 127.0.0.1, X-Original-URL, X-Rewrite-URL), tailored
+to the technology revealed by the headers. Suggest at most %d headers.
+Respond with a JSON object in the format: {"headers": [{"name": "X-Forwarded-For", "value": "127.0.0.1"}]}.
+No preamble or explanation needed.
+
+URL: %s
+Headers: %s
+
+Response:`, headers["Status-Code"], maxBypassHeaders, config.URL, string(headersJSON))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester suggesting authorized access control bypass tests. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   400,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var reply struct {
+                Headers []struct {
+                        Name  string `json:"name"`
+                        Value string `json:"value"`
+                } `json:"headers"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, err
+        }
+
+        var bypass []string
+        for _, header := range reply.Headers {
+                name := strings.TrimSpace(header.Name)
+                value := strings.TrimSpace(header.Value)
+                if !headerNameRegex.MatchString(name) || !headerValueRegex.MatchString(value) {
+                        fmt.Fprintf(os.Stderr, "%sWarning: ignoring invalid bypass header %q%s\n", ColorYellow, name, ColorReset)
+                        continue
+                }
+                bypass = append(bypass, name+": "+value)
+                if len(bypass) == maxBypassHeaders {
+                        break
+                }
+        }
+        return bypass, nil
+}
+
+// Hostnames accepted as virtual host candidates
+var hostnameRegex = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// Hostname-looking tokens inside header values such as Content-Security-Policy
+var headerHostRegex = regexp.MustCompile(`(?i)(?:https?://|wss?://|\*\.)?([a-z0-9-]+(?:\.[a-z0-9-]+)+)`)
+
+// Collect virtual host hints for a target: certificate SANs, hosts named in
+// CSP and CORS headers, and the redirect Location. Through a proxy the SANs
+// come from the certificate the header probe saw instead of a direct dial.
+func collectVhostArtifacts(ctx context.Context, config *Config, baseURL string) (map[string][]string, error) {
+        target, err := url.Parse(baseURL)
+        if err != nil {
+                return nil, fmt.Errorf("parsing URL: %w", err)
+        }
+        artifacts := make(map[string][]string)
+
+        // TLS certificate names, read without verifying since the target may be a bare IP
+        port := target.Port()
+        if port == "" || target.Scheme == "http" {
+                port = "443"
+        }
+        dialer := &net.Dialer{Timeout: config.ProbeTimeout, Resolver: targetResolver}
+        address, tlsConfig := net.JoinHostPort(target.Hostname(), port), withoutVerify(targetTLSConfig(config))
+        if ip := resolvedIP(config, target.Hostname(), port); ip != "" {
+                address, tlsConfig.ServerName = net.JoinHostPort(ip, port), target.Hostname()
+        }
+        if config.SNI != "" {
+                tlsConfig.ServerName = config.SNI
+        }
+        if targetProxy(config, baseURL) != nil {
+                if config.Certificate != nil {
+                        names := append([]string{}, config.Certificate.SANs...)
+                        if config.Certificate.Subject != "" {
+                                names = append(names, config.Certificate.Subject)
+                        }
+                        artifacts["tls_san"] = names
+                }
+        } else if conn, err := tls.DialWithDialer(dialer, "tcp", address, tlsConfig); err == nil {
+                if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+                        names := certs[0].DNSNames
+                        if certs[0].Subject.CommonName != "" {
+                                names = append(names, certs[0].Subject.CommonName)
+                        }
+                        artifacts["tls_san"] = names
+                }
+                conn.Close()
+        }
+
+        // Headers from a request that does not follow redirects
+        client := targetClient(config)
+        client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+                return http.ErrUseLastResponse
+        }
+        req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
+        if err != nil {
+                return nil, fmt.Errorf("creating request: %w", err)
+        }
+        req.Header.Set("User-Agent", "ffufai/"+Version)
+        resp, err := client.Do(req)
+        if err != nil {
+                if len(artifacts) == 0 {
+                        return nil, fmt.Errorf("fetching %s: %w", baseURL, err)
+                }
+                return artifacts, nil
+        }
+        resp.Body.Close()
+        for _, name := range []string{"Location", "Content-Security-Policy", "Access-Control-Allow-Origin"} {
+                for _, match := range headerHostRegex.FindAllStringSubmatch(resp.Header.Get(name), -1) {
+                        key := strings.ToLower(name)
+                        artifacts[key] = append(artifacts[key], match[1])
+                }
+        }
+
+        return artifacts, nil
+}
+
+// Check whether a name resolves in public DNS; such names are already known
+// and make poor virtual host candidates
+func resolvesPublicly(ctx context.Context, host string) bool {
+        ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+        defer cancel()
+        addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+        return err == nil && len(addrs) > 0
+}
+
+// Ask the AI for likely internal virtual hosts, keep those that don't resolve
+// publicly and merge them into the vhosts file. Returns the file path and the new names.
+func suggestVhosts(ctx context.Context, config *Config, baseURL string) (string, []string, error) {
+        artifacts, err := collectVhostArtifacts(ctx, config, baseURL)
+        if err != nil {
+                return "", nil, err
+        }
+        artifactsJSON, err := json.MarshalIndent(artifacts, "", "  ")
+        if err != nil {
+                return "", nil, fmt.Errorf("marshaling artifacts: %w", err)
+        }
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "Virtual host artifacts: %s\n", artifactsJSON)
+        }
+
+        target, _ := url.Parse(baseURL)
+        prompt := fmt.Sprintf(`These names were found on %s in its TLS certificate, security headers and redirects.
+Suggest up to 30 likely internal or non-public virtual host names served by the same machine
+(for example dev., staging., admin., internal. variants of the domains seen). Give full hostnames only.
+Respond with a JSON object in the format: {"vhosts": ["dev.example.com", ...]}. No preamble or explanation needed.
+
+Artifacts:
+%s
+
+Response:`, target.Hostname(), string(artifactsJSON))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester enumerating virtual hosts. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   600,
+                Temperature: 0.3,
+        })
+        if err != nil {
+                return "", nil, err
+        }
+
+        var reply struct {
+                Vhosts []string `json:"vhosts"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return "", nil, err
+        }
+
+        path := config.VhostsOut
+        if path == "" {
+                path = "ffufai-vhosts-" + strings.ReplaceAll(target.Hostname(), ":", "_") + ".txt"
+        }
+
+        // Keep candidates from earlier runs so the file grows across runs
+        var known []string
+        if data, err := os.ReadFile(path); err == nil {
+                for _, line := range strings.Split(string(data), "\n") {
+                        if line = strings.TrimSpace(line); line != "" {
+                                known = append(known, line)
+                        }
+                }
+        }
+
+        var added []string
+        for _, host := range reply.Vhosts {
+                host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+                if !hostnameRegex.MatchString(host) || containsString(known, host) {
+                        continue
+                }
+                if resolvesPublicly(ctx, host) {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Skipping %s, it resolves in public DNS\n", host)
+                        }
+                        continue
+                }
+                known = append(known, host)
+                added = append(added, host)
+        }
+
+        if err := os.WriteFile(path, []byte(strings.Join(known, "\n")+"\n"), 0o644); err != nil {
+                return "", nil, fmt.Errorf("writing vhosts file: %w", err)
+        }
+        return path, added, nil
+}
+
+// Validate URL and provide helpful warnings
+func validateURL(urlStr, keyword string) error {
+        parsedURL, err := url.Parse(urlStr)
+        if err != nil {
+                return fmt.Errorf("invalid URL format: %w", err)
+        }
+
+        if parsedURL.Scheme == "" {
+                return fmt.Errorf("URL must include scheme (http:// or https://)")
+        }
+
+        if parsedURL.Host == "" {
+                return fmt.Errorf("URL must include hostname")
+        }
+
+        if !strings.Contains(urlStr, keyword) {
+                return fmt.Errorf("URL must contain the %s keyword", keyword)
+        }
+
+        // Check if the keyword is at the end of path for extension fuzzing
+        pathParts := strings.Split(parsedURL.Path, "/")
+        if len(pathParts) == 0 || !strings.Contains(pathParts[len(pathParts)-1], keyword) {
+                fmt.Fprintf(os.Stderr, "%sWarning: %s keyword is not at the end of the URL path. Extension fuzzing may not work as expected.%s\n", ColorYellow, keyword, ColorReset)
+        }
+
+        return nil
+}
+
+// Run ffuf, using a generated wordlist as -w when none was given and as a
+// second pass when the user supplied their own, then analyze the results of
+// all passes together
+func runFfufPasses(config *Config, extensions []string, generated, backups string) error {
+        config.PassResults = &FfufOutput{}
+        err := runPasses(config, extensions, generated, backups)
+        var interrupted *interruptedError
+        if len(config.PassResults.Results) > 0 && !errors.As(err, &interrupted) {
+                analyzeResults(config, config.PassResults)
+        }
+        return err
+}
+
+// Run the ffuf passes the flags ask for, collecting their results in
+// config.PassResults
+func runPasses(config *Config, extensions []string, generated, backups string) error {
+        pass := *config
+        // The run whose wordlist refinement passes extend
+        primary := config
+        var output *FfufOutput
+        var err error
+        switch {
+        case generated == "":
+                output, err = executeFfuf(config, extensions)
+        case !hasMainWordlist(config.FfufArgs):
+                pass.FfufArgs = append(append([]string{}, config.FfufArgs...), "-w", generated)
+                primary = &pass
+                output, err = executeFfuf(&pass, extensions)
+        default:
+                if output, err = executeFfuf(config, extensions); err == nil {
+                        fmt.Fprintf(textOutput, "%sRunning a second ffuf pass with the generated wordlist%s\n", ColorCyan, ColorReset)
+                        pass.FfufArgs = withWordlist(config.FfufArgs, config.Keyword, generated)
+                        var second *FfufOutput
+                        if second, err = executeFfuf(&pass, extensions); output != nil && second != nil {
+                                output.Results = append(output.Results, second.Results...)
+                        }
+                }
+        }
+        if err == nil && config.Refine > 0 {
+                err = refinePasses(primary, extensions, output)
+        }
+        if err == nil && config.BypassPass {
+                err = runBypassPass(config, output)
+        }
+        if err == nil && config.Mutate {
+                err = runMutationPass(primary, extensions, output)
+        }
+        if err == nil && config.SmartRecursion > 0 {
+                err = smartRecursion(primary, extensions, output)
+        }
+        if err != nil || backups == "" {
+                return err
+        }
+
+        // Backup names are complete, so this pass needs no -e; filters carry over
+        fmt.Fprintf(textOutput, "%sRunning a backup file pass with the composed names%s\n", ColorCyan, ColorReset)
+        pass.FfufArgs = withWordlist(config.FfufArgs, config.Keyword, backups)
+        _, err = executeFfuf(&pass, nil)
+        return err
+}
+
+// Most 403 paths sent to the AI, and the most variants a bypass pass fuzzes
+const (
+        maxBypassPaths    = 50
+        maxBypassVariants = 500
+)
+
+// Paths of the results that answered 403, without the leading slash
+func forbiddenPaths(output *FfufOutput) []string {
+        var paths []string
+        for _, result := range output.Results {
+                if result.Status != http.StatusForbidden {
+                        continue
+                }
+                parsed, err := url.Parse(result.URL)
+                if err != nil {
+                        continue
+                }
+                if path := strings.TrimPrefix(parsed.EscapedPath(), "/"); path != "" && !containsString(paths, path) {
+                        paths = append(paths, path)
+                }
+                if len(paths) == maxBypassPaths {
+                        break
+                }
+        }
+        return paths
+}
+
+// Percent-encode every byte outside the characters a URL path may carry
+// literally; existing %XX escapes are kept
+func encodeUnsafePath(path string) string {
+        var b strings.Builder
+        for i := 0; i < len(path); i++ {
+                c := path[i]
+                switch {
+                case c == '%' && i+2 < len(path) && isHex(path[i+1]) && isHex(path[i+2]):
+                        b.WriteByte(c)
+                case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+                        strings.IndexByte("-._~!$&'()*+,;=:@/", c) >= 0:
+                        b.WriteByte(c)
+                default:
+                        fmt.Fprintf(&b, "%%%02X", c)
+                }
+        }
+        return b.String()
+}
+
+func isHex(c byte) bool {
+        return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Ask the AI for path-mangling variants of forbidden paths suited to the server
+func suggestPathVariants(ctx context.Context, config *Config, paths []string) ([]string, error) {
+        server := config.TargetHeaders["Server"]
+        if powered := config.TargetHeaders["X-Powered-By"]; powered != "" {
+                server = strings.TrimSpace(server + " " + powered)
+        }
+        if server == "" {
+                server = "unknown"
+        }
+
+        prompt := fmt.Sprintf(`These paths on %s answered 403 Forbidden. The server is: %s
+Suggest path-mangling variants that may reach the same resource past a path-based access rule on this server,
+such as a trailing %%2e or /., /./ segments, case flips, double slashes, ;.css or ;/ suffixes and URL encoding.
+Only use techniques that make sense for this server. Give each variant as a full path relative to the site root.
+Respond with a JSON object in the format: {"variants": ["admin/.", "ADMIN", "admin;.css", ...]}.
+No preamble or explanation needed.
+
+Paths:
+%s
+
+Response:`, config.URL, server, strings.Join(paths, "\n"))
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester testing access control bypasses on an authorized target. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   2000,
+                Temperature: 0.2,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var reply struct {
+                Variants []string `json:"variants"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, err
+        }
+
+        seen := make(map[string]bool)
+        for _, path := range paths {
+                seen[path] = true
+        }
+        var variants []string
+        for _, variant := range reply.Variants {
+                // FUZZ sits right after the root slash, so one leading slash is implied
+                variant = encodeUnsafePath(strings.TrimPrefix(strings.TrimSpace(variant), "/"))
+                if variant == "" || seen[variant] {
+                        continue
+                }
+                seen[variant] = true
+                variants = append(variants, variant)
+                if len(variants) == maxBypassVariants {
+                        break
+                }
+        }
+        if len(variants) == 0 {
+                return nil, fmt.Errorf("AI returned no usable path variants")
+        }
+        return variants, nil
+}
+
+// Replace the -u URL in ffuf arguments
+func withURL(args []string, target string) []string {
+        out := append([]string{}, args...)
+        for i := range out {
+                if out[i] == "-u" && i+1 < len(out) {
+                        out[i+1] = target
+                }
+        }
+        return out
+}
+
+// Fuzz path-mangling variants of the primary pass's 403 results from the
+// site root and report what answered differently
+func runBypassPass(config *Config, primary *FfufOutput) error {
+        if config.DryRun {
+                fmt.Fprintf(textOutput, "%sWould run a bypass pass on the 403 results%s\n", ColorGreen, ColorReset)
+                return nil
+        }
+        if primary == nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: no JSON results from the first pass, skipping the bypass pass%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+        paths := forbiddenPaths(primary)
+        if len(paths) == 0 {
+                fmt.Fprintf(textOutput, "%sNo 403 results, skipping the bypass pass%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+        variants, err := suggestPathVariants(ctx, config, paths)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: could not build bypass variants: %v%s\n", ColorYellow, err, ColorReset)
+                return nil
+        }
+        wordlist, err := writeTempWordlist(variants)
+        if err != nil {
+                return err
+        }
+        defer os.Remove(wordlist)
+
+        // ffuf's -u, which points at the --resolve IP when there is one
+        target, err := url.Parse(ffufFlagValue(config.FfufArgs, "-u"))
+        if err != nil {
+                return fmt.Errorf("parsing URL: %w", err)
+        }
+        fmt.Fprintf(textOutput, "%sRunning a bypass pass with %d variants of %d forbidden paths%s\n", ColorCyan, len(variants), len(paths), ColorReset)
+        pass := *config
+        pass.FfufArgs = withURL(withWordlist(config.FfufArgs, "FUZZ", wordlist), target.Scheme+"://"+target.Host+"/FUZZ")
+        output, err := executeFfuf(&pass, nil)
+        if err != nil {
+                return err
+        }
+
+        // Report the bypass pass apart from the primary one
+        fmt.Fprintf(textOutput, "\n%s%sBypass pass results:%s\n", ColorGreen, ColorBold, ColorReset)
+        forbidden := 0
+        for _, result := range primary.Results {
+                if result.Status == http.StatusForbidden {
+                        forbidden++
+                }
+        }
+        fmt.Fprintf(textOutput, "  Primary pass: %d results, %d answered 403\n", len(primary.Results), forbidden)
+        var changed []FfufResult
+        if output != nil {
+                for _, result := range output.Results {
+                        if result.Status != http.StatusForbidden && result.Status != http.StatusUnauthorized {
+                                changed = append(changed, result)
+                        }
+                }
+        }
+        fmt.Fprintf(textOutput, "  Bypass pass: %d variants, %d answered with something other than 401/403\n", len(variants), len(changed))
+        for _, result := range changed {
+                fmt.Fprintf(textOutput, "  %s%d%s %s (%d bytes)\n", ColorCyan, result.Status, ColorReset, result.URL, result.Length)
+        }
+        return nil
+}
+
+// Hit names sent to the AI by --mutate, and the most mutations kept per name and overall
+const (
+        maxMutationNames    = 30
+        maxMutationsPerName = 10
+        maxMutations        = 300
+)
+
+// Mutated names are single URL-safe path segments
+var mutationRegex = regexp.MustCompile(`^[A-Za-z0-9._~-]{1,64}$`)
+
+// Last path segment of every result, in result order without duplicates
+func hitNames(output *FfufOutput) []string {
+        var names []string
+        for _, result := range output.Results {
+                parsed, err := url.Parse(result.URL)
+                if err != nil {
+                        continue
+                }
+                name := path.Base(strings.TrimSuffix(parsed.Path, "/"))
+                if mutationRegex.MatchString(name) && !containsString(names, name) {
+                        names = append(names, name)
+                }
+        }
+        return names
+}
+
+// Ask the AI for realistic variants of found names (admin -> admin_old,
+// admin2) and map each usable one to the name it came from. Variants already
+// in known are skipped so the pass never repeats a request.
+func suggestMutations(ctx context.Context, config *Config, names []string, known map[string]bool) (map[string]string, []string, error) {
+        prompt := fmt.Sprintf(`ffuf found these names at %s:
+%s
+
+For each name, suggest up to %d realistic variants a developer or admin might have left next to it:
+numbered copies (admin2), old or backup copies (admin_old, admin-backup), test and dev copies (login_test),
+and renamed versions. Keep each variant a single path segment without slashes.
+Respond with a JSON object in the format: {"mutations": {"name": ["variant1", "variant2"]}}.
+No preamble or explanation needed.
+
+Response:`, config.URL, strings.Join(names, "\n"), maxMutationsPerName)
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a cybersecurity expert that builds targeted wordlists for web application fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   len(names)*maxMutationsPerName*8 + 200,
+                Temperature: 0.3,
+        })
+        if err != nil {
+                return nil, nil, err
+        }
+
+        var reply struct {
+                Mutations map[string][]string `json:"mutations"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, nil, err
+        }
+
+        // Walk the names in hit order so the overall cap favors the first hits
+        parents := make(map[string]string)
+        var mutations []string
+        for _, name := range names {
+                kept := 0
+                for _, variant := range reply.Mutations[name] {
+                        variant = strings.TrimSpace(variant)
+                        if kept == maxMutationsPerName || len(mutations) == maxMutations {
+                                break
+                        }
+                        if !mutationRegex.MatchString(variant) || known[variant] || parents[variant] != "" {
+                                continue
+                        }
+                        parents[variant] = name
+                        mutations = append(mutations, variant)
+                        kept++
+                }
+        }
+        if len(mutations) == 0 {
+                return nil, nil, fmt.Errorf("AI returned no new usable mutations")
+        }
+        return parents, mutations, nil
+}
+
+// Entries the first pass already requested: the keyword's wordlist words,
+// alone and with each extension, plus the found names
+func knownEntries(config *Config, extensions, names []string) map[string]bool {
+        known := make(map[string]bool)
+        for _, name := range names {
+                known[name] = true
+        }
+        wordlist, ok := ffufWordlists(config.FfufArgs)[config.Keyword]
+        if !ok {
+                return known
+        }
+        data, err := os.ReadFile(wordlist)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: could not read %s to skip known entries: %v%s\n", ColorYellow, wordlist, err, ColorReset)
+                return known
+        }
+        for _, word := range strings.Split(string(data), "\n") {
+                if word = strings.TrimSpace(word); word != "" {
+                        known[word] = true
+                        for _, ext := range extensions {
+                                known[word+ext] = true
+                        }
+                }
+        }
+        return known
+}
+
+// Fuzz AI-suggested variants of the names found by the first pass and report
+// each new hit with the finding it was derived from
+func runMutationPass(config *Config, extensions []string, primary *FfufOutput) error {
+        if config.DryRun {
+                fmt.Fprintf(textOutput, "%sWould run a mutation pass on the names found%s\n", ColorGreen, ColorReset)
+                return nil
+        }
+        if primary == nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: no JSON results from the first pass, skipping the mutation pass%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+        names := hitNames(primary)
+        if len(names) == 0 {
+                fmt.Fprintf(textOutput, "%sNo results to mutate, skipping the mutation pass%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+        if len(names) > maxMutationNames {
+                names = names[:maxMutationNames]
+        }
+
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+        parents, mutations, err := suggestMutations(ctx, config, names, knownEntries(config, extensions, names))
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: could not build mutations: %v%s\n", ColorYellow, err, ColorReset)
+                return nil
+        }
+        wordlist, err := writeTempWordlist(mutations)
+        if err != nil {
+                return err
+        }
+        defer os.Remove(wordlist)
+
+        // Mutations are complete names, so this pass needs no -e; filters carry over
+        fmt.Fprintf(textOutput, "%sRunning a mutation pass with %d variants of %d found names%s\n", ColorCyan, len(mutations), len(names), ColorReset)
+        pass := *config
+        pass.FfufArgs = withWordlist(config.FfufArgs, config.Keyword, wordlist)
+        output, err := executeFfuf(&pass, nil)
+        if err != nil {
+                return err
+        }
+
+        fmt.Fprintf(textOutput, "\n%s%sMutation pass results:%s\n", ColorGreen, ColorBold, ColorReset)
+        if output == nil || len(output.Results) == 0 {
+                fmt.Fprintf(textOutput, "  No hits among %d mutations\n", len(mutations))
+                return nil
+        }
+        for _, result := range output.Results {
+                parent := ""
+                if parsed, err := url.Parse(result.URL); err == nil {
+                        parent = parents[path.Base(parsed.Path)]
+                }
+                fmt.Fprintf(textOutput, "  %s%d%s %s (%d bytes)", ColorCyan, result.Status, ColorReset, result.URL, result.Length)
+                if parent != "" {
+                        fmt.Fprintf(textOutput, " from %s", parent)
+                }
+                fmt.Fprintln(textOutput)
+        }
+        return nil
+}
+
+// Directory in the --smart-recursion tree: the extensions picked for it and
+// the hits of its run, or why it was not fuzzed
+type recursionNode struct {
+        Path       string
+        Extensions []string
+        Hits       int
+        Skipped    string
+        Children   []*recursionNode
+}
+
+// State of a --smart-recursion run shared by all levels
+type smartRecursor struct {
+        config *Config
+        ctx    context.Context
+        // Scheme and host of the probes, and of ffuf's -u, which may be a
+        // --resolve IP
+        probeOrigin string
+        origin      string
+        words       int
+        used        int
+        visited     map[string]bool
+        exhausted   bool
+}
+
+// Fuzz the directories the first pass found, each with a fresh header probe
+// and extensions suggested for it, down to config.SmartRecursion levels and
+// within config.MaxTotalRequests. A tree of all levels is printed at the
+// end, also after an interrupt.
+func smartRecursion(config *Config, extensions []string, first *FfufOutput) error {
+        if config.DryRun {
+                fmt.Fprintf(textOutput, "%sWould fuzz the directories found, %d levels deep, each with its own extensions%s\n", ColorGreen, config.SmartRecursion, ColorReset)
+                return nil
+        }
+        wordlist := ffufWordlists(config.FfufArgs)["FUZZ"]
+        base, err := url.Parse(strings.TrimSuffix(config.URL, "FUZZ"))
+        if wordlist == "" || first == nil || !strings.HasSuffix(config.URL, "/FUZZ") || err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: --smart-recursion needs a URL ending in /FUZZ, a FUZZ wordlist and JSON results, skipping it%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+        ffufURL, err := url.Parse(ffufFlagValue(config.FfufArgs, "-u"))
+        if err != nil {
+                return fmt.Errorf("parsing URL: %w", err)
+        }
+        words, ok := config.WordlistLines[wordlist]
+        if !ok {
+                if words, err = countLines(wordlist); err != nil {
+                        return fmt.Errorf("reading wordlist: %w", err)
+                }
+        }
+
+        // An interrupt stops every pending level; during a run executeFfuf
+        // reports it as an error
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+        defer signal.Stop(sigChan)
+        go func() {
+                select {
+                case <-sigChan:
+                        cancel()
+                case <-ctx.Done():
+                }
+        }()
+
+        r := &smartRecursor{
+                config:      config,
+                ctx:         ctx,
+                probeOrigin: base.Scheme + "://" + base.Host,
+                origin:      ffufURL.Scheme + "://" + ffufURL.Host,
+                words:       words,
+                used:        words * (1 + len(extensions)),
+                visited:     map[string]bool{base.EscapedPath(): true},
+        }
+        root := &recursionNode{Path: base.EscapedPath(), Extensions: extensions, Hits: len(first.Results)}
+        fmt.Fprintf(textOutput, "%sSmart recursion into the directories found, %d levels deep%s\n", ColorCyan, config.SmartRecursion, ColorReset)
+        err = r.descend(root, first, 1)
+
+        fmt.Fprintf(textOutput, "\n%s%sSmart recursion results:%s\n", ColorGreen, ColorBold, ColorReset)
+        hits, fuzzed := printRecursionTree(root, "", "")
+        fmt.Fprintf(textOutput, "%d hits in %d directories, about %d requests\n", hits, fuzzed, r.used)
+        if ctx.Err() != nil && err == nil {
+                err = &interruptedError{what: "smart recursion"}
+        }
+        return err
+}
+
+// Fuzz each directory among a run's results and, above the last level, the
+// directories its own run finds
+func (r *smartRecursor) descend(parent *recursionNode, output *FfufOutput, depth int) error {
+        config := r.config
+        for _, dir := range directoryResults(output.Results) {
+                if r.ctx.Err() != nil {
+                        return nil
+                }
+                parsed, err := url.Parse(dir.URL)
+                if err != nil {
+                        continue
+                }
+                dirPath := strings.TrimSuffix(parsed.EscapedPath(), "/") + "/"
+                if r.visited[dirPath] {
+                        continue
+                }
+                r.visited[dirPath] = true
+                node := &recursionNode{Path: dirPath}
+                parent.Children = append(parent.Children, node)
+                fmt.Fprintf(textOutput, "%s%s└─ %s (level %d of %d)%s\n", ColorCyan, strings.Repeat("   ", depth-1), dirPath, depth, config.SmartRecursion, ColorReset)
+
+                // The child runs only what this directory needs; the analyses
+                // after the run cover the first pass
+                pass := *config
+                pass.URL = r.probeOrigin + dirPath + "FUZZ"
+                pass.FfufArgs = withURL(withoutFfufFlags(config.FfufArgs, "-o", "-of"), r.origin+dirPath+"FUZZ")
+                pass.Triage, pass.Severity, pass.RankRecursion, pass.NextSteps, pass.Teach = false, false, false, false, false
+                pass.RecordDir = ""
+                pass.PassResults = nil
+
+                node.Extensions = r.suggest(&pass, parent.Extensions)
+                cost := r.words * (1 + len(node.Extensions))
+                if config.MaxTotalRequests > 0 && r.used+cost > config.MaxTotalRequests {
+                        node.Skipped = "over the request budget"
+                        fmt.Fprintf(textOutput, "%sSkipping %s and the directories left: about %d more requests would pass --max-total-requests %d (%d used)%s\n",
+                                ColorYellow, dirPath, cost, config.MaxTotalRequests, r.used, ColorReset)
+                        r.exhausted = true
+                        return nil
+                }
+
+                result, err := executeFfuf(&pass, node.Extensions)
+                if err != nil {
+                        if r.ctx.Err() != nil {
+                                node.Skipped = "interrupted"
+                        }
+                        return err
+                }
+                r.used += cost
+                if result == nil {
+                        continue
+                }
+                node.Hits = len(result.Results)
+                if depth < config.SmartRecursion {
+                        if err := r.descend(node, result, depth+1); err != nil {
+                                return err
+                        }
+                }
+                if r.exhausted {
+                        return nil
+                }
+        }
+        return nil
+}
+
+// Extensions for a directory from a fresh header probe and AI suggestion,
+// or its parent's when either is unavailable. A replayed run reuses the
+// parent's, since only the first prompt was recorded.
+func (r *smartRecursor) suggest(pass *Config, parent []string) []string {
+        if pass.Replay != nil {
+                return parent
+        }
+        ctx, cancel := context.WithTimeout(r.ctx, aiPhaseTimeout(pass))
+        defer cancel()
+        probeURL := strings.TrimSuffix(pass.URL, "FUZZ")
+        headers, _, err := getHeaders(ctx, pass, probeURL)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: could not fetch headers from %s: %v%s\n", ColorYellow, probeURL, err, ColorReset)
+                headers = map[string]string{}
+        }
+        getExtensions := getAIExtensions
+        if pass.Ensemble {
+                getExtensions = getEnsembleExtensions
+        }
+        resp, err := getExtensions(ctx, pass.URL, headers, pass)
+        if err == nil {
+                if extensions := fuzzExtensions(pass, resp); len(extensions) > 0 {
+                        fmt.Fprintf(textOutput, "%sExtensions for %s: %s%s\n", ColorGreen, probeURL, strings.Join(extensions, ","), ColorReset)
+                        return extensions
+                }
+                err = fmt.Errorf("no extensions suggested")
+        }
+        if r.ctx.Err() == nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: %v, fuzzing %s with its parent's extensions%s\n", ColorYellow, err, probeURL, ColorReset)
+        }
+        return parent
+}
+
+// Print a recursion tree below its root line, returning the hits and the
+// number of directories fuzzed
+func printRecursionTree(node *recursionNode, branch, indent string) (int, int) {
+        hits, fuzzed := 0, 0
+        if node.Skipped != "" {
+                fmt.Fprintf(textOutput, "%s%s skipped, %s\n", branch, node.Path, node.Skipped)
+        } else {
+                fmt.Fprintf(textOutput, "%s%s %s%d hits%s (%s)\n", branch, node.Path, ColorCyan, node.Hits, ColorReset, strings.Join(node.Extensions, ","))
+                hits, fuzzed = node.Hits, 1
+        }
+        for i, child := range node.Children {
+                childBranch, childIndent := indent+"├─ ", indent+"│  "
+                if i == len(node.Children)-1 {
+                        childBranch, childIndent = indent+"└─ ", indent+"   "
+                }
+                childHits, childFuzzed := printRecursionTree(child, childBranch, childIndent)
+                hits += childHits
+                fuzzed += childFuzzed
+        }
+        return hits, fuzzed
+}
+
+// Extensions tried in one ffuf pass and the hits each produced
+type refinePass struct {
+        Extensions []string
+        Hits       map[string]int
+        Total      int
+}
+
+// Count results per extension by the end of their URL path
+func countExtensionHits(output *FfufOutput, extensions []string) refinePass {
+        pass := refinePass{Extensions: extensions, Hits: make(map[string]int)}
+        if output == nil {
+                return pass
+        }
+        pass.Total = len(output.Results)
+        for _, result := range output.Results {
+                path := strings.ToLower(result.URL)
+                if parsed, err := url.Parse(result.URL); err == nil {
+                        path = strings.ToLower(parsed.Path)
+                }
+                for _, ext := range extensions {
+                        if strings.HasSuffix(path, strings.ToLower(ext)) {
+                                pass.Hits[ext]++
+                                break
+                        }
+                }
+        }
+        return pass
+}
+
+// Append each extension to every word of a wordlist, leaving out the bare
+// words a previous pass already covered
+func extendWordlist(wordlist string, extensions []string) ([]string, error) {
+        data, err := os.ReadFile(wordlist)
+        if err != nil {
+                return nil, fmt.Errorf("reading wordlist: %w", err)
+        }
+        var names []string
+        for _, word := range strings.Split(string(data), "\n") {
+                word = strings.TrimSpace(word)
+                if word == "" || strings.HasPrefix(word, "#") {
+                        continue
+                }
+                for _, ext := range extensions {
+                        names = append(names, word+ext)
+                }
+        }
+        return names, nil
+}
+
+// Ask the AI for extensions to try next, given the hits of earlier passes
+func suggestRefinedExtensions(ctx context.Context, config *Config, passes []refinePass, tried map[string]bool, output *FfufOutput) ([]string, string, error) {
+        var history strings.Builder
+        for i, pass := range passes {
+                fmt.Fprintf(&history, "Pass %d (%d results):", i+1, pass.Total)
+                for _, ext := range pass.Extensions {
+                        fmt.Fprintf(&history, " %s=%d hits", ext, pass.Hits[ext])
+                }
+                history.WriteString("\n")
+        }
+        var found strings.Builder
+        if output != nil {
+                for i, result := range sampleResults(output.Results) {
+                        if i == 50 {
+                                break
+                        }
+                        fmt.Fprintf(&found, "%s status=%d\n", result.URL, result.Status)
+                }
+        }
+
+        prompt := fmt.Sprintf(`ffuf fuzzed %s with these extensions and got these hits per extension:
+%s
+Sample of found URLs:
+%s
+Based on what actually hit, suggest up to %d further file extensions worth fuzzing on this stack.
+Do not repeat any extension listed above and drop directions that produced no hits.
+Respond with a JSON object in the format: {"extensions": [".ext1", ...], "reason": "one sentence"}.
+Use an empty list if nothing else is worth trying. No preamble or explanation needed.
+
+Response:`, config.URL, history.String(), found.String(), config.MaxExtensions)
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      config.SystemPrompt,
+                Prompt:      prompt,
+                MaxTokens:   300,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return nil, "", err
+        }
+
+        var reply struct {
+                Extensions []string `json:"extensions"`
+                Reason     string   `json:"reason"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, "", err
+        }
+
+        var next []string
+        for _, ext := range cleanExtensions(reply.Extensions) {
+                if tried[strings.ToLower(ext)] {
+                        continue
+                }
+                tried[strings.ToLower(ext)] = true
+                next = append(next, ext)
+                if len(next) == config.MaxExtensions {
+                        break
+                }
+        }
+        return next, reply.Reason, nil
+}
+
+// Run up to config.Refine extra passes with extensions refined from the hits
+// so far. Each pass fuzzes only word+extension names not tried before.
+func refinePasses(config *Config, extensions []string, first *FfufOutput) error {
+        if config.DryRun {
+                fmt.Fprintf(textOutput, "%sWould run up to %d refinement passes based on the results%s\n", ColorGreen, config.Refine, ColorReset)
+                return nil
+        }
+        wordlist := ffufWordlists(config.FfufArgs)[config.Keyword]
+        if wordlist == "" || first == nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: --refine needs a %s wordlist and JSON results, skipping refinement%s\n", ColorYellow, config.Keyword, ColorReset)
+                return nil
+        }
+
+        // An interrupt while waiting on the AI stops the loop; during a pass
+        // executeFfuf reports it as an error
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+        defer signal.Stop(sigChan)
+        go func() {
+                select {
+                case <-sigChan:
+                        cancel()
+                case <-ctx.Done():
+                }
+        }()
+
+        tried := make(map[string]bool)
+        for _, ext := range extensions {
+                tried[strings.ToLower(ext)] = true
+        }
+        passes := []refinePass{countExtensionHits(first, extensions)}
+        output := first
+
+        for i := 1; i <= config.Refine; i++ {
+                next, reason, err := suggestRefinedExtensions(ctx, config, passes, tried, output)
+                if ctx.Err() != nil {
+                        return &interruptedError{what: "refinement"}
+                }
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not refine extensions: %v%s\n", ColorYellow, err, ColorReset)
+                        break
+                }
+                if len(next) == 0 {
+                        fmt.Fprintf(textOutput, "%sNo new extensions worth another pass%s\n", ColorYellow, ColorReset)
+                        break
+                }
+
+                names, err := extendWordlist(wordlist, next)
+                var path string
+                if err == nil {
+                        path, err = writeTempWordlist(names)
+                }
+                if err != nil {
+                        return err
+                }
+                fmt.Fprintf(textOutput, "%sRefinement pass %d with %s: %s%s\n", ColorCyan, i, strings.Join(next, ","), reason, ColorReset)
+                pass := *config
+                pass.FfufArgs = withWordlist(config.FfufArgs, config.Keyword, path)
+                output, err = executeFfuf(&pass, nil)
+                os.Remove(path)
+                if err != nil {
+                        return err
+                }
+                passes = append(passes, countExtensionHits(output, next))
+        }
+
+        fmt.Fprintf(textOutput, "\n%s%sHits per pass:%s\n", ColorGreen, ColorBold, ColorReset)
+        for i, pass := range passes {
+                var counts []string
+                for _, ext := range pass.Extensions {
+                        counts = append(counts, fmt.Sprintf("%s=%d", ext, pass.Hits[ext]))
+                }
+                label := "initial"
+                if i > 0 {
+                        label = fmt.Sprintf("refine %d", i)
+                }
+                fmt.Fprintf(textOutput, "  %-9s %d hits (%s)\n", label, pass.Total, strings.Join(counts, " "))
+        }
+        return nil
+}
+
+// Execute ffuf, in --ext-batch runs when there are more extensions than one
+// takes, and add the results to the run's. Returns the parsed results when
+// something analyzes them after the run.
+func executeFfuf(config *Config, extensions []string) (*FfufOutput, error) {
+        var output *FfufOutput
+        var err error
+        if config.ExtBatch > 0 && len(extensions) > config.ExtBatch {
+                output, err = executeBatches(config, extensions)
+        } else {
+                output, err = runFfuf(config, extensions)
+        }
+        if err != nil || output == nil {
+                return nil, err
+        }
+        if config.PassResults != nil {
+                config.PassResults.Results = mergeResults(config.PassResults.Results, output.Results)
+        }
+        return output, nil
+}
+
+// Append results, skipping URLs already listed
+func mergeResults(results, more []FfufResult) []FfufResult {
+        seen := make(map[string]bool, len(results))
+        for _, result := range results {
+                seen[result.URL] = true
+        }
+        for _, result := range more {
+                if !seen[result.URL] {
+                        seen[result.URL] = true
+                        results = append(results, result)
+                }
+        }
+        return results
+}
+
+// Run the analyses the flags ask for on the results of all passes
+func analyzeResults(config *Config, output *FfufOutput) {
+        if config.Severity {
+                scoreSeverity(config, output)
+        }
+        if config.Triage {
+                if err := triageResults(config, output); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: triage failed: %v%s\n", ColorYellow, err, ColorReset)
+                }
+        }
+        if config.RankRecursion {
+                if err := rankRecursion(config, output); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: recursion ranking failed: %v%s\n", ColorYellow, err, ColorReset)
+                }
+        }
+        if config.NextSteps {
+                if err := suggestNextSteps(config, output); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not suggest next steps: %v%s\n", ColorYellow, err, ColorReset)
+                }
+        }
+}
+
+// Run ffuf once with proper signal handling. Returns the parsed results
+// when something analyzes them after the run.
+func runFfuf(config *Config, extensions []string) (*FfufOutput, error) {
+        // Prepare ffuf command
+        ffufCmd := []string{config.FfufPath}
+        ffufCmd = append(ffufCmd, config.FfufArgs...)
+        if len(extensions) > 0 {
+                ffufCmd = append(ffufCmd, "-e", strings.Join(extensions, ","))
+        }
+        // --print-argv gives scripts the command without the -json ffufai
+        // adds to read the results itself, so ffuf's output stays its own
+        argv := append([]string{}, ffufCmd...)
+        if config.StreamResults && !hasFfufFlag(ffufCmd, "-json") {
+                ffufCmd = append(ffufCmd, "-json")
+        }
+
+        // Explain the command as built, without the temporary results file
+        // ffufai adds for its own analysis
+        if config.Teach {
+                if err := teachCommand(config, ffufCmd); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not explain the command: %v%s\n", ColorYellow, err, ColorReset)
+                }
+        }
+
+        if config.DryRun {
+                // The argv is for scripts, so it is printed unredacted
+                if config.PrintArgv {
+                        encoder := json.NewEncoder(argvOutput)
+                        encoder.SetEscapeHTML(false)
+                        if err := encoder.Encode(argv); err != nil {
+                                return nil, err
+                        }
+                }
+                fmt.Fprintf(textOutput, "%sWould execute: %s%s\n", ColorGreen, formatCommand(redactArgs(ffufCmd)), ColorReset)
+                if config.Ffufrc != "" {
+                        fmt.Fprintf(textOutput, "ffuf also reads %s: %s\n", config.Ffufrc, formatCommand(redactArgs(config.FfufrcArgs)))
+                        for _, note := range ffufrcOverrides(config, ffufCmd) {
+                                fmt.Fprintf(textOutput, "  %s\n", note)
+                        }
+                }
+                if analyzesResults(config) && ffufOutputFile(effectiveFfufArgs(config)) == "" {
+                        fmt.Fprintf(textOutput, "%sResults would also be written to a temporary JSON file (-o FILE -of json) for analysis%s\n", ColorGreen, ColorReset)
+                }
+                return nil, nil
+        }
+
+        // Result analysis reads ffuf's JSON output: the user's -o when it is
+        // JSON, otherwise a temp file
+        var resultsFile string
+        if analyzesResults(config) {
+                var err error
+                if resultsFile, err = resultsOutput(effectiveFfufArgs(config)); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %v, skipping result analysis%s\n", ColorYellow, err, ColorReset)
+                } else if ffufOutputFile(effectiveFfufArgs(config)) == "" {
+                        ffufCmd = append(ffufCmd, "-o", resultsFile, "-of", "json")
+                        if config.KeepArtifacts {
+                                defer fmt.Fprintf(textOutput, "Kept ffuf's JSON results in %s\n", resultsFile)
+                        } else {
+                                defer os.Remove(resultsFile)
+                        }
+                }
+        }
+
+        fmt.Fprintf(textOutput, "%sExecuting: %s%s\n", ColorBlue, formatCommand(redactArgs(ffufCmd)), ColorReset)
+
+        // The context stops --live-triage once ffuf is interrupted
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+
+        cmd := exec.Command(ffufCmd[0], ffufCmd[1:]...)
+
+        // Inherit stdout and stderr so we can see ffuf output; when streaming,
+        // ffufai reads ffuf's JSON result lines from stdout instead
+        var liveStdout io.ReadCloser
+        if config.StreamResults {
+                var err error
+                if liveStdout, err = cmd.StdoutPipe(); err != nil {
+                        return nil, fmt.Errorf("reading ffuf output: %w", err)
+                }
+        } else {
+                cmd.Stdout = os.Stdout
+        }
+        // ffuf's stderr is held back for its first seconds, so a failed start
+        // can be explained instead of dumped
+        stderr := &startupCapture{out: os.Stderr}
+        cmd.Stderr = stderr
+        cmd.Stdin = os.Stdin
+
+        // With a grace period ffuf runs in its own process group, which the
+        // terminal's Ctrl+C does not reach, so ffufai decides when to pass it
+        // on. A background group must not read the terminal, so ffuf gets no
+        // stdin then, and its interactive mode stays off.
+        grouped := config.InterruptGrace > 0 && setProcessGroup(cmd)
+        if info, err := os.Stdin.Stat(); grouped && err == nil && info.Mode()&os.ModeCharDevice != 0 {
+                cmd.Stdin = nil
+        }
+
+        // Handle interruption signals
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+        defer signal.Stop(sigChan)
+
+        if err := cmd.Start(); err != nil {
+                return nil, fmt.Errorf("ffuf execution failed: %w", err)
+        }
+        exited := make(chan struct{})
+        go stopFfufOnInterrupt(config, cmd.Process, grouped, sigChan, cancel, exited)
+        startup := time.AfterFunc(ffufStartupWindow, stderr.release)
+
+        // Run the command
+        var err error
+        if liveStdout != nil {
+                // The triager goes first so its last findings print before
+                // the tally
+                var consumers []resultConsumer
+                if config.LiveTriage {
+                        consumers = append(consumers, newLiveTriager(ctx, config))
+                }
+                consumers = append(consumers, newResultTally())
+                streamResults(liveStdout, consumers)
+                err = cmd.Wait()
+                for _, consumer := range consumers {
+                        consumer.close()
+                }
+        } else {
+                err = cmd.Wait()
+        }
+        close(exited)
+        failedAtStart := startup.Stop() && err != nil && ctx.Err() == nil
+        if failedAtStart {
+                if diagnosis := diagnoseFfufStartup(config, stderr.held()); diagnosis != "" {
+                        if config.Verbose {
+                                stderr.release()
+                        }
+                        return nil, fmt.Errorf("%s (ffuf %w)", diagnosis, err)
+                }
+        }
+        stderr.release()
+        if ctx.Err() == context.Canceled {
+                return nil, &interruptedError{what: "ffuf"}
+        }
+        if err != nil {
+                return nil, fmt.Errorf("ffuf execution failed: %w", err)
+        }
+
+        if resultsFile == "" {
+                return nil, nil
+        }
+        output, err := loadFfufOutput(resultsFile)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: %v, skipping result analysis%s\n", ColorYellow, err, ColorReset)
+                return nil, nil
+        }
+        return output, nil
+}
+
+// Most merged results listed after an --ext-batch run
+const maxBatchSummary = 100
+
+// Run ffuf once per --ext-batch extensions, config.ExtParallel at a time,
+// each writing its own temporary JSON file, and merge the results without
+// repeats into one summary and the -o file. After an interrupt the runs
+// still going stop and the rest are skipped; what finished is merged.
+func executeBatches(config *Config, extensions []string) (*FfufOutput, error) {
+        var batches [][]string
+        for start := 0; start < len(extensions); start += config.ExtBatch {
+                batches = append(batches, extensions[start:min(start+config.ExtBatch, len(extensions))])
+        }
+        combined := ffufOutputFile(effectiveFfufArgs(config))
+        args := withoutFfufFlags(config.FfufArgs, "-o", "-of")
+
+        if config.DryRun {
+                for i, batch := range batches {
+                        fmt.Fprintf(textOutput, "%sBatch %d of %d: %s%s\n", ColorCyan, i+1, len(batches), strings.Join(batch, ","), ColorReset)
+                        pass := *config
+                        pass.FfufArgs = args
+                        if _, err := runFfuf(&pass, batch); err != nil {
+                                return nil, err
+                        }
+                }
+                fmt.Fprintf(textOutput, "%sEach batch would write a temporary JSON file, merged", ColorGreen)
+                if combined != "" {
+                        fmt.Fprintf(textOutput, " into %s", combined)
+                }
+                fmt.Fprintf(textOutput, "%s\n", ColorReset)
+                return nil, nil
+        }
+
+        // An interrupt reaches the running ffufs too; this only keeps the
+        // remaining batches from starting
+        ctx, cancel := context.WithCancel(context.Background())
+        defer cancel()
+        sigChan := make(chan os.Signal, 1)
+        signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+        defer signal.Stop(sigChan)
+        go func() {
+                select {
+                case <-sigChan:
+                        cancel()
+                case <-ctx.Done():
+                }
+        }()
+
+        files := make([]string, len(batches))
+        errs := make([]error, len(batches))
+        slots := make(chan struct{}, config.ExtParallel)
+        var wg sync.WaitGroup
+        started := 0
+        for i, batch := range batches {
+                slots <- struct{}{}
+                if ctx.Err() != nil {
+                        break
+                }
+                file, err := os.CreateTemp("", "ffufai-batch-*.json")
+                if err != nil {
+                        errs[i] = fmt.Errorf("creating batch results file: %w", err)
+                        break
+                }
+                file.Close()
+                files[i] = file.Name()
+                started++
+
+                fmt.Fprintf(textOutput, "%sBatch %d of %d: %s%s\n", ColorCyan, i+1, len(batches), strings.Join(batch, ","), ColorReset)
+                pass := *config
+                pass.FfufArgs = append(append([]string{}, args...), "-o", files[i], "-of", "json")
+                wg.Add(1)
+                go func(i int, batch []string) {
+                        defer wg.Done()
+                        defer func() { <-slots }()
+                        _, errs[i] = runFfuf(&pass, batch)
+                }(i, batch)
+        }
+        wg.Wait()
+        if started < len(batches) {
+                fmt.Fprintf(os.Stderr, "%sSkipped batches %d to %d%s\n", ColorYellow, started+1, len(batches), ColorReset)
+        }
+
+        output, raw, duplicates, err := mergeBatchResults(files)
+        for _, file := range files {
+                if file == "" {
+                        continue
+                }
+                if config.KeepArtifacts {
+                        fmt.Fprintf(textOutput, "Kept batch results in %s\n", file)
+                } else {
+                        os.Remove(file)
+                }
+        }
+        if err != nil {
+                return nil, err
+        }
+        if combined != "" {
+                if err := os.WriteFile(combined, raw, 0o644); err != nil {
+                        return nil, fmt.Errorf("writing merged results: %w", err)
+                }
+        }
+
+        fmt.Fprintf(textOutput, "\n%s%sMerged results of %d of %d batches:%s %d unique, %d repeated across batches\n", ColorGreen, ColorBold, started, len(batches), ColorReset, len(output.Results), duplicates)
+        for i, result := range output.Results {
+                if i == maxBatchSummary {
+                        fmt.Fprintf(textOutput, "  ... and %d more\n", len(output.Results)-maxBatchSummary)
+                        break
+                }
+                fmt.Fprintf(textOutput, "  %s%d%s %s (%d bytes)\n", ColorCyan, result.Status, ColorReset, result.URL, result.Length)
+        }
+        if combined != "" {
+                fmt.Fprintf(textOutput, "Merged results written to %s\n", combined)
+        }
+
+        for _, err := range errs {
+                if err != nil {
+                        return output, err
+                }
+        }
+        if ctx.Err() != nil {
+                return output, &interruptedError{what: "ffuf"}
+        }
+        return output, nil
+}
+
+// Merge ffuf JSON results files, keeping the first result for each URL.
+// Returns the results, an ffuf-style JSON document of them with every field
+// ffuf wrote, and how many repeats were dropped. Missing or empty files,
+// from batches that never ran or were killed, are skipped.
+func mergeBatchResults(files []string) (*FfufOutput, []byte, int, error) {
+        var document struct {
+                CommandLine string            `json:"commandline"`
+                Time        string            `json:"time"`
+                Results     []json.RawMessage `json:"results"`
+                Config      json.RawMessage   `json:"config,omitempty"`
+        }
+        output := &FfufOutput{}
+        seen := make(map[string]bool)
+        duplicates := 0
+        for _, file := range files {
+                data, err := os.ReadFile(file)
+                if file == "" || err != nil || len(bytes.TrimSpace(data)) == 0 {
+                        continue
+                }
+                var batch struct {
+                        CommandLine string            `json:"commandline"`
+                        Results     []json.RawMessage `json:"results"`
+                        Config      json.RawMessage   `json:"config"`
+                }
+                if err := json.Unmarshal(data, &batch); err != nil {
+                        return nil, nil, 0, fmt.Errorf("parsing batch results %s: %w", file, err)
+                }
+                if document.CommandLine == "" {
+                        document.CommandLine, document.Config = batch.CommandLine, batch.Config
+                }
+                for _, raw := range batch.Results {
+                        var result FfufResult
+                        if err := json.Unmarshal(raw, &result); err != nil {
+                                return nil, nil, 0, fmt.Errorf("parsing batch results %s: %w", file, err)
+                        }
+                        if seen[result.URL] {
+                                duplicates++
+                                continue
+                        }
+                        seen[result.URL] = true
+                        document.Results = append(document.Results, raw)
+                        output.Results = append(output.Results, result)
+                }
+        }
+        document.Time = time.Now().Format(time.RFC3339)
+        if document.Results == nil {
+                document.Results = []json.RawMessage{}
+        }
+        raw, err := json.MarshalIndent(document, "", "  ")
+        if err != nil {
+                return nil, nil, 0, err
+        }
+        return output, raw, duplicates, nil
+}
+
+// On the first Ctrl+C or SIGTERM, give ffuf config.InterruptGrace to write
+// its results and print its summary, then kill it; a second Ctrl+C kills it
+// at once. Returns when ffuf exits.
+func stopFfufOnInterrupt(config *Config, process *os.Process, grouped bool, sigChan <-chan os.Signal, cancel context.CancelFunc, exited <-chan struct{}) {
+        select {
+        case <-sigChan:
+        case <-exited:
+                return
+        }
+        cancel()
+        if config.InterruptGrace == 0 {
+                fmt.Fprintf(os.Stderr, "\n%sReceived interrupt signal, stopping ffuf...%s\n", ColorRed, ColorReset)
+                process.Kill()
+                return
+        }
+
+        // Without a process group, as on Windows, the console's Ctrl+C
+        // already reached ffuf
+        fmt.Fprintf(os.Stderr, "\n%sReceived interrupt signal, giving ffuf %s to stop (Ctrl+C again to kill it)...%s\n", ColorRed, config.InterruptGrace, ColorReset)
+        if grouped {
+                signalProcessGroup(process.Pid, os.Interrupt)
+        }
+        timer := time.NewTimer(config.InterruptGrace)
+        defer timer.Stop()
+        select {
+        case <-exited:
+                return
+        case <-sigChan:
+                fmt.Fprintf(os.Stderr, "%sKilling ffuf%s\n", ColorRed, ColorReset)
+        case <-timer.C:
+                fmt.Fprintf(os.Stderr, "%sffuf did not stop within %s, killing it%s\n", ColorRed, config.InterruptGrace, ColorReset)
+        }
+        if !grouped || signalProcessGroup(process.Pid, os.Kill) != nil {
+                process.Kill()
+        }
+}
+
+// How long ffuf's stderr is held back, and most of it that is held
+const (
+        ffufStartupWindow = 2 * time.Second
+        maxStartupCapture = 64 * 1024
+)
+
+// Writer that holds what ffuf writes until release, or until it grows past
+// maxStartupCapture, and then passes everything through
+type startupCapture struct {
+        mu       sync.Mutex
+        out      io.Writer
+        buf      bytes.Buffer
+        released bool
+}
+
+func (c *startupCapture) Write(p []byte) (int, error) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        if !c.released && c.buf.Len()+len(p) <= maxStartupCapture {
+                return c.buf.Write(p)
+        }
+        c.flush()
+        return c.out.Write(p)
+}
+
+// Write out what is held and pass the rest through
+func (c *startupCapture) release() {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        c.flush()
+}
+
+func (c *startupCapture) flush() {
+        c.released = true
+        if c.buf.Len() > 0 {
+                c.out.Write(c.buf.Bytes())
+                c.buf.Reset()
+        }
+}
+
+// What is held so far
+func (c *startupCapture) held() string {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        return c.buf.String()
+}
+
+// ffuf's messages for the usual reasons it fails to start
+var (
+        ffufUnknownFlagRegex   = regexp.MustCompile(`flag provided but not defined: (-\S+)`)
+        ffufMissingValueRegex  = regexp.MustCompile(`flag needs an argument: (-\S+)`)
+        ffufInvalidValueRegex  = regexp.MustCompile(`invalid value "([^"]*)" for flag (-\S+): ([^\n]+)`)
+        ffufMissingFileRegex   = regexp.MustCompile(`(?:stat|open) ([^\n:]+): (no such file or directory|permission denied|is a directory)`)
+        ffufKeywordRegex       = regexp.MustCompile(`Keyword (\S+) defined, but not found`)
+        ffufURLRegex           = regexp.MustCompile(`parse "([^"]*)": ([^\n]+)`)
+        ffufWordlistNeededText = "Either -w or --input-cmd flag is required"
+)
+
+// A short explanation of why ffuf failed to start, from what it wrote to
+// stderr, or "" when the message is not a known one
+func diagnoseFfufStartup(config *Config, stderr string) string {
+        if match := ffufUnknownFlagRegex.FindStringSubmatch(stderr); match != nil {
+                version := config.FfufVersion
+                if version == "" {
+                        version = "this version"
+                }
+                return fmt.Sprintf("ffuf (%s) has no %s option; check its spelling, or run ffufai -h if it is meant for ffufai", version, match[1])
+        }
+        if match := ffufMissingValueRegex.FindStringSubmatch(stderr); match != nil {
+                return fmt.Sprintf("ffuf's %s option needs a value", match[1])
+        }
+        if match := ffufInvalidValueRegex.FindStringSubmatch(stderr); match != nil {
+                return fmt.Sprintf("ffuf rejected %s %q: %s", match[2], match[1], match[3])
+        }
+        if match := ffufMissingFileRegex.FindStringSubmatch(stderr); match != nil {
+                file := strings.TrimSpace(match[1])
+                problem := map[string]string{
+                        "no such file or directory": "does not exist",
+                        "permission denied":         "is not readable",
+                        "is a directory":            "is a directory",
+                }[match[2]]
+                for keyword, wordlist := range ffufWordlists(config.FfufArgs) {
+                        if wordlist == file {
+                                return fmt.Sprintf("the %s wordlist %s %s; check the -w path", keyword, file, problem)
+                        }
+                }
+                return fmt.Sprintf("ffuf could not open %s: it %s", file, problem)
+        }
+        if match := ffufKeywordRegex.FindStringSubmatch(stderr); match != nil {
+                return fmt.Sprintf("the %s keyword has a wordlist but appears nowhere in the URL, headers, method or -d body", match[1])
+        }
+        if match := ffufURLRegex.FindStringSubmatch(stderr); match != nil {
+                return fmt.Sprintf("ffuf could not parse the URL %s: %s", match[1], match[2])
+        }
+        if strings.Contains(stderr, ffufWordlistNeededText) {
+                return "ffuf needs a wordlist; pass -w, or --wordlist-dir or --gen-wordlist to have one picked or generated"
+        }
+        return ""
+}
+
+// Start cmd in a process group of its own. SysProcAttr has Setpgid only on
+// Unix, and a build-tagged helper file is not an option: the Makefile,
+// install.sh and "go run" build ffufai-improved.go on its own, since the
+// legacy ffufai.go next to it declares the same package main names. So the
+// field is set by name through reflect, and Windows, which has no such
+// field, reports false.
+func setProcessGroup(cmd *exec.Cmd) bool {
+        attr := &syscall.SysProcAttr{}
+        setpgid := reflect.ValueOf(attr).Elem().FieldByName("Setpgid")
+        if !setpgid.IsValid() {
+                return false
+        }
+        setpgid.SetBool(true)
+        cmd.SysProcAttr = attr
+        return true
+}
+
+// Send sig to every process in the group pid leads. On Unix os.FindProcess
+// never fails and Process.Signal passes the pid to kill(2) as is, and a
+// negative pid there signals the whole group. Only called for a group that
+// setProcessGroup created, so never on Windows.
+func signalProcessGroup(pid int, sig os.Signal) error {
+        group, err := os.FindProcess(-pid)
+        if err != nil {
+                return err
+        }
+        return group.Signal(sig)
+}
+
+// Whether anything reads ffuf's JSON results after the run
+func analyzesResults(config *Config) bool {
+        return config.Triage || config.Severity || config.RankRecursion || config.NextSteps || config.Refine > 0 || config.BypassPass || config.Mutate ||
+                config.SmartRecursion > 0 || config.StatsOut != ""
+}
+
+// Join a command for display so it can be pasted into the shell it runs
+// from: PowerShell on Windows, a POSIX shell elsewhere
+func formatCommand(args []string) string {
+        if runtime.GOOS == "windows" {
+                return powerShellCommand(args)
+        }
+        return shellCommand(args)
+}
+
+// Arguments PowerShell passes to a program literally without quoting. Commas
+// build arrays and @ splats, so unlike shellSafeRegex they are left out
+var powerShellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_./:=+\\-]+$`)
+
+// Join a command for PowerShell, single-quoting arguments that need it. A
+// quoted program needs the call operator to run instead of being echoed
+func powerShellCommand(args []string) string {
+        quoted := make([]string, len(args))
+        for i, arg := range args {
+                if powerShellSafeRegex.MatchString(arg) {
+                        quoted[i] = arg
+                        continue
+                }
+                // PowerShell also ends single-quoted strings at the curly
+                // quotes, and any of them is escaped by doubling it
+                var b strings.Builder
+                b.WriteByte('\'')
+                for _, r := range arg {
+                        if r == '\'' || r == '\u2018' || r == '\u2019' || r == '\u201a' || r == '\u201b' {
+                                b.WriteRune(r)
+                        }
+                        b.WriteRune(r)
+                }
+                b.WriteByte('\'')
+                quoted[i] = b.String()
+        }
+        command := strings.Join(quoted, " ")
+        if len(args) > 0 && quoted[0] != args[0] {
+                command = "& " + command
+        }
+        return command
+}
+
+// Most flags a --teach explanation lists
+const maxTeachFlags = 40
+
+// One flag of an ffuf command and what it does, as explained by --teach
+type FlagExplanation struct {
+        Flag        string `json:"flag"`
+        Explanation string `json:"explanation"`
+}
+
+// The command with the ffuf path, the target host and the wordlist paths
+// replaced by placeholders, so commands that differ only in those share an
+// explanation. The URL path stays since it shows where FUZZ sits.
+func normalizeTeachArgs(args []string) []string {
+        normalized := []string{"ffuf"}
+        for i := 1; i < len(args); i++ {
+                arg := args[i]
+                switch {
+                case (arg == "-u" || arg == "-w") && i+1 < len(args):
+                        normalized = append(normalized, arg, teachPlaceholder(arg, args[i+1]))
+                        i++
+                case strings.HasPrefix(arg, "-u=") || strings.HasPrefix(arg, "-w="):
+                        normalized = append(normalized, arg[:3]+teachPlaceholder(arg[:2], arg[3:]))
+                default:
+                        normalized = append(normalized, arg)
+                }
+        }
+        return normalized
+}
+
+// Placeholder for the value of -u or -w in a normalized command
+func teachPlaceholder(flag string, value string) string {
+        if flag == "-u" {
+                parsed, err := url.Parse(value)
+                if err != nil || parsed.Host == "" {
+                        return "TARGET_URL"
+                }
+                parsed.Host = "TARGET"
+                parsed.User = nil
+                return parsed.String()
+        }
+        // Keep the keyword of "file:KEYWORD"
+        if index := strings.LastIndex(value, ":"); index > 0 && wordlistKeywordRegex.MatchString(value[index+1:]) {
+                return "WORDLIST" + value[index:]
+        }
+        return "WORDLIST"
+}
+
+// Cache file for the explanation of a normalized command
+func teachCachePath(args []string) (string, error) {
+        dir, err := os.UserCacheDir()
+        if err != nil {
+                return "", err
+        }
+        sum := sha256.Sum256([]byte(strings.Join(args, "\x00")))
+        return filepath.Join(dir, "ffufai", "teach", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// Print a flag-by-flag explanation of the ffuf command for --teach. Each
+// distinct command is explained once and then read from the cache.
+func teachCommand(config *Config, ffufCmd []string) error {
+        args := normalizeTeachArgs(redactArgs(ffufCmd))
+        cachePath, cacheErr := teachCachePath(args)
+        if cacheErr == nil {
+                var flags []FlagExplanation
+                if data, err := os.ReadFile(cachePath); err == nil && json.Unmarshal(data, &flags) == nil && len(flags) > 0 {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Using the cached explanation in %s\n", cachePath)
+                        }
+                        printFlagExplanations(flags)
+                        return nil
+                }
+        }
+
+        prompt := fmt.Sprintf(`Explain this ffuf command to a junior penetration tester, flag by flag, in plain English.
+Cover every flag in the order it appears, including ones a wrapper added such as -e, matchers and filters.
+For each, say in one or two sentences what it does and why it matters for this run. TARGET stands for the
+target host and WORDLIST for a wordlist file; refer to them that way instead of guessing their values.
+Respond with a JSON object in the format: {"flags": [{"flag": "-e .php,.bak", "explanation": "..."}]}.
+No preamble or explanation needed.
+
+Command: %s
+Response:`, formatCommand(args))
+
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a patient penetration testing instructor explaining ffuf. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   1500,
+                Temperature: 0.0,
+        })
+        if err != nil {
+                return err
+        }
+
+        var reply struct {
+                Flags []FlagExplanation `json:"flags"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return err
+        }
+        var flags []FlagExplanation
+        for _, flag := range reply.Flags {
+                flag.Flag = strings.TrimSpace(flag.Flag)
+                flag.Explanation = strings.Join(strings.Fields(flag.Explanation), " ")
+                if flag.Flag == "" || flag.Explanation == "" {
+                        continue
+                }
+                flags = append(flags, flag)
+                if len(flags) == maxTeachFlags {
+                        break
+                }
+        }
+        if len(flags) == 0 {
+                return fmt.Errorf("no flag explanations in AI response")
+        }
+
+        // A cache that cannot be written only costs another call next time
+        if cacheErr == nil {
+                data, _ := json.MarshalIndent(flags, "", "  ")
+                err := os.MkdirAll(filepath.Dir(cachePath), 0o755)
+                if err == nil {
+                        err = os.WriteFile(cachePath, data, 0o644)
+                }
+                if err != nil && config.Verbose {
+                        fmt.Fprintf(textOutput, "Could not cache the explanation: %v\n", err)
+                }
+        }
+
+        printFlagExplanations(flags)
+        return nil
+}
+
+func printFlagExplanations(flags []FlagExplanation) {
+        fmt.Fprintf(textOutput, "\n%s%sWhat this command does:%s\n", ColorGreen, ColorBold, ColorReset)
+        for _, flag := range flags {
+                fmt.Fprintf(textOutput, "  %s%s%s\n      %s\n", ColorCyan, flag.Flag, ColorReset, flag.Explanation)
+        }
+        fmt.Fprintln(textOutput)
+}
+
+// Value of ffuf's -o option, or "" when results are not written to a file
+func ffufOutputFile(args []string) string {
+        return ffufFlagValue(args, "-o")
+}
+
+// Value of a single-dash ffuf option given as "-x value" or "-x=value"
+func ffufFlagValue(args []string, name string) string {
+        value := ""
+        for i, arg := range args {
+                if arg == name && i+1 < len(args) {
+                        value = args[i+1]
+                } else if strings.HasPrefix(arg, name+"=") {
+                        value = strings.TrimPrefix(arg, name+"=")
+                }
+        }
+        return value
+}
+
+// Choose the file result analysis will read: the user's -o if its format
+// is JSON or CSV, the .json file ffuf adds for -of all, otherwise a new
+// temporary file. The user's file is only ever read.
+func resultsOutput(args []string) (string, error) {
+        if output := ffufOutputFile(args); output != "" {
+                // ffuf writes JSON unless -of says otherwise
+                switch format := ffufFlagValue(args, "-of"); format {
+                case "", "json", "ejson", "csv", "ecsv":
+                        return output, nil
+                case "all":
+                        return output + ".json", nil
+                default:
+                        return "", fmt.Errorf("analyzing results needs JSON or CSV output but -of is %s", format)
+                }
+        }
+
+        file, err := os.CreateTemp("", "ffufai-results-*.json")
+        if err != nil {
+                return "", fmt.Errorf("creating results file: %w", err)
+        }
+        file.Close()
+        return file.Name(), nil
+}
+
+// ffuf JSON output; only the fields result analysis uses
+type FfufOutput struct {
+        Results []FfufResult `json:"results"`
+}
+
+type FfufResult struct {
+        URL              string `json:"url"`
+        Status           int    `json:"status"`
+        Length           int    `json:"length"`
+        Words            int    `json:"words"`
+        Lines            int    `json:"lines"`
+        RedirectLocation string `json:"redirectlocation"`
+}
+
+// Finding picked by the AI during triage
+type TriageFinding struct {
+        URL    string `json:"url"`
+        Reason string `json:"reason"`
+}
+
+// Most results sent to the model; larger runs are sampled
+const maxTriageResults = 300
+
+// Keep at most maxTriageResults, favoring rare status/size combinations since
+// responses identical to hundreds of others are rarely interesting
+func sampleResults(results []FfufResult) []FfufResult {
+        if len(results) <= maxTriageResults {
+                return results
+        }
+
+        signature := func(result FfufResult) string {
+                return fmt.Sprintf("%d/%d", result.Status, result.Words)
+        }
+        counts := make(map[string]int)
+        for _, result := range results {
+                counts[signature(result)]++
+        }
+
+        sorted := append([]FfufResult(nil), results...)
+        sort.SliceStable(sorted, func(i, j int) bool { return counts[signature(sorted[i])] < counts[signature(sorted[j])] })
+
+        // At most five results per signature leave room for the others
+        taken := make(map[string]int)
+        var sample []FfufResult
+        for _, result := range sorted {
+                if len(sample) == maxTriageResults {
+                        break
+                }
+                if taken[signature(result)] < 5 {
+                        taken[signature(result)]++
+                        sample = append(sample, result)
+                }
+        }
+        return sample
+}
+
+// Read an ffuf results file. Besides the JSON document of -of json and
+// ejson this takes a bare array of results, one result per line as -json
+// prints them, and the CSV of -of csv and ecsv. An empty file holds no
+// results, since some ffuf versions write nothing when nothing matched.
+func loadFfufOutput(path string) (*FfufOutput, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, fmt.Errorf("reading ffuf results: %w", err)
+        }
+        output, err := parseFfufOutput(data)
+        if err != nil {
+                return nil, fmt.Errorf("parsing ffuf results %s: %w", path, err)
+        }
+        return output, nil
+}
+
+func parseFfufOutput(data []byte) (*FfufOutput, error) {
+        data = bytes.TrimSpace(data)
+        output := &FfufOutput{}
+        switch {
+        case len(data) == 0:
+                return output, nil
+        case data[0] == '[':
+                if err := json.Unmarshal(data, &output.Results); err != nil {
+                        return nil, err
+                }
+                return output, nil
+        case data[0] != '{':
+                return parseFfufCSV(data)
+        }
+
+        // A results document, or a stream of single results
+        decoder := json.NewDecoder(bytes.NewReader(data))
+        for {
+                var value struct {
+                        Results *[]FfufResult `json:"results"`
+                        FfufResult
+                }
+                if err := decoder.Decode(&value); err == io.EOF {
+                        break
+                } else if err != nil {
+                        return nil, err
+                }
+                switch {
+                case value.Results != nil:
+                        output.Results = append(output.Results, *value.Results...)
+                case value.URL != "":
+                        output.Results = append(output.Results, value.FfufResult)
+                }
+        }
+        return output, nil
+}
+
+// Parse ffuf's CSV output by its header, which starts with one column per
+// fuzz keyword
+func parseFfufCSV(data []byte) (*FfufOutput, error) {
+        reader := csv.NewReader(bytes.NewReader(data))
+        reader.FieldsPerRecord = -1
+        records, err := reader.ReadAll()
+        if err != nil {
+                return nil, fmt.Errorf("neither JSON nor CSV: %w", err)
+        }
+        columns := make(map[string]int)
+        for i, name := range records[0] {
+                columns[strings.ToLower(name)] = i
+        }
+        if _, ok := columns["url"]; !ok {
+                return nil, fmt.Errorf("CSV without a url column")
+        }
+        field := func(record []string, name string) string {
+                if i, ok := columns[name]; ok && i < len(record) {
+                        return record[i]
+                }
+                return ""
+        }
+        number := func(record []string, name string) int {
+                value, _ := strconv.Atoi(field(record, name))
+                return value
+        }
+
+        output := &FfufOutput{}
+        for _, record := range records[1:] {
+                output.Results = append(output.Results, FfufResult{
+                        URL:              field(record, "url"),
+                        Status:           number(record, "status_code"),
+                        Length:           number(record, "content_length"),
+                        Words:            number(record, "content_words"),
+                        Lines:            number(record, "content_lines"),
+                        RedirectLocation: field(record, "redirectlocation"),
+                })
+        }
+        return output, nil
+}
+
+// Ask the AI for the most interesting results of a finished ffuf run and print them
+func triageResults(config *Config, output *FfufOutput) error {
+        if len(output.Results) == 0 {
+                fmt.Fprintf(textOutput, "%sNo ffuf results to triage%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+
+        sample := sampleResults(output.Results)
+        var summary strings.Builder
+        for _, result := range sample {
+                fmt.Fprintf(&summary, "%s status=%d length=%d words=%d lines=%d", result.URL, result.Status, result.Length, result.Words, result.Lines)
+                if result.RedirectLocation != "" {
+                        fmt.Fprintf(&summary, " redirect=%s", result.RedirectLocation)
+                }
+                summary.WriteString("\n")
+        }
+        note := ""
+        if len(sample) < len(output.Results) {
+                note = fmt.Sprintf(" (a sample of %d out of %d results, favoring unusual responses)", len(sample), len(output.Results))
+        }
+
+        prompt := fmt.Sprintf(`These are the results of an ffuf run against %s%s.
+Pick the %d most interesting findings for a penetration tester (exposed backups, configs, admin panels,
+unusual status codes or sizes, interesting redirects) and give a one-line reason for each.
+Respond with a JSON object in the format: {"findings": [{"url": "...", "reason": "..."}]}, most interesting first.
+No preamble or explanation needed.
+
+Results:
+%s
+Response:`, config.URL, note, config.TriageTop, summary.String())
+
+        fmt.Fprintf(textOutput, "%sTriaging %d ffuf results...%s\n", ColorCyan, len(output.Results), ColorReset)
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester triaging web fuzzing results. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   config.TriageTop*60 + 200,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return err
+        }
+
+        var reply struct {
+                Findings []TriageFinding `json:"findings"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return err
+        }
+        findings := reply.Findings
+        if len(findings) > config.TriageTop {
+                findings = findings[:config.TriageTop]
+        }
+
+        fmt.Fprintf(textOutput, "\n%s%sTop findings:%s\n", ColorGreen, ColorBold, ColorReset)
+        for i, finding := range findings {
+                fmt.Fprintf(textOutput, "%s%2d. %s%s - %s\n", ColorCyan, i+1, finding.URL, ColorReset, finding.Reason)
+        }
+
+        if config.TriageOut != "" {
+                var markdown strings.Builder
+                fmt.Fprintf(&markdown, "# ffufai triage for %s\n\n", config.URL)
+                if config.Stack != nil {
+                        fmt.Fprintf(&markdown, "Stack: %s\n\n", config.Stack)
+                }
+                if config.FfufVersion != "" {
+                        fmt.Fprintf(&markdown, "ffuf: %s\n\n", config.FfufVersion)
+                }
+                if config.AIContext != "" {
+                        fmt.Fprintf(&markdown, "AI context:\n\n> %s\n\n", strings.ReplaceAll(config.AIContext, "\n", "\n> "))
+                }
+                for i, finding := range findings {
+                        if severity, ok := config.Severities[finding.URL]; ok {
+                                fmt.Fprintf(&markdown, "%d. **%s** `%s` - %s\n", i+1, severity, finding.URL, finding.Reason)
+                        } else {
+                                fmt.Fprintf(&markdown, "%d. `%s` - %s\n", i+1, finding.URL, finding.Reason)
+                        }
+                }
+                if len(config.Severities) > 0 {
+                        markdown.WriteString("\n## Severity\n\n| Severity | Status | URL |\n| --- | --- | --- |\n")
+                        for _, level := range severityLevels {
+                                for _, result := range output.Results {
+                                        if config.Severities[result.URL] == level {
+                                                fmt.Fprintf(&markdown, "| %s | %d | `%s` |\n", level, result.Status, result.URL)
+                                        }
+                                }
+                        }
+                }
+                if len(config.Rationale) > 0 {
+                        markdown.WriteString("\n## Suggested extensions\n\n| Extension | Rationale |\n| --- | --- |\n")
+                        for _, row := range config.Rationale {
+                                fmt.Fprintf(&markdown, "| `%s` | %s |\n", row.Ext, strings.ReplaceAll(row.Reason, "|", "\\|"))
+                        }
+                }
+                if err := os.WriteFile(config.TriageOut, []byte(markdown.String()), 0o644); err != nil {
+                        return fmt.Errorf("writing triage file: %w", err)
+                }
+                fmt.Fprintf(textOutput, "%sTriage written to %s%s\n", ColorGreen, config.TriageOut, ColorReset)
+        }
+        return nil
+}
+
+// Batches of --live-triage results waiting for the AI; when the queue is
+// full, new batches are dropped so ffuf never waits on the model
+const liveTriageQueue = 4
+
+// Classifies ffuf results in batches while the scan runs and prints the
+// interesting ones as soon as the AI flags them
+type liveTriager struct {
+        ctx     context.Context
+        config  *Config
+        batches chan []FfufResult
+        done    chan struct{}
+
+        mu      sync.Mutex
+        pending []FfufResult
+        dropped int
+}
+
+// Start the AI worker and the timer that flushes partial batches. Batches
+// still queued when ctx ends are discarded.
+func newLiveTriager(ctx context.Context, config *Config) *liveTriager {
+        t := &liveTriager{
+                ctx:     ctx,
+                config:  config,
+                batches: make(chan []FfufResult, liveTriageQueue),
+                done:    make(chan struct{}),
+        }
+        go func() {
+                defer close(t.done)
+                for batch := range t.batches {
+                        if t.ctx.Err() != nil {
+                                continue
+                        }
+                        if err := t.classify(batch); err != nil && t.ctx.Err() == nil {
+                                fmt.Fprintf(os.Stderr, "\r\033[K%sWarning: live triage of %d results failed: %v%s\n", ColorYellow, len(batch), err, ColorReset)
+                        }
+                }
+        }()
+        go func() {
+                ticker := time.NewTicker(config.LiveTriageInterval)
+                defer ticker.Stop()
+                for {
+                        select {
+                        case <-ticker.C:
+                                t.mu.Lock()
+                                t.flush()
+                                t.mu.Unlock()
+                        case <-t.done:
+                                return
+                        }
+                }
+        }()
+        return t
+}
+
+func (t *liveTriager) add(result FfufResult) {
+        t.mu.Lock()
+        defer t.mu.Unlock()
+        t.pending = append(t.pending, result)
+        if len(t.pending) >= t.config.LiveTriageBatch {
+                t.flush()
+        }
+}
+
+// Queue the pending results without blocking; the caller holds t.mu
+func (t *liveTriager) flush() {
+        if len(t.pending) == 0 || t.batches == nil {
+                return
+        }
+        select {
+        case t.batches <- t.pending:
+        default:
+                t.dropped += len(t.pending)
+                fmt.Fprintf(os.Stderr, "\r\033[K%sWarning: live triage is falling behind, skipped %d results%s\n", ColorYellow, len(t.pending), ColorReset)
+        }
+        t.pending = nil
+}
+
+// Queue the last results and wait for the queued batches to be classified
+func (t *liveTriager) close() {
+        t.mu.Lock()
+        t.flush()
+        close(t.batches)
+        t.batches = nil
+        t.mu.Unlock()
+        <-t.done
+        if t.dropped > 0 {
+                fmt.Fprintf(os.Stderr, "%sLive triage skipped %d results; run --triage for a full review%s\n", ColorYellow, t.dropped, ColorReset)
+        }
+}
+
+// Ask the AI which results of a batch are interesting and print those
+func (t *liveTriager) classify(batch []FfufResult) error {
+        var summary strings.Builder
+        urls := make(map[string]bool)
+        for _, result := range batch {
+                urls[result.URL] = true
+                fmt.Fprintf(&summary, "%s status=%d length=%d words=%d", result.URL, result.Status, result.Length, result.Words)
+                if result.RedirectLocation != "" {
+                        fmt.Fprintf(&summary, " redirect=%s", result.RedirectLocation)
+                }
+                summary.WriteString("\n")
+        }
+
+        prompt := fmt.Sprintf(`These results just arrived from an ffuf scan of %s.
+Classify each as interesting or boring for a penetration tester (interesting: exposed backups, configs,
+admin panels, unusual status codes or sizes). List only the interesting ones with a one-line reason.
+Respond with a JSON object in the format: {"interesting": [{"url": "...", "reason": "..."}]}.
+No preamble or explanation needed.
+
+Results:
+%s
+Response:`, t.config.URL, summary.String())
+
+        ctx, cancel := context.WithTimeout(t.ctx, aiPhaseTimeout(t.config)/2)
+        defer cancel()
+        completion, err := askProviders(ctx, t.config, PromptInput{
+                System:      "You are a penetration tester triaging web fuzzing results as they arrive. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   len(batch)*40 + 200,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return err
+        }
+        var reply struct {
+                Interesting []TriageFinding `json:"interesting"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return err
+        }
+        for _, finding := range reply.Interesting {
+                if urls[finding.URL] {
+                        fmt.Fprintf(os.Stderr, "\r\033[K%s%s[!] %s%s - %s\n", ColorRed, ColorBold, finding.URL, ColorReset, finding.Reason)
+                }
+        }
+        return nil
+}
+
+// Receives ffuf's results while it runs; close is called once its output
+// has ended
+type resultConsumer interface {
+        add(result FfufResult)
+        close()
+}
+
+// Read ffuf's -json result lines, print a line for each result and pass it
+// to the consumers. Other lines are copied through unchanged. A line is only
+// parsed once it is complete, however the reads split it, and the last one
+// needs no line ending.
+func streamResults(r io.Reader, consumers []resultConsumer) {
+        reader := bufio.NewReader(r)
+        for {
+                line, err := reader.ReadBytes('\n')
+                if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 || err == nil {
+                        var result FfufResult
+                        if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("{")) || json.Unmarshal(line, &result) != nil || result.URL == "" {
+                                fmt.Fprintf(textOutput, "%s\n", line)
+                        } else {
+                                printResult(result)
+                                for _, consumer := range consumers {
+                                        consumer.add(result)
+                                }
+                        }
+                }
+                if err != nil {
+                        return
+                }
+        }
+}
+
+// Print one streamed result like ffuf does, colored by its status class,
+// over ffuf's progress line
+func printResult(result FfufResult) {
+        color := ""
+        switch {
+        case result.Status >= 500:
+                color = ColorRed
+        case result.Status >= 400:
+                color = ColorYellow
+        case result.Status >= 300:
+                color = ColorBlue
+        case result.Status >= 200:
+                color = ColorGreen
+        }
+        redirect := ""
+        if result.RedirectLocation != "" {
+                redirect = " -> " + result.RedirectLocation
+        }
+        fmt.Fprintf(textOutput, "\r\033[K%s[Status: %d, Size: %d, Words: %d, Lines: %d]%s %s%s\n", color, result.Status, result.Length, result.Words, result.Lines, ColorReset, result.URL, redirect)
+}
+
+// How often a terminal gets the running count of streamed results
+const tallyInterval = 15 * time.Second
+
+// Counts the streamed results by status, printing the count so far every
+// tallyInterval on a terminal and the total once ffuf is done
+type resultTally struct {
+        mu      sync.Mutex
+        counts  map[int]int
+        total   int
+        printed int
+        stop    chan struct{}
+}
+
+func newResultTally() *resultTally {
+        t := &resultTally{counts: make(map[int]int), stop: make(chan struct{})}
+        if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+                go func() {
+                        ticker := time.NewTicker(tallyInterval)
+                        defer ticker.Stop()
+                        for {
+                                select {
+                                case <-ticker.C:
+                                        t.mu.Lock()
+                                        if t.total != t.printed {
+                                                t.printed = t.total
+                                                fmt.Fprintf(os.Stderr, "\r\033[K%s%s so far%s\n", ColorCyan, t.summary(), ColorReset)
+                                        }
+                                        t.mu.Unlock()
+                                case <-t.stop:
+                                        return
+                                }
+                        }
+                }()
+        }
+        return t
+}
+
+func (t *resultTally) add(result FfufResult) {
+        t.mu.Lock()
+        defer t.mu.Unlock()
+        t.counts[result.Status]++
+        t.total++
+}
+
+// The count as "N results (200: 12, 301: 3)"; the caller holds t.mu
+func (t *resultTally) summary() string {
+        statuses := make([]int, 0, len(t.counts))
+        for status := range t.counts {
+                statuses = append(statuses, status)
+        }
+        sort.Ints(statuses)
+        parts := make([]string, len(statuses))
+        for i, status := range statuses {
+                parts[i] = fmt.Sprintf("%d: %d", status, t.counts[status])
+        }
+        if t.total == 0 {
+                return "no results"
+        }
+        noun := "results"
+        if t.total == 1 {
+                noun = "result"
+        }
+        return fmt.Sprintf("%d %s (%s)", t.total, noun, strings.Join(parts, ", "))
+}
+
+func (t *resultTally) close() {
+        close(t.stop)
+        t.mu.Lock()
+        defer t.mu.Unlock()
+        fmt.Fprintf(textOutput, "%sffuf reported %s%s\n", ColorCyan, t.summary(), ColorReset)
+}
+
+// Severity levels, most severe first
+const (
+        SeverityHigh     = "high"
+        SeverityMedium   = "medium"
+        SeverityLow      = "low"
+        SeverityInfo     = "info"
+        SeverityUnscored = "unscored"
+)
+
+var severityLevels = []string{SeverityHigh, SeverityMedium, SeverityLow, SeverityInfo, SeverityUnscored}
+
+// Local severity rule: a result matches when its URL path matches Pattern
+// (case-insensitive) and, if Status is set, its status is one of them
+type SeverityRule struct {
+        Pattern  string `json:"pattern"`
+        Status   []int  `json:"status"`
+        Severity string `json:"severity"`
+
+        regex *regexp.Regexp
+}
+
+// Built-in rules; rules from the config file are checked first
+var defaultSeverityRules = []SeverityRule{
+        {Pattern: `(^|/)\.(git|svn|hg|bzr)(/|$)`, Severity: SeverityHigh},
+        {Pattern: `(^|/)\.(env|htpasswd|npmrc|pgpass|aws|ssh|docker)`, Severity: SeverityHigh},
+        {Pattern: `\.(bak|backup|old|orig|save|swp|sql|sqlite|db|dump|tar|tgz|gz|zip|7z|rar|pem|key|kdbx)$`, Severity: SeverityHigh},
+        {Pattern: `~$`, Severity: SeverityHigh},
+        {Pattern: `(^|/)(wp-config\.php|web\.config|config\.php|settings\.py|id_rsa|id_dsa)$`, Severity: SeverityHigh},
+        {Pattern: `(^|/)(phpinfo|info)\.php$`, Severity: SeverityMedium},
+        {Pattern: `(^|/)(admin|administrator|phpmyadmin|adminer|manager|console|debug|actuator|jenkins|server-status|graphql|swagger|api-docs)(/|\.|$)`, Severity: SeverityMedium},
+        {Pattern: `\.(log|conf|cfg|config|ini|yml|yaml|properties|env)$`, Severity: SeverityMedium},
+        {Pattern: `(^|/)(\.ds_store|\.htaccess|crossdomain\.xml|composer\.(json|lock)|package(-lock)?\.json)$`, Severity: SeverityLow},
+}
+
+// Most ambiguous results sent to the AI; the rest stay unscored
+const maxSeverityAI = 100
+
+// Most classified results listed in the summary
+const maxSeverityListed = 50
+
+// Check a rule and compile its pattern
+func (r *SeverityRule) compile() error {
+        if !containsString(severityLevels[:4], r.Severity) {
+                return fmt.Errorf("severity rule %q: severity must be one of %s", r.Pattern, strings.Join(severityLevels[:4], ", "))
+        }
+        if r.Pattern == "" && len(r.Status) == 0 {
+                return fmt.Errorf("severity rule needs a pattern or a status")
+        }
+        regex, err := regexp.Compile("(?i)" + r.Pattern)
+        if err != nil {
+                return fmt.Errorf("severity rule %q: %w", r.Pattern, err)
+        }
+        r.regex = regex
+        return nil
+}
+
+func (r *SeverityRule) matches(path string, status int) bool {
+        if len(r.Status) > 0 {
+                found := false
+                for _, code := range r.Status {
+                        found = found || code == status
+                }
+                if !found {
+                        return false
+                }
+        }
+        return r.regex.MatchString(path)
+}
+
+// Settings read from the ffufai config file
+type FileConfig struct {
+        SeverityRules []SeverityRule `json:"severity_rules"`
+        // Favicon hash (as a decimal string) to product name
+        FaviconHashes map[string]string `json:"favicon_hashes"`
+        // Technology rules checked before the built-in ones
+        FingerprintRules []FingerprintRule `json:"fingerprint_rules"`
+}
+
+// ffufrc options ffufai checks before adding flags of its own, by section
+// and key, and the ffuf flags they stand for
+var ffufrcFlags = map[string]string{
+        "general.autocalibration": "-ac",
+        "general.rate":            "-rate",
+        "general.threads":         "-t",
+        "http.headers":            "-H",
+        "http.http2":              "-http2",
+        "http.method":             "-X",
+        "http.proxyurl":           "-x",
+        "http.recursion":          "-recursion",
+        "http.sni":                "-sni",
+        "input.extensions":        "-e",
+        "output.outputfile":       "-o",
+        "output.outputformat":     "-of",
+        "filter.lines":            "-fl",
+        "filter.regexp":           "-fr",
+        "filter.size":             "-fs",
+        "filter.status":           "-fc",
+        "filter.time":             "-ft",
+        "filter.words":            "-fw",
+        "matcher.lines":           "-ml",
+        "matcher.regexp":          "-mr",
+        "matcher.size":            "-ms",
+        "matcher.status":          "-mc",
+        "matcher.time":            "-mt",
+        "matcher.words":           "-mw",
+}
+
+// ffufrc flags that take no value, set by "true"
+var ffufrcBoolFlags = []string{"-ac", "-http2", "-recursion"}
+
+// ffufrc values that are ffuf's own defaults, as in its ffufrc.example, and
+// so set nothing
+var ffufrcDefaults = map[string][]string{
+        "general.rate":    {"0"},
+        "general.threads": {"40"},
+        "http.method":     {"GET"},
+        "matcher.status":  {"200,204,301,302,307,401,403,405,500", "200-299,301,302,307,401,403,405,500"},
+}
+
+// Quoted TOML strings, basic or literal
+var tomlStringRegex = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"|'([^']*)'`)
+
+// Where ffuf reads its default options, such as ~/.config/ffuf/ffufrc
+func defaultFfufrc() string {
+        dir := os.Getenv("XDG_CONFIG_HOME")
+        if dir == "" {
+                var err error
+                if dir, err = os.UserConfigDir(); err != nil {
+                        return ""
+                }
+        }
+        return filepath.Join(dir, "ffuf", "ffufrc")
+}
+
+// Read an ffufrc as ffuf flags. A missing file is only an error when ffuf's
+// -config named it.
+func loadFfufrc(path string, explicit bool) ([]string, error) {
+        if path == "" {
+                return nil, nil
+        }
+        data, err := os.ReadFile(path)
+        if errors.Is(err, os.ErrNotExist) && !explicit {
+                return nil, nil
+        }
+        if err != nil {
+                return nil, fmt.Errorf("reading ffuf config: %w", err)
+        }
+        args, err := parseFfufrc(string(data))
+        if err != nil {
+                return nil, fmt.Errorf("ffuf config %s: %w", path, err)
+        }
+        return args, nil
+}
+
+// The options of ffufrcFlags an ffufrc sets, in file order. Only the TOML
+// ffuf writes is understood: sections, and keys with a string, number,
+// boolean or string array value, which may span lines.
+func parseFfufrc(data string) ([]string, error) {
+        var args []string
+        section := ""
+        lines := strings.Split(data, "\n")
+        for i := 0; i < len(lines); i++ {
+                line := strings.TrimSpace(lines[i])
+                if line == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+                        section = strings.TrimSpace(strings.Trim(line, "[]"))
+                        continue
+                }
+                key, value, ok := strings.Cut(line, "=")
+                if !ok {
+                        continue
+                }
+                key, value = section+"."+strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+                start := i + 1
+                if strings.HasPrefix(value, "[") {
+                        for !strings.HasSuffix(strings.TrimSpace(tomlStringRegex.ReplaceAllString(stripTOMLComment(value), "")), "]") {
+                                if i++; i == len(lines) {
+                                        return nil, fmt.Errorf("line %d: unterminated array for %s", start, key)
+                                }
+                                value += "\n" + lines[i]
+                        }
+                }
+                flag, known := ffufrcFlags[key]
+                if !known {
+                        continue
+                }
+
+                var values []string
+                for _, match := range tomlStringRegex.FindAllStringSubmatch(value, -1) {
+                        if strings.HasPrefix(match[0], "'") {
+                                values = append(values, match[2])
+                        } else if unquoted, err := strconv.Unquote(match[0]); err == nil {
+                                values = append(values, unquoted)
+                        } else {
+                                return nil, fmt.Errorf("line %d: invalid string for %s", start, key)
+                        }
+                        if !strings.HasPrefix(value, "[") {
+                                break
+                        }
+                }
+                if len(values) == 0 && !strings.HasPrefix(value, "[") {
+                        values = []string{stripTOMLComment(value)}
+                }
+
+                for _, v := range values {
+                        switch {
+                        case containsString(ffufrcBoolFlags, flag):
+                                if v == "true" {
+                                        args = append(args, flag)
+                                }
+                        case v != "" && v != "false" && !containsString(ffufrcDefaults[key], v):
+                                args = append(args, flag, v)
+                        }
+                }
+        }
+        return args, nil
+}
+
+// A bare TOML value without its trailing comment
+func stripTOMLComment(value string) string {
+        if i := strings.Index(value, "#"); i >= 0 && !strings.ContainsAny(value[:i], `"'`) {
+                value = value[:i]
+        }
+        return strings.TrimSpace(value)
+}
+
+// ffuf's options as it will see them: the ffufrc's first, so that the
+// command line's own win
+func effectiveFfufArgs(config *Config) []string {
+        return append(append([]string{}, config.FfufrcArgs...), config.FfufArgs...)
+}
+
+// How the command overrides or repeats the ffufrc's options, one line each
+func ffufrcOverrides(config *Config, command []string) []string {
+        var notes []string
+        args := config.FfufrcArgs
+        for i := 0; i < len(args); i++ {
+                flag, value := args[i], ""
+                if !containsString(ffufrcBoolFlags, flag) && i+1 < len(args) {
+                        i++
+                        value = args[i]
+                }
+                switch {
+                case value == "" || !hasFfufFlag(command, flag):
+                case flag == "-H":
+                        name, _, _ := strings.Cut(value, ":")
+                        for _, line := range ffufFlagValues(command, "-H") {
+                                if other, _, _ := strings.Cut(line, ":"); strings.EqualFold(strings.TrimSpace(other), strings.TrimSpace(name)) && line != value {
+                                        notes = append(notes, fmt.Sprintf("the ffufrc and the command both set the %s header", strings.TrimSpace(name)))
+                                }
+                        }
+                case flag == "-e":
+                        ffufrcExts, _ := splitExtensions([]string{"-e", value})
+                        commandExts, _ := splitExtensions(command)
+                        merged := true
+                        for _, ext := range ffufrcExts {
+                                merged = merged && containsString(commandExts, ext)
+                        }
+                        if merged {
+                                notes = append(notes, fmt.Sprintf("-e %s from the ffufrc is merged into -e", value))
+                        } else {
+                                notes = append(notes, fmt.Sprintf("-e %s from the ffufrc is overridden by -e %s", value, strings.Join(commandExts, ",")))
+                        }
+                case ffufFlagValue(command, flag) != value:
+                        ours, theirs := redactArgs([]string{flag, ffufFlagValue(command, flag)}), redactArgs([]string{flag, value})
+                        notes = append(notes, fmt.Sprintf("%s from the ffufrc is overridden by %s", formatCommand(theirs), formatCommand(ours)))
+                }
+        }
+        return notes
+}
+
+// Default config file location, such as ~/.config/ffufai/config.json
+func defaultConfigFile() string {
+        dir, err := os.UserConfigDir()
+        if err != nil {
+                return ""
+        }
+        return filepath.Join(dir, "ffufai", "config.json")
+}
+
+// Read the config file. A missing file is only an error when it was named
+// with --config.
+func loadFileConfig(path string, explicit bool) (*FileConfig, error) {
+        fileConfig := &FileConfig{}
+        if path == "" {
+                return fileConfig, nil
+        }
+        data, err := os.ReadFile(path)
+        if errors.Is(err, os.ErrNotExist) && !explicit {
+                return fileConfig, nil
+        }
+        if err != nil {
+                return nil, fmt.Errorf("reading config file: %w", err)
+        }
+        decoder := json.NewDecoder(bytes.NewReader(data))
+        decoder.DisallowUnknownFields()
+        if err := decoder.Decode(fileConfig); err != nil {
+                return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+        }
+        return fileConfig, nil
+}
+
+// Favicon hashes from the config file, keyed by their numeric value
+func faviconHashes(fileConfig *FileConfig) (map[int32]string, error) {
+        hashes := make(map[int32]string)
+        for key, product := range fileConfig.FaviconHashes {
+                hash, err := strconv.ParseInt(strings.TrimSpace(key), 10, 32)
+                if err != nil {
+                        return nil, fmt.Errorf("favicon hash %q is not a 32-bit integer", key)
+                }
+                hashes[int32(hash)] = product
+        }
+        return hashes, nil
+}
+
+// Rules from the config file followed by the built-in ones, compiled
+func severityRules(fileConfig *FileConfig) ([]SeverityRule, error) {
+        rules := append(append([]SeverityRule(nil), fileConfig.SeverityRules...), defaultSeverityRules...)
+        for i := range rules {
+                if err := rules[i].compile(); err != nil {
+                        return nil, err
+                }
+        }
+        return rules, nil
+}
+
+// Severity of a result by the local rules; ok is false when no rule matched
+func localSeverity(rules []SeverityRule, result FfufResult) (string, bool) {
+        path := result.URL
+        if parsed, err := url.Parse(result.URL); err == nil {
+                path = parsed.Path
+        }
+        for i := range rules {
+                if rules[i].matches(path, result.Status) {
+                        return rules[i].Severity, true
+                }
+        }
+        return "", false
+}
+
+// Severity of a result no rule matched when the AI does not score it
+func statusSeverity(status int) string {
+        if status == http.StatusUnauthorized || status == http.StatusForbidden || status >= 500 {
+                return SeverityLow
+        }
+        return SeverityInfo
+}
+
+// Classify every result by the local rules and, with --severity-ai, ask the
+// AI about the ones no rule matched. Results the AI does not get to stay
+// unscored; classification never fails the run.
+func scoreSeverity(config *Config, output *FfufOutput) {
+        severities := make(map[string]string)
+        var ambiguous []FfufResult
+        for _, result := range output.Results {
+                if severity, ok := localSeverity(config.SeverityRules, result); ok {
+                        severities[result.URL] = severity
+                } else if config.SeverityAI {
+                        severities[result.URL] = SeverityUnscored
+                        ambiguous = append(ambiguous, result)
+                } else {
+                        severities[result.URL] = statusSeverity(result.Status)
+                }
+        }
+
+        if len(ambiguous) > 0 {
+                if len(ambiguous) > maxSeverityAI {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %d results match no severity rule, only %d are sent to the AI%s\n", ColorYellow, len(ambiguous), maxSeverityAI, ColorReset)
+                        ambiguous = sampleResults(ambiguous)
+                        if len(ambiguous) > maxSeverityAI {
+                                ambiguous = ambiguous[:maxSeverityAI]
+                        }
+                }
+                scored, err := aiSeverity(config, ambiguous)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: AI severity scoring failed, leaving %d results unscored: %v%s\n", ColorYellow, len(ambiguous), err, ColorReset)
+                }
+                for url, severity := range scored {
+                        if severities[url] == SeverityUnscored {
+                                severities[url] = severity
+                        }
+                }
+        }
+
+        config.Severities = severities
+        printSeverities(output.Results, severities)
+}
+
+// Ask the AI to score results no local rule matched
+func aiSeverity(config *Config, results []FfufResult) (map[string]string, error) {
+        var summary strings.Builder
+        for _, result := range results {
+                fmt.Fprintf(&summary, "%s status=%d length=%d", result.URL, result.Status, result.Length)
+                if result.RedirectLocation != "" {
+                        fmt.Fprintf(&summary, " redirect=%s", result.RedirectLocation)
+                }
+                summary.WriteString("\n")
+        }
+
+        prompt := fmt.Sprintf(`Rate the severity of these ffuf results from %s for a penetration test report as
+high (exposed secrets, source or backups), medium (admin panels, debug or config endpoints),
+low (minor information disclosure) or info (ordinary content).
+Respond with a JSON object in the format: {"scores": [{"url": "...", "severity": "..."}]}.
+No preamble or explanation needed.
+
+Results:
+%s
+Response:`, config.URL, summary.String())
+
+        fmt.Fprintf(textOutput, "%sScoring %d results with the AI...%s\n", ColorCyan, len(results), ColorReset)
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester rating web fuzzing results. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   len(results)*30 + 200,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return nil, err
+        }
+
+        var reply struct {
+                Scores []struct {
+                        URL      string `json:"url"`
+                        Severity string `json:"severity"`
+                } `json:"scores"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return nil, err
+        }
+        scored := make(map[string]string)
+        for _, score := range reply.Scores {
+                severity := strings.ToLower(strings.TrimSpace(score.Severity))
+                if containsString(severityLevels[:4], severity) {
+                        scored[score.URL] = severity
+                }
+        }
+        return scored, nil
+}
+
+func severityColor(severity string) string {
+        switch severity {
+        case SeverityHigh:
+                return ColorRed + ColorBold
+        case SeverityMedium:
+                return ColorYellow
+        case SeverityLow:
+                return ColorCyan
+        default:
+                return ""
+        }
+}
+
+// Print the classified results, most severe first, and a count per level
+func printSeverities(results []FfufResult, severities map[string]string) {
+        rank := make(map[string]int)
+        for i, level := range severityLevels {
+                rank[level] = i
+        }
+        sorted := append([]FfufResult(nil), results...)
+        sort.SliceStable(sorted, func(i, j int) bool { return rank[severities[sorted[i].URL]] < rank[severities[sorted[j].URL]] })
+
+        fmt.Fprintf(textOutput, "\n%s%sSeverity:%s\n", ColorGreen, ColorBold, ColorReset)
+        counts := make(map[string]int)
+        listed := 0
+        for _, result := range sorted {
+                severity := severities[result.URL]
+                counts[severity]++
+                if severity == SeverityInfo || listed == maxSeverityListed {
+                        continue
+                }
+                listed++
+                fmt.Fprintf(textOutput, "%s%-8s%s %d %s\n", severityColor(severity), severity, ColorReset, result.Status, result.URL)
+        }
+
+        var parts []string
+        for _, level := range severityLevels {
+                if counts[level] > 0 {
+                        parts = append(parts, fmt.Sprintf("%s%d %s%s", severityColor(level), counts[level], level, ColorReset))
+                }
+        }
+        fmt.Fprintf(textOutput, "%s\n", strings.Join(parts, ", "))
+}
+
+// Results that look like directories: a trailing slash, a redirect to the
+// same path with a slash added, or a denied path without an extension
+func directoryResults(results []FfufResult) []FfufResult {
+        var dirs []FfufResult
+        seen := make(map[string]bool)
+        for _, result := range results {
+                trimmed := strings.TrimSuffix(result.URL, "/")
+                last := trimmed[strings.LastIndex(trimmed, "/")+1:]
+                isDir := strings.HasSuffix(result.URL, "/") ||
+                        (result.RedirectLocation != "" && strings.HasSuffix(result.RedirectLocation, "/") && result.Status >= 300 && result.Status < 400) ||
+                        ((result.Status == http.StatusUnauthorized || result.Status == http.StatusForbidden) && !strings.Contains(last, "."))
+                if isDir && !seen[trimmed] {
+                        seen[trimmed] = true
+                        dirs = append(dirs, result)
+                }
+        }
+        return dirs
+}
+
+// Recursion target picked by the AI
+type RecursionCandidate struct {
+        URL    string `json:"url"`
+        Reason string `json:"reason"`
+}
+
+// Ask the AI which directories of an ffuf run are worth fuzzing next, print
+// them ranked and write them to config.RecursionOut as URL/FUZZ targets
+func rankRecursion(config *Config, output *FfufOutput) error {
+        dirs := directoryResults(output.Results)
+        if len(dirs) == 0 {
+                fmt.Fprintf(textOutput, "%sNo directory-like results to rank for recursion%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+        dirs = sampleResults(dirs)
+
+        var summary strings.Builder
+        known := make(map[string]bool)
+        for _, dir := range dirs {
+                fmt.Fprintf(&summary, "%s status=%d length=%d\n", dir.URL, dir.Status, dir.Length)
+                known[strings.TrimSuffix(dir.URL, "/")] = true
+        }
+
+        prompt := fmt.Sprintf(`These directories were found by an ffuf run. Rank the %d most promising ones to fuzz
+next for a penetration tester (admin areas, APIs, backups, uploads, internal tools rank high; static assets low)
+and give a one-line justification for each. Only use URLs from the list.
+Respond with a JSON object in the format: {"candidates": [{"url": "...", "reason": "..."}]}, best first.
+No preamble or explanation needed.
+
+Directories:
+%s
+Response:`, config.MaxRecursion, summary.String())
+
+        fmt.Fprintf(textOutput, "%sRanking %d directories for recursion...%s\n", ColorCyan, len(dirs), ColorReset)
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester planning the next fuzzing steps. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   config.MaxRecursion*60 + 200,
+                Temperature: 0.1,
+        })
+        if err != nil {
+                return err
+        }
+
+        var reply struct {
+                Candidates []RecursionCandidate `json:"candidates"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return err
+        }
+
+        // Drop URLs the model invented or repeated
+        var candidates []RecursionCandidate
+        for _, candidate := range reply.Candidates {
+                candidate.URL = strings.TrimSuffix(strings.TrimSpace(candidate.URL), "/")
+                if !known[candidate.URL] {
+                        continue
+                }
+                known[candidate.URL] = false
+                candidates = append(candidates, candidate)
+                if len(candidates) == config.MaxRecursion {
+                        break
+                }
+        }
+        if len(candidates) == 0 {
+                return fmt.Errorf("AI ranked none of the found directories")
+        }
+
+        fmt.Fprintf(textOutput, "\n%s%sRecursion candidates:%s\n", ColorGreen, ColorBold, ColorReset)
+        var targets strings.Builder
+        for i, candidate := range candidates {
+                fmt.Fprintf(textOutput, "%s%2d. %s/%s - %s\n", ColorCyan, i+1, candidate.URL, ColorReset, candidate.Reason)
+                targets.WriteString(candidate.URL + "/FUZZ\n")
+        }
+        if err := os.WriteFile(config.RecursionOut, []byte(targets.String()), 0o644); err != nil {
+                return fmt.Errorf("writing targets file: %w", err)
+        }
+        fmt.Fprintf(textOutput, "%sTargets written to %s%s\n", ColorGreen, config.RecursionOut, ColorReset)
+        return nil
+}
+
+// Follow-up scan proposed by the AI. Only the kind and path come from the
+// model; nextStepCommand builds the actual command.
+type NextStep struct {
+        Kind   string `json:"kind"`
+        Path   string `json:"path"`
+        Reason string `json:"reason"`
+}
+
+// Most next steps suggested after a run
+const maxNextSteps = 5
+
+// Paths a next step may target: plain segments, no traversal or query
+var nextStepPathRegex = regexp.MustCompile(`^(/[A-Za-z0-9._~%-]+)*/?$`)
+
+// Build the ffufai invocation for a next step, or nil if the step is unusable
+func nextStepCommand(config *Config, step NextStep) []string {
+        target, err := url.Parse(config.URL)
+        if err != nil || !nextStepPathRegex.MatchString(step.Path) || strings.Contains(step.Path, "..") {
+                return nil
+        }
+        origin := target.Scheme + "://" + target.Host
+        path := strings.TrimSuffix(step.Path, "/")
+
+        wordlist := ffufWordlists(config.FfufArgs)[config.Keyword]
+        if wordlist == "" {
+                wordlist = "wordlist.txt"
+        }
+
+        command := []string{"ffufai"}
+        if config.Provider != ProviderPerplexity {
+                command = append(command, "--provider", config.Provider)
+        }
+        switch step.Kind {
+        case "path":
+                command = append(command, "-u", origin+path+"/FUZZ")
+        case "param":
+                if path == "" {
+                        return nil
+                }
+                command = append(command, "-u", origin+path+"?FUZZ=1")
+        case "vhost":
+                command = append(command, "--suggest-vhosts", "-u", origin+"/FUZZ")
+        default:
+                return nil
+        }
+        return append(command, "-w", wordlist)
+}
+
+// Arguments a POSIX shell takes literally without quoting
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_./:=,@%+-]+$`)
+
+// Join a command for a POSIX shell, single-quoting arguments that need it
+func shellCommand(args []string) string {
+        quoted := make([]string, len(args))
+        for i, arg := range args {
+                if shellSafeRegex.MatchString(arg) {
+                        quoted[i] = arg
+                } else {
+                        quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+                }
+        }
+        return strings.Join(quoted, " ")
+}
+
+// Ask the AI where to go after a finished run and print ffufai commands for it
+func suggestNextSteps(config *Config, output *FfufOutput) error {
+        headersJSON, err := json.MarshalIndent(config.TargetHeaders, "", "  ")
+        if err != nil {
+                return fmt.Errorf("marshaling headers: %w", err)
+        }
+
+        var summary strings.Builder
+        sample := sampleResults(output.Results)
+        for _, result := range sample {
+                fmt.Fprintf(&summary, "%s status=%d length=%d\n", result.URL, result.Status, result.Length)
+        }
+        if len(sample) == 0 {
+                summary.WriteString("(no results)\n")
+        }
+
+        prompt := fmt.Sprintf(`An ffuf run against %s finished. Based on its results and the target's headers, propose
+up to %d concrete follow-up scans. Each step has a kind:
+- "path": fuzz inside a directory, e.g. {"kind": "path", "path": "/api/v2"}
+- "param": fuzz query parameter names on a found endpoint, e.g. {"kind": "param", "path": "/search"}
+- "vhost": fuzz virtual hosts on the same server, with "path": ""
+Paths are absolute paths on the same host without a query string.
+Respond with a JSON object in the format: {"steps": [{"kind": "...", "path": "...", "reason": "one sentence"}]}, best first.
+No preamble or explanation needed.
+
+Headers: %s
+
+Results:
+%s
+Response:`, config.URL, maxNextSteps, string(headersJSON), summary.String())
+
+        ctx, cancel := context.WithTimeout(context.Background(), aiPhaseTimeout(config))
+        defer cancel()
+
+        completion, err := askProviders(ctx, config, PromptInput{
+                System:      "You are a penetration tester planning follow-up web fuzzing. You respond only with valid JSON.",
+                Prompt:      prompt,
+                MaxTokens:   600,
+                Temperature: 0.2,
+        })
+        if err != nil {
+                return err
+        }
+
+        var reply struct {
+                Steps []NextStep `json:"steps"`
+        }
+        if err := decodeJSONReply(completion.Content, &reply); err != nil {
+                return err
+        }
+
+        var script strings.Builder
+        fmt.Fprintf(&script, "#!/bin/sh\n# Suggested next steps from ffufai for %s\n", config.URL)
+        fmt.Fprintf(textOutput, "\n%s%sSuggested next steps:%s\n", ColorGreen, ColorBold, ColorReset)
+        count := 0
+        seen := make(map[string]bool)
+        for _, step := range reply.Steps {
+                command := nextStepCommand(config, step)
+                if command == nil {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Dropping next step %+v\n", step)
+                        }
+                        continue
+                }
+                line := shellCommand(command)
+                if seen[line] {
+                        continue
+                }
+                seen[line] = true
+                count++
+                reason := strings.Join(strings.Fields(step.Reason), " ")
+                fmt.Fprintf(textOutput, "%s%d. %s%s\n   %s\n", ColorCyan, count, reason, ColorReset, line)
+                fmt.Fprintf(&script, "\n# %s\n%s\n", reason, line)
+                if count == maxNextSteps {
+                        break
+                }
+        }
+        if count == 0 {
+                fmt.Fprintf(textOutput, "%sNo usable next steps suggested%s\n", ColorYellow, ColorReset)
+                return nil
+        }
+
+        if config.NextStepsOut != "" {
+                if err := os.WriteFile(config.NextStepsOut, []byte(script.String()), 0o755); err != nil {
+                        return fmt.Errorf("writing next steps script: %w", err)
+                }
+                fmt.Fprintf(textOutput, "%sNext steps written to %s%s\n", ColorGreen, config.NextStepsOut, ColorReset)
+        }
+        return nil
+}
+
+func main() {
+        // Parse command line arguments; parseArgs displays the banner
+        config, err := parseArgs()
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sError: %v%s\n\n", ColorRed, err, ColorReset)
+                flag.Usage()
+                os.Exit(ExitUsage)
+        }
+
+        if config.PrintArgv {
+                textOutput = os.Stderr
         }
 
-        // If help or version requested, parse and handle immediately
-        if len(knownArgs) > 0 {
-                if err := fs.Parse(knownArgs); err != nil {
-                        return nil, err
+        if config.Command == CommandModels {
+                if err := runModels(config); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitAIFailure)
                 }
+                return
+        }
 
-                if showHelp {
-                        fs.Usage()
-                        os.Exit(0)
+        if config.Command == CommandBench {
+                if err := runBench(config); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitAIFailure)
                 }
+                return
+        }
 
-                if showVersion {
-                        displayBanner()
-                        fmt.Printf("ffufai version %s\n", Version)
-                        os.Exit(0)
+        if config.Command == CommandDoctor {
+                if err := runDoctor(config); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitFailure)
                 }
+                return
         }
 
-        // Normal argument parsing for actual execution
-        for i := 1; i < len(os.Args); i++ {
-                arg := os.Args[i]
+        if config.Command == CommandRecurse {
+                output, err := loadFfufOutput(config.ResultsFile)
+                if err == nil {
+                        err = rankRecursion(config, output)
+                }
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitFailure)
+                }
+                return
+        }
 
-                // Check if this is one of our flags
-                if arg == "--ffuf-path" || arg == "--max-extensions" || arg == "--model" ||
-                        arg == "--verbose" || arg == "--dry-run" || arg == "-u" || arg == "--version" || 
-                        arg == "--help" || arg == "-h" {
-                        knownArgs = append(knownArgs, arg)
-                        // If flag takes a value, include the next argument too
-                        if arg == "--ffuf-path" || arg == "--max-extensions" || arg == "--model" || arg == "-u" {
-                                if i+1 < len(os.Args) {
-                                        i++
-                                        knownArgs = append(knownArgs, os.Args[i])
-                                }
-                        }
-                } else {
-                        // This is an ffuf argument
-                        ffufArgs = append(ffufArgs, arg)
+        // Validate URL; loadRequest checked a --request that fuzzes only a
+        // header or the body
+        if config.Request == nil || strings.Contains(config.URL, config.Keyword) {
+                if err := validateURL(config.URL, config.Keyword); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitUsage)
                 }
         }
 
-        // Parse our known arguments
-        if err := fs.Parse(knownArgs); err != nil {
-                return nil, err
+        // A replayed exchange needs neither credentials nor network access
+        if config.ReplayFile != "" {
+                if config.Replay, err = loadExchange(config.ReplayFile); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitUsage)
+                }
         }
 
-        // Handle help and version (shouldn't reach here due to early check, but safety)
-        if showHelp {
-                fs.Usage()
-                os.Exit(0)
+        // Check credentials; with a fallback chain any usable provider will do
+        keyAvailable := config.Replay != nil
+        for _, provider := range config.Providers {
+                if _, err := newProvider(providerConfig(config, provider)); err == nil {
+                        keyAvailable = true
+                        break
+                }
+        }
+        if !keyAvailable {
+                _, err := newProvider(config)
+                fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                if envName := apiKeyEnv(config); envName != "" {
+                        fmt.Fprintf(os.Stderr, "Please set the %s environment variable.\n", envName)
+                }
+                if config.Provider == ProviderPerplexity {
+                        fmt.Fprintf(os.Stderr, "Get your API key from: https://www.perplexity.ai/settings/api\n")
+                }
+                os.Exit(ExitUsage)
         }
 
-        if showVersion {
-                displayBanner()
-                fmt.Printf("ffufai version %s\n", Version)
-                os.Exit(0)
+        // ffuf never verifies certificates, so only the probes change
+        if config.Insecure && config.Replay == nil {
+                fmt.Fprintf(os.Stderr, "%sWarning: TLS certificate verification is off for the target probes (--insecure)%s\n", ColorYellow, ColorReset)
+        }
+        resolveFfufTarget(config)
+        if target, err := url.Parse(config.URL); err == nil && config.DNSServer != "" && net.ParseIP(target.Hostname()) == nil && ffufFlagValue(config.FfufArgs, "-u") == config.URL {
+                fmt.Fprintf(os.Stderr, "%sWarning: ffuf has no DNS server option and resolves the target with the system's; --resolve pins it%s\n", ColorYellow, ColorReset)
         }
 
-        // Validate max extensions
-        if config.MaxExtensions < 1 || config.MaxExtensions > 10 {
-                return nil, fmt.Errorf("max-extensions must be between 1 and 10")
+        if config.Verbose {
+                if config.FfufVersion != "" {
+                        fmt.Fprintf(textOutput, "ffuf version: %s\n", config.FfufVersion)
+                }
+                fmt.Fprintf(textOutput, "Timeouts: %s per probe, %s per AI request\n", config.ProbeTimeout, config.AITimeout)
+                // ffuf's own -H User-Agent takes precedence over --user-agent
+                for _, header := range config.ProbeHeaders {
+                        if strings.EqualFold(header.Name, "User-Agent") {
+                                fmt.Fprintf(textOutput, "User-Agent: %s\n", header.Value)
+                        }
+                }
+                reportProxies(config)
+                for _, override := range config.Resolve {
+                        fmt.Fprintf(textOutput, "Resolve: %s:%s to %s\n", override.Host, override.Port, override.IP)
+                }
+                if config.DNSServer != "" {
+                        fmt.Fprintf(textOutput, "DNS server: %s\n", config.DNSServer)
+                }
+                if config.SNI != "" {
+                        fmt.Fprintf(textOutput, "SNI: %s\n", config.SNI)
+                }
+                if config.CACerts != nil {
+                        fmt.Fprintf(textOutput, "CA certificates: %d from %s, trusted with the system roots\n", config.CACertCount, config.CACert)
+                }
+                if len(config.ProbeHeaders) > 0 {
+                        var names []string
+                        for _, header := range config.ProbeHeaders {
+                                names = append(names, header.Name)
+                        }
+                        fmt.Fprintf(textOutput, "Probe headers: %s\n", strings.Join(names, ", "))
+                }
+                for _, header := range config.ProbeHeaders {
+                        if strings.EqualFold(header.Name, "Cookie") {
+                                fmt.Fprintf(textOutput, "Probe cookies: %s\n", cookieNames(strings.ReplaceAll(header.Value, ";", "\n")))
+                        }
+                }
         }
 
-        // Check if URL was provided
-        if urlFlag == "" {
-                return nil, fmt.Errorf("-u URL argument is required")
+        // An unreachable target would waste the AI call and the ffuf run
+        if config.Replay == nil {
+                if err := preflight(context.Background(), config, targetResolver, config.URL); err != nil {
+                        if !config.Force {
+                                fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                                fmt.Fprintf(os.Stderr, "Pass --force to run anyway.\n")
+                                os.Exit(ExitProbeFailure)
+                        }
+                        fmt.Fprintf(os.Stderr, "%sWarning: %v, continuing because of --force%s\n", ColorYellow, err, ColorReset)
+                }
         }
 
-        config.URL = urlFlag
+        // Create context with timeout for the entire operation
+        ctx, cancel := context.WithTimeout(context.Background(), max(5*time.Minute, 2*aiPhaseTimeout(config)))
+        defer cancel()
 
-        // Build ffuf arguments: add back the -u URL and remaining ffuf args
-        config.FfufArgs = []string{"-u", urlFlag}
-        config.FfufArgs = append(config.FfufArgs, ffufArgs...)
+        // Get headers from base URL
+        baseURL := strings.Replace(config.URL, config.Keyword, "", 1)
 
-        return config, nil
-}
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "%sAnalyzing target: %s%s\n", ColorBlue, baseURL, ColorReset)
+        }
+
+        // robots.txt and the sitemaps it names are fetched while the headers are
+        // probed
+        var robots *RobotsSummary
+        var sitemap *SitemapSummary
+        var scripts *ScriptSummary
+        var robotsErr, sitemapErr error
+        robotsDone := make(chan struct{})
+        if config.Replay == nil && (config.FetchRobots || config.FetchSitemap) {
+                go func() {
+                        defer close(robotsDone)
+                        if config.FetchRobots {
+                                robots, robotsErr = getRobots(ctx, config, config.URL)
+                        }
+                        if config.FetchSitemap {
+                                sitemap, sitemapErr = getSitemap(ctx, config, config.URL, robotsSitemaps(robots))
+                        }
+                }()
+        } else {
+                close(robotsDone)
+        }
 
+        // The host's DNS names are looked up while the headers are probed,
+        // unless a proxy should be the only one to resolve the target
+        var dnsInfo *DNSInfo
+        dnsDone := make(chan struct{})
+        if target, err := url.Parse(baseURL); err == nil && config.Replay == nil && config.DNS && targetProxy(config, baseURL) == nil {
+                go func() {
+                        defer close(dnsDone)
+                        dnsInfo = lookupDNS(ctx, targetResolver, target.Hostname())
+                }()
+        } else {
+                close(dnsDone)
+        }
 
-// Validate URL and provide helpful warnings
-func validateURL(urlStr string) error {
-        parsedURL, err := url.Parse(urlStr)
+        var headers, rawHeaders map[string]string
+        if config.Replay != nil {
+                headers = config.Replay.Headers
+        } else if config.Request != nil {
+                // The request's own headers stand in for the header probe, so
+                // there are no response headers to audit or fingerprint
+                headers = requestPromptHeaders(config.Request)
+        } else {
+                headers, config.Certificate, err = getHeaders(ctx, config, baseURL)
+                warnCertificate(config.Certificate)
+                if config.Verbose && config.Certificate != nil {
+                        fmt.Fprintf(textOutput, "TLS certificate for %s, issued by %s, valid until %s, names: %s\n", config.Certificate.Subject, config.Certificate.Issuer, config.Certificate.NotAfter.Format("2006-01-02"), strings.Join(config.Certificate.SANs, " "))
+                }
+        }
         if err != nil {
-                return fmt.Errorf("invalid URL format: %w", err)
+                fmt.Fprintf(os.Stderr, "%sWarning: Could not fetch headers from %s: %v%s\n", ColorYellow, baseURL, err, ColorReset)
+                headers = map[string]string{"Header": "Error fetching headers"}
+        } else {
+                if config.Verbose && config.Request != nil {
+                        fmt.Fprintf(textOutput, "%sUsing the %d headers of --request%s\n", ColorGreen, len(headers)-1, ColorReset)
+                } else if config.Verbose {
+                        fmt.Fprintf(textOutput, "%sRetrieved %d headers%s\n", ColorGreen, len(headers), ColorReset)
+                }
+                if config.Auth != "" && config.Replay == nil && config.Request == nil {
+                        reportAuth(config, headers)
+                }
+                // A replayed exchange holds the headers exactly as they were sent
+                if config.Request == nil {
+                        rawHeaders = headers
+                }
+                if config.Replay == nil && config.Request == nil && !config.FullHeaders {
+                        headers = compactHeaders(config, headers)
+                } else if value := headers["Set-Cookie"]; config.Replay == nil && probesWithCookies(config) && value != "" {
+                        // The target may hand the session back in Set-Cookie
+                        named := make(map[string]string, len(headers))
+                        for name, value := range headers {
+                                named[name] = value
+                        }
+                        named["Set-Cookie"] = cookieNames(value)
+                        headers = named
+                }
         }
+        config.TargetHeaders = headers
 
-        if parsedURL.Scheme == "" {
-                return fmt.Errorf("URL must include scheme (http:// or https://)")
+        <-robotsDone
+        if robotsErr != nil && config.Verbose {
+                fmt.Fprintf(textOutput, "Could not fetch robots.txt: %v\n", robotsErr)
+        }
+        if robots != nil {
+                config.Robots = robots.String()
+                if config.Verbose && len(robots.Disallow) > 0 {
+                        fmt.Fprintf(textOutput, "robots.txt disallows: %s\n", strings.Join(robots.Disallow, " "))
+                }
+        }
+        if sitemapErr != nil && config.Verbose {
+                fmt.Fprintf(textOutput, "Could not read the sitemaps: %v\n", sitemapErr)
+        }
+        if sitemap != nil {
+                config.Sitemap = sitemap.String()
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "Sitemaps list %d paths, extensions: %s\n", len(sitemap.Paths), strings.Join(sitemap.Extensions, " "))
+                }
         }
 
-        if parsedURL.Host == "" {
-                return fmt.Errorf("URL must include hostname")
+        // Page hints help both the stack and the extension prompt; a replayed
+        // exchange carries the ones it was recorded with
+        if config.Replay != nil {
+                config.PageHints = config.Replay.PageHints
+                config.Robots = config.Replay.Robots
+                config.Sitemap = config.Replay.Sitemap
+                config.FaviconMatch = config.Replay.Favicon
+                config.Certificate = config.Replay.Certificate
+                config.Technologies = config.Replay.Technologies
+                config.PathProbes = config.Replay.PathProbes
+                config.Scripts = config.Replay.Scripts
+                config.CSP = config.Replay.CSP
+                config.Links = config.Replay.Links
+                config.DNSInfo = config.Replay.DNS
+        } else {
+                links := &PageLinks{}
+                if config.BodyHints {
+                        if hints, pageLinks, err := getBodyHints(ctx, config, baseURL); err != nil {
+                                if config.Verbose {
+                                        fmt.Fprintf(textOutput, "No page hints: %v\n", err)
+                                }
+                        } else {
+                                config.PageHints, links = hints, pageLinks
+                                if config.Verbose {
+                                        fmt.Fprintf(textOutput, "Page hints:\n%s\n", config.PageHints)
+                                }
+                        }
+                }
+                if config.ScriptFiles > 0 {
+                        if scripts = scrapeScripts(ctx, config, baseURL, links.Scripts); scripts != nil {
+                                config.Scripts = scripts.String()
+                                if config.Verbose {
+                                        fmt.Fprintf(textOutput, "Scripts name %d endpoints, extensions: %s\n", len(scripts.Endpoints), strings.Join(scripts.Extensions, " "))
+                                }
+                        }
+                }
+                if config.Favicon {
+                        var misses []int32
+                        config.FaviconMatch, misses = fingerprintFavicon(ctx, config, baseURL, links.Icons)
+                        if config.Verbose {
+                                switch {
+                                case config.FaviconMatch != "":
+                                        fmt.Fprintf(textOutput, "Favicon matches %s\n", config.FaviconMatch)
+                                case len(misses) > 0:
+                                        fmt.Fprintf(textOutput, "Favicon hash %d matches no known product; name it under favicon_hashes in the config file\n", misses[0])
+                                }
+                        }
+                }
+                fingerprintHeaders := rawHeaders
+                if len(config.ProbePaths) > 0 && rawHeaders != nil {
+                        probes := probePaths(ctx, config, baseURL, rawHeaders)
+                        if config.Verbose {
+                                for _, probe := range probes {
+                                        fmt.Fprintf(textOutput, "Probed %s: %d\n", probe.Path, probe.Status)
+                                }
+                        }
+                        config.PathProbes = pathProbeSummary(probes)
+                        fingerprintHeaders = withProbeHeaders(rawHeaders, probes)
+                }
+                if config.Fingerprint {
+                        config.Technologies = fingerprintStack(config.FingerprintRules, fingerprintHeaders, config.PageHints, config.Robots+"\n"+config.Sitemap)
+                }
+                <-dnsDone
+                if config.DNSInfo = dnsInfo; dnsInfo != nil {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "DNS:\n%s\n", dnsInfo)
+                        }
+                        if dnsInfo.Platform != nil {
+                                if config.Technologies == nil {
+                                        config.Technologies = &StackDescriptor{}
+                                }
+                                fillStack(config.Technologies, *dnsInfo.Platform)
+                        }
+                }
+                if rawHeaders != nil {
+                        reportCSP(config, baseURL, rawHeaders)
+                        config.Links = interestingLinks(parseLinks(rawHeaders[ResponseURLHeader], rawHeaders["Link"]))
+                        if config.Verbose {
+                                for _, link := range config.Links {
+                                        fmt.Fprintf(textOutput, "Link header: %s\n", link)
+                                }
+                        }
+                }
+        }
+        if config.Technologies != nil {
+                fmt.Fprintf(textOutput, "%sDetected technologies: %s%s\n", ColorGreen, config.Technologies, ColorReset)
+        }
+        if config.Audit && rawHeaders != nil {
+                config.AuditFindings = auditHeaders(baseURL, rawHeaders)
+                printAudit(config.AuditFindings)
+        }
+        if rawHeaders != nil {
+                guardRate(ctx, config, baseURL, rawHeaders)
+                offerHTTP2(config, rawHeaders)
         }
 
-        if !strings.Contains(urlStr, "FUZZ") {
-                return fmt.Errorf("URL must contain the FUZZ keyword")
+        // Page paths from the sitemaps and endpoints from the scripts, as a
+        // wordlist for a later run
+        if config.SeedPathsOut != "" {
+                var seeds []string
+                if sitemap != nil {
+                        seeds = append(seeds, sitemap.Paths...)
+                }
+                if scripts != nil {
+                        for _, endpoint := range scripts.Endpoints {
+                                if seed := strings.TrimPrefix(endpoint, "/"); seed != "" && !containsString(seeds, seed) {
+                                        seeds = append(seeds, seed)
+                                }
+                        }
+                }
+                if len(seeds) == 0 {
+                        fmt.Fprintf(os.Stderr, "%sWarning: no sitemap paths or script endpoints found, not writing %s%s\n", ColorYellow, config.SeedPathsOut, ColorReset)
+                } else if err := os.WriteFile(config.SeedPathsOut, []byte(strings.Join(seeds, "\n")+"\n"), 0o644); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not write seed paths: %v%s\n", ColorYellow, err, ColorReset)
+                } else {
+                        fmt.Fprintf(textOutput, "%sWrote %d seed paths to %s%s\n", ColorGreen, len(seeds), config.SeedPathsOut, ColorReset)
+                }
         }
 
-        // Check if FUZZ is at the end of path for extension fuzzing
-        pathParts := strings.Split(parsedURL.Path, "/")
-        if len(pathParts) == 0 || !strings.Contains(pathParts[len(pathParts)-1], "FUZZ") {
-                fmt.Fprintf(os.Stderr, "%sWarning: FUZZ keyword is not at the end of the URL path. Extension fuzzing may not work as expected.%s\n", ColorYellow, ColorReset)
+        // Stage one: identify the stack, unless --stack named it or the replayed
+        // exchange recorded it
+        switch {
+        case config.Stack != nil:
+                fmt.Fprintf(textOutput, "%sUsing stack: %s%s\n", ColorGreen, config.Stack, ColorReset)
+        case config.Replay != nil:
+                if config.Stack = config.Replay.Stack; config.Stack != nil {
+                        fmt.Fprintf(textOutput, "%sRecorded stack: %s%s\n", ColorGreen, config.Stack, ColorReset)
+                }
+        default:
+                fmt.Fprintf(textOutput, "%sIdentifying the technology stack...%s\n", ColorCyan, ColorReset)
+                if config.Stack, err = detectStack(ctx, config, config.URL, headers, config.PageHints); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not identify the stack, suggesting extensions without it: %v%s\n", ColorYellow, err, ColorReset)
+                } else {
+                        fmt.Fprintf(textOutput, "%sDetected stack: %s%s\n", ColorGreen, config.Stack, ColorReset)
+                }
         }
 
-        return nil
-}
+        // Describe the keyword's wordlist so the extensions fit its entries
+        if wordlist, ok := ffufWordlists(config.FfufArgs)[config.Keyword]; ok && wordlist != "-" && config.WordlistContext {
+                if config.WordlistSample, err = wordlistCharacteristics(wordlist); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: skipping wordlist context: %v%s\n", ColorYellow, err, ColorReset)
+                } else if config.Verbose {
+                        fmt.Fprintf(textOutput, "Wordlist characteristics:\n%s\n", config.WordlistSample)
+                }
+        }
 
-// Execute ffuf with proper signal handling
-func executeFfuf(config *Config, extensions []string) error {
-        // Prepare ffuf command
-        ffufCmd := []string{config.FfufPath}
-        ffufCmd = append(ffufCmd, config.FfufArgs...)
-        ffufCmd = append(ffufCmd, "-e", strings.Join(extensions, ","))
+        // Matchers and filters the user passed, before ffufai adds any of its own
+        userFilters := hasUserFilters(config)
 
-        if config.DryRun {
-                fmt.Printf("%sWould execute: %s%s\n", ColorGreen, strings.Join(ffufCmd, " "), ColorReset)
-                return nil
+        // Calibrate against nonexistent paths; the misses also show whether the
+        // target is a single-page app before the extensions are asked for
+        var probes []CalibrationProbe
+        var probeErr error
+        if (config.SuggestFilter && config.Replay == nil) || (config.AutoCalibrate && !userFilters) {
+                if probes, probeErr = probeCalibration(ctx, config, config.URL); probeErr == nil && config.Verbose {
+                        for _, probe := range probes {
+                                fmt.Fprintf(textOutput, "Calibration /%s: status %d, %d bytes, %d words, %d lines\n", probe.Path, probe.Status, probe.Length, probe.Words, probe.Lines)
+                        }
+                }
+        }
+        if config.Replay != nil {
+                config.SPAShell = config.Replay.SPAShell
+        } else {
+                config.SPAShell = detectSPA(probes)
+        }
+        if config.SPAShell != "" {
+                fmt.Fprintf(os.Stderr, "%s%sWarning: random paths return the same HTML app shell (%s), this looks like a single-page app. Every path will match; its API, such as /api/%s with .json, is the likelier target%s\n", ColorYellow, ColorBold, config.SPAShell, config.Keyword, ColorReset)
         }
 
-        fmt.Printf("%sExecuting: %s%s\n", ColorBlue, strings.Join(ffufCmd, " "), ColorReset)
+        // Get AI suggestions for extensions
+        fmt.Fprintf(textOutput, "%sGetting AI suggestions for file extensions...%s\n", ColorCyan, ColorReset)
+        if config.Verbose {
+                fmt.Fprintf(textOutput, "System prompt: %s\n", config.SystemPrompt)
+                if config.AIContext != "" {
+                        fmt.Fprintf(textOutput, "AI context: %s\n", config.AIContext)
+                }
+        }
+        getExtensions := getAIExtensions
+        if config.Ensemble {
+                getExtensions = getEnsembleExtensions
+        }
+        extensionsResp, err := getExtensions(ctx, config.URL, headers, config)
+        var budgetErr *budgetError
+        if errors.As(err, &budgetErr) {
+                fmt.Fprintf(os.Stderr, "%sWarning: suggesting extensions from the headers without the AI%s\n", ColorYellow, ColorReset)
+                extensionsResp, err = heuristicExtensions(config, headers), nil
+        } else if err != nil && config.Technologies != nil && !isInvalidModelError(err) {
+                fmt.Fprintf(os.Stderr, "%sWarning: the AI is unavailable (%v), suggesting extensions from the detected technologies%s\n", ColorYellow, err, ColorReset)
+                extensionsResp, err = heuristicExtensions(config, headers), nil
+        }
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "%sError getting AI extensions: %v%s\n", ColorRed, err, ColorReset)
+                if isInvalidModelError(err) {
+                        if suggestion := suggestModel(ctx, config); suggestion != "" {
+                                fmt.Fprintf(os.Stderr, "Did you mean --model %s? Run '%s models' to list available models.\n", suggestion, os.Args[0])
+                        }
+                }
+                os.Exit(ExitAIFailure)
+        }
 
-        // Create command with context for cancellation
-        ctx, cancel := context.WithCancel(context.Background())
-        defer cancel()
+        if len(extensionsResp.Extensions) == 0 && len(config.UserExtensions) == 0 {
+                fmt.Fprintf(textOutput, "%sNo extensions suggested by AI.%s\n", ColorYellow, ColorReset)
+                os.Exit(ExitAIFailure)
+        }
 
-        cmd := exec.CommandContext(ctx, ffufCmd[0], ffufCmd[1:]...)
+        // Keep the most confident extensions up to maxExtensions, after the
+        // user's own -e ones
+        extensions := fuzzExtensions(config, extensionsResp)
+        if len(extensions) == 0 {
+                fmt.Fprintf(textOutput, "%sNo extensions reached --min-confidence %.2f.%s\n", ColorYellow, config.MinConfidence, ColorReset)
+                os.Exit(ExitAIFailure)
+        }
 
-        // Inherit stdout and stderr so we can see ffuf output
-        cmd.Stdout = os.Stdout
-        cmd.Stderr = os.Stderr
-        cmd.Stdin = os.Stdin
+        suggested := extensions[len(config.UserExtensions):]
+        if config.Verbose && extensionsResp.Confidence != nil {
+                fmt.Fprintf(textOutput, "%s%sAI suggested extensions: %s%s\n", ColorGreen, ColorBold, formatConfidences(suggested, extensionsResp.Confidence), ColorReset)
+        } else {
+                fmt.Fprintf(textOutput, "%s%sAI suggested extensions: %v%s\n", ColorGreen, ColorBold, suggested, ColorReset)
+        }
+        if len(config.UserExtensions) > 0 {
+                fmt.Fprintf(textOutput, "%sFuzzing extensions: %s (yours first, then the AI's new ones)%s\n", ColorGreen, strings.Join(extensions, ","), ColorReset)
+        }
+        if usage := totalTokenUsage(); config.Verbose && usage.TotalTokens > 0 {
+                fmt.Fprintf(textOutput, "Total token usage: %d prompt + %d completion = %d total\n", usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+        }
+        // Let the user refine the list before anything runs
+        if config.Command == CommandChat {
+                var accepted bool
+                extensionsResp, extensions, accepted = chatExtensions(context.Background(), config, headers, extensionsResp, extensions)
+                if !accepted {
+                        return
+                }
+                // The session may have outlasted the timeout; the rest gets a fresh one
+                ctx, cancel = context.WithTimeout(context.Background(), max(5*time.Minute, 2*aiPhaseTimeout(config)))
+                defer cancel()
+        }
+        if config.Interactive {
+                if !stdinIsTerminal() {
+                        fmt.Fprintf(os.Stderr, "%sWarning: --interactive needs a terminal on stdin, using the suggested extensions as they are%s\n", ColorYellow, ColorReset)
+                } else {
+                        edited, accepted, err := editExtensions(extensions)
+                        if err != nil || !accepted {
+                                if config.RequestCopy != "" && !config.DryRun {
+                                        os.Remove(config.RequestCopy)
+                                }
+                                if err != nil {
+                                        fmt.Fprintf(textOutput, "%sInterrupted at the prompt, ffuf was not run%s\n", ColorYellow, ColorReset)
+                                        os.Exit(exitCode(err))
+                                }
+                                fmt.Fprintf(textOutput, "%sAborted, ffuf was not run%s\n", ColorYellow, ColorReset)
+                                return
+                        }
+                        for _, ext := range edited {
+                                if !containsString(extensions, ext) {
+                                        if extensionsResp.Reasons == nil {
+                                                extensionsResp.Reasons = make(map[string]string)
+                                        }
+                                        extensionsResp.Reasons[ext] = addedExtensionReason
+                                }
+                        }
+                        extensions = edited
+                        fmt.Fprintf(textOutput, "%sFuzzing extensions: %s%s\n", ColorGreen, strings.Join(extensions, ","), ColorReset)
+                        ctx, cancel = context.WithTimeout(context.Background(), max(5*time.Minute, 2*aiPhaseTimeout(config)))
+                        defer cancel()
+                }
+        }
+        if config.Explain {
+                config.Rationale = explainExtensions(extensions, extensionsResp.Reasons)
+                printRationale(config.Rationale)
+        }
 
-        // Handle interruption signals
-        sigChan := make(chan os.Signal, 1)
-        signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+        // Apply the suggested HTTP method; with an explicit -X the AI is not
+        // asked, and a method it offers anyway is ignored
+        if method := strings.ToUpper(strings.TrimSpace(extensionsResp.Method)); config.AskMethod && method != "" {
+                if config.Verbose {
+                        fmt.Fprintf(textOutput, "AI suggested method %s: %s\n", method, extensionsResp.MethodReason)
+                }
+                switch {
+                case !containsString(allowedMethods, method):
+                        fmt.Fprintf(os.Stderr, "%sWarning: ignoring unsupported suggested method %q%s\n", ColorYellow, method, ColorReset)
+                case !optionsAllows(headers, method):
+                        fmt.Fprintf(os.Stderr, "%sWarning: ignoring suggested method %s, OPTIONS allows only %s%s\n", ColorYellow, method, headers[OptionsAllowHeader], ColorReset)
+                case containsString(stateChangingMethods, method) && !optionsLists(headers, method):
+                        fmt.Fprintf(textOutput, "%sAI suggested method %s, not applied since it changes server state and OPTIONS did not list it; pass -X %s to use it: %s%s\n", ColorYellow, method, method, extensionsResp.MethodReason, ColorReset)
+                case method == "GET" || hasFfufFlag(effectiveFfufArgs(config), "-X"):
+                        // GET is ffuf's default and the user's -X is never overridden
+                default:
+                        config.FfufArgs = append(config.FfufArgs, "-X", method)
+                        fmt.Fprintf(textOutput, "%sUsing HTTP method %s: %s%s\n", ColorGreen, method, extensionsResp.MethodReason, ColorReset)
+                }
+        }
 
-        go func() {
-                <-sigChan
-                fmt.Fprintf(os.Stderr, "\n%sReceived interrupt signal, stopping ffuf...%s\n", ColorRed, ColorReset)
-                cancel()
-        }()
+        // Apply the suggested match codes unless the user chose matchers or filters
+        if codes := strings.ToLower(strings.ReplaceAll(extensionsResp.MatchCodes, " ", "")); config.AutoMatchers && codes != "" {
+                switch {
+                case userFilters:
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "AI suggested -mc %s, not applied since you set matchers or filters\n", codes)
+                        }
+                case !validMatchCodes(codes):
+                        fmt.Fprintf(os.Stderr, "%sWarning: ignoring invalid suggested match codes %q%s\n", ColorYellow, codes, ColorReset)
+                default:
+                        config.FfufArgs = append(config.FfufArgs, "-mc", codes)
+                        fmt.Fprintf(textOutput, "%sMatching status codes %s: %s%s\n", ColorGreen, codes, extensionsResp.MatchReason, ColorReset)
+                }
+        }
 
-        // Run the command
-        err := cmd.Run()
-        if err != nil {
-                if ctx.Err() == context.Canceled {
-                        return fmt.Errorf("ffuf was interrupted")
+        // Let the AI pick a wordlist unless the user already chose one
+        if config.WordlistDir != "" && !hasMainWordlist(config.FfufArgs) {
+                wordlist, err := selectWordlist(ctx, config, headers)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError selecting wordlist: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitAIFailure)
                 }
-                return fmt.Errorf("ffuf execution failed: %w", err)
+                config.FfufArgs = append(config.FfufArgs, "-w", wordlist)
         }
 
-        return nil
-}
+        // Let the AI pick filters for the calibration noise
+        aiFilters := false
+        if config.SuggestFilter && config.Replay == nil {
+                var filters []string
+                var reason string
+                err := probeErr
+                if err == nil {
+                        filters, reason, err = suggestFilters(ctx, config, probes)
+                }
 
-func main() {
-        // Display banner first
-        displayBanner()
+                switch {
+                case err != nil:
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not suggest filters: %v%s\n", ColorYellow, err, ColorReset)
+                case len(filters) == 0:
+                        fmt.Fprintf(textOutput, "%sNo filters suggested%s\n", ColorYellow, ColorReset)
+                case userFilters:
+                        fmt.Fprintf(textOutput, "%sSuggested filters (not applied, you already set filters): %s - %s%s\n", ColorYellow, strings.Join(filters, " "), reason, ColorReset)
+                default:
+                        config.FfufArgs = append(config.FfufArgs, filters...)
+                        fmt.Fprintf(textOutput, "%sAdded filters %s: %s%s\n", ColorGreen, strings.Join(filters, " "), reason, ColorReset)
+                        aiFilters = true
+                }
+        }
 
-        // Parse command line arguments
-        config, err := parseArgs()
-        if err != nil {
-                fmt.Fprintf(os.Stderr, "%sError: %v%s\n\n", ColorRed, err, ColorReset)
-                flag.Usage()
-                os.Exit(1)
+        // Without AI filters, filter a wildcard target's misses locally; a
+        // single-page app's shell is filtered by its mount point
+        spaFilter := []string{"-fr", regexp.QuoteMeta(config.SPAShell)}
+        if config.AutoCalibrate && !userFilters && !aiFilters {
+                if probeErr != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not calibrate: %v%s\n", ColorYellow, probeErr, ColorReset)
+                } else if config.SPAShell != "" {
+                        config.FfufArgs = append(config.FfufArgs, spaFilter...)
+                        fmt.Fprintf(textOutput, "%sAdded %s to filter the app shell%s\n", ColorGreen, formatCommand(spaFilter), ColorReset)
+                } else if filters, warning := calibrationFilters(probes); len(filters) > 0 {
+                        config.FfufArgs = append(config.FfufArgs, filters...)
+                        fmt.Fprintf(textOutput, "%sRandom paths all answer %d with the same page, added %s%s\n", ColorGreen, probes[0].Status, strings.Join(filters, " "), ColorReset)
+                } else if warning != "" {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %s%s\n", ColorYellow, warning, ColorReset)
+                }
+        } else if config.SPAShell != "" {
+                fmt.Fprintf(textOutput, "%sFilter the app shell with %s%s\n", ColorYellow, formatCommand(spaFilter), ColorReset)
         }
 
-        // Validate URL
-        if err := validateURL(config.URL); err != nil {
-                fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
-                os.Exit(1)
+        // Suggest virtual hosts for a Host header fuzz
+        if config.SuggestVhosts && config.Replay == nil {
+                if path, added, err := suggestVhosts(ctx, config, baseURL); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not suggest virtual hosts: %v%s\n", ColorYellow, err, ColorReset)
+                } else {
+                        fmt.Fprintf(textOutput, "%sAdded %d virtual host candidates to %s%s\n", ColorGreen, len(added), path, ColorReset)
+                        fmt.Fprintf(textOutput, "Fuzz them with: %s\n", formatCommand([]string{config.FfufPath, "-w", path, "-u", baseURL, "-H", "Host: FUZZ", "-ac"}))
+                }
         }
 
-        // Get API key
-        apiKey, err := getAPIKey()
-        if err != nil {
-                fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
-                fmt.Fprintf(os.Stderr, "Please set the PERPLEXITY_API_KEY environment variable.\n")
-                fmt.Fprintf(os.Stderr, "Get your API key from: https://www.perplexity.ai/settings/api\n")
-                os.Exit(1)
+        // Add AI-suggested bypass headers when the target denies access
+        if status := probeStatus(headers); config.SuggestBypass && config.Replay == nil {
+                if status != http.StatusUnauthorized && status != http.StatusForbidden {
+                        if config.Verbose {
+                                fmt.Fprintf(textOutput, "Target answered %d, no bypass headers needed\n", status)
+                        }
+                } else if bypass, err := suggestBypassHeaders(ctx, config, headers); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not suggest bypass headers: %v%s\n", ColorYellow, err, ColorReset)
+                } else {
+                        for _, header := range bypass {
+                                config.FfufArgs = append(config.FfufArgs, "-H", header)
+                                fmt.Fprintf(textOutput, "%sAdded bypass header: %s%s\n", ColorGreen, header, ColorReset)
+                        }
+                }
         }
 
-        // Create context with timeout for the entire operation
-        ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-        defer cancel()
+        // Generate a target-specific wordlist; it replaces a missing -w or gets its own ffuf pass
+        var generatedPath string
+        if config.GenWordlist > 0 {
+                words, err := generateWordlist(ctx, config, headers)
+                if err == nil {
+                        generatedPath, err = writeTempWordlist(words)
+                }
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError generating wordlist: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitAIFailure)
+                }
 
-        // Get headers from base URL
-        baseURL := strings.Replace(config.URL, "FUZZ", "", 1)
+                fmt.Fprintf(textOutput, "%sAI generated %d words: %s%s\n", ColorGreen, len(words), generatedPath, ColorReset)
+                if config.DryRun {
+                        preview := words
+                        if len(preview) > 20 {
+                                preview = preview[:20]
+                        }
+                        fmt.Fprintf(textOutput, "Preview: %s\n", strings.Join(preview, ", "))
+                        fmt.Fprintf(textOutput, "The generated wordlist is kept for inspection in dry-run mode\n")
+                }
+        }
 
-        if config.Verbose {
-                fmt.Printf("%sAnalyzing target: %s%s\n", ColorBlue, baseURL, ColorReset)
+        // Without any wordlist or input command for FUZZ, ffuf gets a built-in
+        // one instead of failing after the probes and the AI calls
+        var builtinPath string
+        args := effectiveFfufArgs(config)
+        if config.GenWordlist == 0 && config.Keyword == "FUZZ" && !hasMainWordlist(args) &&
+                !hasFfufFlag(args, "-input-cmd") && !hasFfufFlag(args, "-input-file") {
+                name := builtinWordlistFor(config, headers)
+                var words int
+                if builtinPath, words, err = writeBuiltinWordlist(name); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitFailure)
+                }
+                config.FfufArgs = append(config.FfufArgs, "-w", builtinPath)
+                fmt.Fprintf(textOutput, "%sNo -w given, using the built-in %s wordlist (%d words); pass -w FILE to use your own%s\n", ColorGreen, name, words, ColorReset)
         }
 
-        headers, err := getHeaders(ctx, baseURL)
-        if err != nil {
-                fmt.Fprintf(os.Stderr, "%sWarning: Could not fetch headers from %s: %v%s\n", ColorYellow, baseURL, err, ColorReset)
-                headers = map[string]string{"Header": "Error fetching headers"}
-        } else if config.Verbose {
-                fmt.Printf("%sRetrieved %d headers%s\n", ColorGreen, len(headers), ColorReset)
+        // Fill a second keyword with AI-generated values unless the user bound it already
+        var valuesPath string
+        if keyword := config.GenValues; keyword != "" {
+                _, bound := ffufWordlists(config.FfufArgs)[keyword]
+                used := strings.Contains(config.URL, keyword) || strings.Contains(ffufFlagValue(config.FfufArgs, "-d"), keyword)
+                if config.Request != nil {
+                        used = len(requestKeywordPlaces(config.Request, keyword)) > 0
+                }
+                if !used {
+                        fmt.Fprintf(os.Stderr, "%sWarning: keyword %s not found in the URL or -d body, not generating values%s\n", ColorYellow, keyword, ColorReset)
+                } else if !bound {
+                        values, err := generateValues(ctx, config, headers)
+                        if err == nil {
+                                valuesPath, err = writeTempWordlist(values)
+                        }
+                        if err != nil {
+                                fmt.Fprintf(os.Stderr, "%sError generating values for %s: %v%s\n", ColorRed, keyword, err, ColorReset)
+                                os.Exit(ExitAIFailure)
+                        }
+                        config.FfufArgs = append(config.FfufArgs, "-w", valuesPath+":"+keyword)
+                        fmt.Fprintf(textOutput, "%sAI generated %d values for %s: %s%s\n", ColorGreen, len(values), keyword, valuesPath, ColorReset)
+                }
         }
 
-        // Get AI suggestions for extensions
-        fmt.Printf("%sGetting AI suggestions for file extensions...%s\n", ColorCyan, ColorReset)
-        extensionsResp, err := getAIExtensions(ctx, config.URL, headers, apiKey, config)
-        if err != nil {
-                fmt.Fprintf(os.Stderr, "%sError getting AI extensions: %v%s\n", ColorRed, err, ColorReset)
-                os.Exit(1)
+        // Compose backup and leftover names from the FUZZ wordlist for an extra pass
+        var backupsPath string
+        if config.Backups {
+                source := ffufWordlists(config.FfufArgs)[config.Keyword]
+                if source == "" {
+                        source = generatedPath
+                }
+                if source == "" {
+                        fmt.Fprintf(os.Stderr, "%sWarning: --backups needs a %s wordlist, skipping the backup pass%s\n", ColorYellow, config.Keyword, ColorReset)
+                } else {
+                        patterns, err := suggestBackupPatterns(ctx, config, headers, extensions)
+                        var names []string
+                        if err == nil {
+                                names, err = composeBackupNames(source, extensions, patterns)
+                        }
+                        if err == nil {
+                                backupsPath, err = writeTempWordlist(names)
+                        }
+                        if err != nil {
+                                fmt.Fprintf(os.Stderr, "%sError preparing backup pass: %v%s\n", ColorRed, err, ColorReset)
+                                os.Exit(ExitAIFailure)
+                        }
+                        fmt.Fprintf(textOutput, "%sAI suggested backup patterns: %s%s\n", ColorGreen, strings.Join(patterns, " "), ColorReset)
+                        if len(names) == maxBackupNames {
+                                fmt.Fprintf(os.Stderr, "%sWarning: backup names capped at %d%s\n", ColorYellow, maxBackupNames, ColorReset)
+                        }
+                        if config.Verbose || config.DryRun {
+                                fmt.Fprintf(textOutput, "Composed %d backup names: %s\n", len(names), backupsPath)
+                        }
+                }
         }
 
-        if len(extensionsResp.Extensions) == 0 {
-                fmt.Printf("%sNo extensions suggested by AI.%s\n", ColorYellow, ColorReset)
-                os.Exit(1)
+        if config.DryRun {
+                wordlists := ffufWordlists(config.FfufArgs)
+                keywords := make([]string, 0, len(wordlists))
+                for keyword := range wordlists {
+                        keywords = append(keywords, keyword)
+                }
+                sort.Strings(keywords)
+                for _, keyword := range keywords {
+                        fmt.Fprintf(textOutput, "Wordlist for %s: %s\n", keyword, wordlists[keyword])
+                }
         }
 
-        // Limit extensions to maxExtensions
-        extensions := extensionsResp.Extensions
-        if len(extensions) > config.MaxExtensions {
-                extensions = extensions[:config.MaxExtensions]
+        // ffuf gets the decrypted client key only while it runs
+        var clientKeyPath string
+        if config.ClientKeyPEM != nil && !config.DryRun {
+                if clientKeyPath, err = writeClientKey(config); err != nil {
+                        fmt.Fprintf(os.Stderr, "%sError: writing the decrypted client key for ffuf: %v%s\n", ColorRed, err, ColorReset)
+                        os.Exit(ExitFailure)
+                }
         }
 
-        fmt.Printf("%s%sAI suggested extensions: %v%s\n", ColorGreen, ColorBold, extensions, ColorReset)
+        // Dry runs list these with the command
+        if config.Ffufrc != "" && !config.DryRun {
+                command := append(append([]string{}, config.FfufArgs...), "-e", strings.Join(extensions, ","))
+                for _, note := range ffufrcOverrides(config, command) {
+                        fmt.Fprintf(os.Stderr, "%sWarning: %s%s\n", ColorYellow, note, ColorReset)
+                }
+        }
 
         // Execute ffuf
-        if err := executeFfuf(config, extensions); err != nil {
+        err = runFfufPasses(config, extensions, generatedPath, backupsPath)
+        for _, path := range []string{generatedPath, builtinPath, valuesPath, backupsPath, clientKeyPath, config.RequestCopy} {
+                if path != "" && !config.DryRun {
+                        os.Remove(path)
+                }
+        }
+        // Written even when ffuf failed or was interrupted, with what it found
+        if config.StatsOut != "" {
+                if statsErr := writeRunStats(config, extensions); statsErr != nil {
+                        fmt.Fprintf(os.Stderr, "%sWarning: could not write --stats-out: %v%s\n", ColorYellow, statsErr, ColorReset)
+                } else if config.Verbose {
+                        fmt.Fprintf(textOutput, "Run statistics written to %s\n", config.StatsOut)
+                }
+        }
+        if err != nil {
                 fmt.Fprintf(os.Stderr, "%sError: %v%s\n", ColorRed, err, ColorReset)
-                os.Exit(1)
+                os.Exit(exitCode(err))
         }
 
+        printTokenUsage()
         if config.Verbose {
-                fmt.Printf("%s%sffufai completed successfully%s\n", ColorGreen, ColorBold, ColorReset)
+                fmt.Fprintf(textOutput, "%s%sffufai completed successfully%s\n", ColorGreen, ColorBold, ColorReset)
         }
 }
   
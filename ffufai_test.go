@@ -0,0 +1,1297 @@
+package main
+
+import (
+        "context"
+        "crypto/x509"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "net"
+        "net/http"
+        "net/http/httptest"
+        "os"
+        "os/exec"
+        "path/filepath"
+        "runtime"
+        "sort"
+        "strings"
+        "testing"
+        "time"
+)
+
+// Skip tests that run POSIX shell scripts
+func needShell(t *testing.T) {
+        t.Helper()
+        if runtime.GOOS == "windows" {
+                t.Skip("needs a POSIX shell")
+        }
+}
+
+// Start a shell script as ffuf would be started, in its own process group
+func startGrouped(t *testing.T, script string) (*exec.Cmd, chan struct{}) {
+        t.Helper()
+        needShell(t)
+        cmd := exec.Command("sh", "-c", script)
+        if !setProcessGroup(cmd) {
+                t.Fatal("setProcessGroup reported no process group support")
+        }
+        if err := cmd.Start(); err != nil {
+                t.Fatal(err)
+        }
+        exited := make(chan struct{})
+        go func() {
+                cmd.Wait()
+                close(exited)
+        }()
+        return cmd, exited
+}
+
+func TestStopFfufOnInterruptGraceful(t *testing.T) {
+        marker := filepath.Join(t.TempDir(), "marker")
+        // The child traps Ctrl+C, writes its results and exits on its own
+        cmd, exited := startGrouped(t, `trap 'echo results > "`+marker+`"; exit 0' INT; while :; do sleep 0.1; done`)
+        time.Sleep(200 * time.Millisecond)
+
+        config := &Config{InterruptGrace: 5 * time.Second}
+        sigChan := make(chan os.Signal, 1)
+        sigChan <- os.Interrupt
+        done := make(chan struct{})
+        go func() {
+                stopFfufOnInterrupt(config, cmd.Process, true, sigChan, func() {}, exited)
+                close(done)
+        }()
+
+        select {
+        case <-done:
+        case <-time.After(3 * time.Second):
+                t.Fatal("the child did not stop within the grace period")
+        }
+        <-exited
+        data, err := os.ReadFile(marker)
+        if err != nil || strings.TrimSpace(string(data)) != "results" {
+                t.Fatalf("the trap did not run: %q, %v", data, err)
+        }
+        if !cmd.ProcessState.Success() {
+                t.Errorf("child state %v, want a clean exit", cmd.ProcessState)
+        }
+}
+
+func TestStopFfufOnInterruptKillsAfterGrace(t *testing.T) {
+        // The child ignores Ctrl+C, so the grace period runs out
+        cmd, exited := startGrouped(t, `trap '' INT; while :; do sleep 0.1; done`)
+        time.Sleep(200 * time.Millisecond)
+
+        config := &Config{InterruptGrace: 300 * time.Millisecond}
+        sigChan := make(chan os.Signal, 1)
+        sigChan <- os.Interrupt
+        stopFfufOnInterrupt(config, cmd.Process, true, sigChan, func() {}, exited)
+
+        select {
+        case <-exited:
+        case <-time.After(3 * time.Second):
+                t.Fatal("the child was not killed after the grace period")
+        }
+        if cmd.ProcessState.Success() {
+                t.Error("the child exited cleanly, want it killed")
+        }
+}
+
+// Write an executable shell script to stand in for ffuf
+func fakeFfuf(t *testing.T, body string) string {
+        t.Helper()
+        needShell(t)
+        path := filepath.Join(t.TempDir(), "ffuf")
+        if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+                t.Fatal(err)
+        }
+        return path
+}
+
+func TestExitCodeFromFfuf(t *testing.T) {
+        cases := []struct {
+                name   string
+                script string
+                want   int
+        }{
+                {"exit 1 passes through", "exit 1", 1},
+                {"other codes pass through", "exit 3", 3},
+                {"SIGTERM maps to 128+15", "kill -TERM $$", 143},
+                {"SIGKILL maps to 128+9", "kill -KILL $$", 137},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        config := &Config{FfufPath: fakeFfuf(t, c.script), FfufArgs: []string{"-u", "http://127.0.0.1/FUZZ"}}
+                        _, err := runFfuf(config, nil)
+                        if err == nil {
+                                t.Fatal("runFfuf succeeded, want an error")
+                        }
+                        if got := exitCode(err); got != c.want {
+                                t.Errorf("exitCode(%v) = %d, want %d", err, got, c.want)
+                        }
+                })
+        }
+
+        config := &Config{FfufPath: fakeFfuf(t, "exit 0"), FfufArgs: []string{"-u", "http://127.0.0.1/FUZZ"}}
+        if _, err := runFfuf(config, nil); err != nil {
+                t.Errorf("runFfuf with a successful ffuf: %v", err)
+        }
+}
+
+func TestExitCodeInterrupted(t *testing.T) {
+        for _, err := range []error{
+                &interruptedError{what: "ffuf"},
+                fmt.Errorf("refinement: %w", &interruptedError{what: "refinement"}),
+        } {
+                if got := exitCode(err); got != ExitInterrupted {
+                        t.Errorf("exitCode(%v) = %d, want %d", err, got, ExitInterrupted)
+                }
+        }
+        if got := exitCode(errors.New("no such file")); got != ExitFailure {
+                t.Errorf("exitCode of a plain error = %d, want %d", got, ExitFailure)
+        }
+}
+
+func TestCheckFfufFlags(t *testing.T) {
+        cases := []struct {
+                name  string
+                url   string
+                args  string
+                fatal bool
+                want  string // part of the message, "" for no problems
+        }{
+                {"consistent flags", "http://x/FUZZ", "-mc 200,301 -fc 404 -fs 10 -X POST -d a=1 -recursion -recursion-depth 2", false, ""},
+                {"bad match mode", "http://x/FUZZ", "-mmode xor", true, `-mmode "xor"`},
+                {"bad filter mode", "http://x/FUZZ", "-fmode any", true, `-fmode "any"`},
+                {"unparsable list", "http://x/FUZZ", "-fc 20x", true, `"20x" is not a number`},
+                {"reversed range", "http://x/FUZZ", "-fs 300-200", true, `"300-200" is not a number`},
+                {"every matched code filtered", "http://x/FUZZ", "-mc 200,301 -fc 200-399", true, "every status code -mc matches"},
+                {"last -fc counts", "http://x/FUZZ", "-fc 404 -fc 200 -mc 200", true, "every status code -mc matches"},
+                {"some matched codes filtered", "http://x/FUZZ", "-mc 200,301 -fc 301", false, "both cover 301"},
+                {"filtered codes with another matcher", "http://x/FUZZ", "-mc 200 -fc 200 -ms 100", false, "-mc 200 and -fc 200 both cover 200"},
+                {"-mc all with -fc", "http://x/FUZZ", "-mc all -fc 404", false, ""},
+                {"overlapping sizes", "http://x/FUZZ", "-ms 100-200 -fs 150", false, "-ms 100-200 and -fs 150 both cover 150"},
+                {"overlapping words", "http://x/FUZZ", "-mw 5 -fw 1-10", false, "-mw 5 and -fw 1-10 both cover 5"},
+                {"overlapping lines", "http://x/FUZZ", "-ml 3 -fl 3", false, "-ml 3 and -fl 3 both cover 3"},
+                {"empty size filter", "http://x/FUZZ", "-fs 0", false, "-fs 0 filters every response with an empty body"},
+                {"empty size filter on HEAD", "http://x/FUZZ", "-fs 0,10 -X HEAD", true, "HEAD have no body"},
+                {"POST without a body", "http://x/FUZZ", "-X POST", false, "-X POST without -d"},
+                {"lowercase PUT without a body", "http://x/FUZZ", "-X put", false, "-X PUT without -d"},
+                {"-d without -X", "http://x/FUZZ", "-d a=1", false, ""},
+                {"GET with a body", "http://x/FUZZ", "-X GET -d a=1", false, "-X GET with -d"},
+                {"recursion with a mid-path keyword", "http://x/FUZZ/a", "-recursion", true, "-recursion needs the URL to end in FUZZ"},
+                {"recursion depth without recursion", "http://x/FUZZ", "-recursion-depth 2", false, "-recursion-depth has no effect"},
+                {"recursion strategy without recursion", "http://x/FUZZ", "-recursion-strategy greedy", false, "-recursion-strategy has no effect"},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        problems := checkFfufFlags(&Config{URL: c.url}, strings.Fields(c.args))
+                        if c.want == "" {
+                                if len(problems) != 0 {
+                                        t.Errorf("unexpected problems %+v", problems)
+                                }
+                                return
+                        }
+                        for _, problem := range problems {
+                                if strings.Contains(problem.message, c.want) {
+                                        if problem.fatal != c.fatal {
+                                                t.Errorf("%q fatal = %v, want %v", problem.message, problem.fatal, c.fatal)
+                                        }
+                                        return
+                                }
+                        }
+                        t.Errorf("no problem mentions %q in %+v", c.want, problems)
+                })
+        }
+}
+
+func TestRangeOverlap(t *testing.T) {
+        overlap, all := rangeOverlap([][2]int{{200, 299}}, [][2]int{{250, 260}, {255, 299}, {100, 210}})
+        if all || formatValueRanges(overlap) != "200-210,250-260,261-299" {
+                t.Errorf("got %s, all %v", formatValueRanges(overlap), all)
+        }
+        if _, all := rangeOverlap([][2]int{{200, 200}, {301, 302}}, [][2]int{{200, 399}}); !all {
+                t.Error("200 and 301-302 are all within 200-399")
+        }
+}
+
+func TestShellCommand(t *testing.T) {
+        cases := []struct {
+                args []string
+                want string
+        }{
+                {[]string{"ffuf", "-u", "https://example.com/FUZZ", "-mc", "200,301"}, "ffuf -u https://example.com/FUZZ -mc 200,301"},
+                {[]string{"ffuf", "-H", "X-Note: it's me"}, `ffuf -H 'X-Note: it'\''s me'`},
+                {[]string{"ffuf", "-d", `{"a": "b"}`}, `ffuf -d '{"a": "b"}'`},
+                {[]string{"/opt/my tools/ffuf", "-w", "word list.txt"}, `'/opt/my tools/ffuf' -w 'word list.txt'`},
+                {[]string{"ffuf", "-d", ""}, `ffuf -d ''`},
+                {[]string{"ffuf", "-H", "X-Name: café ü"}, `ffuf -H 'X-Name: café ü'`},
+                {[]string{"ffuf", "-mr", "$HOME `id` *"}, "ffuf -mr '$HOME `id` *'"},
+        }
+        for _, c := range cases {
+                if got := shellCommand(c.args); got != c.want {
+                        t.Errorf("shellCommand(%q) = %s, want %s", c.args, got, c.want)
+                }
+        }
+}
+
+func TestShellCommandRoundTrip(t *testing.T) {
+        needShell(t)
+        args := []string{"-H", "X-Note: it's me", "", "a b", `"quoted"`, "café ü 日本", "$HOME `id` *", "back\\slash", "new\nline"}
+        script := "for a in " + shellCommand(args) + `; do printf '<%s>' "$a"; done`
+        out, err := exec.Command("sh", "-c", script).Output()
+        if err != nil {
+                t.Fatal(err)
+        }
+        var want strings.Builder
+        for _, arg := range args {
+                want.WriteString("<" + arg + ">")
+        }
+        if string(out) != want.String() {
+                t.Errorf("the shell read %q, want %q", out, want.String())
+        }
+}
+
+func TestPowerShellCommand(t *testing.T) {
+        cases := []struct {
+                args []string
+                want string
+        }{
+                {[]string{"ffuf", "-u", "https://example.com/FUZZ"}, "ffuf -u https://example.com/FUZZ"},
+                {[]string{"ffuf", "-mc", "200,301"}, "ffuf -mc '200,301'"},
+                {[]string{"ffuf", "-H", "X-Note: it's me"}, "ffuf -H 'X-Note: it''s me'"},
+                {[]string{"ffuf", "-H", "X-Note: it\u2019s me"}, "ffuf -H 'X-Note: it\u2019\u2019s me'"},
+                {[]string{"ffuf", "-d", `{"a": "b"}`}, `ffuf -d '{"a": "b"}'`},
+                {[]string{`C:\Program Files\ffuf\ffuf.exe`, "-w", "word list.txt"}, `& 'C:\Program Files\ffuf\ffuf.exe' -w 'word list.txt'`},
+                {[]string{`C:\tools\ffuf.exe`, "-d", ""}, `C:\tools\ffuf.exe -d ''`},
+                {[]string{"ffuf", "-H", "X-Name: café ü"}, "ffuf -H 'X-Name: café ü'"},
+                {[]string{"ffuf", "-mr", "$env:HOME @args"}, "ffuf -mr '$env:HOME @args'"},
+        }
+        for _, c := range cases {
+                if got := powerShellCommand(c.args); got != c.want {
+                        t.Errorf("powerShellCommand(%q) = %s, want %s", c.args, got, c.want)
+                }
+        }
+}
+
+func TestFingerprintStack(t *testing.T) {
+        rules, err := fingerprintRules(&FileConfig{})
+        if err != nil {
+                t.Fatal(err)
+        }
+        cases := []struct {
+                name    string
+                headers map[string]string
+                hints   string
+                paths   string
+                want    string // StackDescriptor.String(), "" for no match
+        }{
+                {"nginx and PHP", map[string]string{"Server": "nginx/1.25.3", "X-Powered-By": "PHP/8.2.1"}, "", "", "server nginx, language php"},
+                {"WordPress on Apache", map[string]string{"Server": "Apache/2.4.57 (Debian)", "X-Pingback": "https://example.com/xmlrpc.php"}, "", "", "server Apache httpd, language php, cms wordpress"},
+                {"ASP.NET MVC on IIS", map[string]string{"Server": "Microsoft-IIS/10.0", "X-Powered-By": "ASP.NET", "X-AspNetMvc-Version": "5.2"}, "", "", "server Microsoft IIS, language asp.net, framework asp.net mvc"},
+                {"Tomcat", map[string]string{"Server": "Apache-Coyote/1.1", "Set-Cookie": "JSESSIONID=8F2A; Path=/; HttpOnly"}, "", "", "server Apache Tomcat, language java"},
+                {"Express", map[string]string{"X-Powered-By": "Express", "Set-Cookie": "connect.sid=s%3Aabc; Path=/"}, "", "", "language node.js, framework express"},
+                {"Next.js", map[string]string{"X-Powered-By": "Next.js"}, "", "", "language node.js, framework next.js"},
+                {"Django", map[string]string{"Server": "gunicorn", "Set-Cookie": "csrftoken=abc; Path=/\nsessionid=def"}, "", "", "language python, framework django"},
+                {"Flask", map[string]string{"Server": "Werkzeug/3.0.1 Python/3.12.1"}, "", "", "language python, framework flask"},
+                {"Rails on Puma", map[string]string{"Server": "Puma", "X-Runtime": "0.012345"}, "", "", "language ruby, framework rails"},
+                {"Laravel", map[string]string{"Server": "nginx", "Set-Cookie": "XSRF-TOKEN=a; path=/\nlaravel_session=b; path=/; httponly"}, "", "", "server nginx, language php, framework laravel"},
+                {"Drupal", map[string]string{"X-Generator": "Drupal 10 (https://www.drupal.org)"}, "", "", "language php, cms drupal"},
+                {"Joomla from the page and robots.txt", map[string]string{"Server": "LiteSpeed"}, "generator: Joomla! - Open Source Content Management", "/administrator/", "server LiteSpeed, language php, cms joomla"},
+                {"classic ASP", map[string]string{"Set-Cookie": "ASPSESSIONIDQQTRSDBA=KLMN; path=/"}, "", "", "language classic asp"},
+                {"Kestrel", map[string]string{"Server": "Kestrel"}, "", "", "server Kestrel, language asp.net"},
+                {"WordPress cookies on Caddy", map[string]string{"Server": "Caddy", "Set-Cookie": "wordpress_test_cookie=WP; path=/"}, "", "", "server Caddy, language php, cms wordpress"},
+                {"header names ignore case", map[string]string{"server": "OpenResty", "x-powered-by": "php/7.4"}, "", "", "server OpenResty, language php"},
+                {"nothing known", map[string]string{"Server": "cloudserver", "Set-Cookie": "id=1"}, "welcome", "/private/", ""},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        got := ""
+                        if stack := fingerprintStack(rules, c.headers, c.hints, c.paths); stack != nil {
+                                got = stack.String()
+                        }
+                        if got != c.want {
+                                t.Errorf("got %q, want %q", got, c.want)
+                        }
+                })
+        }
+}
+
+func TestFingerprintRulesFromConfig(t *testing.T) {
+        // Rules from the config file come first, so they win over the built-in ones
+        rules, err := fingerprintRules(&FileConfig{FingerprintRules: []FingerprintRule{
+                {Header: "Server", Pattern: `nginx`, Stack: StackDescriptor{Server: "nginx", Language: "python"}},
+                {Cookie: "sid_", Stack: StackDescriptor{Framework: "in-house"}},
+        }})
+        if err != nil {
+                t.Fatal(err)
+        }
+        stack := fingerprintStack(rules, map[string]string{"Server": "nginx", "X-Powered-By": "PHP/8.2", "Set-Cookie": "sid_x=1"}, "", "")
+        if want := "server nginx, language python, framework in-house"; stack == nil || stack.String() != want {
+                t.Errorf("got %v, want %q", stack, want)
+        }
+}
+
+func TestFingerprintRuleCompile(t *testing.T) {
+        cases := []struct {
+                rule FingerprintRule
+                want string // part of the error, "" for a valid rule
+        }{
+                {FingerprintRule{Header: "Server", Pattern: `^envoy`, Stack: StackDescriptor{Server: "Envoy"}}, ""},
+                {FingerprintRule{Cookie: "sid", Pattern: `abc`, Stack: StackDescriptor{Language: "go"}}, "a pattern needs a header"},
+                {FingerprintRule{Body: "gitea", Pattern: `1\.2`, Stack: StackDescriptor{Language: "go"}}, "a pattern needs a header"},
+                {FingerprintRule{Stack: StackDescriptor{Language: "go"}}, "needs a header, cookie, body or path"},
+                {FingerprintRule{Header: "Server"}, "needs a stack"},
+                {FingerprintRule{Header: "Server", Pattern: `(`, Stack: StackDescriptor{Server: "x"}}, "missing closing )"},
+        }
+        for _, c := range cases {
+                err := c.rule.compile()
+                switch {
+                case c.want == "" && err != nil:
+                        t.Errorf("%+v: %v", c.rule, err)
+                case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+                        t.Errorf("%+v: got %v, want an error about %q", c.rule, err, c.want)
+                }
+        }
+        if _, err := fingerprintRules(&FileConfig{FingerprintRules: []FingerprintRule{{Cookie: "sid", Pattern: "x", Stack: StackDescriptor{Language: "go"}}}}); err == nil {
+                t.Error("fingerprintRules accepted a pattern without a header")
+        }
+}
+
+func TestDetectWAF(t *testing.T) {
+        cases := []struct {
+                name    string
+                headers map[string]string
+                want    []string // "Product (waf|cdn): evidence, ..." with sorted evidence
+        }{
+                {"Cloudflare", map[string]string{"Server": "cloudflare", "CF-RAY": "8a1b2c3d4e5f-FRA", "Set-Cookie": "__cf_bm=abc; path=/; HttpOnly"},
+                        []string{"Cloudflare (waf): CF-RAY, Server, cookie __cf_bm"}},
+                {"Akamai", map[string]string{"Server": "AkamaiGHost", "X-Akamai-Transformed": "9 - 0 pmb=mRUM,1"},
+                        []string{"Akamai (waf): Server, X-Akamai-Transformed"}},
+                {"Imperva Incapsula", map[string]string{"X-CDN": "Imperva", "X-Iinfo": "10-1234-0 0NNN", "Set-Cookie": "visid_incap_123=abc\nincap_ses_456_123=def"},
+                        []string{"Imperva Incapsula (waf): X-CDN, X-Iinfo, cookie incap_ses_456_123, cookie visid_incap_123"}},
+                {"F5 BIG-IP", map[string]string{"Set-Cookie": "BIGipServerpool_web=1677787402.20480.0000; path=/"},
+                        []string{"F5 BIG-IP (waf): cookie BIGipServerpool_web"}},
+                {"Sucuri", map[string]string{"Server": "Sucuri/Cloudproxy", "X-Sucuri-ID": "11005"},
+                        []string{"Sucuri (waf): Server, X-Sucuri-ID"}},
+                {"AWS WAF behind CloudFront", map[string]string{"Via": "1.1 3f2a.cloudfront.net (CloudFront)", "X-Amz-Cf-Id": "abc==", "Set-Cookie": "aws-waf-token=xyz"},
+                        []string{"AWS WAF (waf): cookie aws-waf-token", "Amazon CloudFront (cdn): Via, X-Amz-Cf-Id"}},
+                {"Fastly", map[string]string{"X-Served-By": "cache-fra-eddf8230086-FRA", "X-Cache": "HIT"},
+                        []string{"Fastly (cdn): X-Served-By"}},
+                {"header names ignore case", map[string]string{"x-azure-ref": "0Zx"},
+                        []string{"Azure Front Door (waf): x-azure-ref"}},
+                {"generic Via and X-Cache", map[string]string{"Via": "1.1 varnish (Varnish/7.1)", "X-Cache": "HIT, MISS", "X-Cache-Hits": "1"}, nil},
+                {"a plain origin", map[string]string{"Server": "nginx/1.25", "Set-Cookie": "session=abc; path=/", "X-Served-By": "web-3"}, nil},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        var got []string
+                        for _, detection := range detectWAF(c.headers) {
+                                kind := "cdn"
+                                if detection.WAF {
+                                        kind = "waf"
+                                }
+                                evidence := append([]string{}, detection.Evidence...)
+                                sort.Strings(evidence)
+                                got = append(got, fmt.Sprintf("%s (%s): %s", detection.Product, kind, strings.Join(evidence, ", ")))
+                        }
+                        if strings.Join(got, "; ") != strings.Join(c.want, "; ") {
+                                t.Errorf("got %q, want %q", got, c.want)
+                        }
+                })
+        }
+}
+
+func TestProbeWAFCanary(t *testing.T) {
+        // A server that blocks the canary query, and one that forbids everything
+        blocking := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                if strings.Contains(r.URL.RawQuery, "script") {
+                        w.Header().Set("X-Sucuri-Block", "1")
+                        w.WriteHeader(http.StatusForbidden)
+                        return
+                }
+                fmt.Fprint(w, "ok")
+        }))
+        defer blocking.Close()
+        forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.WriteHeader(http.StatusForbidden)
+        }))
+        defer forbidden.Close()
+        ignoring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                fmt.Fprint(w, "ok")
+        }))
+        defer ignoring.Close()
+
+        cases := []struct {
+                name       string
+                url        string
+                baseStatus int
+                want       bool
+        }{
+                {"blocked canary", blocking.URL + "/", http.StatusOK, true},
+                {"blocked canary after a query", blocking.URL + "/?page=1", http.StatusOK, true},
+                {"403 for the base request too", forbidden.URL + "/", http.StatusForbidden, false},
+                {"canary answered like the base", ignoring.URL + "/", http.StatusOK, false},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        config := &Config{URL: c.url, ProbeTimeout: 5 * time.Second}
+                        blocked, headers, err := probeWAFCanary(context.Background(), config, c.url, c.baseStatus)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if blocked != c.want {
+                                t.Errorf("blocked = %v, want %v", blocked, c.want)
+                        }
+                        if c.want && len(detectWAF(headers)) == 0 {
+                                t.Errorf("the block page's headers %v named no WAF", headers)
+                        }
+                })
+        }
+}
+
+// Resolver backed by a local DNS server that answers A queries for
+// app.test with 127.0.0.1 and NXDOMAIN for every other name
+func fakeResolver(t *testing.T) *net.Resolver {
+        t.Helper()
+        conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { conn.Close() })
+        go func() {
+                buffer := make([]byte, 512)
+                for {
+                        n, addr, err := conn.ReadFrom(buffer)
+                        if err != nil {
+                                return
+                        }
+                        query := buffer[:n]
+                        end := 12
+                        for end < n && query[end] != 0 {
+                                end += int(query[end]) + 1
+                        }
+                        end += 5
+                        if end > n {
+                                continue
+                        }
+                        name, qtype := strings.ToLower(string(query[12:end-4])), query[end-3]
+                        reply := append([]byte{query[0], query[1], 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0}, query[12:end]...)
+                        switch {
+                        case name != "\x03app\x04test\x00":
+                                reply[3] |= 3
+                        case qtype == 1:
+                                reply[7] = 1
+                                reply = append(reply, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+                        }
+                        conn.WriteTo(reply, addr)
+                }
+        }()
+        return &net.Resolver{
+                PreferGo: true,
+                Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+                        var dialer net.Dialer
+                        return dialer.DialContext(ctx, "udp", conn.LocalAddr().String())
+                },
+        }
+}
+
+// Port on 127.0.0.1 that nothing listens on
+func closedPort(t *testing.T) string {
+        t.Helper()
+        listener, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        _, port, _ := net.SplitHostPort(listener.Addr().String())
+        listener.Close()
+        return port
+}
+
+func TestPreflight(t *testing.T) {
+        resolver := fakeResolver(t)
+        plain := httptest.NewServer(http.NotFoundHandler())
+        defer plain.Close()
+        secure := httptest.NewTLSServer(http.NotFoundHandler())
+        defer secure.Close()
+        trusted := x509.NewCertPool()
+        trusted.AddCert(secure.Certificate())
+        _, plainPort, _ := net.SplitHostPort(plain.Listener.Addr().String())
+        onApp := func(server *httptest.Server) string {
+                return strings.Replace(server.URL, "127.0.0.1", "app.test", 1) + "/FUZZ"
+        }
+
+        cases := []struct {
+                name   string
+                config Config
+                want   string
+        }{
+                {"reachable over http", Config{URL: onApp(plain)}, ""},
+                {"reachable over https", Config{URL: onApp(secure), CACerts: trusted, SNI: "example.com"}, ""},
+                {"NXDOMAIN", Config{URL: "http://typo.test/FUZZ"}, "typo.test does not resolve (NXDOMAIN)"},
+                {"connection refused", Config{URL: "http://app.test:" + closedPort(t) + "/FUZZ"}, "refused; nothing listens on port"},
+                {"certificate error", Config{URL: onApp(secure)}, "certificate error for app.test"},
+                {"-k accepts the certificate", Config{URL: onApp(secure), Insecure: true}, ""},
+                {"--resolve skips the lookup", Config{URL: "http://typo.test:" + plainPort + "/FUZZ", Resolve: []ResolveOverride{{Host: "typo.test", Port: plainPort, IP: "127.0.0.1"}}}, ""},
+                {"keyword in the hostname", Config{URL: "http://FUZZ.typo.test/"}, ""},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        config := c.config
+                        config.Keyword = "FUZZ"
+                        config.ProbeTimeout = 5 * time.Second
+                        if targetProxy(&config, config.URL) != nil {
+                                t.Skip("a proxy is configured in the environment")
+                        }
+                        err := preflight(context.Background(), &config, resolver, config.URL)
+                        switch {
+                        case c.want == "" && err != nil:
+                                t.Errorf("unexpected error: %v", err)
+                        case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+                                t.Errorf("got error %v, want one containing %q", err, c.want)
+                        }
+                })
+        }
+}
+
+func TestRunDoctor(t *testing.T) {
+        ffuf := fakeFfuf(t, `echo "ffuf version: 2.1.0"`)
+        saved := targetResolver
+        targetResolver = fakeResolver(t)
+        defer func() { targetResolver = saved }()
+        server := httptest.NewServer(http.NotFoundHandler())
+        defer server.Close()
+
+        cases := []struct {
+                name string
+                url  string
+                want string
+        }{
+                {"target reachable", strings.Replace(server.URL, "127.0.0.1", "app.test", 1) + "/FUZZ", ""},
+                {"no target", "", ""},
+                {"target refused", "http://app.test:" + closedPort(t) + "/FUZZ", "1 of the checks failed"},
+                {"target does not resolve", "http://typo.test/FUZZ", "1 of the checks failed"},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        config := &Config{FfufPath: ffuf, URL: c.url, Keyword: "FUZZ", ProbeTimeout: 5 * time.Second}
+                        if c.url != "" && targetProxy(config, c.url) != nil {
+                                t.Skip("a proxy is configured in the environment")
+                        }
+                        err := runDoctor(config)
+                        switch {
+                        case c.want == "" && err != nil:
+                                t.Errorf("unexpected error: %v", err)
+                        case c.want != "" && (err == nil || err.Error() != c.want):
+                                t.Errorf("got error %v, want %q", err, c.want)
+                        }
+                })
+        }
+}
+
+// Config parseArgs builds from the command line args, run against a fake
+// ffuf that supports every option
+func parseTestArgs(t *testing.T, args ...string) *Config {
+        t.Helper()
+        ffuf := fakeFfuf(t, `echo "ffuf version: 2.1.0"`)
+        savedArgs, savedOutput := os.Args, textOutput
+        defer func() { os.Args, textOutput = savedArgs, savedOutput }()
+        os.Args = append([]string{"ffufai", "--ffuf-path", ffuf}, args...)
+        // Keep the banner out of the test output
+        textOutput = io.Discard
+        config, err := parseArgs()
+        if err != nil {
+                t.Fatalf("parseArgs %q: %v", args, err)
+        }
+        return config
+}
+
+// Request headers the target probes send, as the server saw them
+func sentHeaders(t *testing.T, config *Config, server *httptest.Server) http.Header {
+        t.Helper()
+        received := make(chan http.Header, 1)
+        server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                received <- r.Header.Clone()
+        })
+        if _, _, _, err := probeHeaders(context.Background(), targetClient(config), http.MethodGet, server.URL+"/"); err != nil {
+                t.Fatal(err)
+        }
+        return <-received
+}
+
+func TestProbeCookies(t *testing.T) {
+        server := httptest.NewServer(http.NotFoundHandler())
+        defer server.Close()
+        wordlist := filepath.Join(t.TempDir(), "words.txt")
+        if err := os.WriteFile(wordlist, []byte("admin\n"), 0o644); err != nil {
+                t.Fatal(err)
+        }
+
+        cases := []struct {
+                name string
+                args []string
+                want string
+        }{
+                {"-b", []string{"-b", "session=abc123"}, "session=abc123"},
+                {"repeated -b", []string{"-b", "session=abc123", "-b=theme=dark"}, "session=abc123; theme=dark"},
+                {"-cookie", []string{"-cookie", "session=abc123"}, "session=abc123"},
+                {"--cookie", []string{"--cookie", "session=abc123; csrf=x=y"}, "session=abc123; csrf=x=y"},
+                {"--cookie and -b", []string{"--cookie", "session=abc123", "-b", "theme=dark"}, "theme=dark; session=abc123"},
+                {"a Cookie header wins", []string{"-b", "session=abc123", "-H", "Cookie: other=1"}, "other=1"},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        args := append([]string{"-u", server.URL + "/FUZZ", "-w", wordlist}, c.args...)
+                        config := parseTestArgs(t, args...)
+                        if got := sentHeaders(t, config, server).Values("Cookie"); len(got) != 1 || got[0] != c.want {
+                                t.Errorf("probe sent Cookie %q, want %q", got, c.want)
+                        }
+                })
+        }
+}
+
+func TestRedactArgs(t *testing.T) {
+        cases := []struct {
+                args []string
+                want []string
+        }{
+                {[]string{"-b", "session=abc123; theme=dark"}, []string{"-b", "session=[REDACTED]; theme=[REDACTED]"}},
+                {[]string{"-cookie=session=abc123"}, []string{"-cookie=session=[REDACTED]"}},
+                {[]string{"-b=csrf=x=y;"}, []string{"-b=csrf=[REDACTED]"}},
+                {[]string{"-H", "Cookie: session=abc123;theme=dark"}, []string{"-H", "Cookie: session=[REDACTED]; theme=[REDACTED]"}},
+                {[]string{"-H=cookie:flag"}, []string{"-H=cookie: flag=[REDACTED]"}},
+                {[]string{"-H", "Authorization: Bearer eyJhbGciOi"}, []string{"-H", "Authorization: Bearer [REDACTED]"}},
+                {[]string{"-H", "Proxy-Authorization: c2VjcmV0"}, []string{"-H", "Proxy-Authorization: [REDACTED]"}},
+                {[]string{"-H", "X-Session: abc123", "-mc", "200"}, []string{"-H", "X-Session: abc123", "-mc", "200"}},
+                {[]string{"-w", "session=abc123"}, []string{"-w", "session=abc123"}},
+                {[]string{"-b"}, []string{"-b"}},
+        }
+        for _, c := range cases {
+                if got := redactArgs(c.args); strings.Join(got, "\x00") != strings.Join(c.want, "\x00") {
+                        t.Errorf("redactArgs(%q) = %q, want %q", c.args, got, c.want)
+                }
+        }
+        args := []string{"-b", "session=abc123"}
+        redactArgs(args)
+        if args[1] != "session=abc123" {
+                t.Errorf("redactArgs changed its argument to %q", args)
+        }
+}
+
+func TestHeadersFile(t *testing.T) {
+        server := httptest.NewServer(http.NotFoundHandler())
+        defer server.Close()
+        dir := t.TempDir()
+        wordlist := filepath.Join(dir, "words.txt")
+        headersFile := filepath.Join(dir, "headers.txt")
+        content := "# captured from the browser\r\n" +
+                "Referer: https://example.com:8443/app?next=/a:b\r\n" +
+                "\r\n" +
+                "X-Api-Key: from-file\r\n" +
+                "X-Trace: a:b:c\n"
+        for path, data := range map[string]string{wordlist: "admin\n", headersFile: content} {
+                if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+                        t.Fatal(err)
+                }
+        }
+
+        lines, err := readHeadersFile(headersFile)
+        if err != nil {
+                t.Fatal(err)
+        }
+        want := []string{"Referer: https://example.com:8443/app?next=/a:b", "X-Api-Key: from-file", "X-Trace: a:b:c"}
+        if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+                t.Errorf("readHeadersFile = %q, want %q", lines, want)
+        }
+
+        config := parseTestArgs(t, "--headers-file", headersFile, "-u", server.URL+"/FUZZ", "-w", wordlist, "-H", "x-api-key: from-flag")
+        sent := sentHeaders(t, config, server)
+        for name, value := range map[string]string{"Referer": "https://example.com:8443/app?next=/a:b", "X-Trace": "a:b:c", "X-Api-Key": "from-flag"} {
+                if got := sent.Values(name); len(got) != 1 || got[0] != value {
+                        t.Errorf("probe sent %s %q, want %q", name, got, value)
+                }
+        }
+        headers := strings.Join(ffufFlagValues(config.FfufArgs, "-H"), "\n")
+        if want := "x-api-key: from-flag\nReferer: https://example.com:8443/app?next=/a:b\nX-Trace: a:b:c"; headers != want {
+                t.Errorf("ffuf gets -H %q, want %q", headers, want)
+        }
+}
+
+func TestHeadersFileErrors(t *testing.T) {
+        path := filepath.Join(t.TempDir(), "headers.txt")
+        if err := os.WriteFile(path, []byte("X-Ok: 1\n# comment\nno colon here\n"), 0o644); err != nil {
+                t.Fatal(err)
+        }
+        if _, err := readHeadersFile(path); err == nil || !strings.HasPrefix(err.Error(), "line 3: ") {
+                t.Errorf("got error %v, want one for line 3", err)
+        }
+}
+
+func TestSplitExtensions(t *testing.T) {
+        cases := []struct {
+                args       []string
+                extensions []string
+                rest       []string
+        }{
+                {[]string{"-u", "http://x/FUZZ", "-e", ".php,.bak"}, []string{".php", ".bak"}, []string{"-u", "http://x/FUZZ"}},
+                {[]string{"-e", "php, bak", "-mc", "200"}, []string{".php", ".bak"}, []string{"-mc", "200"}},
+                {[]string{"-e", ".php,php", "-e=.bak,.php"}, []string{".php", ".bak"}, nil},
+                {[]string{"-e", "~,.orig,_old,.tar.gz"}, []string{"~", ".orig", "_old", ".tar.gz"}, nil},
+                {[]string{"-e", ",,"}, nil, nil},
+                {[]string{"-w", "words.txt", "-e"}, nil, []string{"-w", "words.txt", "-e"}},
+        }
+        for _, c := range cases {
+                extensions, rest := splitExtensions(c.args)
+                if strings.Join(extensions, ",") != strings.Join(c.extensions, ",") || strings.Join(rest, " ") != strings.Join(c.rest, " ") {
+                        t.Errorf("splitExtensions(%q) = %q, %q, want %q, %q", c.args, extensions, rest, c.extensions, c.rest)
+                }
+        }
+}
+
+func TestWithUserExtensions(t *testing.T) {
+        cases := []struct {
+                name     string
+                user, ai []string
+                maxAdded int
+                want     string
+        }{
+                {"user first", []string{".zip", ".php"}, []string{".bak", ".inc"}, 4, ".zip,.php,.bak,.inc"},
+                {"AI repeats a user extension", []string{".php"}, []string{".bak", ".php", ".inc"}, 4, ".php,.bak,.inc"},
+                {"duplicates within each list", []string{".php", ".php"}, []string{".bak", ".bak", ".inc"}, 4, ".php,.bak,.inc"},
+                {"the cap counts only the AI's", []string{".a", ".b", ".c"}, []string{".d", ".e", ".f"}, 2, ".a,.b,.c,.d,.e"},
+                {"skipped repeats do not use the cap", []string{".php"}, []string{".php", ".bak", ".inc"}, 1, ".php,.bak"},
+                {"no AI extensions", []string{".php"}, nil, 4, ".php"},
+                {"no user extensions", nil, []string{".bak", ".inc"}, 1, ".bak"},
+                {"a cap of zero", []string{".php"}, []string{".bak"}, 0, ".php"},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        if got := strings.Join(withUserExtensions(c.user, c.ai, c.maxAdded), ","); got != c.want {
+                                t.Errorf("got %s, want %s", got, c.want)
+                        }
+                })
+        }
+
+        // A bare -e php and the AI's .php are one extension
+        user, _ := splitExtensions([]string{"-e", "php,bak"})
+        if got := strings.Join(withUserExtensions(user, cleanExtensions([]string{"php", ".inc"}), 4), ","); got != ".php,.bak,.inc" {
+                t.Errorf("got %s, want .php,.bak,.inc", got)
+        }
+}
+
+func TestRequestMethod(t *testing.T) {
+        cases := []struct {
+                args         []string
+                method, body string
+        }{
+                {[]string{"-w", "words.txt"}, "", ""},
+                {[]string{"-X", "get"}, "", ""},
+                {[]string{"-X", "HEAD"}, "", ""},
+                {[]string{"-d", "user=admin&pass=FUZZ"}, "POST", "form"},
+                {[]string{"-d", `{"id": "FUZZ"}`}, "POST", "JSON"},
+                {[]string{"-X", "post", "-d", " [1, 2]"}, "POST", "JSON"},
+                {[]string{"-X", "PUT", "-d", "<user>FUZZ</user>"}, "PUT", "XML"},
+                {[]string{"-X", "PUT", "-d", "id=1", "-H", "Content-Type: application/json"}, "PUT", "JSON"},
+                {[]string{"-X", "PUT", "-H", "content-type: multipart/form-data; boundary=x"}, "PUT", "multipart"},
+                {[]string{"-X", "PUT", "-H", "Content-Type: Text/Plain"}, "PUT", "text/plain"},
+                {[]string{"-X", "PUT", "-d", "FUZZ"}, "PUT", "raw"},
+                {[]string{"-X", "DELETE"}, "DELETE", ""},
+        }
+        for _, c := range cases {
+                if method, body := requestMethod(c.args); method != c.method || body != c.body {
+                        t.Errorf("requestMethod(%q) = %q, %q, want %q, %q", c.args, method, body, c.method, c.body)
+                }
+        }
+}
+
+func TestBuildPromptMethod(t *testing.T) {
+        cases := []struct {
+                name    string
+                args    []string
+                want    []string
+                notWant []string
+        }{
+                {"GET", []string{"-w", "words.txt"},
+                        []string{"URL: https://example.com/app/FUZZ\nHeaders:", "URL: https://example.com/presentations/FUZZ"},
+                        []string{"Method: POST", "handler extensions"}},
+                {"POST", []string{"-d", "user=FUZZ"},
+                        []string{"URL: https://example.com/app/FUZZ\nMethod: POST with a form body\nHeaders:", "The scan sends POST requests with a form body", "prefer handler extensions"},
+                        []string{"presentations"}},
+                {"PUT", []string{"-X", "PUT", "-d", `{"name": "FUZZ"}`},
+                        []string{"URL: https://example.com/app/FUZZ\nMethod: PUT with a JSON body\nHeaders:", "The scan sends PUT requests with a JSON body"},
+                        []string{"presentations"}},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        config := &Config{MaxExtensions: 4}
+                        config.RequestMethod, config.BodyType = requestMethod(c.args)
+                        prompt, err := buildPrompt(config, "https://example.com/app/FUZZ", map[string]string{"Server": "Apache-Coyote/1.1"})
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        for _, text := range c.want {
+                                if !strings.Contains(prompt, text) {
+                                        t.Errorf("prompt lacks %q", text)
+                                }
+                        }
+                        for _, text := range c.notWant {
+                                if strings.Contains(prompt, text) {
+                                        t.Errorf("prompt contains %q", text)
+                                }
+                        }
+                })
+        }
+}
+
+func TestEditExtensionsLeavesStdin(t *testing.T) {
+        reader, writer, err := os.Pipe()
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer reader.Close()
+        savedStdin, savedOutput := os.Stdin, textOutput
+        defer func() { os.Stdin, textOutput = savedStdin, savedOutput }()
+        os.Stdin = reader
+        textOutput = io.Discard
+        if _, err := writer.WriteString("2 +inc\na\nfor ffuf\n"); err != nil {
+                t.Fatal(err)
+        }
+        writer.Close()
+
+        accepted, ok, err := editExtensions([]string{".php", ".bak", ".old"})
+        if err != nil || !ok {
+                t.Fatalf("editExtensions = %v, %v", ok, err)
+        }
+        if got := strings.Join(accepted, ","); got != ".php,.old,.inc" {
+                t.Errorf("accepted %s, want .php,.old,.inc", got)
+        }
+        rest, err := io.ReadAll(reader)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if string(rest) != "for ffuf\n" {
+                t.Errorf("stdin left %q, want %q", rest, "for ffuf\n")
+        }
+}
+
+func TestReadLine(t *testing.T) {
+        reader := strings.NewReader("first\nsecond")
+        for _, want := range []string{"first\n", "second"} {
+                line, err := readLine(reader)
+                if line != want {
+                        t.Errorf("readLine = %q, want %q", line, want)
+                }
+                if want == "second" && err != io.EOF {
+                        t.Errorf("got error %v at the end, want EOF", err)
+                }
+        }
+}
+
+func TestWriteRunStats(t *testing.T) {
+        before := totalTokenUsage()
+        addTokenUsage(ProviderOllama, Usage{PromptTokens: 120, CompletionTokens: 30, TotalTokens: 150})
+        config := &Config{
+                URL:         "https://example.com/FUZZ",
+                StatsOut:    filepath.Join(t.TempDir(), "stats.json"),
+                PassResults: &FfufOutput{Results: []FfufResult{{URL: "https://example.com/a.php", Status: 200}, {URL: "https://example.com/b.php", Status: 200}, {URL: "https://example.com/c", Status: 301}}},
+        }
+        if err := writeRunStats(config, []string{".php"}); err != nil {
+                t.Fatal(err)
+        }
+        data, err := os.ReadFile(config.StatsOut)
+        if err != nil {
+                t.Fatal(err)
+        }
+        var stats RunStats
+        if err := json.Unmarshal(data, &stats); err != nil {
+                t.Fatal(err)
+        }
+        if stats.Results != 3 || stats.Statuses[200] != 2 || stats.Statuses[301] != 1 {
+                t.Errorf("results %d by status %v, want 3 with 200: 2, 301: 1", stats.Results, stats.Statuses)
+        }
+        if got := stats.Usage.TotalTokens - before.TotalTokens; got != 150 || stats.Usage.PromptTokens-before.PromptTokens != 120 {
+                t.Errorf("usage %+v, want 150 more tokens than %+v", stats.Usage, before)
+        }
+        if strings.Join(stats.Extensions, ",") != ".php" || stats.Target != config.URL {
+                t.Errorf("got %+v", stats)
+        }
+}
+
+func TestExtensionsSchemaMatchCodes(t *testing.T) {
+        for _, ask := range []bool{true, false} {
+                schema := newExtensionsSchema(false, true, ask)
+                properties := schema["properties"].(map[string]interface{})
+                required := strings.Join(schema["required"].([]string), ",")
+                _, listed := properties["match_codes"]
+                if listed != ask || strings.Contains(required, "match_codes") != ask || strings.Contains(required, "match_reason") != ask {
+                        t.Errorf("matchCodes %v: properties %v, required %s", ask, properties, required)
+                }
+
+                prompt, err := buildPrompt(&Config{MaxExtensions: 4, AskMatchCodes: ask}, "https://example.com/FUZZ", map[string]string{"Server": "nginx"})
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if strings.Contains(prompt, "match_codes") != ask || strings.Contains(prompt, "Match codes:") != ask {
+                        t.Errorf("AskMatchCodes %v: prompt mentions match codes %v", ask, !ask)
+                }
+        }
+}
+
+func TestAskMatchCodes(t *testing.T) {
+        wordlist := filepath.Join(t.TempDir(), "words.txt")
+        if err := os.WriteFile(wordlist, []byte("admin\n"), 0o644); err != nil {
+                t.Fatal(err)
+        }
+        cases := []struct {
+                args []string
+                want bool
+        }{
+                {nil, true},
+                {[]string{"--no-auto-matchers"}, false},
+                {[]string{"-mc", "200"}, false},
+                {[]string{"-fc=404"}, false},
+                {[]string{"-ac"}, false},
+        }
+        for _, c := range cases {
+                args := append(append([]string{}, c.args...), "-u", "http://127.0.0.1:1/FUZZ", "-w", wordlist)
+                if got := parseTestArgs(t, args...).AskMatchCodes; got != c.want {
+                        t.Errorf("%q: AskMatchCodes = %v, want %v", c.args, got, c.want)
+                }
+        }
+}
+
+func TestExtensionsSchemaMethod(t *testing.T) {
+        for _, ask := range []bool{true, false} {
+                schema := newExtensionsSchema(false, ask, true)
+                properties := schema["properties"].(map[string]interface{})
+                required := strings.Join(schema["required"].([]string), ",")
+                _, listed := properties["method"]
+                if listed != ask || strings.Contains(required, "method") != ask || !strings.Contains(required, "match_codes") {
+                        t.Errorf("method %v: properties %v, required %s", ask, properties, required)
+                }
+
+                prompt, err := buildPrompt(&Config{MaxExtensions: 4, AskMethod: ask, AskMatchCodes: true}, "https://example.com/FUZZ", map[string]string{"Server": "nginx"})
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if strings.Contains(prompt, `"method"`) != ask || strings.Contains(prompt, "HTTP method:") != ask {
+                        t.Errorf("AskMethod %v: prompt mentions the method %v", ask, !ask)
+                }
+                if !strings.Contains(prompt, `"match_codes"`) {
+                        t.Errorf("AskMethod %v: prompt lost the match codes", ask)
+                }
+        }
+}
+
+func TestAskMethod(t *testing.T) {
+        wordlist := filepath.Join(t.TempDir(), "words.txt")
+        if err := os.WriteFile(wordlist, []byte("admin\n"), 0o644); err != nil {
+                t.Fatal(err)
+        }
+        cases := []struct {
+                args []string
+                want bool
+        }{
+                {nil, true},
+                {[]string{"--suggest-method=false"}, false},
+                {[]string{"-X", "POST"}, false},
+                {[]string{"-X=GET"}, false},
+        }
+        for _, c := range cases {
+                args := append(append([]string{}, c.args...), "-u", "http://127.0.0.1:1/FUZZ", "-w", wordlist)
+                if got := parseTestArgs(t, args...).AskMethod; got != c.want {
+                        t.Errorf("%q: AskMethod = %v, want %v", c.args, got, c.want)
+                }
+        }
+}
+
+func TestOptionsLists(t *testing.T) {
+        cases := []struct {
+                allow           string
+                method          string
+                allows, listing bool
+        }{
+                {"", "PUT", true, false},
+                {"*", "PUT", true, false},
+                {"GET, HEAD, PUT", "PUT", true, true},
+                {"get,patch", "PATCH", true, true},
+                {"GET, POST", "PUT", false, false},
+        }
+        for _, c := range cases {
+                headers := map[string]string{}
+                if c.allow != "" {
+                        headers[OptionsAllowHeader] = c.allow
+                }
+                if got := optionsAllows(headers, c.method); got != c.allows {
+                        t.Errorf("optionsAllows(%q, %s) = %v, want %v", c.allow, c.method, got, c.allows)
+                }
+                if got := optionsLists(headers, c.method); got != c.listing {
+                        t.Errorf("optionsLists(%q, %s) = %v, want %v", c.allow, c.method, got, c.listing)
+                }
+        }
+}
+
+// AIProvider that answers with canned replies, in order, and records the
+// prompts it was sent
+type stubProvider struct {
+        replies []string
+        inputs  []PromptInput
+}
+
+func (p *stubProvider) Name() string { return "stub" }
+
+func (p *stubProvider) Suggest(ctx context.Context, input PromptInput) (RawCompletion, error) {
+        p.inputs = append(p.inputs, input)
+        if len(p.replies) == 0 {
+                return RawCompletion{}, errors.New("no reply left")
+        }
+        reply := p.replies[0]
+        p.replies = p.replies[1:]
+        return RawCompletion{Content: reply, Structured: true}, nil
+}
+
+// Extensions of a response with their confidence and reason, for comparing
+func describeExtensions(resp *ExtensionsResponse) string {
+        var parts []string
+        for _, ext := range resp.Extensions {
+                part := ext
+                if confidence, ok := resp.Confidence[ext]; ok {
+                        part += fmt.Sprintf(" %.2f", confidence)
+                }
+                if reason, ok := resp.Reasons[ext]; ok {
+                        part += " (" + reason + ")"
+                }
+                parts = append(parts, part)
+        }
+        return strings.Join(parts, ", ")
+}
+
+func TestParseExtensions(t *testing.T) {
+        cases := []struct {
+                name    string
+                content string
+                want    string
+                method  string
+                err     string
+        }{
+                {"plain", `{"extensions": [{"ext": ".php", "confidence": 0.9}, {"ext": ".bak", "confidence": 0.4}], "method": "GET"}`, ".php 0.90, .bak 0.40", "GET", ""},
+                {"fenced", "```json\n{\"extensions\": [{\"ext\": \".aspx\", \"confidence\": 0.8}]}\n```", ".aspx 0.80", "", ""},
+                {"prose-wrapped", "Sure! Based on the IIS headers:\n{\"extensions\": [{\"ext\": \"aspx\", \"confidence\": 0.7, \"reason\": \"IIS\"}]}\nHope that helps.", ".aspx 0.70 (IIS)", "", ""},
+                {"legacy array of strings", `{"extensions": [".php", "inc", ".html"]}`, ".php, .inc, .html", "", ""},
+                {"mixed legacy and scored", `{"extensions": [".php", {"ext": ".bak", "confidence": 0.5}]}`, ".php, .bak 0.50", "", ""},
+                {"unsafe entries dropped", `{"extensions": [".php", "../etc", ".tar.gz", ""]}`, ".php", "", ""},
+                {"no JSON", "I cannot help with that.", "", "", "no valid JSON found"},
+                {"no extensions key", `{"suggestions": [".php"]}`, "", "", "no valid JSON found"},
+                {"trailing comma", `{"extensions": [".php",]}`, "", "", "parsing AI response JSON"},
+                {"entry of the wrong type", `{"extensions": [".php", 42]}`, "", "", "neither a string nor an object"},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        resp, err := parseExtensions(c.content)
+                        if c.err != "" {
+                                if err == nil || !strings.Contains(err.Error(), c.err) {
+                                        t.Fatalf("got error %v, want one containing %q", err, c.err)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if got := describeExtensions(resp); got != c.want || resp.Method != c.method {
+                                t.Errorf("got %q method %q, want %q method %q", got, resp.Method, c.want, c.method)
+                        }
+                })
+        }
+}
+
+func TestDecodeJSONReply(t *testing.T) {
+        cases := []struct {
+                content string
+                want    string
+                err     bool
+        }{
+                {`{"name": "nginx"}`, "nginx", false},
+                {"The stack is:\n```\n{\"name\": \"IIS\"}\n```", "IIS", false},
+                {"no object here", "", true},
+                {`} backwards {`, "", true},
+                {`{"name": }`, "", true},
+        }
+        for _, c := range cases {
+                var out struct {
+                        Name string `json:"name"`
+                }
+                err := decodeJSONReply(c.content, &out)
+                if (err != nil) != c.err || out.Name != c.want {
+                        t.Errorf("decodeJSONReply(%q) = %q, %v", c.content, out.Name, err)
+                }
+        }
+}
+
+func TestSelectExtensions(t *testing.T) {
+        resp := &ExtensionsResponse{
+                Extensions: []string{".html", ".php", ".bak", ".inc", ".old"},
+                Confidence: map[string]float64{".php": 0.9, ".bak": 0.3, ".inc": 0.6, ".old": 0.6},
+        }
+        cases := []struct {
+                minConfidence float64
+                max           int
+                want          string
+        }{
+                {0, 10, ".html,.php,.inc,.old,.bak"},
+                {0.5, 10, ".html,.php,.inc,.old"},
+                {0.5, 2, ".html,.php"},
+                {0.95, 10, ".html"},
+        }
+        for _, c := range cases {
+                if got := strings.Join(selectExtensions(resp, c.minConfidence, c.max), ","); got != c.want {
+                        t.Errorf("selectExtensions(%v, %d) = %s, want %s", c.minConfidence, c.max, got, c.want)
+                }
+        }
+}
+
+func TestExtractExtensions(t *testing.T) {
+        valid := `{"extensions": [{"ext": ".php", "confidence": 0.9}], "method": "GET", "method_reason": "static"}`
+        cases := []struct {
+                name       string
+                completion RawCompletion
+                replies    []string
+                want       string
+                calls      int
+                err        string
+        }{
+                {"unstructured reply is extracted", RawCompletion{Content: "Here you go: " + valid}, nil, ".php 0.90", 0, ""},
+                {"structured reply decodes", RawCompletion{Content: valid, Structured: true}, nil, ".php 0.90", 0, ""},
+                {"fenced structured reply is retried", RawCompletion{Content: "```json\n" + valid + "\n```", Structured: true}, []string{valid}, ".php 0.90", 1, ""},
+                {"unknown field is retried", RawCompletion{Content: `{"extensions": [".php"], "notes": "x"}`, Structured: true}, []string{valid}, ".php 0.90", 1, ""},
+                {"missing extensions is retried", RawCompletion{Content: `{"method": "GET"}`, Structured: true}, []string{valid}, ".php 0.90", 1, ""},
+                {"invalid after the retry", RawCompletion{Content: "nope", Structured: true}, []string{"still nope"}, "", 1, "still invalid after retry"},
+        }
+        for _, c := range cases {
+                t.Run(c.name, func(t *testing.T) {
+                        provider := &stubProvider{replies: c.replies}
+                        input := PromptInput{Prompt: "suggest", Schema: newExtensionsSchema(false, true, false)}
+                        resp, err := extractExtensions(context.Background(), provider, input, c.completion)
+                        if len(provider.inputs) != c.calls {
+                                t.Errorf("provider called %d times, want %d", len(provider.inputs), c.calls)
+                        }
+                        if c.calls > 0 && !strings.Contains(provider.inputs[0].Prompt, `"required":["extensions","method","method_reason"]`) {
+                                t.Errorf("retry prompt does not restate the schema: %q", provider.inputs[0].Prompt)
+                        }
+                        if c.err != "" {
+                                if err == nil || !strings.Contains(err.Error(), c.err) {
+                                        t.Fatalf("got error %v, want one containing %q", err, c.err)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if got := describeExtensions(resp); got != c.want {
+                                t.Errorf("got %q, want %q", got, c.want)
+                        }
+                })
+        }
+}
+
+// What fn writes to os.Stderr
+func captureStderr(t *testing.T, fn func()) string {
+        t.Helper()
+        reader, writer, err := os.Pipe()
+        if err != nil {
+                t.Fatal(err)
+        }
+        saved := os.Stderr
+        os.Stderr = writer
+        fn()
+        os.Stderr = saved
+        writer.Close()
+        data, _ := io.ReadAll(reader)
+        reader.Close()
+        return string(data)
+}
+
+func TestRecordReplay(t *testing.T) {
+        target := "https://example.com/admin/FUZZ"
+        headers := map[string]string{"Server": "Microsoft-IIS/10.0", "X-Powered-By": "ASP.NET"}
+        config := &Config{MaxExtensions: 4, AskMethod: true, AskMatchCodes: true}
+        prompt, err := buildPrompt(config, target, headers)
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        recorded := Exchange{
+                Timestamp:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+                TargetURL:  target,
+                Headers:    headers,
+                Provider:   ProviderOpenAI,
+                Model:      "gpt-4o-mini",
+                PromptHash: promptHash(PromptInput{Prompt: prompt}),
+                Content:    `{"extensions": [{"ext": ".aspx", "confidence": 0.9}, {"ext": ".config", "confidence": 0.6}], "method": "GET", "method_reason": "pages", "match_codes": "200,401,403", "match_reason": "protected"}`,
+                Structured: true,
+                Extensions: []string{".aspx", ".config"},
+        }
+        path, err := writeExchange(t.TempDir(), recorded)
+        if err != nil {
+                t.Fatal(err)
+        }
+        loaded, err := loadExchange(path)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !loaded.Timestamp.Equal(recorded.Timestamp) || loaded.PromptHash != recorded.PromptHash || loaded.Content != recorded.Content ||
+                loaded.Headers["X-Powered-By"] != "ASP.NET" || strings.Join(loaded.Extensions, ",") != ".aspx,.config" || !loaded.Structured {
+                t.Fatalf("round trip changed the exchange: %+v", loaded)
+        }
+
+        config.Replay = loaded
+        resp, err := getAIExtensions(context.Background(), target, headers, config)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if got := describeExtensions(resp); got != ".aspx 0.90, .config 0.60" || resp.MatchCodes != "200,401,403" || resp.Provider != ProviderOpenAI || resp.Model != "gpt-4o-mini" {
+                t.Errorf("replayed %q -mc %q from %s/%s", got, resp.MatchCodes, resp.Provider, resp.Model)
+        }
+
+        // A different target changes the prompt
+        other := "https://example.com/api/FUZZ"
+        if _, err := getAIExtensions(context.Background(), other, headers, config); err == nil || !strings.Contains(err.Error(), "--replay-force") {
+                t.Errorf("got error %v, want a prompt mismatch", err)
+        }
+        config.ReplayForce = true
+        warning := captureStderr(t, func() {
+                resp, err = getAIExtensions(context.Background(), other, headers, config)
+        })
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !strings.Contains(warning, "recorded prompt differs") || describeExtensions(resp) != ".aspx 0.90, .config 0.60" {
+                t.Errorf("forced replay warned %q and gave %q", warning, describeExtensions(resp))
+        }
+}
+
+// Writer that always fails, like a closed pipe
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestDryRunBatchesPrintArgv(t *testing.T) {
+        savedArgv, savedOutput := argvOutput, textOutput
+        defer func() { argvOutput, textOutput = savedArgv, savedOutput }()
+        textOutput = io.Discard
+        config := &Config{FfufPath: "ffuf", FfufArgs: []string{"-u", "https://example.com/FUZZ", "-w", "words.txt"}, ExtBatch: 1, DryRun: true, PrintArgv: true}
+
+        var argv strings.Builder
+        argvOutput = &argv
+        if _, err := executeBatches(config, []string{".php", ".bak"}); err != nil {
+                t.Fatal(err)
+        }
+        want := `["ffuf","-u","https://example.com/FUZZ","-w","words.txt","-e",".php"]` + "\n" + `["ffuf","-u","https://example.com/FUZZ","-w","words.txt","-e",".bak"]` + "\n"
+        if argv.String() != want {
+                t.Errorf("printed %q, want %q", argv.String(), want)
+        }
+
+        argvOutput = failingWriter{}
+        if _, err := executeBatches(config, []string{".php", ".bak"}); err == nil || !strings.Contains(err.Error(), "broken pipe") {
+                t.Errorf("got error %v, want the failed write", err)
+        }
+}